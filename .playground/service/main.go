@@ -1,13 +1,29 @@
 package main
 
 import (
+	"flag"
 	"log"
 
+	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/network"
 	"github.com/adrianosela/rdtp/service"
 )
 
 func main() {
-	svc, err := service.NewService()
+	udp := flag.Bool("udp", false, "carry rdtp packets in UDP datagrams (no root needed)")
+	port := flag.Int("port", rdtp.UDPPortRDTP, "UDP port to carry rdtp packets on")
+	flag.Parse()
+
+	var c service.Config
+	if *udp {
+		udpNetwork, err := network.NewUDP(nil, *port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Network = udpNetwork
+	}
+
+	svc, err := service.New(c)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,7 +1,7 @@
 package rdtp
 
 import (
-	"fmt"
+	"net"
 	"strconv"
 	"strings"
 
@@ -23,38 +23,52 @@ func (a *Addr) Network() string {
 	return Network
 }
 
-// String returns the string form of the address
+// String returns the string form of the address,
+// with IPv6 hosts in brackets e.g. [::1]:4444
 func (a *Addr) String() string {
-	return fmt.Sprintf("%s:%d", a.Host, a.Port)
+	return net.JoinHostPort(a.Host, strconv.Itoa(int(a.Port)))
 }
 
-func fromString(address string) (*Addr, error) {
-	addrParts := strings.Split(address, ":")
-	if len(addrParts) > 2 || len(addrParts) == 0 {
-		return nil, errors.New("invalid ipv4 address")
+// ParseAddr parses an rdtp address of the form ${host}:${port}, where IPv6
+// hosts go in brackets e.g. [fe80::1]:4444. The host may be empty, and
+// the port defaults to the discovery port if left out. IP addresses
+// are normalized so that addresses compare equal as strings
+func ParseAddr(address string) (*Addr, error) {
+	host, port := address, ""
+	switch {
+	case strings.HasPrefix(address, "["):
+		end := strings.Index(address, "]")
+		if end < 0 {
+			return nil, errors.New("missing ']' in address")
+		}
+		host, port = address[1:end], address[end+1:]
+		if port != "" && !strings.HasPrefix(port, ":") {
+			return nil, errors.New("unexpected characters after ']' in address")
+		}
+		if net.ParseIP(host) == nil || !strings.Contains(host, ":") {
+			return nil, errors.New("only IPv6 addresses go in brackets")
+		}
+		port = strings.TrimPrefix(port, ":")
+	case strings.Count(address, ":") > 1:
+		// a bare IPv6 address, which cannot carry a port
+		if net.ParseIP(address) == nil {
+			return nil, errors.New("invalid address, IPv6 addresses with a port go in brackets")
+		}
+	case strings.Contains(address, ":"):
+		i := strings.LastIndex(address, ":")
+		host, port = address[:i], address[i+1:]
 	}
 
-	var a Addr
-
-	// if no port given
-	if len(addrParts) == 1 {
-		a.Host = addrParts[0]
-		a.Port = DiscoveryPort
+	a := Addr{Host: host, Port: DiscoveryPort}
+	if ip := net.ParseIP(host); ip != nil {
+		a.Host = ip.String()
 	}
-
-	// if port is given
-	if len(addrParts) <= 2 {
-		a.Host = addrParts[0] // host might be empty, which is okay
-		if addrParts[1] == "" {
-			a.Port = uint16(0)
-		} else {
-			port, err := strconv.ParseUint(addrParts[1], 10, 16)
-			if err != nil {
-				return nil, errors.Wrap(err, "invalid port number")
-			}
-			a.Port = uint16(port)
+	if port != "" {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid port number")
 		}
+		a.Port = uint16(p)
 	}
-
 	return &a, nil
 }
@@ -0,0 +1,50 @@
+package rdtp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		address string
+		want    Addr
+	}{
+		{address: "10.0.0.1:4444", want: Addr{Host: "10.0.0.1", Port: 4444}},
+		{address: "10.0.0.1", want: Addr{Host: "10.0.0.1", Port: DiscoveryPort}},
+		{address: "10.0.0.1:", want: Addr{Host: "10.0.0.1", Port: DiscoveryPort}},
+		{address: ":4444", want: Addr{Port: 4444}},
+		{address: "localhost:4444", want: Addr{Host: "localhost", Port: 4444}},
+		{address: "[::1]:4444", want: Addr{Host: "::1", Port: 4444}},
+		{address: "[fe80:0::0001]:80", want: Addr{Host: "fe80::1", Port: 80}},
+		{address: "[::1]", want: Addr{Host: "::1", Port: DiscoveryPort}},
+		{address: "fe80::1", want: Addr{Host: "fe80::1", Port: DiscoveryPort}},
+	}
+	for _, test := range tests {
+		got, err := ParseAddr(test.address)
+		assert.Nil(t, err, test.address)
+		assert.Equal(t, test.want, *got, test.address)
+	}
+}
+
+func TestParseAddrErrors(t *testing.T) {
+	for _, address := range []string{
+		"10.0.0.1:port",
+		"10.0.0.1:65536",
+		"[::1",
+		"[::1]4444",
+		"[10.0.0.1]:4444",
+		"fe80::1:4444:port",
+		"fe80::zz",
+	} {
+		_, err := ParseAddr(address)
+		assert.NotNil(t, err, address)
+	}
+}
+
+func TestAddrString(t *testing.T) {
+	assert.Equal(t, "10.0.0.1:4444", (&Addr{Host: "10.0.0.1", Port: 4444}).String())
+	assert.Equal(t, "[::1]:4444", (&Addr{Host: "::1", Port: 4444}).String())
+	assert.Equal(t, ":80", (&Addr{Port: 80}).String())
+}
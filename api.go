@@ -1,16 +1,29 @@
 package rdtp
 
-import "encoding/json"
-
-// ClientMessage is the json model of a request for the rdtp service
+// ClientMessage is the json model of a request for the rdtp service.
+// Control messages are framed as WriteMessage does. Once the service
+// answers a dial or accept request with an OK message, the connection
+// carries only the bytes of the rdtp connection's stream
 type ClientMessage struct {
+	Version    int               `json:"version"` // of the control protocol
 	Type       ClientMessageType `json:"type"`
 	LocalAddr  Addr              `json:"local_addr"`
 	RemoteAddr Addr              `json:"remote_addr"`
+
+	// settings of the connection's socket (dial and accept requests
+	// only), for which the rdtp service's defaults are used if unset:
+	// its congestion control algorithm, the bytes it holds for delivery
+	// to the application and for retransmission, and the period after
+	// which it probes a silent remote
+	CongestionControl string `json:"congestion_control,omitempty"`
+	ReceiveBufferSize int    `json:"receive_buffer_size,omitempty"`
+	SendBufferSize    int    `json:"send_buffer_size,omitempty"`
+	KeepAliveMillis   int64  `json:"keep_alive_ms,omitempty"`
 }
 
 // ServiceMessage is the json model of a message/response from the rdtp service
 type ServiceMessage struct {
+	Version    int                `json:"version"` // of the control protocol
 	Type       ServiceMessageType `json:"type"`
 	LocalAddr  Addr               `json:"local_addr"`
 	RemoteAddr Addr               `json:"remote_addr"`
@@ -38,7 +51,8 @@ const (
 	ClientMessageTypeAccept = ClientMessageType("ACCEPT")
 
 	// ClientMessageTypeDial is the message type sent from clients
-	// to rdtp-service to "dial" a remote rdtp address
+	// to rdtp-service to "dial" a remote rdtp address, from the
+	// LocalAddr given if any of it is
 	// Note: RemoteAddr **must** be defined
 	ClientMessageTypeDial = ClientMessageType("DIAL")
 
@@ -47,6 +61,13 @@ const (
 	// Note: LocalAddr **must** be defined
 	ClientMessageTypeListen = ClientMessageType("LISTEN")
 
+	// ClientMessageTypeMultiplex is the message type sent from clients
+	// to rdtp-service to multiplex many requests over the connection.
+	// Once the service answers with an OK message, the connection
+	// carries streams (see the mux package), each starting with a
+	// request of its own as if it were a connection of its own
+	ClientMessageTypeMultiplex = ClientMessageType("MULTIPLEX")
+
 	// ServiceMessageTypeOK is the message type sent from rdtp-service to clients
 	// to acknowledge their request and indicate that it was served successfully
 	ServiceMessageTypeOK = ServiceMessageType("OK")
@@ -76,6 +97,11 @@ const (
 	// by the rdtp service failing to create a new socket
 	ServiceErrorTypeFailedToCreateSocket = ServiceErrorType("CREATE_SOCKET_FAIL")
 
+	// ServiceErrorTypeAddressNotAvailable is the error type for errors
+	// caused by the rdtp client dialing from a local address which is
+	// not the rdtp service's
+	ServiceErrorTypeAddressNotAvailable = ServiceErrorType("ADDRESS_NOT_AVAILABLE")
+
 	// ServiceErrorTypeFailedToAttachSocket is the error type for errors caused
 	// by the rdtp service failing to attach a created socket to the socket mgr
 	ServiceErrorTypeFailedToAttachSocket = ServiceErrorType("ATTACH_SOCKET_FAIL")
@@ -88,27 +114,44 @@ const (
 	// by the rdtp service failing the rdtp handshake with a remote address
 	ServiceErrorTypeFailedHandshake = ServiceErrorType("HANDSHAKE_FAILED")
 
+	// ServiceErrorTypeConnectionRefused is the error type for errors caused
+	// by the remote rdtp service refusing a connection, e.g. since nothing
+	// listens on the port dialed
+	ServiceErrorTypeConnectionRefused = ServiceErrorType("CONNECTION_REFUSED")
+
 	// ServiceErrorTypeFailedCommunication is the error type for errors caused
 	// by the rdtp service failing to communicate with the rdtp client
 	ServiceErrorTypeFailedCommunication = ServiceErrorType("COMMUNICATION_FAILED")
+
+	// ServiceErrorTypeUnsupportedVersion is the error type for errors caused
+	// by the rdtp client speaking a version of the control protocol which
+	// the rdtp service does not. The service states its own version
+	ServiceErrorTypeUnsupportedVersion = ServiceErrorType("UNSUPPORTED_VERSION")
 )
 
-// NewClientMessage returns a serialized client message
+// NewClientMessage returns a serialized client message, framed
 func NewClientMessage(clientMessageType ClientMessageType,
 	laddr, raddr *Addr) ([]byte, error) {
+	return frame(newClientMessage(clientMessageType, laddr, raddr))
+}
+
+func newClientMessage(clientMessageType ClientMessageType,
+	laddr, raddr *Addr) ClientMessage {
 	laddrd, raddrd := getAddressesDereferenced(laddr, raddr)
-	return json.Marshal(ClientMessage{
+	return ClientMessage{
+		Version:    ControlProtocolVersion,
 		Type:       clientMessageType,
 		LocalAddr:  laddrd,
 		RemoteAddr: raddrd,
-	})
+	}
 }
 
-// NewServiceMessage returns a serialized service message
+// NewServiceMessage returns a serialized service message, framed
 func NewServiceMessage(serviceMessageType ServiceMessageType,
 	laddr, raddr *Addr, errorType *ServiceErrorType) ([]byte, error) {
 	laddrd, raddrd := getAddressesDereferenced(laddr, raddr)
 	msg := ServiceMessage{
+		Version:    ControlProtocolVersion,
 		Type:       serviceMessageType,
 		LocalAddr:  laddrd,
 		RemoteAddr: raddrd,
@@ -116,7 +159,7 @@ func NewServiceMessage(serviceMessageType ServiceMessageType,
 	if serviceMessageType == ServiceMessageTypeError && errorType != nil {
 		msg.Error = *errorType
 	}
-	return json.Marshal(msg)
+	return frame(msg)
 }
 
 func getAddressesDereferenced(laddr, raddr *Addr) (local Addr, remote Addr) {
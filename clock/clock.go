@@ -0,0 +1,84 @@
+// Package clock abstracts the passing of time so that the rdtp stack
+// can run on real time or on simulated time, e.g. to check in
+// milliseconds what takes minutes on a real network. Goroutines start
+// and wait on one another through the clock too, so that a simulated
+// clock knows when all of them are waiting for time to pass
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time, runs functions after a delay,
+// and runs goroutines which wait on one another
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// Sleep pauses the calling goroutine until the duration elapses
+	Sleep(d time.Duration)
+
+	// AfterFunc calls f in its own goroutine
+	// once the duration elapses
+	AfterFunc(d time.Duration, f func()) Timer
+
+	// Go calls f in a goroutine of its own
+	Go(f func())
+
+	// NewCond returns a condition variable associated
+	// with l, for goroutines to wait on one another
+	NewCond(l sync.Locker) Cond
+}
+
+// Timer is a pending call of a function, as returned by AfterFunc
+type Timer interface {
+	// Stop prevents the call from happening. It returns false
+	// if the call already happened or the timer was stopped
+	Stop() bool
+
+	// Reset changes the timer to expire once the duration elapses.
+	// It returns true if the timer had been active
+	Reset(d time.Duration) bool
+}
+
+// Cond is a condition variable, as a sync.Cond is
+type Cond interface {
+	// Wait unlocks the associated lock, waits until woken up by
+	// Signal or Broadcast, and locks the associated lock again
+	Wait()
+
+	// Signal wakes up one goroutine waiting, if there is any
+	Signal()
+
+	// Broadcast wakes up all goroutines waiting
+	Broadcast()
+}
+
+// Real is the clock of the time package
+type Real struct{}
+
+// Now returns the current time
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep pauses the calling goroutine until the duration elapses
+func (Real) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// AfterFunc calls f in its own goroutine once the duration elapses
+func (Real) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Go calls f in a goroutine of its own
+func (Real) Go(f func()) {
+	go f()
+}
+
+// NewCond returns a sync.Cond associated with l
+func (Real) NewCond(l sync.Locker) Cond {
+	return sync.NewCond(l)
+}
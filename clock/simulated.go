@@ -0,0 +1,325 @@
+package clock
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Simulated is a clock on which time only passes when told to, firing
+// the timers due in order. Timers due at the same time fire in the
+// order they were set.
+//
+// The goroutines started with Go run one at a time rather than in
+// parallel: each runs until it waits on a condition variable of the
+// clock, sleeps or returns, and then hands over to the goroutine which
+// was ready to run the earliest. Given the same timers firing, the same
+// goroutines therefore run in the same order, so that runs can be
+// replayed exactly. They must wait on nothing but the clock, or no
+// other goroutine runs in the meantime, and goroutines which were not
+// started with Go must not wait on the clock
+type Simulated struct {
+	mu     sync.Mutex
+	now    time.Time
+	events eventQueue
+	seq    uint64
+
+	// whether a goroutine is running, and the turns of those ready
+	// to run in order, each of which runs once sent on its turn
+	running bool
+	ready   []chan bool
+	idle    *sync.Cond // signalled once no goroutine is running
+}
+
+// NewSimulated returns a simulated clock set to the given time
+func NewSimulated(start time.Time) *Simulated {
+	c := &Simulated{now: start}
+	c.idle = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current simulated time
+func (c *Simulated) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep pauses the calling goroutine, which must have been started
+// with Go, until the duration elapses in simulated time
+func (c *Simulated) Sleep(d time.Duration) {
+	turn := make(chan bool, 1)
+	c.schedule(d, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.wake(turn)
+	})
+	c.yield()
+	<-turn
+}
+
+// AfterFunc calls f in its own goroutine, as Go does,
+// once the duration elapses in simulated time
+func (c *Simulated) AfterFunc(d time.Duration, f func()) Timer {
+	return &simulatedTimer{
+		clock: c,
+		event: c.schedule(d, func() { c.Go(f) }),
+	}
+}
+
+// Go calls f in a goroutine of its own, once
+// the goroutines ready to run before it have
+func (c *Simulated) Go(f func()) {
+	turn := make(chan bool, 1)
+	c.mu.Lock()
+	c.wake(turn)
+	c.mu.Unlock()
+
+	go func() {
+		<-turn
+		defer c.yield()
+		f()
+	}()
+}
+
+// NewCond returns a condition variable associated with l, on which
+// goroutines started with Go wait until others wake them up. Those
+// woken up run in the order they started waiting
+func (c *Simulated) NewCond(l sync.Locker) Cond {
+	return &simulatedCond{clock: c, l: l}
+}
+
+// Settle waits until every goroutine started with Go is waiting on the
+// clock, i.e. until nothing happens unless time passes. It must not be
+// called by a goroutine started with Go
+func (c *Simulated) Settle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.running {
+		c.idle.Wait()
+	}
+}
+
+// wake lets a goroutine run right away if no other is
+// running, and once its turn comes otherwise. Must hold c.mu
+func (c *Simulated) wake(turn chan bool) {
+	if !c.running {
+		c.running = true
+		turn <- true
+		return
+	}
+	c.ready = append(c.ready, turn)
+}
+
+// yield hands over from the goroutine running, as it waits or
+// returns, to the goroutine which was ready to run the earliest
+func (c *Simulated) yield() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.ready) == 0 {
+		c.running = false
+		c.idle.Broadcast()
+		return
+	}
+	turn := c.ready[0]
+	c.ready[0] = nil
+	c.ready = c.ready[1:]
+	turn <- true
+}
+
+// Next returns the time the earliest pending timer is due,
+// or false if there are no pending timers
+func (c *Simulated) Next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.events) == 0 {
+		return time.Time{}, false
+	}
+	return c.events[0].due, true
+}
+
+// Step moves the time on to the earliest pending timer and fires it,
+// once the goroutines started with Go settle, and waits for them to
+// settle again. It returns false, without moving the time on, if there
+// are no pending timers. It must not be called by a goroutine started
+// with Go
+func (c *Simulated) Step() bool {
+	c.Settle()
+
+	c.mu.Lock()
+	if len(c.events) == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	e := heap.Pop(&c.events).(*event)
+	if e.due.After(c.now) {
+		c.now = e.due
+	}
+	c.mu.Unlock()
+
+	e.fire()
+	c.Settle()
+	return true
+}
+
+// Advance moves the time on by the given duration, firing all of
+// the timers due in the meantime as Step does. It must not be
+// called by a goroutine started with Go
+func (c *Simulated) Advance(d time.Duration) {
+	c.mu.Lock()
+	until := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		next, ok := c.Next()
+		if !ok || next.After(until) {
+			break
+		}
+		c.Step()
+	}
+
+	c.mu.Lock()
+	if until.After(c.now) {
+		c.now = until
+	}
+	c.mu.Unlock()
+}
+
+// schedule queues fire to be called once the duration elapses
+func (c *Simulated) schedule(d time.Duration, fire func()) *event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &event{fire: fire, index: -1}
+	c.push(e, d)
+	return e
+}
+
+// push queues an event due once the duration elapses. Must hold c.mu
+func (c *Simulated) push(e *event, d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	c.seq++
+	e.due = c.now.Add(d)
+	e.seq = c.seq
+	heap.Push(&c.events, e)
+}
+
+// simulatedCond is a condition variable of a simulated clock
+type simulatedCond struct {
+	clock   *Simulated
+	l       sync.Locker
+	waiting []chan bool // turns of the goroutines waiting, guarded by clock.mu
+}
+
+// Wait unlocks l, hands over to the next goroutine ready to run
+// until woken up, and locks l again
+func (c *simulatedCond) Wait() {
+	turn := make(chan bool, 1)
+	c.clock.mu.Lock()
+	c.waiting = append(c.waiting, turn)
+	c.clock.mu.Unlock()
+
+	c.l.Unlock()
+	c.clock.yield()
+	<-turn
+	c.l.Lock()
+}
+
+// Signal wakes up the goroutine which has waited the longest
+func (c *simulatedCond) Signal() {
+	c.clock.mu.Lock()
+	defer c.clock.mu.Unlock()
+
+	if len(c.waiting) > 0 {
+		c.clock.wake(c.waiting[0])
+		c.waiting[0] = nil
+		c.waiting = c.waiting[1:]
+	}
+}
+
+// Broadcast wakes up all goroutines waiting, in the order they started to
+func (c *simulatedCond) Broadcast() {
+	c.clock.mu.Lock()
+	defer c.clock.mu.Unlock()
+
+	for _, turn := range c.waiting {
+		c.clock.wake(turn)
+	}
+	c.waiting = nil
+}
+
+// simulatedTimer is a timer of a simulated clock
+type simulatedTimer struct {
+	clock *Simulated
+	event *event
+}
+
+// Stop prevents the call from happening
+func (t *simulatedTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if t.event.index < 0 {
+		return false
+	}
+	heap.Remove(&t.clock.events, t.event.index)
+	return true
+}
+
+// Reset changes the timer to expire once the duration elapses
+func (t *simulatedTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.event.index >= 0
+	if active {
+		heap.Remove(&t.clock.events, t.event.index)
+	}
+	t.clock.push(t.event, d)
+	return active
+}
+
+// event is a timer pending on a simulated clock
+type event struct {
+	due   time.Time
+	seq   uint64 // breaks ties in the order timers were set
+	fire  func()
+	index int // position in the queue, or -1 once off it
+}
+
+// eventQueue is a min-heap of events by due time
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].due.Equal(q[j].due) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].due.Before(q[j].due)
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x interface{}) {
+	e := x.(*event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,131 @@
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fired returns a function which reports the name given once called
+func fired(ch chan string, name string) func() {
+	return func() { ch <- name }
+}
+
+func TestSimulatedStep(t *testing.T) {
+	c := NewSimulated(epoch)
+	assert.Equal(t, epoch, c.Now())
+
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(time.Second, func() { ch <- c.Now() })
+	c.AfterFunc(time.Second*2, func() {})
+
+	next, ok := c.Next()
+	assert.True(t, ok)
+	assert.Equal(t, epoch.Add(time.Second), next)
+
+	assert.True(t, c.Step())
+	assert.Equal(t, epoch.Add(time.Second), c.Now())
+	assert.Equal(t, epoch.Add(time.Second), <-ch)
+
+	assert.True(t, c.Step())
+	assert.Equal(t, epoch.Add(time.Second*2), c.Now())
+
+	assert.False(t, c.Step())
+	_, ok = c.Next()
+	assert.False(t, ok)
+}
+
+func TestSimulatedOrder(t *testing.T) {
+	c := NewSimulated(epoch)
+
+	// timers fire in order of due time and then in the order they were set
+	ch := make(chan string, 3)
+	c.AfterFunc(time.Second*2, fired(ch, "c"))
+	c.AfterFunc(time.Second, fired(ch, "a"))
+	c.AfterFunc(time.Second, fired(ch, "b"))
+
+	var order []string
+	for c.Step() {
+		order = append(order, <-ch)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, order)
+}
+
+func TestSimulatedStopReset(t *testing.T) {
+	c := NewSimulated(epoch)
+
+	ch := make(chan string, 2)
+	stopped := c.AfterFunc(time.Second, fired(ch, "stopped"))
+	reset := c.AfterFunc(time.Second, fired(ch, "reset"))
+
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	assert.True(t, reset.Reset(time.Second*3))
+	c.Advance(time.Second * 2)
+	assert.Equal(t, epoch.Add(time.Second*2), c.Now())
+	_, ok := c.Next()
+	assert.True(t, ok)
+
+	c.Advance(time.Second)
+	assert.Equal(t, "reset", <-ch)
+
+	// expired timers can be reset too
+	assert.False(t, reset.Reset(time.Second))
+	c.Advance(time.Second)
+	assert.Equal(t, "reset", <-ch)
+
+	_, ok = c.Next()
+	assert.False(t, ok)
+	assert.Empty(t, ch)
+}
+
+func TestSimulatedGoroutines(t *testing.T) {
+	c := NewSimulated(epoch)
+
+	// goroutines run one at a time, in the order they
+	// are started and woken up, until they all wait
+	var mu sync.Mutex
+	cond := c.NewCond(&mu)
+	var order []string
+	run := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name+" waits")
+		cond.Wait()
+		order = append(order, name+" woken")
+	}
+	c.Go(func() { run("a") })
+	c.Go(func() { run("b") })
+	c.Settle()
+	assert.Equal(t, []string{"a waits", "b waits"}, order)
+
+	c.Go(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, "c wakes")
+		cond.Broadcast()
+	})
+	c.Settle()
+	assert.Equal(t, []string{"a waits", "b waits", "c wakes", "a woken", "b woken"}, order)
+}
+
+func TestSimulatedSleep(t *testing.T) {
+	c := NewSimulated(epoch)
+
+	woke := make(chan time.Time, 1)
+	c.Go(func() {
+		c.Sleep(time.Second)
+		woke <- c.Now()
+	})
+	c.Settle()
+	assert.Empty(t, woke)
+
+	// sleepers are woken up by time passing alone
+	assert.True(t, c.Step())
+	assert.Equal(t, epoch.Add(time.Second), <-woke)
+}
@@ -0,0 +1,361 @@
+package congestion
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// bbrHighGain is the smallest gain which doubles the sending
+	// rate every round trip while probing for bandwidth (2/ln2)
+	bbrHighGain = 2.885
+
+	// bbrDrainGain drains the queue built up during startup
+	bbrDrainGain = 1 / bbrHighGain
+
+	// bbrCwndGain lets the window cover delayed and
+	// aggregated acknowledgements in steady state
+	bbrCwndGain = 2
+
+	// bbrBtlBwFilterRounds is the number of round trips
+	// over which the bottleneck bandwidth is the maximum
+	bbrBtlBwFilterRounds = 10
+
+	// bbrMinRTTWindow is how long a minimum round trip time is trusted
+	bbrMinRTTWindow = time.Second * 10
+
+	// bbrProbeRTTDuration is how long the window is held at
+	// its minimum to let queues drain and measure the round trip
+	bbrProbeRTTDuration = time.Millisecond * 200
+
+	// bbrMinWindowSegments is the smallest window in maximum size segments
+	bbrMinWindowSegments = 4
+
+	// bbrFullBwGrowth and bbrFullBwRounds decide when the pipe is full:
+	// once bandwidth grows by less than 25% for three round trips
+	bbrFullBwGrowth = 1.25
+	bbrFullBwRounds = 3
+)
+
+// bbrPacingGainCycle probes for more bandwidth for one round trip, drains
+// any queue this created over the next, and then cruises for six more
+var bbrPacingGainCycle = []float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+type bbrMode int
+
+const (
+	bbrStartup bbrMode = iota
+	bbrDrain
+	bbrProbeBW
+	bbrProbeRTT
+)
+
+// BBR paces data at the bottleneck bandwidth it measures and keeps about
+// a bandwidth-delay product in flight, rather than reacting to losses, so
+// that random loss on long paths does not collapse throughput (BBR v1)
+type BBR struct {
+	mss  int
+	mode bbrMode
+	cwnd int
+
+	// path model
+	btlBw       bandwidthFilter
+	minRTT      time.Duration
+	minRTTStamp time.Time
+
+	// round trip counting, by bytes delivered
+	delivered          int
+	round              int
+	nextRoundDelivered int
+	roundStart         bool
+
+	// startup
+	fullBw       float64
+	fullBwRounds int
+	filledPipe   bool
+
+	// gain cycling
+	pacingGain float64
+	cwndGain   float64
+	cycleIndex int
+	cycleStamp time.Time
+	lossSeen   bool
+
+	// round trip time probing
+	probeRTTDone      time.Time
+	probeRTTRoundDone bool
+	priorCwnd         int
+
+	rtt rttStats
+}
+
+// NewBBR returns a BBR controller for the given maximum segment size
+func NewBBR(mss int) *BBR {
+	return &BBR{
+		mss:        mss,
+		mode:       bbrStartup,
+		cwnd:       initialWindowSegments * mss,
+		pacingGain: bbrHighGain,
+		cwndGain:   bbrHighGain,
+	}
+}
+
+// OnAck updates the path model from the delivery rate sample and moves
+// through BBR's states, then sets the window from the model
+func (b *BBR) OnAck(s AckSample) {
+	b.rtt.update(s.RTT)
+	newlyDelivered := s.Delivered - b.delivered
+	if newlyDelivered < 0 {
+		newlyDelivered = 0
+	}
+	b.delivered = s.Delivered
+	inFlight := s.InFlight - newlyDelivered
+
+	b.updateRound(s)
+	b.updateBtlBw(s)
+	b.checkCyclePhase(s.Now, s.InFlight)
+	b.checkFullPipe(s)
+	b.checkDrain(s.Now, inFlight)
+	b.updateMinRTT(s, inFlight)
+	b.setWindow(newlyDelivered)
+}
+
+func (b *BBR) updateRound(s AckSample) {
+	b.roundStart = false
+	if s.Delivered > 0 && s.PriorDelivered >= b.nextRoundDelivered {
+		b.nextRoundDelivered = s.Delivered
+		b.round++
+		b.roundStart = true
+	}
+}
+
+func (b *BBR) updateBtlBw(s AckSample) {
+	if s.DeliveryRate <= 0 {
+		return
+	}
+	// application limited samples underestimate the bandwidth
+	// and are only taken if they raise the estimate anyway
+	if !s.AppLimited || s.DeliveryRate >= b.btlBw.best(b.round) {
+		b.btlBw.update(b.round, s.DeliveryRate)
+	}
+}
+
+func (b *BBR) checkFullPipe(s AckSample) {
+	if b.filledPipe || !b.roundStart || s.AppLimited {
+		return
+	}
+	bw := b.btlBw.best(b.round)
+	if bw >= b.fullBw*bbrFullBwGrowth {
+		b.fullBw = bw
+		b.fullBwRounds = 0
+		return
+	}
+	b.fullBwRounds++
+	if b.fullBwRounds >= bbrFullBwRounds {
+		b.filledPipe = true
+	}
+}
+
+func (b *BBR) checkDrain(now time.Time, inFlight int) {
+	if b.mode == bbrStartup && b.filledPipe {
+		b.mode = bbrDrain
+		b.pacingGain = bbrDrainGain
+		b.cwndGain = bbrHighGain
+	}
+	if b.mode == bbrDrain && inFlight <= b.bdp(1) {
+		b.enterProbeBW(now)
+	}
+}
+
+func (b *BBR) enterProbeBW(now time.Time) {
+	b.mode = bbrProbeBW
+	b.cwndGain = bbrCwndGain
+	b.cycleIndex = 0
+	b.cycleStamp = now
+	b.pacingGain = bbrPacingGainCycle[b.cycleIndex]
+}
+
+// checkCyclePhase moves on to the next gain once the current one has
+// been in use for a round trip, given the bytes in flight before the
+// acknowledgement
+func (b *BBR) checkCyclePhase(now time.Time, priorInFlight int) {
+	if b.mode != bbrProbeBW {
+		return
+	}
+	fullLength := now.Sub(b.cycleStamp) > b.minRTT
+	next := fullLength
+	switch {
+	case b.pacingGain > 1:
+		// probe until the extra data is actually in flight,
+		// unless losses show the probe has gone far enough
+		next = fullLength && (b.lossSeen || priorInFlight >= b.bdp(b.pacingGain))
+	case b.pacingGain < 1:
+		// drain until the queue is gone or a round trip passes
+		next = fullLength || priorInFlight <= b.bdp(1)
+	}
+	if next {
+		b.cycleIndex = (b.cycleIndex + 1) % len(bbrPacingGainCycle)
+		b.cycleStamp = now
+		b.pacingGain = bbrPacingGainCycle[b.cycleIndex]
+		b.lossSeen = false
+	}
+}
+
+func (b *BBR) updateMinRTT(s AckSample, inFlight int) {
+	expired := !b.minRTTStamp.IsZero() && s.Now.Sub(b.minRTTStamp) > bbrMinRTTWindow
+	if s.RTT > 0 && (b.minRTT == 0 || s.RTT <= b.minRTT || expired) {
+		b.minRTT = s.RTT
+		b.minRTTStamp = s.Now
+	}
+
+	if expired && b.mode != bbrProbeRTT {
+		b.mode = bbrProbeRTT
+		b.pacingGain = 1
+		b.cwndGain = 1
+		b.priorCwnd = b.cwnd
+		b.probeRTTDone = time.Time{}
+	}
+	if b.mode != bbrProbeRTT {
+		return
+	}
+
+	// hold the window at its minimum for a while and
+	// at least a round trip once little is in flight
+	if b.probeRTTDone.IsZero() {
+		if inFlight <= b.minWindow() {
+			b.probeRTTDone = s.Now.Add(bbrProbeRTTDuration)
+			b.probeRTTRoundDone = false
+			b.nextRoundDelivered = s.Delivered
+		}
+		return
+	}
+	if b.roundStart {
+		b.probeRTTRoundDone = true
+	}
+	if b.probeRTTRoundDone && s.Now.After(b.probeRTTDone) {
+		b.minRTTStamp = s.Now
+		if b.priorCwnd > b.cwnd {
+			b.cwnd = b.priorCwnd
+		}
+		if b.filledPipe {
+			b.enterProbeBW(s.Now)
+			return
+		}
+		b.mode = bbrStartup
+		b.pacingGain = bbrHighGain
+		b.cwndGain = bbrHighGain
+	}
+}
+
+func (b *BBR) setWindow(newlyDelivered int) {
+	target := b.bdp(b.cwndGain)
+	if target == 0 {
+		target = initialWindowSegments * b.mss
+	}
+	// leave room for a few segments held up at each end
+	target += 3 * b.mss
+
+	if b.filledPipe {
+		if b.cwnd+newlyDelivered < target {
+			target = b.cwnd + newlyDelivered
+		}
+		b.cwnd = target
+	} else if b.cwnd < target || b.delivered < initialWindowSegments*b.mss {
+		b.cwnd += newlyDelivered
+	}
+
+	if b.cwnd < b.minWindow() {
+		b.cwnd = b.minWindow()
+	}
+	if b.mode == bbrProbeRTT && b.cwnd > b.minWindow() {
+		b.cwnd = b.minWindow()
+	}
+}
+
+// bdp returns the estimated bandwidth-delay product scaled by gain,
+// or zero until both bandwidth and round trip time have been measured
+func (b *BBR) bdp(gain float64) int {
+	bw := b.btlBw.best(b.round)
+	if bw == 0 || b.minRTT == 0 {
+		return 0
+	}
+	return int(gain * bw * b.minRTT.Seconds())
+}
+
+func (b *BBR) minWindow() int {
+	return bbrMinWindowSegments * b.mss
+}
+
+// OnLoss leaves the model and window untouched since BBR does not
+// take loss as a signal of congestion, which is the point of it.
+// Losses only cut a bandwidth probe short
+func (b *BBR) OnLoss(inFlight int, now time.Time) {
+	b.lossSeen = true
+}
+
+// OnTimeout falls back to the minimum window, which
+// then grows back towards the model's target
+func (b *BBR) OnTimeout(inFlight int, now time.Time) {
+	b.priorCwnd = b.cwnd
+	b.cwnd = b.minWindow()
+}
+
+// SetMSS updates the maximum segment size. The window is kept
+// in segments until the model sets it from the bandwidth-delay
+// product, which does not depend on the segment size
+func (b *BBR) SetMSS(mss int) {
+	if mss <= 0 || mss == b.mss {
+		return
+	}
+	if b.bdp(b.cwndGain) == 0 {
+		b.cwnd = rescale(b.cwnd, b.mss, mss)
+		b.priorCwnd = rescale(b.priorCwnd, b.mss, mss)
+	}
+	b.mss = mss
+	if b.cwnd < b.minWindow() {
+		b.cwnd = b.minWindow()
+	}
+}
+
+// Window returns the congestion window in bytes
+func (b *BBR) Window() int {
+	return b.cwnd
+}
+
+// PacingRate returns the bottleneck bandwidth scaled by the current
+// pacing gain. Until a bandwidth sample is taken the initial window
+// is paced out over the smoothed round trip time instead
+func (b *BBR) PacingRate() float64 {
+	bw := b.btlBw.best(b.round)
+	if bw == 0 {
+		return b.rtt.pacingRate(initialWindowSegments*b.mss, bbrHighGain)
+	}
+	return b.pacingGain * bw
+}
+
+// bandwidthFilter keeps the maximum bandwidth sampled
+// over the last bbrBtlBwFilterRounds round trips
+type bandwidthFilter struct {
+	samples [bbrBtlBwFilterRounds]float64
+	rounds  [bbrBtlBwFilterRounds]int
+}
+
+func (f *bandwidthFilter) update(round int, bw float64) {
+	i := round % bbrBtlBwFilterRounds
+	if f.rounds[i] != round {
+		f.rounds[i] = round
+		f.samples[i] = bw
+		return
+	}
+	f.samples[i] = math.Max(f.samples[i], bw)
+}
+
+func (f *bandwidthFilter) best(round int) float64 {
+	best := 0.0
+	for i, bw := range f.samples {
+		if round-f.rounds[i] < bbrBtlBwFilterRounds {
+			best = math.Max(best, bw)
+		}
+	}
+	return best
+}
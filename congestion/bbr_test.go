@@ -0,0 +1,190 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bbrPath is a synthetic path with a fixed bottleneck bandwidth
+// and round trip time, which delivers whatever the controller
+// sends up to the bottleneck bandwidth, one round trip later
+type bbrPath struct {
+	bw        float64 // bytes per second
+	rtt       time.Duration
+	now       time.Time
+	delivered int
+
+	// called after every acknowledgement if set
+	observe func()
+}
+
+// round sends for one round trip at the controller's pacing rate
+// (or a window per round trip if unpaced) and acknowledges the
+// delivered bytes one segment at a time, spread over the round trip
+func (p *bbrPath) round(b *BBR, lossy bool) {
+	rate := b.PacingRate()
+	if rate == 0 || rate > float64(b.Window())/p.rtt.Seconds() {
+		rate = float64(b.Window()) / p.rtt.Seconds()
+	}
+	inFlight := int(rate * p.rtt.Seconds())
+	if rate > p.bw {
+		rate = p.bw
+	}
+	segments := int(rate*p.rtt.Seconds()) / testMSS
+
+	prior := p.delivered
+	start := p.now.Add(p.rtt)
+	for i := 0; i < segments; i++ {
+		p.now = start.Add(p.rtt * time.Duration(i) / time.Duration(segments))
+		if lossy && i%10 == 0 {
+			b.OnLoss(inFlight, p.now)
+			continue
+		}
+		p.delivered += testMSS
+		b.OnAck(AckSample{
+			Acked:          testMSS,
+			InFlight:       inFlight,
+			RTT:            p.rtt,
+			Now:            p.now,
+			Delivered:      p.delivered,
+			PriorDelivered: prior,
+			DeliveryRate:   rate,
+		})
+		inFlight -= testMSS
+		if p.observe != nil {
+			p.observe()
+		}
+	}
+	p.now = start
+}
+
+func newBBRPath() *bbrPath {
+	// 10MB/s over 50ms is a bandwidth-delay product of 500 segments
+	return &bbrPath{bw: 10e6, rtt: 50 * time.Millisecond, now: time.Unix(0, 0)}
+}
+
+func TestBBRStartupAndDrain(t *testing.T) {
+	b := NewBBR(testMSS)
+	p := newBBRPath()
+
+	// no samples yet, so the initial window is paced over the round trip
+	assert.Equal(t, float64(0), b.PacingRate())
+	assert.Equal(t, 10*testMSS, b.Window())
+
+	for i := 0; i < 30 && b.mode == bbrStartup; i++ {
+		p.round(b, false)
+	}
+	assert.True(t, b.filledPipe)
+	assert.NotEqual(t, bbrStartup, b.mode)
+	assert.InDelta(t, p.bw, b.btlBw.best(b.round), 1)
+	assert.Equal(t, p.rtt, b.minRTT)
+
+	// the queue built up during startup is drained
+	// before settling on cycling through the gains
+	for i := 0; i < 5 && b.mode == bbrDrain; i++ {
+		p.round(b, false)
+	}
+	assert.Equal(t, bbrProbeBW, b.mode)
+}
+
+func TestBBRSteadyState(t *testing.T) {
+	b := NewBBR(testMSS)
+	p := newBBRPath()
+	for i := 0; i < 40; i++ {
+		p.round(b, false)
+	}
+	assert.Equal(t, bbrProbeBW, b.mode)
+
+	// about twice the bandwidth-delay product is allowed in flight
+	bdp := int(p.bw * p.rtt.Seconds())
+	assert.InDelta(t, 2*bdp+3*testMSS, b.Window(), float64(testMSS))
+
+	// the pacing rate cycles through the gains around the bandwidth
+	rates := map[float64]bool{}
+	p.observe = func() { rates[b.PacingRate()/p.bw] = true }
+	for i := 0; i < 2*len(bbrPacingGainCycle); i++ {
+		p.round(b, false)
+	}
+	assert.Equal(t, map[float64]bool{1.25: true, 0.75: true, 1: true}, rates)
+}
+
+func TestBBRIgnoresRandomLoss(t *testing.T) {
+	b := NewBBR(testMSS)
+	p := newBBRPath()
+	for i := 0; i < 40; i++ {
+		p.round(b, false)
+	}
+	window := b.Window()
+
+	// one in ten segments is lost on every round trip
+	for i := 0; i < 20; i++ {
+		p.round(b, true)
+	}
+	assert.Equal(t, bbrProbeBW, b.mode)
+	assert.InDelta(t, window, b.Window(), float64(testMSS))
+	assert.Greater(t, b.btlBw.best(b.round), 0.85*p.bw)
+}
+
+func TestBBRProbeRTT(t *testing.T) {
+	b := NewBBR(testMSS)
+	p := newBBRPath()
+	for i := 0; i < 40; i++ {
+		p.round(b, false)
+	}
+	window := b.Window()
+
+	// round trips get slower so the minimum is not refreshed
+	p.rtt = 60 * time.Millisecond
+	for i := 0; i < 200 && b.mode != bbrProbeRTT; i++ {
+		p.round(b, false)
+	}
+	assert.Equal(t, bbrProbeRTT, b.mode)
+	assert.Equal(t, bbrMinWindowSegments*testMSS, b.Window())
+	assert.True(t, p.now.Sub(time.Unix(0, 0)) > bbrMinRTTWindow)
+
+	// the window is restored after a few round trips at the minimum
+	for i := 0; i < 10 && b.mode == bbrProbeRTT; i++ {
+		p.round(b, false)
+	}
+	assert.Equal(t, bbrProbeBW, b.mode)
+	assert.Equal(t, 60*time.Millisecond, b.minRTT)
+	assert.GreaterOrEqual(t, b.Window(), window)
+}
+
+func TestBBRTimeout(t *testing.T) {
+	b := NewBBR(testMSS)
+	p := newBBRPath()
+	for i := 0; i < 40; i++ {
+		p.round(b, false)
+	}
+
+	b.OnTimeout(b.Window(), p.now)
+	assert.Equal(t, bbrMinWindowSegments*testMSS, b.Window())
+
+	// the window grows back to what the model allows
+	for i := 0; i < 10; i++ {
+		p.round(b, false)
+	}
+	bdp := int(p.bw * p.rtt.Seconds())
+	assert.InDelta(t, 2*bdp+3*testMSS, b.Window(), float64(testMSS))
+}
+
+func TestBandwidthFilter(t *testing.T) {
+	var f bandwidthFilter
+	assert.Equal(t, float64(0), f.best(0))
+
+	f.update(1, 100)
+	f.update(1, 50)
+	f.update(2, 80)
+	assert.Equal(t, float64(100), f.best(2))
+
+	// samples older than the filter's window are forgotten
+	assert.Equal(t, float64(100), f.best(10))
+	assert.Equal(t, float64(80), f.best(11))
+	assert.Equal(t, float64(0), f.best(12))
+
+	f.update(11, 90)
+	assert.Equal(t, float64(90), f.best(11))
+}
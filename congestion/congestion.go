@@ -0,0 +1,140 @@
+package congestion
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// AlgorithmNewReno selects NewReno congestion control (RFC 6582)
+	AlgorithmNewReno = "newreno"
+
+	// AlgorithmCUBIC selects CUBIC congestion control (RFC 8312)
+	AlgorithmCUBIC = "cubic"
+
+	// AlgorithmBBR selects BBR congestion control
+	AlgorithmBBR = "bbr"
+
+	// AlgorithmLEDBAT selects LEDBAT, a less-than-best-effort
+	// congestion control for background transfers (RFC 6817)
+	AlgorithmLEDBAT = "ledbat"
+
+	// DefaultAlgorithm is used when no algorithm is selected
+	DefaultAlgorithm = AlgorithmCUBIC
+
+	// initialWindowSegments is the number of maximum size
+	// segments in the initial congestion window (RFC 6928)
+	initialWindowSegments = 10
+
+	// minWindowSegments is the number of maximum size segments
+	// the slow start threshold is never reduced below
+	minWindowSegments = 2
+)
+
+// Controller decides how many bytes a socket may have in
+// flight, and how fast they should be sent, from the
+// acknowledgements and losses the socket observes
+type Controller interface {
+	// OnAck is called whenever new data is acknowledged,
+	// cumulatively or selectively
+	OnAck(s AckSample)
+
+	// OnLoss is called once per round trip in which segments
+	// were deemed lost from selective acknowledgements
+	OnLoss(inFlight int, now time.Time)
+
+	// OnTimeout is called whenever the retransmission timer expires
+	OnTimeout(inFlight int, now time.Time)
+
+	// Window returns the congestion window in bytes
+	Window() int
+
+	// SetMSS updates the maximum segment size, once agreed on
+	// in the handshake or changed by path MTU discovery
+	SetMSS(mss int)
+
+	// PacingRate returns the rate in bytes per second at which
+	// packets should be spaced out, or zero if they need not be
+	PacingRate() float64
+}
+
+// AckSample describes an acknowledgement of new data
+type AckSample struct {
+	Acked      int           // bytes newly acknowledged cumulatively
+	InFlight   int           // bytes in flight before the acknowledgement
+	RTT        time.Duration // round trip time sample, zero if none was taken
+	InRecovery bool          // whether losses are still being repaired
+	Now        time.Time     // time the acknowledgement was received
+
+	// delivery rate sample, counting bytes acknowledged
+	// both cumulatively and selectively as delivered
+	Delivered      int     // bytes delivered over the connection's lifetime
+	PriorDelivered int     // bytes delivered when the latest segment acknowledged was sent
+	DeliveryRate   float64 // bytes per second, zero if no sample was taken
+	AppLimited     bool    // whether the sample was limited by the application
+
+	// one-way delay of the latest segment the remote received, measured
+	// against the remote's clock and therefore only meaningful relative
+	// to other samples, zero if none was taken
+	OneWayDelay time.Duration
+}
+
+// DelayBased is implemented by controllers driven by one-way delay,
+// which is only measured if outbound packets carry timestamps
+type DelayBased interface {
+	Controller
+
+	// TargetDelay returns the queuing delay the controller aims for
+	TargetDelay() time.Duration
+}
+
+// New returns a controller for the given algorithm and maximum segment size
+func New(algorithm string, mss int) (Controller, error) {
+	switch algorithm {
+	case AlgorithmNewReno:
+		return NewNewReno(mss), nil
+	case AlgorithmCUBIC, "":
+		return NewCUBIC(mss), nil
+	case AlgorithmBBR:
+		return NewBBR(mss), nil
+	case AlgorithmLEDBAT:
+		return NewLEDBAT(mss), nil
+	default:
+		return nil, fmt.Errorf("unknown congestion control algorithm %q", algorithm)
+	}
+}
+
+// rescale converts a number of bytes from one maximum segment
+// size to another, keeping the number of segments they make up
+func rescale(bytes, from, to int) int {
+	return int(int64(bytes) * int64(to) / int64(from))
+}
+
+// rttStats keeps the minimum and smoothed round trip times
+type rttStats struct {
+	min      time.Duration
+	smoothed time.Duration
+}
+
+func (r *rttStats) update(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+	if r.min == 0 || rtt < r.min {
+		r.min = rtt
+	}
+	if r.smoothed == 0 {
+		r.smoothed = rtt
+		return
+	}
+	r.smoothed = r.smoothed - r.smoothed/8 + rtt/8
+}
+
+// pacingRate spreads a window over the smoothed round trip time,
+// scaled by gain so that the window can still be filled
+func (r *rttStats) pacingRate(window int, gain float64) float64 {
+	if r.smoothed == 0 {
+		return 0
+	}
+	return gain * float64(window) / r.smoothed.Seconds()
+}
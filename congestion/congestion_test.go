@@ -0,0 +1,78 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMSS = 1000
+
+// ackRound acknowledges a full window one segment at a time
+// as if it all arrived one round trip after time now
+func ackRound(c Controller, now time.Time, rtt time.Duration) time.Time {
+	now = now.Add(rtt)
+	window := c.Window()
+	for acked := 0; acked < window; acked += testMSS {
+		c.OnAck(AckSample{
+			Acked:    testMSS,
+			InFlight: window - acked,
+			RTT:      rtt,
+			Now:      now,
+		})
+	}
+	return now
+}
+
+func TestNew(t *testing.T) {
+	c, err := New(AlgorithmNewReno, testMSS)
+	assert.Nil(t, err)
+	assert.IsType(t, &NewReno{}, c)
+
+	c, err = New(AlgorithmCUBIC, testMSS)
+	assert.Nil(t, err)
+	assert.IsType(t, &CUBIC{}, c)
+
+	c, err = New("", testMSS)
+	assert.Nil(t, err)
+	assert.IsType(t, &CUBIC{}, c)
+
+	_, err = New("vegas", testMSS)
+	assert.NotNil(t, err)
+}
+
+func TestSetMSS(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmNewReno, AlgorithmCUBIC, AlgorithmBBR, AlgorithmLEDBAT} {
+		c, err := New(algorithm, testMSS)
+		assert.Nil(t, err)
+		segments := c.Window() / testMSS
+
+		// the initial window is kept in segments
+		c.SetMSS(testMSS / 2)
+		assert.Equal(t, segments*testMSS/2, c.Window(), algorithm)
+
+		// as is a window collapsed by a timeout
+		c.OnTimeout(c.Window(), time.Now())
+		segments = c.Window() / (testMSS / 2)
+		c.SetMSS(testMSS * 2)
+		assert.Equal(t, segments*testMSS*2, c.Window(), algorithm)
+	}
+}
+
+func TestRTTStats(t *testing.T) {
+	var r rttStats
+	assert.Equal(t, float64(0), r.pacingRate(10*testMSS, 1))
+
+	r.update(100 * time.Millisecond)
+	r.update(0) // no sample
+	assert.Equal(t, 100*time.Millisecond, r.min)
+	assert.Equal(t, 100*time.Millisecond, r.smoothed)
+
+	r.update(20 * time.Millisecond)
+	assert.Equal(t, 20*time.Millisecond, r.min)
+	assert.Equal(t, 90*time.Millisecond, r.smoothed)
+
+	// 10 segments every 90ms, doubled
+	assert.InDelta(t, 2*10*testMSS/0.09, r.pacingRate(10*testMSS, 2), 0.001)
+}
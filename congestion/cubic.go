@@ -0,0 +1,147 @@
+package congestion
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// cubicC scales the cubic window growth function
+	cubicC = 0.4
+
+	// cubicBeta is the multiplicative window decrease on loss
+	cubicBeta = 0.7
+
+	// cubicAlpha is the additive increase of the Reno-friendly window
+	// estimate which makes CUBIC as aggressive as Reno with the same beta
+	cubicAlpha = 3 * (1 - cubicBeta) / (1 + cubicBeta)
+)
+
+// CUBIC grows the window as a cubic function of the time since the last
+// loss, plateauing around the window at which that loss happened and
+// probing beyond it only cautiously, which keeps throughput up on paths
+// with large bandwidth-delay products (RFC 8312)
+type CUBIC struct {
+	mss      int
+	cwnd     float64 // bytes
+	ssthresh float64 // bytes
+
+	wMax   float64   // window before the last reduction, in segments
+	k      float64   // seconds the cubic function takes to reach wMax
+	origin float64   // window the cubic function plateaus at, in segments
+	epoch  time.Time // start of the current congestion avoidance period
+	wEst   float64   // Reno-friendly window estimate, in bytes
+
+	rtt rttStats
+}
+
+// NewCUBIC returns a CUBIC controller for the given maximum segment size
+func NewCUBIC(mss int) *CUBIC {
+	return &CUBIC{
+		mss:      mss,
+		cwnd:     float64(initialWindowSegments * mss),
+		ssthresh: math.MaxFloat64,
+	}
+}
+
+// OnAck grows the window unless losses are being repaired
+func (c *CUBIC) OnAck(s AckSample) {
+	c.rtt.update(s.RTT)
+	if s.InRecovery {
+		return
+	}
+
+	if c.cwnd < c.ssthresh {
+		c.cwnd += float64(s.Acked)
+		return
+	}
+
+	mss := float64(c.mss)
+	if c.epoch.IsZero() {
+		c.epoch = s.Now
+		c.wEst = c.cwnd
+		if w := c.cwnd / mss; w < c.wMax {
+			c.k = math.Cbrt((c.wMax - w) / cubicC)
+			c.origin = c.wMax
+		} else {
+			c.k = 0
+			c.origin = w
+		}
+	}
+
+	// the window is grown towards where the cubic function
+	// will be one round trip from now, but by no more than
+	// half of the window per round trip
+	t := s.Now.Sub(c.epoch).Seconds() + c.rtt.min.Seconds()
+	target := (c.origin + cubicC*math.Pow(t-c.k, 3)) * mss
+	if target > 1.5*c.cwnd {
+		target = 1.5 * c.cwnd
+	}
+	if target > c.cwnd {
+		c.cwnd += (target - c.cwnd) * float64(s.Acked) / c.cwnd
+	}
+
+	// never grow slower than Reno would
+	c.wEst += cubicAlpha * float64(s.Acked) / c.cwnd * mss
+	if c.wEst > c.cwnd {
+		c.cwnd = c.wEst
+	}
+}
+
+// OnLoss reduces the window by a factor of beta. The reduction is based
+// on the window rather than the bytes in flight, as per RFC 8312
+func (c *CUBIC) OnLoss(inFlight int, now time.Time) {
+	c.reduce()
+	c.cwnd = c.ssthresh
+}
+
+// OnTimeout collapses the window to a single segment and
+// slow starts up to the window a loss would have left
+func (c *CUBIC) OnTimeout(inFlight int, now time.Time) {
+	c.reduce()
+	c.cwnd = float64(c.mss)
+}
+
+func (c *CUBIC) reduce() {
+	w := c.cwnd / float64(c.mss)
+
+	// fast convergence: a flow losing before reaching its previous
+	// maximum releases bandwidth for new flows by aiming lower
+	if w < c.wMax {
+		c.wMax = w * (1 + cubicBeta) / 2
+	} else {
+		c.wMax = w
+	}
+
+	c.ssthresh = math.Max(c.cwnd*cubicBeta, float64(minWindowSegments*c.mss))
+	c.epoch = time.Time{}
+}
+
+// SetMSS updates the maximum segment size, keeping the
+// window and slow start threshold in segments
+func (c *CUBIC) SetMSS(mss int) {
+	if mss <= 0 || mss == c.mss {
+		return
+	}
+	from, to := float64(c.mss), float64(mss)
+	c.cwnd = c.cwnd * to / from
+	if c.ssthresh != math.MaxFloat64 {
+		c.ssthresh = c.ssthresh * to / from
+	}
+	c.wEst = c.wEst * to / from
+	c.mss = mss
+}
+
+// Window returns the congestion window in bytes
+func (c *CUBIC) Window() int {
+	return int(c.cwnd)
+}
+
+// PacingRate returns twice the window per round trip in slow start
+// and 1.2 times the window per round trip otherwise, as Linux does
+func (c *CUBIC) PacingRate() float64 {
+	if c.cwnd < c.ssthresh {
+		return c.rtt.pacingRate(int(c.cwnd), 2)
+	}
+	return c.rtt.pacingRate(int(c.cwnd), 1.2)
+}
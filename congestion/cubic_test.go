@@ -0,0 +1,102 @@
+package congestion
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCUBICSlowStart(t *testing.T) {
+	c := NewCUBIC(testMSS)
+	assert.Equal(t, 10*testMSS, c.Window())
+
+	ackRound(c, time.Unix(0, 0), 100*time.Millisecond)
+	assert.Equal(t, 20*testMSS, c.Window())
+}
+
+func TestCUBICLoss(t *testing.T) {
+	c := NewCUBIC(testMSS)
+	c.cwnd = 100 * testMSS
+
+	c.OnLoss(100*testMSS, time.Unix(0, 0))
+	assert.Equal(t, 70*testMSS, c.Window())
+	assert.Equal(t, float64(100), c.wMax)
+
+	// losing again before getting back to wMax
+	// lowers it further (fast convergence)
+	c.OnLoss(70*testMSS, time.Unix(0, 0))
+	assert.Equal(t, 49*testMSS, c.Window())
+	assert.InDelta(t, 70*(1+cubicBeta)/2, c.wMax, 0.001)
+}
+
+func TestCUBICWindowGrowth(t *testing.T) {
+	const rtt = 100 * time.Millisecond
+
+	c := NewCUBIC(testMSS)
+	c.cwnd = 100 * testMSS
+	start := time.Unix(0, 0)
+	c.OnLoss(100*testMSS, start)
+
+	k := time.Duration(math.Cbrt(100*(1-cubicBeta)/cubicC) * float64(time.Second))
+	assert.InDelta(t, 4.217, k.Seconds(), 0.001)
+
+	// concave growth: fast at first, then flattening out below wMax
+	now := ackRound(c, start, rtt)
+	first := c.Window() - 70*testMSS
+	for now.Sub(start) < k/2 {
+		now = ackRound(c, now, rtt)
+	}
+	assert.Less(t, c.Window(), 100*testMSS)
+	previous := c.Window()
+	now = ackRound(c, now, rtt)
+	assert.Less(t, c.Window()-previous, first)
+
+	// plateau around wMax
+	for now.Sub(start) < k {
+		now = ackRound(c, now, rtt)
+	}
+	assert.InDelta(t, 100*testMSS, c.Window(), 2*testMSS)
+
+	// convex growth: probing beyond wMax ever faster
+	for now.Sub(start) < 2*k {
+		now = ackRound(c, now, rtt)
+	}
+	previous = c.Window()
+	now = ackRound(c, now, rtt)
+	growth := c.Window() - previous
+	previous = c.Window()
+	ackRound(c, now, rtt)
+	assert.Greater(t, c.Window()-previous, growth)
+	assert.Greater(t, c.Window(), 110*testMSS)
+}
+
+func TestCUBICRenoFriendly(t *testing.T) {
+	// with a small window and a short round trip the cubic
+	// function grows slower than Reno would, and the window
+	// follows the Reno-friendly estimate instead
+	c := NewCUBIC(testMSS)
+	c.cwnd = 10 * testMSS
+	now := time.Unix(0, 0)
+	c.OnLoss(10*testMSS, now)
+	c.wMax = 7 // as if the window had plateaued
+
+	for i := 0; i < 10; i++ {
+		now = ackRound(c, now, time.Millisecond)
+	}
+	assert.Greater(t, c.Window(), 7*testMSS+5*testMSS/2)
+}
+
+func TestCUBICTimeout(t *testing.T) {
+	c := NewCUBIC(testMSS)
+	c.cwnd = 100 * testMSS
+
+	c.OnTimeout(100*testMSS, time.Unix(0, 0))
+	assert.Equal(t, testMSS, c.Window())
+	assert.Equal(t, float64(70*testMSS), c.ssthresh)
+
+	// no growth while the loss is being repaired
+	c.OnAck(AckSample{Acked: testMSS, InRecovery: true, Now: time.Unix(1, 0)})
+	assert.Equal(t, testMSS, c.Window())
+}
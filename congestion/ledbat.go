@@ -0,0 +1,153 @@
+package congestion
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// ledbatTarget is the queuing delay LEDBAT aims to stay under
+	ledbatTarget = time.Millisecond * 100
+
+	// ledbatGain scales how fast the window reacts to queuing delay
+	ledbatGain = 1
+
+	// ledbatBaseHistory is the number of one minute intervals over
+	// which the base delay is the minimum delay measured
+	ledbatBaseHistory = 10
+
+	// ledbatCurrentFilter is the number of latest delay samples
+	// over which the current delay is the minimum, filtering noise
+	ledbatCurrentFilter = 4
+
+	// ledbatAllowedIncrease is the number of maximum size segments the
+	// window may exceed the bytes in flight by, so that it does not
+	// grow unboundedly while the application is not sending
+	ledbatAllowedIncrease = 1
+
+	// ledbatMinWindowSegments bounds the window from below
+	// and is also the initial window, in maximum size segments
+	ledbatMinWindowSegments = 2
+)
+
+// LEDBAT is a less-than-best-effort congestion controller which keeps the
+// queuing delay it induces under a target, measured as the one-way delay
+// above the minimum seen. It backs off as soon as other traffic builds a
+// queue, well before any loss, so it yields to interactive flows (RFC 6817)
+type LEDBAT struct {
+	mss  int
+	cwnd float64 // bytes
+
+	baseDelays    []time.Duration // minimum delay of each of the last minutes
+	baseRollover  time.Time       // start of the latest minute
+	currentDelays []time.Duration // latest delay samples
+
+	rtt rttStats
+}
+
+// NewLEDBAT returns a LEDBAT controller for the given maximum segment size
+func NewLEDBAT(mss int) *LEDBAT {
+	return &LEDBAT{
+		mss:  mss,
+		cwnd: float64(ledbatMinWindowSegments * mss),
+	}
+}
+
+// OnAck grows the window in proportion to how far the queuing delay is
+// below the target, and shrinks it in proportion to how far it is above
+func (l *LEDBAT) OnAck(s AckSample) {
+	l.rtt.update(s.RTT)
+	if s.OneWayDelay > 0 {
+		l.updateBaseDelay(s.OneWayDelay, s.Now)
+		l.updateCurrentDelay(s.OneWayDelay)
+	}
+	if s.InRecovery || s.Acked == 0 {
+		return
+	}
+
+	// without delay samples the window grows as Reno's would
+	offTarget := 1.0
+	if len(l.currentDelays) > 0 {
+		queuing := l.currentDelay() - l.baseDelay()
+		offTarget = float64(ledbatTarget-queuing) / float64(ledbatTarget)
+	}
+
+	mss := float64(l.mss)
+	l.cwnd += ledbatGain * offTarget * float64(s.Acked) * mss / l.cwnd
+	l.cwnd = math.Min(l.cwnd, float64(s.InFlight)+ledbatAllowedIncrease*mss)
+	l.cwnd = math.Max(l.cwnd, ledbatMinWindowSegments*mss)
+}
+
+func (l *LEDBAT) updateBaseDelay(delay time.Duration, now time.Time) {
+	n := len(l.baseDelays)
+	if n == 0 || now.Sub(l.baseRollover) >= time.Minute {
+		l.baseRollover = now
+		if n == ledbatBaseHistory {
+			l.baseDelays = l.baseDelays[1:]
+		}
+		l.baseDelays = append(l.baseDelays, delay)
+		return
+	}
+	if delay < l.baseDelays[n-1] {
+		l.baseDelays[n-1] = delay
+	}
+}
+
+func (l *LEDBAT) updateCurrentDelay(delay time.Duration) {
+	if len(l.currentDelays) == ledbatCurrentFilter {
+		l.currentDelays = l.currentDelays[1:]
+	}
+	l.currentDelays = append(l.currentDelays, delay)
+}
+
+func (l *LEDBAT) baseDelay() time.Duration {
+	return minDelay(l.baseDelays)
+}
+
+func (l *LEDBAT) currentDelay() time.Duration {
+	return minDelay(l.currentDelays)
+}
+
+func minDelay(delays []time.Duration) time.Duration {
+	min := delays[0]
+	for _, d := range delays[1:] {
+		if d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+// OnLoss halves the window
+func (l *LEDBAT) OnLoss(inFlight int, now time.Time) {
+	l.cwnd = math.Max(l.cwnd/2, float64(ledbatMinWindowSegments*l.mss))
+}
+
+// OnTimeout collapses the window to a single segment
+func (l *LEDBAT) OnTimeout(inFlight int, now time.Time) {
+	l.cwnd = float64(l.mss)
+}
+
+// SetMSS updates the maximum segment size, keeping the window in segments
+func (l *LEDBAT) SetMSS(mss int) {
+	if mss <= 0 || mss == l.mss {
+		return
+	}
+	l.cwnd = l.cwnd * float64(mss) / float64(l.mss)
+	l.mss = mss
+}
+
+// Window returns the congestion window in bytes
+func (l *LEDBAT) Window() int {
+	return int(l.cwnd)
+}
+
+// PacingRate returns zero since LEDBAT does not pace
+func (l *LEDBAT) PacingRate() float64 {
+	return 0
+}
+
+// TargetDelay returns the queuing delay LEDBAT aims to stay under
+func (l *LEDBAT) TargetDelay() time.Duration {
+	return ledbatTarget
+}
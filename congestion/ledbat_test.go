@@ -0,0 +1,143 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ledbatRound acknowledges a full window one segment at a
+// time, each with the given one-way delay
+func ledbatRound(l *LEDBAT, now time.Time, delay time.Duration) time.Time {
+	now = now.Add(100 * time.Millisecond)
+	window := l.Window()
+	for acked := 0; acked < window; acked += testMSS {
+		l.OnAck(AckSample{
+			Acked:       testMSS,
+			InFlight:    window,
+			Now:         now,
+			OneWayDelay: delay,
+		})
+	}
+	return now
+}
+
+func TestLEDBATIsDelayBased(t *testing.T) {
+	c, err := New(AlgorithmLEDBAT, testMSS)
+	assert.Nil(t, err)
+	l, ok := c.(DelayBased)
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, l.TargetDelay())
+
+	c, err = New(AlgorithmCUBIC, testMSS)
+	assert.Nil(t, err)
+	_, ok = c.(DelayBased)
+	assert.False(t, ok)
+}
+
+func TestLEDBATGrowsWithoutQueuing(t *testing.T) {
+	l := NewLEDBAT(testMSS)
+	assert.Equal(t, 2*testMSS, l.Window())
+	assert.Equal(t, float64(0), l.PacingRate())
+
+	// about one segment per round trip with no queuing delay
+	now := time.Unix(0, 0)
+	for i := 0; i < 10; i++ {
+		now = ledbatRound(l, now, 20*time.Millisecond)
+	}
+	assert.InDelta(t, 12*testMSS, l.Window(), testMSS)
+}
+
+func TestLEDBATBacksOffAboveTarget(t *testing.T) {
+	l := NewLEDBAT(testMSS)
+	l.cwnd = 20 * testMSS
+
+	// the base delay is 20ms
+	now := ledbatRound(l, time.Unix(0, 0), 20*time.Millisecond)
+	window := l.Window()
+
+	// other traffic builds up a 200ms queue, twice
+	// the target, so the window shrinks every round
+	for i := 0; i < 5; i++ {
+		now = ledbatRound(l, now, 220*time.Millisecond)
+		assert.Less(t, l.Window(), window)
+		window = l.Window()
+	}
+
+	// but never below the minimum
+	for i := 0; i < 50; i++ {
+		now = ledbatRound(l, now, 220*time.Millisecond)
+	}
+	assert.Equal(t, 2*testMSS, l.Window())
+}
+
+func TestLEDBATHoldsAtTarget(t *testing.T) {
+	l := NewLEDBAT(testMSS)
+	l.cwnd = 20 * testMSS
+
+	// a queuing delay right on target neither grows nor shrinks the window
+	now := ledbatRound(l, time.Unix(0, 0), 20*time.Millisecond)
+	now = ledbatRound(l, now, 120*time.Millisecond)
+	window := l.Window()
+	for i := 0; i < 10; i++ {
+		now = ledbatRound(l, now, 120*time.Millisecond)
+	}
+	assert.Equal(t, window, l.Window())
+}
+
+func TestLEDBATFiltersDelaySpikes(t *testing.T) {
+	l := NewLEDBAT(testMSS)
+	l.cwnd = 20 * testMSS
+	now := ledbatRound(l, time.Unix(0, 0), 20*time.Millisecond)
+
+	// a single delayed acknowledgement is not mistaken for a queue
+	window := l.Window()
+	l.OnAck(AckSample{Acked: testMSS, InFlight: window, Now: now, OneWayDelay: time.Second})
+	assert.Greater(t, l.Window(), window)
+}
+
+func TestLEDBATBaseDelayHistory(t *testing.T) {
+	l := NewLEDBAT(testMSS)
+	now := time.Unix(0, 0)
+
+	// the path changes from a 20ms to a 300ms base delay
+	l.OnAck(AckSample{Now: now, OneWayDelay: 20 * time.Millisecond})
+	for i := 1; i <= ledbatBaseHistory; i++ {
+		now = now.Add(time.Minute)
+		l.OnAck(AckSample{Now: now, OneWayDelay: 300 * time.Millisecond})
+		if i < ledbatBaseHistory {
+			assert.Equal(t, 20*time.Millisecond, l.baseDelay())
+		}
+	}
+
+	// and the old base delay is forgotten after ten minutes
+	assert.Equal(t, 300*time.Millisecond, l.baseDelay())
+	assert.Len(t, l.baseDelays, ledbatBaseHistory)
+}
+
+func TestLEDBATLimitedByFlight(t *testing.T) {
+	l := NewLEDBAT(testMSS)
+	l.cwnd = 10 * testMSS
+
+	// the window does not grow while the application sends little
+	for i := 0; i < 10; i++ {
+		l.OnAck(AckSample{Acked: testMSS, InFlight: 2 * testMSS, Now: time.Unix(0, 0)})
+	}
+	assert.Equal(t, 3*testMSS, l.Window())
+}
+
+func TestLEDBATLossAndTimeout(t *testing.T) {
+	l := NewLEDBAT(testMSS)
+	l.cwnd = 10 * testMSS
+
+	l.OnLoss(10*testMSS, time.Unix(0, 0))
+	assert.Equal(t, 5*testMSS, l.Window())
+
+	l.OnTimeout(5*testMSS, time.Unix(0, 0))
+	assert.Equal(t, testMSS, l.Window())
+
+	// growth resumes from the minimum window
+	l.OnAck(AckSample{Acked: testMSS, InFlight: testMSS, Now: time.Unix(1, 0)})
+	assert.Equal(t, 2*testMSS, l.Window())
+}
@@ -0,0 +1,91 @@
+package congestion
+
+import "time"
+
+// NewReno is the classic loss-based congestion controller: the window
+// doubles every round trip in slow start, grows by one segment every
+// round trip in congestion avoidance, and halves on loss (RFC 5681)
+type NewReno struct {
+	mss      int
+	cwnd     int
+	ssthresh int
+	acked    int // bytes acknowledged towards the next increase
+	rtt      rttStats
+}
+
+// NewNewReno returns a NewReno controller for the given maximum segment size
+func NewNewReno(mss int) *NewReno {
+	return &NewReno{
+		mss:      mss,
+		cwnd:     initialWindowSegments * mss,
+		ssthresh: int(^uint(0) >> 1),
+	}
+}
+
+// OnAck grows the window unless losses are being repaired
+func (r *NewReno) OnAck(s AckSample) {
+	r.rtt.update(s.RTT)
+	if s.InRecovery {
+		return
+	}
+
+	if r.cwnd < r.ssthresh {
+		r.cwnd += s.Acked
+		return
+	}
+
+	r.acked += s.Acked
+	if r.acked >= r.cwnd {
+		r.acked -= r.cwnd
+		r.cwnd += r.mss
+	}
+}
+
+// OnLoss halves the window
+func (r *NewReno) OnLoss(inFlight int, now time.Time) {
+	r.reduce(inFlight)
+	r.cwnd = r.ssthresh
+}
+
+// OnTimeout collapses the window to a single segment
+// and slow starts up to half of what was in flight
+func (r *NewReno) OnTimeout(inFlight int, now time.Time) {
+	r.reduce(inFlight)
+	r.cwnd = r.mss
+}
+
+func (r *NewReno) reduce(inFlight int) {
+	r.ssthresh = inFlight / 2
+	if r.ssthresh < minWindowSegments*r.mss {
+		r.ssthresh = minWindowSegments * r.mss
+	}
+	r.acked = 0
+}
+
+// SetMSS updates the maximum segment size, keeping the
+// window and slow start threshold in segments
+func (r *NewReno) SetMSS(mss int) {
+	if mss <= 0 || mss == r.mss {
+		return
+	}
+	r.cwnd = rescale(r.cwnd, r.mss, mss)
+	if r.ssthresh != int(^uint(0)>>1) {
+		r.ssthresh = rescale(r.ssthresh, r.mss, mss)
+	}
+	r.acked = rescale(r.acked, r.mss, mss)
+	r.mss = mss
+}
+
+// Window returns the congestion window in bytes
+func (r *NewReno) Window() int {
+	return r.cwnd
+}
+
+// PacingRate returns twice the window per round trip in slow start
+// and 1.2 times the window per round trip otherwise, as Linux does
+func (r *NewReno) PacingRate() float64 {
+	if r.cwnd < r.ssthresh {
+		return r.rtt.pacingRate(r.cwnd, 2)
+	}
+	return r.rtt.pacingRate(r.cwnd, 1.2)
+}
@@ -0,0 +1,68 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRenoSlowStart(t *testing.T) {
+	r := NewNewReno(testMSS)
+	assert.Equal(t, 10*testMSS, r.Window())
+
+	// the window doubles every round trip
+	now := time.Unix(0, 0)
+	now = ackRound(r, now, 100*time.Millisecond)
+	assert.Equal(t, 20*testMSS, r.Window())
+	ackRound(r, now, 100*time.Millisecond)
+	assert.Equal(t, 40*testMSS, r.Window())
+}
+
+func TestNewRenoLossAndCongestionAvoidance(t *testing.T) {
+	r := NewNewReno(testMSS)
+	now := time.Unix(0, 0)
+
+	r.OnLoss(30*testMSS, now)
+	assert.Equal(t, 15*testMSS, r.Window())
+
+	// no growth while the loss is being repaired
+	r.OnAck(AckSample{Acked: 5 * testMSS, InRecovery: true, Now: now})
+	assert.Equal(t, 15*testMSS, r.Window())
+
+	// one segment per round trip once out of slow start
+	for i := 1; i <= 3; i++ {
+		now = ackRound(r, now, 100*time.Millisecond)
+		assert.Equal(t, (15+i)*testMSS, r.Window())
+	}
+}
+
+func TestNewRenoTimeout(t *testing.T) {
+	r := NewNewReno(testMSS)
+	now := time.Unix(0, 0)
+
+	r.OnTimeout(12*testMSS, now)
+	assert.Equal(t, testMSS, r.Window())
+	assert.Equal(t, 6*testMSS, r.ssthresh)
+
+	// slow start up to the threshold
+	for r.Window() < r.ssthresh {
+		now = ackRound(r, now, 100*time.Millisecond)
+	}
+	assert.Equal(t, 6*testMSS, r.Window())
+
+	// the threshold never drops below two segments
+	r.OnTimeout(testMSS, now)
+	assert.Equal(t, 2*testMSS, r.ssthresh)
+}
+
+func TestNewRenoPacingRate(t *testing.T) {
+	r := NewNewReno(testMSS)
+	assert.Equal(t, float64(0), r.PacingRate())
+
+	r.OnAck(AckSample{Acked: testMSS, RTT: 100 * time.Millisecond})
+	assert.InDelta(t, 2*11*testMSS/0.1, r.PacingRate(), 0.001)
+
+	r.OnLoss(20*testMSS, time.Unix(0, 0))
+	assert.InDelta(t, 1.2*10*testMSS/0.1, r.PacingRate(), 0.001)
+}
@@ -1,66 +1,81 @@
 package rdtp
 
 import (
-	"fmt"
+	"context"
 	"net"
+	"syscall"
 	"time"
 
+	"github.com/adrianosela/rdtp/mux"
 	"github.com/pkg/errors"
 )
 
+// ErrConnectionRefused is returned by Dial when the remote
+// refuses the connection, e.g. since nothing listens on its port
+var ErrConnectionRefused = errors.New("connection refused")
+
+// ErrConnectionAborted is what reads and writes of a connection return
+// once it aborted rather than closed, e.g. since the remote stopped
+// answering, as told apart with errors.Is. Their errors say why it did
+var ErrConnectionAborted = errors.New("connection aborted")
+
 // Conn is a logical communication channel between the local and remote hosts.
 // Implements the net.Conn interface (https://golang.org/pkg/net/#Conn)
 type Conn struct {
-	laddr *Addr
-	raddr *Addr
-	svc   net.Conn
+	laddr  *Addr
+	raddr  *Addr
+	svc    net.Conn
+	stream *stream
 }
 
 // Dial returns a connection to a remote address
 // where the remote address has a format: ${host}:${port}
-func Dial(address string) (*Conn, error) {
-	svc, err := net.Dial("unix", DefaultRDTPServiceAddr)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not connect to rdtp service")
-	}
+func Dial(address string, opts ...DialOption) (*Conn, error) {
+	return dial(context.Background(), address, newOptions(opts))
+}
 
-	raddr, err := fromString(address)
+// dial requests a connection to a remote address from the rdtp
+// service, giving up on it if the context is done first
+func dial(ctx context.Context, address string, o options) (*Conn, error) {
+	raddr, err := ParseAddr(address)
 	if err != nil {
 		return nil, errors.Wrap(err, "invalid remote rdtp address")
 	}
 
-	req, err := NewClientMessage(ClientMessageTypeDial, nil, raddr)
+	svc, err := o.connect(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not create rdtp dial request")
+		return nil, errors.Wrap(err, "could not connect to rdtp service")
 	}
 
 	// first message out must be the remote rdtp address (e.g. ${host}:${port})
-	if _, err := svc.Write(req); err != nil {
-		return nil, errors.Wrap(err, "could not send address to rdtp service")
-	}
-
-	verifiedLocalAddr, err := waitForServiceMessageOK(svc)
+	verifiedLocalAddr, err := ask(ctx, svc, o.request(ClientMessageTypeDial, o.localAddr, raddr))
 	if err != nil {
 		return nil, errors.Wrap(err, "RDTP Dial error")
 	}
 
 	return &Conn{
-		svc:   svc,
-		laddr: verifiedLocalAddr,
-		raddr: raddr,
+		svc:    svc,
+		stream: newStream(svc, o.clock),
+		laddr:  verifiedLocalAddr,
+		raddr:  raddr,
 	}, nil
 }
 
-// Read reads data from the connection.
+// Read reads data from the connection. Once the connection aborted,
+// it returns an error for which errors.Is(err, ErrConnectionAborted)
+// holds rather than io.EOF
 func (c Conn) Read(b []byte) (n int, err error) {
-	return c.svc.Read(b)
+	return c.stream.Read(b)
 }
 
-// Write writes data to the connection.
+// Write writes data to the connection. Once the connection aborted,
+// it returns an error for which errors.Is(err, ErrConnectionAborted) holds
 func (c Conn) Write(b []byte) (n int, err error) {
 	n, err = c.svc.Write(b)
-	if err != nil && err.Error() == fmt.Sprintf("write unix ->%s: write: broken pipe", DefaultRDTPServiceAddr) {
-		err = errors.New("connection closed by rdtp service")
+	if err != nil && (errors.Is(err, syscall.EPIPE) || errors.Is(err, mux.ErrRemoteClosed)) {
+		if err = c.stream.aborted(); err == nil {
+			err = errors.New("connection closed by rdtp service")
+		}
 	}
 	return
 }
@@ -71,6 +86,28 @@ func (c Conn) Close() error {
 	return c.svc.Close()
 }
 
+// CloseWrite shuts down the writing side of the connection, as
+// net.TCPConn's does: the remote reads EOF once all data written
+// is delivered, while data from the remote can still be read
+func (c Conn) CloseWrite() error {
+	cw, ok := c.svc.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("connection to rdtp service cannot be half-closed")
+	}
+	c.stream.closeWrite()
+	return cw.CloseWrite()
+}
+
+// CloseRead shuts down the reading side of the connection.
+// Data from the remote is discarded from then on
+func (c Conn) CloseRead() error {
+	cr, ok := c.svc.(interface{ CloseRead() error })
+	if !ok {
+		return errors.New("connection to rdtp service cannot be half-closed")
+	}
+	return cr.CloseRead()
+}
+
 // LocalAddr returns the local address for this conn
 func (c Conn) LocalAddr() net.Addr {
 	return c.laddr
@@ -0,0 +1,154 @@
+package rdtp
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+)
+
+// Dialer contains options for connecting to an rdtp address, as
+// net.Dialer does for other networks. The zero value of each field
+// leaves the setting to the rdtp service, as Dial does
+type Dialer struct {
+	// Timeout is the most a dial waits for the connection to be
+	// established, the handshake included. Dials do not time out
+	// if zero, other than by the handshake's own timeout
+	Timeout time.Duration
+
+	// LocalAddr is the local address to dial from. The rdtp service
+	// picks its own IP of the remote's family if no host is given, and
+	// a random port if no port is
+	LocalAddr *Addr
+
+	// ServiceAddr is the unix socket of the rdtp service to dial
+	// through (DefaultRDTPServiceAddr if empty)
+	ServiceAddr string
+
+	// Session is the session to the rdtp service to dial through
+	// (see NewSession), in which case ServiceAddr is not used
+	Session *Session
+
+	// DialService opens the connection to the rdtp service to dial
+	// through (see WithServiceDialer), in which case ServiceAddr is
+	// not used either
+	DialService func(ctx context.Context) (net.Conn, error)
+
+	// Clock is the clock the connection waits on (see WithClock)
+	Clock clock.Clock
+
+	// CongestionControl is the connection's congestion control
+	// algorithm (see WithCongestionControl)
+	CongestionControl string
+
+	// ReceiveBufferSize and SendBufferSize are the bytes the connection
+	// holds for delivery to the application and for retransmission
+	// (see WithReceiveBufferSize and WithSendBufferSize)
+	ReceiveBufferSize int
+	SendBufferSize    int
+
+	// KeepAlive is the period after which the connection probes a
+	// silent remote (see WithKeepAlive). Disabled if zero
+	KeepAlive time.Duration
+}
+
+// Dial returns a connection to a remote address
+// where the remote address has a format: ${host}:${port}
+func (d *Dialer) Dial(address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), address)
+}
+
+// DialContext returns a connection to a remote address as Dial does,
+// giving up on it if the context is done before the connection is
+// established. Once it is, the context no longer affects it
+func (d *Dialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	o := newOptions([]Option{
+		withServiceAddrIfSet(d.ServiceAddr),
+		WithSession(d.Session),
+		WithServiceDialer(d.DialService),
+		WithClock(d.Clock),
+		WithCongestionControl(d.CongestionControl),
+		WithReceiveBufferSize(d.ReceiveBufferSize),
+		WithSendBufferSize(d.SendBufferSize),
+		WithKeepAlive(d.KeepAlive),
+	})
+	o.localAddr = d.LocalAddr
+
+	c, err := dial(ctx, address, o)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ListenConfig contains options for listening on an rdtp address, as
+// net.ListenConfig does for other networks. The settings of connections
+// apply to all those accepted, and are left to the rdtp service if zero
+type ListenConfig struct {
+	// ServiceAddr is the unix socket of the rdtp service to listen
+	// through (DefaultRDTPServiceAddr if empty)
+	ServiceAddr string
+
+	// Session is the session to the rdtp service to listen and accept
+	// through (see NewSession), in which case ServiceAddr is not used
+	Session *Session
+
+	// DialService opens the connections to the rdtp service to listen
+	// and accept through (see WithServiceDialer), in which case
+	// ServiceAddr is not used either
+	DialService func(ctx context.Context) (net.Conn, error)
+
+	// Clock is the clock the connections accepted wait on (see WithClock)
+	Clock clock.Clock
+
+	// CongestionControl is the congestion control algorithm
+	// of the connections accepted (see WithCongestionControl)
+	CongestionControl string
+
+	// ReceiveBufferSize and SendBufferSize are the bytes the connections
+	// accepted hold for delivery to the application and for retransmission
+	// (see WithReceiveBufferSize and WithSendBufferSize)
+	ReceiveBufferSize int
+	SendBufferSize    int
+
+	// KeepAlive is the period after which the connections accepted
+	// probe a silent remote (see WithKeepAlive). Disabled if zero
+	KeepAlive time.Duration
+}
+
+// Listen announces on the local network address, giving up if the
+// context is done before the rdtp service answers. Once it does,
+// the context no longer affects the listener
+func (lc *ListenConfig) Listen(ctx context.Context, address string) (net.Listener, error) {
+	l, err := listen(ctx, address, newOptions([]Option{
+		withServiceAddrIfSet(lc.ServiceAddr),
+		WithSession(lc.Session),
+		WithServiceDialer(lc.DialService),
+		WithClock(lc.Clock),
+		WithCongestionControl(lc.CongestionControl),
+		WithReceiveBufferSize(lc.ReceiveBufferSize),
+		WithSendBufferSize(lc.SendBufferSize),
+		WithKeepAlive(lc.KeepAlive),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// withServiceAddrIfSet is WithServiceAddr for a
+// unix socket which is left to the default if empty
+func withServiceAddrIfSet(addr string) Option {
+	return func(o *options) {
+		if addr != "" {
+			o.serviceAddr = addr
+		}
+	}
+}
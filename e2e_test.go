@@ -0,0 +1,466 @@
+package rdtp_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/network"
+	"github.com/adrianosela/rdtp/service"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// testHost is a simulated host running an rdtp service
+// attached to an in-memory switch
+type testHost struct {
+	ips         []string
+	serviceAddr string
+}
+
+// startHost starts an rdtp service for a host with the given IP addresses,
+// of either or both address families, whose links to the switch are
+// impaired as given, and waits until it accepts clients
+func startHost(t *testing.T, sw *network.Switch, c network.ImpairedConfig, ips ...string) *testHost {
+	h := &testHost{
+		ips:         ips,
+		serviceAddr: filepath.Join(t.TempDir(), "rdtp.sock"),
+	}
+	config := service.Config{ServiceAddr: h.serviceAddr}
+	networks := make(map[bool]network.Network)
+	for _, ip := range ips {
+		port, err := sw.Attach(net.ParseIP(ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(port.Detach)
+
+		ipv4 := port.IP().To4() != nil
+		if ipv4 {
+			config.LocalIP = port.IP()
+		} else {
+			config.LocalIPv6 = port.IP()
+		}
+		networks[ipv4] = network.Impair(port, c)
+	}
+	switch {
+	case networks[true] != nil && networks[false] != nil:
+		config.Network = network.NewDualStack(networks[true], networks[false])
+	case networks[true] != nil:
+		config.Network = networks[true]
+	default:
+		t.Fatal("test hosts need an IPv4 address")
+	}
+
+	svc, err := service.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Close() })
+	go svc.Run()
+
+	for deadline := time.Now().Add(time.Second * 5); ; {
+		if _, err := os.Stat(h.serviceAddr); err == nil {
+			return h
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rdtp service on %s did not start", ips)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.1")
+	server := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.2")
+	transfer(t, client, server, "10.0.0.2:4444", 1<<20)
+}
+
+func TestEndToEndDualStack(t *testing.T) {
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.1", "fd00::1")
+	server := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.2", "fd00::2")
+	transfer(t, client, server, "10.0.0.2:4444", 1<<16)
+	transfer(t, client, server, "[fd00::2]:6666", 1<<16)
+}
+
+func TestEndToEndImpaired(t *testing.T) {
+	impairment := network.Impairment{
+		Loss:      0.02,
+		Delay:     time.Millisecond * 5,
+		Jitter:    time.Millisecond * 2,
+		Reorder:   0.01,
+		Duplicate: 0.01,
+		Corrupt:   0.01,
+	}
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{Outbound: impairment, Seed: 1}, "10.0.0.1")
+	server := startHost(t, sw, network.ImpairedConfig{Outbound: impairment, Seed: 2}, "10.0.0.2")
+	transfer(t, client, server, "10.0.0.2:4444", 1<<18)
+}
+
+func TestEndToEndConnectionRefused(t *testing.T) {
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.1")
+	startHost(t, sw, network.ImpairedConfig{}, "10.0.0.2")
+
+	// nothing listens on the port, so the dial fails
+	// without waiting for the handshake to time out
+	start := time.Now()
+	_, err := rdtp.Dial("10.0.0.2:4444", rdtp.WithServiceAddr(client.serviceAddr))
+	assert.True(t, errors.Is(err, rdtp.ErrConnectionRefused))
+	assert.Contains(t, err.Error(), "connection refused")
+	assert.Less(t, int64(time.Since(start)), int64(time.Millisecond*500))
+}
+
+// transfer connects a client to a server at the given address
+// and checks that data makes it across both ways intact
+func transfer(t *testing.T, client, server *testHost, raddr string, size int) {
+	serverIP, port, err := net.SplitHostPort(raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := rdtp.Listen(":"+port, rdtp.WithServiceAddr(server.serviceAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		assert.Nil(t, err)
+		accepted <- c
+	}()
+
+	conn, err := rdtp.Dial(raddr, rdtp.WithServiceAddr(client.serviceAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.Equal(t, raddr, conn.RemoteAddr().String())
+
+	var peer net.Conn
+	select {
+	case peer = <-accepted:
+		if peer == nil {
+			t.FailNow()
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("connection not accepted")
+	}
+	defer peer.Close()
+
+	request := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(request)
+	go conn.Write(request)
+
+	got := make([]byte, len(request))
+	peer.SetReadDeadline(time.Now().Add(time.Second * 30))
+	_, err = io.ReadFull(peer, got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(request, got), "request corrupted in transit")
+
+	// and back the other way
+	response := []byte("hello from " + serverIP)
+	_, err = peer.Write(response)
+	assert.Nil(t, err)
+
+	got = make([]byte, len(response))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = io.ReadFull(conn, got)
+	assert.Nil(t, err)
+	assert.Equal(t, response, got)
+}
+
+func TestEndToEndUnsupportedVersion(t *testing.T) {
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.1")
+
+	svc, err := net.Dial("unix", client.serviceAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close()
+
+	// clients state the version of the control protocol
+	// they speak, which the service refuses if unknown
+	assert.Nil(t, rdtp.WriteMessage(svc, rdtp.ClientMessage{
+		Version:    rdtp.ControlProtocolVersion + 1,
+		Type:       rdtp.ClientMessageTypeDial,
+		RemoteAddr: rdtp.Addr{Host: "10.0.0.2", Port: 4444},
+	}))
+
+	var msg rdtp.ServiceMessage
+	assert.Nil(t, rdtp.ReadMessage(svc, &msg))
+	assert.Equal(t, rdtp.ServiceMessageTypeError, msg.Type)
+	assert.Equal(t, rdtp.ServiceErrorTypeUnsupportedVersion, msg.Error)
+	assert.Equal(t, rdtp.ControlProtocolVersion, msg.Version)
+}
+
+func TestEndToEndHalfClose(t *testing.T) {
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.1")
+	server := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.2")
+
+	l, err := rdtp.Listen(":4444", rdtp.WithServiceAddr(server.serviceAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server answers each request once it has read all of it
+	served := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(time.Second * 5))
+		request, err := io.ReadAll(c)
+		if err != nil {
+			served <- err
+			return
+		}
+		_, err = c.Write(append([]byte("echo: "), request...))
+		served <- err
+	}()
+
+	conn, err := rdtp.Dial("10.0.0.2:4444", rdtp.WithServiceAddr(client.serviceAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	// the client signals the end of its request by closing
+	// its side of the connection, and still reads the response
+	_, err = conn.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, conn.CloseWrite())
+
+	response, err := io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "echo: hello", string(response))
+	assert.Nil(t, <-served)
+}
+
+func TestEndToEndFullClose(t *testing.T) {
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.1")
+	server := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.2")
+
+	l, err := rdtp.Listen(":4444", rdtp.WithServiceAddr(server.serviceAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+
+	conn, err := rdtp.Dial("10.0.0.2:4444", rdtp.WithServiceAddr(client.serviceAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, ok := <-accepted
+	if !ok {
+		t.Fatal("connection not accepted")
+	}
+	defer peer.Close()
+
+	// the client closes altogether rather than for writing only
+	assert.Nil(t, conn.Close())
+
+	// so the server's writes fail rather than being acknowledged
+	// and dropped, once what it writes reaches the client
+	peer.SetDeadline(time.Now().Add(time.Second * 5))
+	chunk := make([]byte, 1024)
+	for written := 0; ; written += len(chunk) {
+		if _, err = peer.Write(chunk); err != nil {
+			break
+		}
+		if written > 1<<24 {
+			t.Fatal("writes to a closed connection kept succeeding")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.False(t, errors.Is(err, os.ErrDeadlineExceeded), "write did not fail: %s", err)
+
+	// as the client resets the connection, which the server's
+	// writes tell apart from the client closing for writing only
+	assert.True(t, errors.Is(err, rdtp.ErrConnectionAborted), "write did not abort: %s", err)
+}
+
+func TestEndToEndMultiplexed(t *testing.T) {
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.1")
+	server := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.2")
+
+	// listeners, dials and accepts each share a single
+	// connection to their host's rdtp service
+	serverSession, err := rdtp.NewSession(rdtp.WithServiceAddr(server.serviceAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverSession.Close()
+	clientSession, err := rdtp.NewSession(rdtp.WithServiceAddr(client.serviceAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientSession.Close()
+
+	l, err := rdtp.Listen(":4444", rdtp.WithSession(serverSession))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	const conns = 4
+	go func() {
+		for i := 0; i < conns; i++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.SetDeadline(time.Now().Add(time.Second * 5))
+				request, err := io.ReadAll(c)
+				if err != nil {
+					return
+				}
+				c.Write(append([]byte("echo: "), request...))
+			}(c)
+		}
+	}()
+
+	errs := make(chan error, conns)
+	for i := 0; i < conns; i++ {
+		go func(i int) {
+			conn, err := rdtp.Dial("10.0.0.2:4444", rdtp.WithSession(clientSession))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+			request := fmt.Sprintf("hello %d", i)
+			if _, err = conn.Write([]byte(request)); err != nil {
+				errs <- err
+				return
+			}
+			if err = conn.CloseWrite(); err != nil {
+				errs <- err
+				return
+			}
+			response, err := io.ReadAll(conn)
+			if err == nil && string(response) != "echo: "+request {
+				err = fmt.Errorf("unexpected response %q", response)
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < conns; i++ {
+		assert.Nil(t, <-errs)
+	}
+
+	// failed requests leave the session usable
+	_, err = rdtp.Dial("10.0.0.2:5555", rdtp.WithSession(clientSession))
+	assert.True(t, errors.Is(err, rdtp.ErrConnectionRefused))
+
+	// and closing it closes the listeners using it
+	assert.Nil(t, serverSession.Close())
+	_, err = l.Accept()
+	assert.NotNil(t, err)
+}
+
+func TestEndToEndDialer(t *testing.T) {
+	sw := network.NewSwitch()
+	client := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.1")
+	server := startHost(t, sw, network.ImpairedConfig{}, "10.0.0.2")
+
+	lc := rdtp.ListenConfig{
+		ServiceAddr:       server.serviceAddr,
+		CongestionControl: "newreno",
+		ReceiveBufferSize: 1 << 16,
+		KeepAlive:         time.Second,
+	}
+	l, err := lc.Listen(context.Background(), ":4444")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(time.Second * 5))
+		io.Copy(c, c)
+	}()
+
+	// connections are dialed from the local address chosen
+	d := rdtp.Dialer{
+		Timeout:        time.Second * 5,
+		LocalAddr:      &rdtp.Addr{Port: 5000},
+		ServiceAddr:    client.serviceAddr,
+		SendBufferSize: 1 << 14,
+		KeepAlive:      time.Second,
+	}
+	conn, err := d.DialContext(context.Background(), "10.0.0.2:4444")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.Equal(t, "10.0.0.1:5000", conn.LocalAddr().String())
+
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	_, err = conn.Write([]byte("hello"))
+	assert.Nil(t, err)
+	got := make([]byte, 5)
+	_, err = io.ReadFull(conn, got)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	// which must be the rdtp service's
+	d.LocalAddr = &rdtp.Addr{Host: "10.0.0.9"}
+	_, err = d.Dial("10.0.0.2:4444")
+	assert.Contains(t, err.Error(), string(rdtp.ServiceErrorTypeAddressNotAvailable))
+
+	// dials give up once their context is done
+	d.LocalAddr = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = d.DialContext(ctx, "10.0.0.2:4444")
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	// or they time out, e.g. dialing a host which never answers
+	d.Timeout = time.Millisecond * 200
+	start := time.Now()
+	_, err = d.Dial("10.0.0.3:4444")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
@@ -3,8 +3,10 @@ package handshake
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/adrianosela/rdtp/clock"
 	"github.com/adrianosela/rdtp/packet"
 	"github.com/pkg/errors"
 )
@@ -14,10 +16,70 @@ const (
 	flagFmt = "{SYN[%t] ACK[%t] FIN[%t] ERR[%t]}"
 )
 
+var (
+	// ErrConnectionRefused is returned when the remote answers
+	// the SYN with a reset, e.g. since nothing listens on its port
+	ErrConnectionRefused = errors.New("connection refused")
+
+	// ErrConnectionReset is returned when the remote answers
+	// with a reset at any other point of a handshake
+	ErrConnectionReset = errors.New("connection reset by peer")
+)
+
 type ctrlPacketSender func(syn, ack, fin, err bool) error
 
-// InitiateConnection sends a SYN, waits for a SYN ACK, and sends an ACK
-func InitiateConnection(recv chan *packet.Packet, recvTimeout time.Duration, sendCtrl ctrlPacketSender) error {
+// receiver waits for the next packet received from the remote for up
+// to the given duration. It returns false if none is received in time
+type receiver func(timeout time.Duration) (*packet.Packet, bool)
+
+// initializer picks the local initial sequence number, which
+// the SYN or SYN ACK sent next carries, and returns it
+type initializer func() uint32
+
+// synchronizer is given the SYN or SYN ACK received from the remote
+// and the protocol version negotiated, which is to be spoken on
+// the connection from the packets sent next on
+type synchronizer func(remote *packet.Packet, version uint8)
+
+// stepper is given every control packet of a handshake received from
+// the remote, for the connection's state to move on. The handshake
+// fails if the packet is not valid in the connection's state
+type stepper func(remote *packet.Packet) error
+
+// flags are the control flags of a packet
+type flags struct {
+	syn, ack, fin, err bool
+}
+
+var (
+	flagsSYN    = flags{syn: true}
+	flagsSYNACK = flags{syn: true, ack: true}
+	flagsACK    = flags{ack: true}
+	flagsFIN    = flags{fin: true}
+	flagsFINACK = flags{ack: true, fin: true}
+)
+
+func flagsOf(p *packet.Packet) flags {
+	return flags{syn: p.IsSYN(), ack: p.IsACK(), fin: p.IsFIN(), err: p.IsERR()}
+}
+
+func (f flags) String() string {
+	return fmt.Sprintf(flagFmt, f.syn, f.ack, f.fin, f.err)
+}
+
+// InitiateConnection sends a SYN, waits for a SYN ACK, and sends an ACK.
+// The SYN offers the latest protocol version, which the remote answers
+// with the version to speak in its SYN ACK. From version 1 on, the SYN
+// ACK must acknowledge the initial sequence number of the SYN. A reset
+// answering the SYN fails the handshake with ErrConnectionRefused.
+//
+// If the remote is opening the connection at the same time, its SYN
+// arrives instead of the SYN ACK. Both ends then answer each other's
+// SYN with a SYN ACK, and the connection is open once either a SYN ACK
+// or an ACK acknowledging the local SYN is received (simultaneous open)
+func InitiateConnection(recv receiver, clk clock.Clock, recvTimeout time.Duration, sendCtrl ctrlPacketSender, init initializer, sync synchronizer, step stepper) error {
+	isn := init()
+
 	// send SYN
 	if err := sendCtrl(true, false, false, false); err != nil {
 		conditionallyLog(debug, "DIAL: Send SYN [FAIL]: %s", err)
@@ -26,10 +88,31 @@ func InitiateConnection(recv chan *packet.Packet, recvTimeout time.Duration, sen
 	conditionallyLog(debug, "DIAL: Send SYN [OK]")
 
 	// wait for SYN ACK
-	if err := receiveControlPacket(recv, true, true, false, false, recvTimeout); err != nil {
+	synAck, err := receiveControlPacketOf(recv, clk, recvTimeout, flagsSYNACK, flagsSYN)
+	if errors.Is(err, ErrConnectionReset) && (synAck.Version == packet.Version0 || synAck.AckNo == isn) {
+		conditionallyLog(debug, "DIAL: Receive SYN ACK [FAIL]: reset (%s)", synAck.Reset)
+		return errors.Wrapf(ErrConnectionRefused, "connect handshake failed (%s)", synAck.Reset)
+	}
+	if err != nil {
 		conditionallyLog(debug, "DIAL: Receive SYN ACK [FAIL]: %s", err)
 		return errors.Wrap(err, "connect handshake failed when waiting for SYN ACK")
 	}
+	if !synAck.IsACK() {
+		return openSimultaneously(recv, clk, recvTimeout, sendCtrl, isn, synAck, sync, step)
+	}
+	if synAck.Version > packet.MaxVersion {
+		conditionallyLog(debug, "DIAL: Receive SYN ACK [FAIL]: version %d", synAck.Version)
+		return fmt.Errorf("connect handshake failed: remote answered with unsupported protocol version %d", synAck.Version)
+	}
+	if synAck.Version != packet.Version0 && synAck.AckNo != isn {
+		conditionallyLog(debug, "DIAL: Receive SYN ACK [FAIL]: acknowledgement %d", synAck.AckNo)
+		return fmt.Errorf("connect handshake failed: SYN ACK acknowledges %d rather than the SYN", synAck.AckNo)
+	}
+	if err := step(synAck); err != nil {
+		conditionallyLog(debug, "DIAL: Receive SYN ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "connect handshake failed")
+	}
+	sync(synAck, synAck.Version)
 	conditionallyLog(debug, "DIAL: Receive SYN ACK [OK]")
 
 	// send ACK
@@ -42,8 +125,65 @@ func InitiateConnection(recv chan *packet.Packet, recvTimeout time.Duration, sen
 	return nil
 }
 
-// AcceptConnection sends a SYN ACK and waits for an ACK
-func AcceptConnection(recv chan *packet.Packet, recvTimeout time.Duration, sendCtrl ctrlPacketSender) error {
+// openSimultaneously answers the SYN of a remote opening the connection
+// at the same time as the local end with a SYN ACK, and waits for the
+// remote to acknowledge the local SYN, with either a SYN ACK or an ACK
+func openSimultaneously(recv receiver, clk clock.Clock, recvTimeout time.Duration, sendCtrl ctrlPacketSender, isn uint32, syn *packet.Packet, sync synchronizer, step stepper) error {
+	if err := step(syn); err != nil {
+		conditionallyLog(debug, "DIAL: Receive SYN [FAIL]: %s", err)
+		return errors.Wrap(err, "connect handshake failed")
+	}
+	version := negotiateVersion(syn.Version)
+	sync(syn, version)
+	conditionallyLog(debug, "DIAL: Receive SYN [OK]")
+
+	// send SYN ACK
+	if err := sendCtrl(true, true, false, false); err != nil {
+		conditionallyLog(debug, "DIAL: Send SYN ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "connect handshake failed when sending SYN ACK")
+	}
+	conditionallyLog(debug, "DIAL: Send SYN ACK [OK]")
+
+	// wait for SYN ACK
+	ack, err := receiveControlPacketOf(recv, clk, recvTimeout, flagsSYNACK, flagsACK)
+	if err != nil {
+		conditionallyLog(debug, "DIAL: Receive SYN ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "connect handshake failed when waiting for SYN ACK")
+	}
+	if version != packet.Version0 && ack.AckNo != isn {
+		conditionallyLog(debug, "DIAL: Receive SYN ACK [FAIL]: acknowledgement %d", ack.AckNo)
+		return fmt.Errorf("connect handshake failed: SYN ACK acknowledges %d rather than the SYN", ack.AckNo)
+	}
+	if err := step(ack); err != nil {
+		conditionallyLog(debug, "DIAL: Receive SYN ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "connect handshake failed")
+	}
+	conditionallyLog(debug, "DIAL: Receive SYN ACK [OK]")
+
+	return nil
+}
+
+// AcceptConnection waits for a SYN, sends a SYN ACK and waits for an ACK.
+// The SYN ACK answers the SYN with the latest protocol version both
+// ends speak, which is the version spoken on the connection. From
+// version 1 on, the ACK must acknowledge the initial sequence
+// number of the SYN ACK
+func AcceptConnection(recv receiver, clk clock.Clock, recvTimeout time.Duration, sendCtrl ctrlPacketSender, init initializer, sync synchronizer, step stepper) error {
+	// wait for SYN
+	syn, err := receiveControlPacket(recv, true, false, false, false, clk, recvTimeout)
+	if err != nil {
+		conditionallyLog(debug, "ACCEPT: Receive SYN [FAIL]: %s", err)
+		return errors.Wrap(err, "connect handshake failed when waiting for SYN")
+	}
+	if err := step(syn); err != nil {
+		conditionallyLog(debug, "ACCEPT: Receive SYN [FAIL]: %s", err)
+		return errors.Wrap(err, "connect handshake failed")
+	}
+	version := negotiateVersion(syn.Version)
+	sync(syn, version)
+	isn := init()
+	conditionallyLog(debug, "ACCEPT: Receive SYN [OK]")
+
 	// send SYN ACK
 	if err := sendCtrl(true, true, false, false); err != nil {
 		conditionallyLog(debug, "ACCEPT: Send SYN ACK [FAIL]: %s", err)
@@ -52,17 +192,29 @@ func AcceptConnection(recv chan *packet.Packet, recvTimeout time.Duration, sendC
 	conditionallyLog(debug, "ACCEPT: Send SYN ACK [OK]")
 
 	// wait for ACK
-	if err := receiveControlPacket(recv, false, true, false, false, recvTimeout); err != nil {
+	ack, err := receiveControlPacket(recv, false, true, false, false, clk, recvTimeout)
+	if err != nil {
 		conditionallyLog(debug, "ACCEPT: Receive ACK [FAIL]: %s", err)
 		return errors.Wrap(err, "connect handshake failed when waiting for ACK")
 	}
+	if version != packet.Version0 && ack.AckNo != isn {
+		conditionallyLog(debug, "ACCEPT: Receive ACK [FAIL]: acknowledgement %d", ack.AckNo)
+		return fmt.Errorf("connect handshake failed: ACK acknowledges %d rather than the SYN ACK", ack.AckNo)
+	}
+	if err := step(ack); err != nil {
+		conditionallyLog(debug, "ACCEPT: Receive ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "connect handshake failed")
+	}
 	conditionallyLog(debug, "ACCEPT: Receive ACK [OK]")
 
 	return nil
 }
 
-// InitiateDisconnection sends a FIN, waits for a FIN ACK, and sends an ACK
-func InitiateDisconnection(recv chan *packet.Packet, recvTimeout time.Duration, sendCtrl ctrlPacketSender) error {
+// InitiateDisconnection sends a FIN, waits for a FIN ACK, and sends an ACK.
+// If the remote is closing the connection at the same time, its FIN
+// arrives instead of the FIN ACK. Both ends then acknowledge each
+// other's FIN with an ACK, and wait for theirs (simultaneous close)
+func InitiateDisconnection(recv receiver, clk clock.Clock, recvTimeout time.Duration, sendCtrl ctrlPacketSender, step stepper) error {
 	// SEND FIN
 	if err := sendCtrl(false, false, true, false); err != nil {
 		conditionallyLog(debug, "FINISH (closed by local): Send FIN [FAIL]: %s", err)
@@ -71,10 +223,15 @@ func InitiateDisconnection(recv chan *packet.Packet, recvTimeout time.Duration,
 	conditionallyLog(debug, "FINISH (closed by local): Send FIN [OK]")
 
 	// wait for FIN ACK
-	if err := receiveControlPacket(recv, false, true, true, false, recvTimeout); err != nil {
+	finAck, err := receiveControlPacketOf(recv, clk, recvTimeout, flagsFINACK, flagsFIN)
+	if err != nil {
 		conditionallyLog(debug, "FINISH (closed by local): Receive FIN ACK [FAIL]: %s", err)
 		return errors.Wrap(err, "finish handshake failed when waiting for FIN ACK")
 	}
+	if err := step(finAck); err != nil {
+		conditionallyLog(debug, "FINISH (closed by local): Receive FIN ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "finish handshake failed")
+	}
 	conditionallyLog(debug, "FINISH (closed by local): Receive FIN ACK [OK]")
 
 	// send ACK
@@ -84,11 +241,27 @@ func InitiateDisconnection(recv chan *packet.Packet, recvTimeout time.Duration,
 	}
 	conditionallyLog(debug, "FINISH (closed by local): Send ACK [OK]")
 
+	if finAck.IsACK() {
+		return nil
+	}
+
+	// wait for ACK of the FIN, closing simultaneously
+	ack, err := receiveControlPacket(recv, false, true, false, false, clk, recvTimeout)
+	if err != nil {
+		conditionallyLog(debug, "FINISH (closed by both): Receive ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "finish handshake failed when waiting for ACK")
+	}
+	if err := step(ack); err != nil {
+		conditionallyLog(debug, "FINISH (closed by both): Receive ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "finish handshake failed")
+	}
+	conditionallyLog(debug, "FINISH (closed by both): Receive ACK [OK]")
+
 	return nil
 }
 
 // AcceptDisconnection sends a FIN ACK and waits for an ACK
-func AcceptDisconnection(recv chan *packet.Packet, recvTimeout time.Duration, sendCtrl ctrlPacketSender) error {
+func AcceptDisconnection(recv receiver, clk clock.Clock, recvTimeout time.Duration, sendCtrl ctrlPacketSender, step stepper) error {
 	// send FIN ACK
 	if err := sendCtrl(false, true, true, false); err != nil {
 		conditionallyLog(debug, "FINISH (closed by remote): Send FIN ACK [FAIL]: %s", err)
@@ -97,30 +270,66 @@ func AcceptDisconnection(recv chan *packet.Packet, recvTimeout time.Duration, se
 	conditionallyLog(debug, "FINISH (closed by remote): Send FIN ACK [OK]")
 
 	// wait for ACK
-	if err := receiveControlPacket(recv, false, true, false, false, recvTimeout); err != nil {
+	ack, err := receiveControlPacket(recv, false, true, false, false, clk, recvTimeout)
+	if err != nil {
 		conditionallyLog(debug, "FINISH (closed by remote): Receive ACK [FAIL]: %s", err)
 		return errors.Wrap(err, "finish handshake failed when waiting for ACK")
 	}
+	if err := step(ack); err != nil {
+		conditionallyLog(debug, "FINISH (closed by remote): Receive ACK [FAIL]: %s", err)
+		return errors.Wrap(err, "finish handshake failed")
+	}
 	conditionallyLog(debug, "FINISH (closed by remote): Receive ACK [OK]")
 
 	return nil
 }
 
-// receiveControlPacket blocks until the a packet is received (or timeout)
-func receiveControlPacket(in chan *packet.Packet, syn, ack, fin, err bool, recvTimeout time.Duration) error {
+// negotiateVersion returns the protocol version of a connection whose
+// initiator offered the given version: the latest both ends speak
+func negotiateVersion(offered uint8) uint8 {
+	if offered > packet.MaxVersion {
+		return packet.MaxVersion
+	}
+	return offered
+}
+
+// receiveControlPacket blocks until the a packet is received (or timeout).
+// An unexpected reset is returned along with ErrConnectionReset
+func receiveControlPacket(recv receiver, syn, ack, fin, err bool, clk clock.Clock, recvTimeout time.Duration) (*packet.Packet, error) {
+	return receiveControlPacketOf(recv, clk, recvTimeout, flags{syn: syn, ack: ack, fin: fin, err: err})
+}
+
+// receiveControlPacketOf blocks until a packet with any of the given
+// flags is received (or timeout). Data and acknowledgements of data,
+// which may still be in flight, are skipped unless an ACK is expected.
+// An unexpected reset is returned along with ErrConnectionReset
+func receiveControlPacketOf(recv receiver, clk clock.Clock, recvTimeout time.Duration, expected ...flags) (*packet.Packet, error) {
+	deadline := clk.Now().Add(recvTimeout)
 	for {
-		select {
-		case p := <-in:
-			if syn != p.IsSYN() || ack != p.IsACK() || fin != p.IsFIN() || err != p.IsERR() {
-				return fmt.Errorf(
-					"expected packet with flags %s but got %s",
-					fmt.Sprintf(flagFmt, syn, ack, fin, err),
-					fmt.Sprintf(flagFmt, p.IsSYN(), p.IsACK(), p.IsFIN(), p.IsERR()))
+		p, ok := recv(deadline.Sub(clk.Now()))
+		if !ok {
+			return nil, errors.New("operation timed out")
+		}
+		got := flagsOf(p)
+		resetExpected := false
+		expectedStrs := make([]string, len(expected))
+		for i, f := range expected {
+			if got == f {
+				return p, nil
 			}
-			return nil
-		case <-time.After(recvTimeout):
-			return errors.New("operation timed out")
+			resetExpected = resetExpected || f.err
+			expectedStrs[i] = f.String()
+		}
+		if got.err && !resetExpected {
+			return p, ErrConnectionReset
+		}
+		if got == flagsACK || got == (flags{}) {
+			continue
 		}
+		return nil, fmt.Errorf(
+			"expected packet with flags %s but got %s",
+			strings.Join(expectedStrs, " or "),
+			got)
 	}
 }
 
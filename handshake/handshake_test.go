@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/adrianosela/rdtp/clock"
 	"github.com/adrianosela/rdtp/packet"
 	"github.com/stretchr/testify/assert"
 )
@@ -57,7 +58,7 @@ func TestInitiateConnectionOK(t *testing.T) {
 		assert.False(t, p.IsFIN())
 		assert.False(t, p.IsERR())
 
-		// send SYN ACK
+		// send SYN ACK, answering with version 0
 		local <- mockControlPacket(true, true, false, false)
 
 		// wait for ACK
@@ -68,20 +69,26 @@ func TestInitiateConnectionOK(t *testing.T) {
 		assert.False(t, p.IsERR())
 	}()
 
-	err := InitiateConnection(local, time.Millisecond*1, func(syn, ack, fin, err bool) error {
+	var synced *packet.Packet
+	version := packet.MaxVersion
+	err := InitiateConnection(fromChan(local), clock.Real{}, time.Millisecond*1, func(syn, ack, fin, err bool) error {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		return nil
-	})
+	}, noinit, func(p *packet.Packet, v uint8) {
+		synced, version = p, v
+	}, nostep)
 	assert.Nil(t, err)
+	assert.True(t, synced.IsSYN() && synced.IsACK())
+	assert.Equal(t, packet.Version0, version)
 
 	// let mock remote go routine complete
 	time.Sleep(time.Millisecond * 1)
 }
 
 func TestInitiateConnectionSendSynError(t *testing.T) {
-	err := InitiateConnection(make(chan *packet.Packet), recvTimeout, func(syn, ack, fin, err bool) error {
+	err := InitiateConnection(fromChan(make(chan *packet.Packet)), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		return errMock
-	})
+	}, noinit, nosync, nostep)
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), fmt.Sprintf("connect handshake failed when sending SYN: %s", errMock))
 }
@@ -102,10 +109,10 @@ func TestInitiateConnectionWaitForSynAckError(t *testing.T) {
 		// don't send SYN ACK (let it time out)
 	}()
 
-	err := InitiateConnection(local, recvTimeout, func(syn, ack, fin, err bool) error {
+	err := InitiateConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		return nil
-	})
+	}, noinit, nosync, nostep)
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), "connect handshake failed when waiting for SYN ACK: operation timed out")
 }
@@ -131,7 +138,7 @@ func TestInitiateConnectionSendAckError(t *testing.T) {
 
 	sendInvocations := 0
 
-	err := InitiateConnection(local, recvTimeout, func(syn, ack, fin, err bool) error {
+	err := InitiateConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		if sendInvocations > 0 {
 			return errMock
 		}
@@ -139,48 +146,197 @@ func TestInitiateConnectionSendAckError(t *testing.T) {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		sendInvocations++
 		return nil
-	})
+	}, noinit, nosync, nostep)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), fmt.Sprintf("connect handshake failed when sending ACK: %s", errMock))
 }
 
-func TestAcceptConnectionOK(t *testing.T) {
-	local := make(chan *packet.Packet)
-	remote := make(chan *packet.Packet)
+func TestInitiateConnectionUnsupportedVersion(t *testing.T) {
+	local := make(chan *packet.Packet, 1)
 
-	// mock remote operation
-	go func() {
-		// AcceptConnection is only invoked upon receiving
-		// a SYN packet so no need to send one here
+	// the remote answers with a version later than offered
+	synAck := mockControlPacket(true, true, false, false)
+	synAck.Version = packet.MaxVersion + 1
+	local <- synAck
 
-		// wait for SYN ACK
-		p := <-remote
-		assert.True(t, p.IsSYN())
-		assert.True(t, p.IsACK())
-		assert.False(t, p.IsFIN())
-		assert.False(t, p.IsERR())
+	err := InitiateConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return nil
+	}, noinit, nosync, nostep)
+	assert.NotNil(t, err)
+	assert.Equal(t, err.Error(), fmt.Sprintf(
+		"connect handshake failed: remote answered with unsupported protocol version %d", packet.MaxVersion+1))
+}
 
-		// send ACK
-		local <- mockControlPacket(false, true, false, false)
-	}()
+func TestInitiateConnectionUnacknowledgedSYN(t *testing.T) {
+	local := make(chan *packet.Packet, 1)
 
-	err := AcceptConnection(local, recvTimeout, func(syn, ack, fin, err bool) error {
-		remote <- mockControlPacket(syn, ack, fin, err)
+	// a SYN ACK which does not acknowledge the SYN's sequence number
+	synAck := mockControlPacket(true, true, false, false)
+	synAck.Version = packet.MaxVersion
+	synAck.SetAckNo(41)
+	local <- synAck
+
+	err := InitiateConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return nil
+	}, func() uint32 { return 42 }, nosync, nostep)
+	assert.NotNil(t, err)
+	assert.Equal(t, err.Error(), "connect handshake failed: SYN ACK acknowledges 41 rather than the SYN")
+}
+
+func TestAcceptConnectionUnacknowledgedSYNAck(t *testing.T) {
+	local := make(chan *packet.Packet, 2)
+
+	syn := mockControlPacket(true, false, false, false)
+	syn.Version = packet.MaxVersion
+	local <- syn
+
+	// an ACK which does not acknowledge the SYN ACK's sequence number
+	ack := mockControlPacket(false, true, false, false)
+	ack.SetAckNo(41)
+	local <- ack
+
+	err := AcceptConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return nil
+	}, func() uint32 { return 42 }, nosync, nostep)
+	assert.NotNil(t, err)
+	assert.Equal(t, err.Error(), "connect handshake failed: ACK acknowledges 41 rather than the SYN ACK")
+}
+
+func TestInitiateConnectionRefused(t *testing.T) {
+	local := make(chan *packet.Packet, 1)
+
+	// the remote answers the SYN with a reset
+	syn := mockControlPacket(true, false, false, false)
+	syn.Version = packet.MaxVersion
+	syn.SetSeqNo(42)
+	local <- packet.NewReset(syn, packet.ResetNoListener)
+
+	err := InitiateConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return nil
+	}, func() uint32 { return 42 }, nosync, nostep)
+	assert.True(t, errors.Is(err, ErrConnectionRefused))
+	assert.Equal(t, err.Error(), "connect handshake failed (no listener): connection refused")
+
+	// unless the reset does not acknowledge the SYN
+	local <- packet.NewReset(syn, packet.ResetNoListener)
+	err = InitiateConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return nil
+	}, func() uint32 { return 41 }, nosync, nostep)
+	assert.False(t, errors.Is(err, ErrConnectionRefused))
+	assert.Equal(t, err.Error(), "connect handshake failed when waiting for SYN ACK: connection reset by peer")
+}
+
+func TestInitiateConnectionSimultaneousOpen(t *testing.T) {
+	local := make(chan *packet.Packet, 2)
+
+	// the remote's SYN crosses the local SYN
+	syn := mockControlPacket(true, false, false, false)
+	syn.Version = packet.MaxVersion
+	syn.SetSeqNo(7)
+	local <- syn
+
+	// and its SYN ACK acknowledges the local SYN
+	synAck := mockControlPacket(true, true, false, false)
+	synAck.Version = packet.MaxVersion
+	synAck.SetAckNo(42)
+	local <- synAck
+
+	var sent, stepped []string
+	var synced *packet.Packet
+	err := InitiateConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		sent = append(sent, flags{syn: syn, ack: ack, fin: fin, err: err}.String())
+		return nil
+	}, func() uint32 { return 42 }, func(p *packet.Packet, v uint8) {
+		synced = p
+	}, func(p *packet.Packet) error {
+		stepped = append(stepped, flagsOf(p).String())
 		return nil
 	})
 	assert.Nil(t, err)
+	assert.Equal(t, syn, synced)
+
+	// both ends answer the other's SYN with a SYN ACK
+	assert.Equal(t, []string{flagsSYN.String(), flagsSYNACK.String()}, sent)
+	assert.Equal(t, []string{flagsSYN.String(), flagsSYNACK.String()}, stepped)
 }
 
-func TestAcceptConnectionSendSynAckError(t *testing.T) {
-	err := AcceptConnection(make(chan *packet.Packet), recvTimeout, func(syn, ack, fin, err bool) error {
+func TestInitiateConnectionStepError(t *testing.T) {
+	local := make(chan *packet.Packet, 1)
+	local <- mockControlPacket(true, true, false, false)
+
+	err := InitiateConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return nil
+	}, noinit, nosync, func(p *packet.Packet) error {
 		return errMock
 	})
 	assert.NotNil(t, err)
+	assert.Equal(t, err.Error(), fmt.Sprintf("connect handshake failed: %s", errMock))
+}
+
+func TestAcceptConnectionOK(t *testing.T) {
+	for offered, negotiated := range map[uint8]uint8{
+		packet.Version0:       packet.Version0,
+		packet.MaxVersion:     packet.MaxVersion,
+		packet.MaxVersion + 1: packet.MaxVersion,
+	} {
+		local := make(chan *packet.Packet, 1)
+		remote := make(chan *packet.Packet)
+
+		// the SYN was received before AcceptConnection is invoked
+		syn := mockControlPacket(true, false, false, false)
+		syn.Version = offered
+		local <- syn
+
+		// mock remote operation
+		go func() {
+			// wait for SYN ACK
+			p := <-remote
+			assert.True(t, p.IsSYN())
+			assert.True(t, p.IsACK())
+			assert.False(t, p.IsFIN())
+			assert.False(t, p.IsERR())
+
+			// send ACK
+			local <- mockControlPacket(false, true, false, false)
+		}()
+
+		var synced *packet.Packet
+		var version uint8
+		err := AcceptConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+			remote <- mockControlPacket(syn, ack, fin, err)
+			return nil
+		}, noinit, func(p *packet.Packet, v uint8) {
+			synced, version = p, v
+		}, nostep)
+		assert.Nil(t, err)
+		assert.Equal(t, syn, synced)
+		assert.Equal(t, negotiated, version)
+	}
+}
+
+func TestAcceptConnectionWaitForSynError(t *testing.T) {
+	err := AcceptConnection(fromChan(make(chan *packet.Packet)), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return nil
+	}, noinit, nosync, nostep)
+	assert.NotNil(t, err)
+	assert.Equal(t, err.Error(), "connect handshake failed when waiting for SYN: operation timed out")
+}
+
+func TestAcceptConnectionSendSynAckError(t *testing.T) {
+	local := make(chan *packet.Packet, 1)
+	local <- mockControlPacket(true, false, false, false)
+
+	err := AcceptConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return errMock
+	}, noinit, nosync, nostep)
+	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), fmt.Sprintf("connect handshake failed when sending SYN ACK: %s", errMock))
 }
 
 func TestAcceptConnectionWaitForAckError(t *testing.T) {
+	local := make(chan *packet.Packet, 1)
+	local <- mockControlPacket(true, false, false, false)
 	remote := make(chan *packet.Packet)
 
 	// mock remote operation
@@ -195,10 +351,10 @@ func TestAcceptConnectionWaitForAckError(t *testing.T) {
 		// don't send ACK (let it time out)
 	}()
 
-	err := AcceptConnection(make(chan *packet.Packet), recvTimeout, func(syn, ack, fin, err bool) error {
+	err := AcceptConnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		return nil
-	})
+	}, noinit, nosync, nostep)
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), "connect handshake failed when waiting for ACK: operation timed out")
 }
@@ -227,10 +383,10 @@ func TestInitiateDisconnectionOK(t *testing.T) {
 		assert.False(t, p.IsERR())
 	}()
 
-	err := InitiateDisconnection(local, recvTimeout, func(syn, ack, fin, err bool) error {
+	err := InitiateDisconnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		return nil
-	})
+	}, nostep)
 	assert.Nil(t, err)
 
 	// let mock remote go routine complete
@@ -238,9 +394,9 @@ func TestInitiateDisconnectionOK(t *testing.T) {
 }
 
 func TestInitiateDisconnectionSendFinError(t *testing.T) {
-	err := InitiateDisconnection(make(chan *packet.Packet), recvTimeout, func(syn, ack, fin, err bool) error {
+	err := InitiateDisconnection(fromChan(make(chan *packet.Packet)), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		return errMock
-	})
+	}, nostep)
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), fmt.Sprintf("finish handshake failed when sending FIN: %s", errMock))
 }
@@ -261,10 +417,10 @@ func TestInitiateDisconnectionWaitForFinAckError(t *testing.T) {
 		// don't send FIN ACK (let it time out)
 	}()
 
-	err := InitiateDisconnection(local, recvTimeout, func(syn, ack, fin, err bool) error {
+	err := InitiateDisconnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		return nil
-	})
+	}, nostep)
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), "finish handshake failed when waiting for FIN ACK: operation timed out")
 }
@@ -290,7 +446,7 @@ func TestInitiateDisconnectionSendAckError(t *testing.T) {
 
 	sendInvocations := 0
 
-	err := InitiateDisconnection(local, recvTimeout, func(syn, ack, fin, err bool) error {
+	err := InitiateDisconnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		if sendInvocations > 0 {
 			return errMock
 		}
@@ -298,12 +454,52 @@ func TestInitiateDisconnectionSendAckError(t *testing.T) {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		sendInvocations++
 		return nil
-	})
+	}, nostep)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), fmt.Sprintf("finish handshake failed when sending ACK: %s", errMock))
 }
 
+func TestInitiateDisconnectionSimultaneousClose(t *testing.T) {
+	local := make(chan *packet.Packet, 2)
+
+	// the remote's FIN crosses the local FIN, and
+	// each end acknowledges the other's
+	local <- mockControlPacket(false, false, true, false)
+	local <- mockControlPacket(false, true, false, false)
+
+	var sent, stepped []string
+	err := InitiateDisconnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		sent = append(sent, flags{syn: syn, ack: ack, fin: fin, err: err}.String())
+		return nil
+	}, func(p *packet.Packet) error {
+		stepped = append(stepped, flagsOf(p).String())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{flagsFIN.String(), flagsACK.String()}, sent)
+	assert.Equal(t, []string{flagsFIN.String(), flagsACK.String()}, stepped)
+}
+
+func TestInitiateDisconnectionSkipsData(t *testing.T) {
+	local := make(chan *packet.Packet, 3)
+
+	// acknowledgements of data still in flight precede the FIN ACK
+	local <- mockControlPacket(false, true, false, false)
+	local <- mockControlPacket(false, true, false, false)
+	local <- mockControlPacket(false, true, true, false)
+
+	var stepped []string
+	err := InitiateDisconnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
+		return nil
+	}, func(p *packet.Packet) error {
+		stepped = append(stepped, flagsOf(p).String())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{flagsFINACK.String()}, stepped)
+}
+
 func TestAcceptDisconnectionOK(t *testing.T) {
 	local := make(chan *packet.Packet)
 	remote := make(chan *packet.Packet)
@@ -324,17 +520,17 @@ func TestAcceptDisconnectionOK(t *testing.T) {
 		local <- mockControlPacket(false, true, false, false)
 	}()
 
-	err := AcceptDisconnection(local, recvTimeout, func(syn, ack, fin, err bool) error {
+	err := AcceptDisconnection(fromChan(local), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		return nil
-	})
+	}, nostep)
 	assert.Nil(t, err)
 }
 
 func TestAcceptDisconnectionSendFinAckError(t *testing.T) {
-	err := AcceptDisconnection(make(chan *packet.Packet), recvTimeout, func(syn, ack, fin, err bool) error {
+	err := AcceptDisconnection(fromChan(make(chan *packet.Packet)), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		return errMock
-	})
+	}, nostep)
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), fmt.Sprintf("finish handshake failed when sending FIN ACK: %s", errMock))
 }
@@ -354,10 +550,10 @@ func TestAcceptDisconnectionWaitForAckError(t *testing.T) {
 		// don't send ACK (let it time out)
 	}()
 
-	err := AcceptDisconnection(make(chan *packet.Packet), recvTimeout, func(syn, ack, fin, err bool) error {
+	err := AcceptDisconnection(fromChan(make(chan *packet.Packet)), clock.Real{}, recvTimeout, func(syn, ack, fin, err bool) error {
 		remote <- mockControlPacket(syn, ack, fin, err)
 		return nil
-	})
+	}, nostep)
 	assert.NotNil(t, err)
 	assert.Equal(t, err.Error(), "finish handshake failed when waiting for ACK: operation timed out")
 }
@@ -368,21 +564,22 @@ func TestReceiveControlPacketOK(t *testing.T) {
 		go func() {
 			recvChan <- mockControlPacket(comb.syn, comb.ack, comb.fin, comb.err)
 		}()
-		err := receiveControlPacket(
-			recvChan,
+		p, err := receiveControlPacket(
+			func(time.Duration) (*packet.Packet, bool) { return <-recvChan, true },
 			comb.syn, comb.ack, comb.fin, comb.err,
-			time.Millisecond*1 /* no network inbetween -- use short timeout */)
+			clock.NewSimulated(time.Time{}), time.Millisecond*1 /* simulated time never runs out */)
 		assert.Nil(t, err)
+		assert.NotNil(t, p)
 	}
 }
 
 func TestReceiveControlPacketErrorTimeout(t *testing.T) {
 	for _, comb := range flagCombinations {
 		recvChan := make(chan *packet.Packet)
-		err := receiveControlPacket(
-			recvChan,
+		_, err := receiveControlPacket(
+			fromChan(recvChan),
 			comb.syn, comb.ack, comb.fin, comb.err,
-			time.Nanosecond*1 /* no network inbetween -- use short timeout */)
+			clock.Real{}, time.Nanosecond*1 /* no network inbetween -- use short timeout */)
 		assert.NotNil(t, err)
 		assert.Equal(t, err.Error(), "operation timed out")
 	}
@@ -397,9 +594,15 @@ func TestReceiveControlPacketErrorUnexpectedPacket(t *testing.T) {
 				recvChan <- mockControlPacket(get.syn, get.ack, get.fin, get.err)
 			}()
 
-			err := receiveControlPacket(recvChan, expect.syn, expect.ack, expect.fin, expect.err, time.Millisecond*1)
+			_, err := receiveControlPacket(fromChan(recvChan), expect.syn, expect.ack, expect.fin, expect.err, clock.Real{}, time.Millisecond*1)
 
-			if expect.syn != get.syn || expect.ack != get.ack || expect.fin != get.fin || expect.err != get.err {
+			skipped := !get.syn && !get.fin && !get.err
+			if get.err && !expect.err {
+				assert.Equal(t, ErrConnectionReset, err)
+			} else if skipped && expect != get {
+				// data and acknowledgements of data are skipped
+				assert.EqualError(t, err, "operation timed out")
+			} else if expect.syn != get.syn || expect.ack != get.ack || expect.fin != get.fin || expect.err != get.err {
 				assert.NotNil(t, err)
 				assert.Equal(t, err.Error(), fmt.Sprintf(
 					"expected packet with flags %s but got %s",
@@ -443,6 +646,27 @@ func TestConditionallyLogFalse(t *testing.T) {
 	assert.Equal(t, 0, invocations)
 }
 
+// fromChan receives packets from a channel, timing out on real time
+func fromChan(ch chan *packet.Packet) receiver {
+	return func(timeout time.Duration) (*packet.Packet, bool) {
+		select {
+		case p := <-ch:
+			return p, true
+		case <-time.After(timeout):
+			return nil, false
+		}
+	}
+}
+
+// noinit picks an initial sequence number of zero
+func noinit() uint32 { return 0 }
+
+// nosync ignores the packets and version synchronized on
+func nosync(*packet.Packet, uint8) {}
+
+// nostep accepts all control packets received
+func nostep(*packet.Packet) error { return nil }
+
 func mockControlPacket(syn, ack, fin, err bool) *packet.Packet {
 	p, _ := packet.NewPacket(0, 0, nil)
 	if syn {
@@ -0,0 +1,66 @@
+package handshake
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+)
+
+// isnTick is the period of the clock initial sequence numbers
+// move on with, so that successive connections between the same
+// addresses start far apart in sequence space (RFC 6528)
+const isnTick = time.Microsecond * 4
+
+// defaultISNKey is the secret of the initial sequence numbers of all
+// connections whose generator is not given one, picked once per process
+var defaultISNKey = randomKey()
+
+// ISNGenerator generates initial sequence numbers as in RFC 6528: a
+// keyed hash of the connection's addresses, which remotes cannot
+// predict without the key, plus a clock ticking every 4 microseconds
+type ISNGenerator struct {
+	key   []byte
+	clock clock.Clock
+}
+
+// NewISNGenerator returns a generator of initial sequence numbers keyed
+// with the given secret (a random key shared by the process if nil),
+// e.g. so that a simulation with the same key replays exactly
+func NewISNGenerator(key []byte, clk clock.Clock) *ISNGenerator {
+	if key == nil {
+		key = defaultISNKey
+	}
+	if clk == nil {
+		clk = clock.Real{}
+	}
+	return &ISNGenerator{
+		key:   key,
+		clock: clk,
+	}
+}
+
+// ISN returns the initial sequence number of a connection
+// between the given local and remote addresses
+func (g *ISNGenerator) ISN(local, remote string) uint32 {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write([]byte(local))
+	mac.Write([]byte{0}) // so that the addresses cannot run into each other
+	mac.Write([]byte(remote))
+	hash := binary.BigEndian.Uint32(mac.Sum(nil))
+
+	ticks := uint32(g.clock.Now().UnixNano() / int64(isnTick))
+	return ticks + hash
+}
+
+// randomKey returns a random secret for keyed hashes
+func randomKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic("could not read random key: " + err.Error())
+	}
+	return key
+}
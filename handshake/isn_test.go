@@ -0,0 +1,28 @@
+package handshake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestISNGenerator(t *testing.T) {
+	clk := clock.NewSimulated(time.Unix(0, 0))
+	g := NewISNGenerator([]byte("secret"), clk)
+
+	// the same addresses and key give the same sequence number
+	isn := g.ISN("10.0.0.1:1024", "10.0.0.2:80")
+	assert.Equal(t, isn, NewISNGenerator([]byte("secret"), clk).ISN("10.0.0.1:1024", "10.0.0.2:80"))
+
+	// which others cannot predict without the key
+	assert.NotEqual(t, isn, g.ISN("10.0.0.1:1025", "10.0.0.2:80"))
+	assert.NotEqual(t, isn, g.ISN("10.0.0.2:80", "10.0.0.1:1024"))
+	assert.NotEqual(t, isn, NewISNGenerator([]byte("other"), clk).ISN("10.0.0.1:1024", "10.0.0.2:80"))
+	assert.NotEqual(t, isn, NewISNGenerator(nil, clk).ISN("10.0.0.1:1024", "10.0.0.2:80"))
+
+	// and moves on with time
+	clk.Advance(time.Millisecond)
+	assert.Equal(t, isn+250, g.ISN("10.0.0.1:1024", "10.0.0.2:80"))
+}
@@ -1,7 +1,7 @@
 package rdtp
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -9,10 +9,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	messageBufferBytes = 1024
-)
-
 // Listener listens for new inbound rdtp
 // connections on a local rdtp port
 // Implements the net.Listener interface
@@ -20,30 +16,32 @@ const (
 type Listener struct {
 	laddr *Addr
 	svc   net.Conn
+	opts  options
 }
 
 // Listen announces on the local network address
-func Listen(address string) (net.Listener, error) {
-	svc, err := net.Dial("unix", DefaultRDTPServiceAddr)
+func Listen(address string, opts ...Option) (net.Listener, error) {
+	l, err := listen(context.Background(), address, newOptions(opts))
 	if err != nil {
-		return nil, errors.Wrap(err, "could not connect to rdtp service")
+		return nil, err
 	}
+	return l, nil
+}
 
-	laddr, err := fromString(address)
+// listen requests a listener on a local address from the rdtp
+// service, giving up on it if the context is done first
+func listen(ctx context.Context, address string, o options) (*Listener, error) {
+	laddr, err := ParseAddr(address)
 	if err != nil {
 		return nil, errors.Wrap(err, "address is not a valid rdtp address")
 	}
 
-	req, err := NewClientMessage(ClientMessageTypeListen, laddr, nil)
+	svc, err := o.connect(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not create listen request for rdtp service")
-	}
-
-	if _, err = svc.Write(req); err != nil {
-		return nil, errors.Wrap(err, "could not send listen request to rdtp service")
+		return nil, errors.Wrap(err, "could not connect to rdtp service")
 	}
 
-	verifiedLocalAddr, err := waitForServiceMessageOK(svc)
+	verifiedLocalAddr, err := ask(ctx, svc, newClientMessage(ClientMessageTypeListen, laddr, nil))
 	if err != nil {
 		if err == io.EOF {
 			return nil, errors.New("Listener terminated by rdtp service")
@@ -54,6 +52,7 @@ func Listen(address string) (net.Listener, error) {
 	l := &Listener{
 		laddr: verifiedLocalAddr,
 		svc:   svc,
+		opts:  o,
 	}
 
 	return l, nil
@@ -69,21 +68,13 @@ func (l *Listener) Accept() (net.Conn, error) {
 		return nil, errors.Wrap(err, "remote address is not valid")
 	}
 
-	svc, err := net.Dial("unix", DefaultRDTPServiceAddr)
+	ctx := context.Background()
+	svc, err := l.opts.connect(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not connect to rdtp service")
 	}
 
-	req, err := NewClientMessage(ClientMessageTypeAccept, l.laddr, verifiedRemoteAddr)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not create accept request for rdtp service")
-	}
-
-	if _, err = svc.Write(req); err != nil {
-		return nil, errors.Wrap(err, "could not send accept request to rdtp service")
-	}
-
-	verifiedLocalAddr, err := waitForServiceMessageOK(svc)
+	verifiedLocalAddr, err := ask(ctx, svc, l.opts.request(ClientMessageTypeAccept, l.laddr, verifiedRemoteAddr))
 	if err != nil {
 		if err == io.EOF {
 			return nil, errors.New("Listener terminated by rdtp service")
@@ -92,9 +83,10 @@ func (l *Listener) Accept() (net.Conn, error) {
 	}
 
 	return &Conn{
-		laddr: verifiedLocalAddr,
-		raddr: verifiedRemoteAddr,
-		svc:   svc,
+		laddr:  verifiedLocalAddr,
+		raddr:  verifiedRemoteAddr,
+		svc:    svc,
+		stream: newStream(svc, l.opts.clock),
 	}, nil
 }
 
@@ -108,22 +100,70 @@ func (l *Listener) Addr() net.Addr {
 	return l.laddr
 }
 
-func waitForServiceMessageOK(c net.Conn) (*Addr, error) {
-	buf := make([]byte, messageBufferBytes)
-	n, err := c.Read(buf)
+// ask sends a request to the rdtp service and waits for its answer,
+// closing the connection to the service if the request fails or the
+// context is done first
+func ask(ctx context.Context, svc net.Conn, msg ClientMessage) (*Addr, error) {
+	stop := closeOnDone(ctx, svc)
+
+	var verifiedLocalAddr *Addr
+	err := WriteMessage(svc, msg)
 	if err != nil {
-		if err == io.EOF {
-			return nil, err
+		err = errors.Wrap(err, "could not send request to rdtp service")
+	} else {
+		verifiedLocalAddr, err = waitForServiceMessageOK(svc)
+	}
+
+	if ctxErr := stop(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		svc.Close()
+		return nil, err
+	}
+	return verifiedLocalAddr, nil
+}
+
+// closeOnDone closes a connection once the context is done, unblocking
+// any request in progress on it, until the function returned is called.
+// The function returns the context's error if it closed the connection
+func closeOnDone(ctx context.Context, c net.Conn) func() error {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+	stop := make(chan struct{})
+	closed := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+			closed <- ctx.Err()
+		case <-stop:
+			closed <- nil
 		}
-		return nil, errors.Wrap(err, "error reading rdtp service message")
+	}()
+	return func() error {
+		close(stop)
+		return <-closed
 	}
+}
 
-	var msg ServiceMessage
-	if err := json.Unmarshal(buf[:n], &msg); err != nil {
-		return nil, errors.Wrap(err, "invalid request json")
+// waitForServiceMessageOK waits for the service's answer to a request,
+// after which the connection carries only stream bytes if it was a
+// dial or accept request
+func waitForServiceMessageOK(c net.Conn) (*Addr, error) {
+	msg, err := readServiceMessage(c)
+	if err != nil {
+		return nil, err
 	}
 
 	if msg.Type == ServiceMessageTypeError {
+		switch msg.Error {
+		case ServiceErrorTypeConnectionRefused:
+			return nil, ErrConnectionRefused
+		case ServiceErrorTypeUnsupportedVersion:
+			return nil, fmt.Errorf("rdtp service speaks control protocol version %d, not %d", msg.Version, ControlProtocolVersion)
+		}
 		return nil, errors.New(string(msg.Error))
 	}
 
@@ -135,18 +175,9 @@ func waitForServiceMessageOK(c net.Conn) (*Addr, error) {
 }
 
 func waitForServiceMessageNotify(c net.Conn) (*Addr, error) {
-	buf := make([]byte, messageBufferBytes)
-	n, err := c.Read(buf)
+	msg, err := readServiceMessage(c)
 	if err != nil {
-		if err == io.EOF {
-			return nil, err
-		}
-		return nil, errors.Wrap(err, "error reading rdtp service message")
-	}
-
-	var msg ServiceMessage
-	if err := json.Unmarshal(buf[:n], &msg); err != nil {
-		return nil, errors.Wrap(err, "invalid request json")
+		return nil, err
 	}
 
 	if msg.Type == ServiceMessageTypeError {
@@ -159,3 +190,16 @@ func waitForServiceMessageNotify(c net.Conn) (*Addr, error) {
 
 	return &msg.RemoteAddr, nil
 }
+
+// readServiceMessage reads the next message from the rdtp service,
+// returning io.EOF if the service closed the connection
+func readServiceMessage(c net.Conn) (*ServiceMessage, error) {
+	var msg ServiceMessage
+	if err := ReadMessage(c, &msg); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "error reading rdtp service message")
+	}
+	return &msg, nil
+}
@@ -0,0 +1,76 @@
+package rdtp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ControlProtocolVersion is the version of the control protocol
+	// spoken between rdtp clients and the rdtp service, which clients
+	// state in the first message on each connection to the service
+	ControlProtocolVersion = 2
+
+	// MaxMessageBytes is the largest control message, framing excluded
+	MaxMessageBytes = 64 * 1024
+
+	// messageLengthBytes is the byte size of the length framing messages
+	messageLengthBytes = 4
+)
+
+// frame returns a serialized control message framed with its length
+func frame(msg interface{}) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxMessageBytes {
+		return nil, fmt.Errorf("message of %d bytes exceeds maximum of %d", len(data), MaxMessageBytes)
+	}
+	framed := make([]byte, messageLengthBytes, messageLengthBytes+len(data))
+	binary.BigEndian.PutUint32(framed, uint32(len(data)))
+	return append(framed, data...), nil
+}
+
+// WriteMessage writes a control message to a connection to or from
+// the rdtp service: its JSON encoding preceded by its byte length as
+// a 4-byte big-endian integer. Messages are written all at once
+func WriteMessage(w io.Writer, msg interface{}) error {
+	framed, err := frame(msg)
+	if err != nil {
+		return errors.Wrap(err, "could not serialize message")
+	}
+	_, err = w.Write(framed)
+	return err
+}
+
+// ReadMessage reads a control message written with WriteMessage. It reads
+// no further than the end of the message, so that whatever follows it on
+// the connection, e.g. the stream once a connection is established, is
+// left to be read. It returns io.EOF if the connection closes before
+// any of the message is read
+func ReadMessage(r io.Reader, msg interface{}) error {
+	var length [messageLengthBytes]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > MaxMessageBytes {
+		return fmt.Errorf("message of %d bytes exceeds maximum of %d", size, MaxMessageBytes)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return errors.Wrap(err, "invalid message json")
+	}
+	return nil
+}
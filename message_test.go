@@ -0,0 +1,61 @@
+package rdtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageFraming(t *testing.T) {
+	var conn bytes.Buffer
+
+	// the stream follows an OK message right away on the same connection
+	ok, err := NewServiceMessage(ServiceMessageTypeOK, &Addr{Host: "10.0.0.1", Port: 1024}, nil, nil)
+	assert.Nil(t, err)
+	conn.Write(ok)
+	conn.WriteString("stream bytes")
+
+	var msg ServiceMessage
+	assert.Nil(t, ReadMessage(&conn, &msg))
+	assert.Equal(t, ServiceMessageTypeOK, msg.Type)
+	assert.Equal(t, ControlProtocolVersion, msg.Version)
+	assert.Equal(t, Addr{Host: "10.0.0.1", Port: 1024}, msg.LocalAddr)
+
+	// and is left for the application to read
+	assert.Equal(t, "stream bytes", conn.String())
+}
+
+func TestWriteMessage(t *testing.T) {
+	var conn bytes.Buffer
+	assert.Nil(t, WriteMessage(&conn, newClientMessage(ClientMessageTypeDial, nil, &Addr{Host: "10.0.0.2", Port: 80})))
+
+	length := binary.BigEndian.Uint32(conn.Bytes()[:messageLengthBytes])
+	assert.Equal(t, conn.Len()-messageLengthBytes, int(length))
+
+	var msg ClientMessage
+	assert.Nil(t, ReadMessage(&conn, &msg))
+	assert.Equal(t, ControlProtocolVersion, msg.Version)
+	assert.Equal(t, ClientMessageTypeDial, msg.Type)
+	assert.Equal(t, Addr{Host: "10.0.0.2", Port: 80}, msg.RemoteAddr)
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	var msg ClientMessage
+
+	// connections closed between messages
+	assert.Equal(t, io.EOF, ReadMessage(&bytes.Buffer{}, &msg))
+
+	// or halfway through one
+	framed, err := NewClientMessage(ClientMessageTypeListen, &Addr{Port: 80}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, io.ErrUnexpectedEOF, ReadMessage(bytes.NewReader(framed[:len(framed)-1]), &msg))
+
+	// messages too large to be framed, e.g. unframed json
+	assert.NotNil(t, ReadMessage(bytes.NewBufferString(`{"type":"DIAL"}`), &msg))
+
+	// and malformed ones
+	assert.NotNil(t, ReadMessage(bytes.NewReader([]byte{0, 0, 0, 1, '{'}), &msg))
+}
@@ -0,0 +1,82 @@
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// frameType is the type of a frame, stating what its payload is
+type frameType uint8
+
+const (
+	// frameOpen opens a stream, and carries no payload
+	frameOpen frameType = iota + 1
+
+	// frameData carries bytes of the stream
+	frameData
+
+	// frameCredit grants the receiver of the frame leave to send as many
+	// more bytes on the stream as its payload states (a 4-byte integer)
+	frameCredit
+
+	// frameCloseWrite states that its sender writes no more on the stream,
+	// so once its data is read the receiver reads EOF
+	frameCloseWrite
+
+	// frameCloseRead states that its sender reads no more from the
+	// stream, so the receiver's writes fail from then on
+	frameCloseRead
+)
+
+const (
+	// headerBytes is the size of a frame's header
+	headerBytes = 8
+
+	// maxDataBytes is the most bytes of a stream sent in a single
+	// frame, so that no one stream holds the connection for long
+	maxDataBytes = 16 * 1024
+
+	// creditBytes is the size of the payload of credit frames
+	creditBytes = 4
+)
+
+var frameTypeNames = map[frameType]string{
+	frameOpen:       "OPEN",
+	frameData:       "DATA",
+	frameCredit:     "CREDIT",
+	frameCloseWrite: "CLOSE_WRITE",
+	frameCloseRead:  "CLOSE_READ",
+}
+
+func (t frameType) String() string {
+	if name, ok := frameTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("frameType(%d)", uint8(t))
+}
+
+// header is the header of a frame
+type header struct {
+	streamID uint32
+	typ      frameType
+	length   uint16 // of the payload
+}
+
+// frame returns a frame of the given type on a stream
+func frame(typ frameType, streamID uint32, payload []byte) []byte {
+	f := make([]byte, headerBytes+len(payload))
+	binary.BigEndian.PutUint32(f[0:4], streamID)
+	f[4] = byte(typ)
+	binary.BigEndian.PutUint16(f[6:8], uint16(len(payload)))
+	copy(f[headerBytes:], payload)
+	return f
+}
+
+// parseHeader parses the header at the start of a frame
+func parseHeader(b []byte) header {
+	return header{
+		streamID: binary.BigEndian.Uint32(b[0:4]),
+		typ:      frameType(b[4]),
+		length:   binary.BigEndian.Uint16(b[6:8]),
+	}
+}
@@ -0,0 +1,248 @@
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// streamWindowBytes is the credit each end of a stream starts with,
+	// i.e. the most bytes it may send before the remote reads any
+	streamWindowBytes = 256 * 1024
+
+	// acceptBacklog is the number of streams opened by the
+	// remote which the session holds until they are accepted
+	acceptBacklog = 128
+)
+
+// ErrSessionClosed is returned by operations on a session, and
+// writes to its streams, once the session is closed by either end
+var ErrSessionClosed = errors.New("mux session closed")
+
+// Session multiplexes streams over a single connection, e.g. an
+// application's connection to the rdtp service. Each stream is a
+// net.Conn of its own, whose bytes are sent in frames tagged with
+// its ID. Each end of a stream may send only as many bytes as the
+// other has granted it credit for, so that streams whose reader
+// falls behind do not hold up the rest
+type Session struct {
+	conn net.Conn
+
+	writeMu sync.Mutex // serializes frames written to conn
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32 // of the next stream opened
+	err     error  // why the session is over, if it is
+
+	accepted  chan *Stream
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewClient returns the session over a connection of the end which
+// opens streams on it. Streams opened by the client are odd-numbered
+func NewClient(conn net.Conn) *Session {
+	return newSession(conn, 1)
+}
+
+// NewServer returns the session over a connection of the end which
+// accepts streams on it. Streams opened by the server are even-numbered
+func NewServer(conn net.Conn) *Session {
+	return newSession(conn, 2)
+}
+
+func newSession(conn net.Conn, firstID uint32) *Session {
+	s := &Session{
+		conn:     conn,
+		streams:  make(map[uint32]*Stream),
+		nextID:   firstID,
+		accepted: make(chan *Stream, acceptBacklog),
+		done:     make(chan struct{}),
+	}
+	go s.receive()
+	return s
+}
+
+// Open opens a new stream to the remote
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	st := newStream(s.nextID, s)
+	s.streams[st.id] = st
+	s.nextID += 2
+	s.mu.Unlock()
+
+	if err := s.writeFrame(frameOpen, st.id, nil); err != nil {
+		s.forget(st.id)
+		return nil, errors.Wrap(err, "could not open stream")
+	}
+	return st, nil
+}
+
+// Accept waits for and returns the next stream opened by the remote
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accepted:
+		return st, nil
+	case <-s.done:
+		return nil, s.Err()
+	}
+}
+
+// Close closes the session's connection, and with it all its streams
+func (s *Session) Close() error {
+	s.fail(ErrSessionClosed)
+	return nil
+}
+
+// Done returns a channel which is closed once the session is over
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the session is over, or nil while it is not
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// fail ends the session for the given reason: the remote's
+// streams are over, so they read EOF and their writes fail
+func (s *Session) fail(err error) {
+	s.closeOnce.Do(func() {
+		if err == io.EOF {
+			err = ErrSessionClosed
+		}
+		s.mu.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+
+		close(s.done)
+		s.conn.Close()
+		for _, st := range streams {
+			st.remoteClose(true, true)
+		}
+	})
+}
+
+// writeFrame writes a frame to the session's connection
+func (s *Session) writeFrame(typ frameType, streamID uint32, payload []byte) error {
+	f := frame(typ, streamID, payload)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	select {
+	case <-s.done:
+		return s.Err()
+	default:
+	}
+	if _, err := s.conn.Write(f); err != nil {
+		s.fail(errors.Wrap(err, "could not write to mux session's connection"))
+		return s.Err()
+	}
+	return nil
+}
+
+// stream returns the stream of the given ID, if it is still open locally
+func (s *Session) stream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+// forget drops a stream closed locally, whose frames are ignored from then on
+func (s *Session) forget(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+// receive reads frames from the session's connection, handing them to
+// their streams, until the connection fails or the remote breaks protocol
+func (s *Session) receive() {
+	hdr := make([]byte, headerBytes)
+	for {
+		if _, err := io.ReadFull(s.conn, hdr); err != nil {
+			s.fail(err)
+			return
+		}
+		h := parseHeader(hdr)
+		payload := make([]byte, h.length)
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			s.fail(errors.Wrap(err, "could not read mux frame payload"))
+			return
+		}
+		if err := s.handle(h, payload); err != nil {
+			s.fail(errors.Wrap(err, "mux protocol violated by remote"))
+			return
+		}
+	}
+}
+
+// handle handles a frame received from the remote
+func (s *Session) handle(h header, payload []byte) error {
+	if h.typ == frameOpen {
+		return s.handleOpen(h.streamID)
+	}
+
+	// frames of streams closed locally are dropped
+	st := s.stream(h.streamID)
+	if st == nil {
+		return nil
+	}
+
+	switch h.typ {
+	case frameData:
+		return st.receive(payload)
+	case frameCredit:
+		if len(payload) != creditBytes {
+			return fmt.Errorf("%s frame of %d bytes", h.typ, len(payload))
+		}
+		st.grant(binary.BigEndian.Uint32(payload))
+	case frameCloseWrite:
+		st.remoteClose(true, false)
+	case frameCloseRead:
+		st.remoteClose(false, true)
+	default:
+		return fmt.Errorf("unknown frame type %s", h.typ)
+	}
+	return nil
+}
+
+// handleOpen queues a stream opened by the remote to be accepted
+func (s *Session) handleOpen(id uint32) error {
+	s.mu.Lock()
+	if id%2 == s.nextID%2 {
+		s.mu.Unlock()
+		return fmt.Errorf("stream %d opened by the wrong end", id)
+	}
+	if _, ok := s.streams[id]; ok {
+		s.mu.Unlock()
+		return fmt.Errorf("stream %d opened twice", id)
+	}
+	st := newStream(id, s)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	select {
+	case s.accepted <- st:
+	default:
+		// refuse streams past the backlog, without holding up the
+		// frames of the rest while the refusal is written
+		go st.Close()
+	}
+	return nil
+}
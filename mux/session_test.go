@@ -0,0 +1,113 @@
+package mux
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newPipeSessions returns the client and server sessions over a pipe
+func newPipeSessions(t *testing.T) (*Session, *Session) {
+	a, b := net.Pipe()
+	client, server := NewClient(a), NewServer(b)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestOpenAccept(t *testing.T) {
+	client, server := newPipeSessions(t)
+
+	var opened, accepted []*Stream
+	for i := 0; i < 2; i++ {
+		st, err := client.Open()
+		assert.Nil(t, err)
+		opened = append(opened, st)
+	}
+	for i := 0; i < 2; i++ {
+		st, err := server.Accept()
+		assert.Nil(t, err)
+		accepted = append(accepted, st)
+	}
+	assert.Equal(t, uint32(1), opened[0].ID())
+	assert.Equal(t, uint32(3), opened[1].ID())
+
+	// each stream carries its own bytes, both ways
+	for i, msg := range []string{"first", "second"} {
+		_, err := opened[i].Write([]byte(msg))
+		assert.Nil(t, err)
+	}
+	for i, msg := range []string{"first", "second"} {
+		buf := make([]byte, 16)
+		n, err := accepted[i].Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, msg, string(buf[:n]))
+
+		_, err = accepted[i].Write([]byte(msg + " back"))
+		assert.Nil(t, err)
+		n, err = opened[i].Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, msg+" back", string(buf[:n]))
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := newPipeSessions(t)
+
+	st, err := client.Open()
+	assert.Nil(t, err)
+	remote, err := server.Accept()
+	assert.Nil(t, err)
+
+	assert.Nil(t, client.Close())
+	<-server.Done()
+
+	// the streams of a session over read EOF and fail writes
+	_, err = remote.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	_, err = remote.Write([]byte("hello"))
+	assert.Equal(t, ErrSessionClosed, err)
+	_, err = st.Write([]byte("hello"))
+	assert.Equal(t, ErrSessionClosed, err)
+
+	_, err = server.Accept()
+	assert.Equal(t, ErrSessionClosed, err)
+	_, err = client.Open()
+	assert.Equal(t, ErrSessionClosed, err)
+}
+
+func TestSessionProtocolViolation(t *testing.T) {
+	for name, frames := range map[string][][]byte{
+		"stream of the wrong end": {frame(frameOpen, 2, nil)},
+		"stream opened twice":     {frame(frameOpen, 1, nil), frame(frameOpen, 1, nil)},
+		"data beyond credit": {
+			frame(frameOpen, 1, nil),
+			frame(frameData, 1, make([]byte, 0xFFFF)),
+			frame(frameData, 1, make([]byte, 0xFFFF)),
+			frame(frameData, 1, make([]byte, 0xFFFF)),
+			frame(frameData, 1, make([]byte, 0xFFFF)),
+			frame(frameData, 1, make([]byte, 0xFFFF)),
+		},
+		"bad credit frame": {frame(frameOpen, 1, nil), frame(frameCredit, 1, []byte{1})},
+		"unknown frame":    {frame(frameOpen, 1, nil), frame(frameType(42), 1, nil)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			a, b := net.Pipe()
+			server := NewServer(b)
+			defer server.Close()
+
+			for _, f := range frames {
+				if _, err := a.Write(f); err != nil {
+					break
+				}
+			}
+			<-server.Done()
+			assert.Contains(t, server.Err().Error(), "mux protocol violated by remote")
+			a.Close()
+		})
+	}
+}
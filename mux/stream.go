@@ -0,0 +1,325 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRemoteClosed is returned by writes to a stream whose remote reads no more
+var ErrRemoteClosed = errors.New("mux stream closed by remote")
+
+// errWriteClosed is returned by writes to a stream closed for writing
+var errWriteClosed = errors.New("mux stream closed for writing")
+
+// Stream is a full duplex stream of a session.
+// Implements the net.Conn interface (https://golang.org/pkg/net/#Conn)
+type Stream struct {
+	id      uint32
+	session *Session
+
+	writeMu sync.Mutex // keeps the frames of concurrent writes in order
+
+	mu       sync.Mutex
+	buf      bytes.Buffer // received but not read yet
+	consumed int          // bytes read since credit was last granted
+	credit   int          // bytes which may still be sent
+
+	closed       bool // by Close
+	readClosed   bool // by CloseRead or Close
+	writeClosed  bool // by CloseWrite or Close
+	remoteEOF    bool // the remote writes no more
+	remoteClosed bool // the remote reads no more
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// closed and replaced whenever any of the above changes
+	changed chan struct{}
+}
+
+func newStream(id uint32, s *Session) *Stream {
+	return &Stream{
+		id:      id,
+		session: s,
+		credit:  streamWindowBytes,
+		changed: make(chan struct{}),
+	}
+}
+
+// ID returns the stream's ID within its session
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read reads data from the stream
+func (st *Stream) Read(b []byte) (int, error) {
+	st.mu.Lock()
+	for {
+		if st.closed {
+			st.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if st.buf.Len() > 0 {
+			break
+		}
+		if st.readClosed || st.remoteEOF {
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		if err := st.waitLocked(st.readDeadline); err != nil {
+			st.mu.Unlock()
+			return 0, err
+		}
+	}
+
+	n, _ := st.buf.Read(b)
+	st.consumed += n
+
+	// grant the remote credit for what was read in batches
+	// of half the window, rather than frame by frame
+	grant := 0
+	if st.consumed >= streamWindowBytes/2 && !st.remoteEOF {
+		grant, st.consumed = st.consumed, 0
+	}
+	st.mu.Unlock()
+
+	if grant > 0 {
+		payload := make([]byte, creditBytes)
+		binary.BigEndian.PutUint32(payload, uint32(grant))
+		if err := st.session.writeFrame(frameCredit, st.id, payload); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Write writes data to the stream, waiting for credit from
+// the remote whenever it has used up what it was granted
+func (st *Stream) Write(b []byte) (int, error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	written := 0
+	for written < len(b) {
+		st.mu.Lock()
+		for {
+			if err := st.writeErrLocked(); err != nil {
+				st.mu.Unlock()
+				return written, err
+			}
+			if st.credit > 0 {
+				break
+			}
+			if err := st.waitLocked(st.writeDeadline); err != nil {
+				st.mu.Unlock()
+				return written, err
+			}
+		}
+		n := len(b) - written
+		if n > st.credit {
+			n = st.credit
+		}
+		if n > maxDataBytes {
+			n = maxDataBytes
+		}
+		st.credit -= n
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(frameData, st.id, b[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// writeErrLocked returns why the stream cannot be written to, if it cannot
+func (st *Stream) writeErrLocked() error {
+	switch {
+	case st.closed:
+		return net.ErrClosed
+	case st.writeClosed:
+		return errWriteClosed
+	case st.remoteClosed:
+		if err := st.session.Err(); err != nil {
+			return err
+		}
+		return ErrRemoteClosed
+	}
+	return nil
+}
+
+// Close closes the stream.
+// Any blocked Read or Write operations will be unblocked and return errors.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	st.notifyLocked()
+	st.mu.Unlock()
+
+	st.session.forget(st.id)
+	errWrite := st.CloseWrite()
+	errRead := st.CloseRead()
+	if errWrite != nil && errWrite != st.session.Err() {
+		return errWrite
+	}
+	if errRead != nil && errRead != st.session.Err() {
+		return errRead
+	}
+	return nil
+}
+
+// CloseWrite shuts down the writing side of the stream: the
+// remote reads EOF once it has read all data written before
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.writeClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.writeClosed = true
+	st.notifyLocked()
+	st.mu.Unlock()
+
+	// after any write in progress
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+	return st.session.writeFrame(frameCloseWrite, st.id, nil)
+}
+
+// CloseRead shuts down the reading side of the stream. Data
+// not read yet is discarded, and the remote's writes fail
+func (st *Stream) CloseRead() error {
+	st.mu.Lock()
+	if st.readClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.readClosed = true
+	st.buf.Reset()
+	st.notifyLocked()
+	st.mu.Unlock()
+
+	return st.session.writeFrame(frameCloseRead, st.id, nil)
+}
+
+// LocalAddr returns the local address of the session's connection
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the session's connection
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines on the stream
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.readDeadline = t
+	st.writeDeadline = t
+	st.notifyLocked()
+	return nil
+}
+
+// SetReadDeadline sets the read deadline on the stream
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.readDeadline = t
+	st.notifyLocked()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline on the stream
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.writeDeadline = t
+	st.notifyLocked()
+	return nil
+}
+
+// receive buffers data received from the remote until it is read
+func (st *Stream) receive(data []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.remoteEOF {
+		return fmt.Errorf("data on stream %d after its remote closed it for writing", st.id)
+	}
+	if st.buf.Len()+st.consumed+len(data) > streamWindowBytes {
+		return fmt.Errorf("data on stream %d beyond the credit granted", st.id)
+	}
+	// the remote's credit is spent either way, so keep account
+	// of data discarded as if it were read
+	if st.readClosed {
+		st.consumed += len(data)
+		return nil
+	}
+	st.buf.Write(data)
+	st.notifyLocked()
+	return nil
+}
+
+// grant adds credit granted by the remote
+func (st *Stream) grant(credit uint32) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.credit += int(credit)
+	st.notifyLocked()
+}
+
+// remoteClose records the remote closing either side of the stream
+func (st *Stream) remoteClose(write, read bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.remoteEOF = st.remoteEOF || write
+	st.remoteClosed = st.remoteClosed || read
+	st.notifyLocked()
+}
+
+// notifyLocked wakes all reads and writes waiting on the stream
+func (st *Stream) notifyLocked() {
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// waitLocked releases the stream's lock until anything about
+// the stream changes or the given deadline, if any, passes
+func (st *Stream) waitLocked(deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	changed := st.changed
+	st.mu.Unlock()
+	defer st.mu.Lock()
+
+	select {
+	case <-changed:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,117 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPipeStreams returns both ends of a stream of sessions over a pipe
+func newPipeStreams(t *testing.T) (*Stream, *Stream) {
+	client, server := newPipeSessions(t)
+	local, err := client.Open()
+	assert.Nil(t, err)
+	remote, err := server.Accept()
+	assert.Nil(t, err)
+	return local, remote
+}
+
+func TestStreamHalfClose(t *testing.T) {
+	local, remote := newPipeStreams(t)
+
+	_, err := local.Write([]byte("request"))
+	assert.Nil(t, err)
+	assert.Nil(t, local.CloseWrite())
+	_, err = local.Write([]byte("more"))
+	assert.NotNil(t, err)
+
+	// the remote reads the data written, then EOF
+	data, err := io.ReadAll(remote)
+	assert.Nil(t, err)
+	assert.Equal(t, "request", string(data))
+
+	// while its writes are still read
+	_, err = remote.Write([]byte("response"))
+	assert.Nil(t, err)
+	assert.Nil(t, remote.Close())
+	data, err = io.ReadAll(local)
+	assert.Nil(t, err)
+	assert.Equal(t, "response", string(data))
+}
+
+func TestStreamCloseRead(t *testing.T) {
+	local, remote := newPipeStreams(t)
+
+	assert.Nil(t, remote.CloseRead())
+	_, err := remote.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// writes fail once the remote reads no more
+	assert.Eventually(t, func() bool {
+		_, err := local.Write([]byte("hello"))
+		return err == ErrRemoteClosed
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	client, server := newPipeSessions(t)
+	slow, err := client.Open()
+	assert.Nil(t, err)
+	slowRemote, err := server.Accept()
+	assert.Nil(t, err)
+
+	// writes block once the credit granted is used up
+	data := bytes.Repeat([]byte{0xAB}, streamWindowBytes*2)
+	slow.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
+	n, err := slow.Write(data)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	assert.Equal(t, streamWindowBytes, n)
+
+	// without holding up the session's other streams
+	fast, err := client.Open()
+	assert.Nil(t, err)
+	fastRemote, err := server.Accept()
+	assert.Nil(t, err)
+	_, err = fast.Write([]byte("hello"))
+	assert.Nil(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(fastRemote, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// reading grants more credit
+	slow.SetWriteDeadline(time.Time{})
+	done := make(chan error)
+	go func() {
+		_, err := slow.Write(data[n:])
+		done <- err
+	}()
+	got := make([]byte, len(data))
+	_, err = io.ReadFull(slowRemote, got)
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+	assert.Nil(t, <-done)
+}
+
+func TestStreamDeadlines(t *testing.T) {
+	local, _ := newPipeStreams(t)
+
+	local.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	_, err := local.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+
+	// closing unblocks reads
+	local.SetReadDeadline(time.Time{})
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		local.Close()
+	}()
+	_, err = local.Read(make([]byte, 1))
+	assert.Equal(t, net.ErrClosed, err)
+}
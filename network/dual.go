@@ -0,0 +1,51 @@
+package network
+
+import (
+	"net"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
+)
+
+// DualStack represents a network which carries rdtp packets over
+// separate IPv4 and IPv6 networks, based on their destination
+type DualStack struct {
+	ipv4 Network
+	ipv6 Network
+}
+
+// NewDualStack returns a network sending packets to IPv4 addresses over
+// the ipv4 network and to IPv6 addresses over the ipv6 network
+func NewDualStack(ipv4, ipv6 Network) *DualStack {
+	return &DualStack{
+		ipv4: ipv4,
+		ipv6: ipv6,
+	}
+}
+
+// Send sends a packet over the network of its destination's address family
+func (d *DualStack) Send(pck *packet.Packet) error {
+	dstIP, err := pck.GetDestinationIP()
+	if err != nil {
+		return errors.Wrap(err, "could not determine destination IP addresss")
+	}
+	if dstIP.To4() != nil {
+		return d.ipv4.Send(pck)
+	}
+	return d.ipv6.Send(pck)
+}
+
+// MaxPacketSize returns the largest rdtp packet the network
+// of the destination's address family can send unfragmented
+func (d *DualStack) MaxPacketSize(dst net.IP) int {
+	if dst.To4() != nil {
+		return MaxPacketSize(d.ipv4, dst)
+	}
+	return MaxPacketSize(d.ipv6, dst)
+}
+
+// StartReceiver forwards all rdtp packets received on either network
+func (d *DualStack) StartReceiver(forward func(*packet.Packet) error) {
+	d.ipv4.StartReceiver(forward)
+	d.ipv6.StartReceiver(forward)
+}
@@ -0,0 +1,40 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDualStack(t *testing.T) {
+	ipv4, ipv6 := newRecorder(), newRecorder()
+	d := NewDualStack(ipv4, ipv6)
+
+	p, err := packet.NewPacket(10, 20, []byte("hello"))
+	assert.Nil(t, err)
+
+	// packets without a destination cannot be routed
+	assert.NotNil(t, d.Send(p))
+
+	p.SetDestinationIP(net.ParseIP("10.0.0.2"))
+	assert.Nil(t, d.Send(p))
+	p.SetDestinationIP(net.ParseIP("::ffff:10.0.0.2"))
+	assert.Nil(t, d.Send(p))
+	p.SetDestinationIP(net.ParseIP("fd00::2"))
+	assert.Nil(t, d.Send(p))
+
+	assert.Len(t, ipv4.wait(t, 2), 2)
+	assert.Len(t, ipv6.wait(t, 1), 1)
+
+	// packets received on either network are forwarded
+	forwarded := 0
+	d.StartReceiver(func(p *packet.Packet) error {
+		forwarded++
+		return nil
+	})
+	assert.Nil(t, ipv4.forward(p))
+	assert.Nil(t, ipv6.forward(p))
+	assert.Equal(t, 2, forwarded)
+}
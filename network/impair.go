@@ -0,0 +1,343 @@
+package network
+
+import (
+	"container/heap"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
+)
+
+// defaultReorderDelay is the extra delay of reordered packets if none is set
+const defaultReorderDelay = time.Millisecond * 10
+
+// Impairment describes the conditions packets going one way through
+// an impaired network are subject to. The zero value impairs nothing
+type Impairment struct {
+	// probability of losing a packet, for independent losses
+	Loss float64
+
+	// bursty losses, which take precedence over Loss if set
+	Burst *GilbertElliott
+
+	// fixed delay of every packet, plus a random delay uniformly
+	// distributed up to Jitter (which may reorder packets too)
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// probability of holding a packet back by an extra ReorderDelay
+	// (defaults to 10ms) so that later packets overtake it
+	Reorder      float64
+	ReorderDelay time.Duration
+
+	// probability of delivering a packet twice
+	Duplicate float64
+
+	// probability of flipping a random bit of a packet
+	Corrupt float64
+
+	// link rate in bytes per second (unlimited if zero). Packets
+	// which would queue for longer than MaxQueueDelay waiting for
+	// the link are dropped (the queue is unbounded if zero)
+	Bandwidth     float64
+	MaxQueueDelay time.Duration
+}
+
+// GilbertElliott is a two-state Markov loss model: the link alternates
+// between a good and a bad state, each with its own loss probability,
+// so that losses come in bursts while the link is in the bad state
+type GilbertElliott struct {
+	P        float64 // probability of going from the good to the bad state
+	R        float64 // probability of going from the bad to the good state
+	LossGood float64 // probability of losing a packet in the good state
+	LossBad  float64 // probability of losing a packet in the bad state
+}
+
+// ImpairedConfig is the configuration of an impaired network
+type ImpairedConfig struct {
+	Outbound Impairment // packets sent
+	Inbound  Impairment // packets received
+
+	// seed of the random decisions, so that a scenario
+	// can be replayed exactly given the same traffic
+	Seed int64
+
+	// source of time for delays (defaults to real time if nil)
+	Clock clock.Clock
+}
+
+// ImpairmentStats are counters of what happened to packets going one way
+type ImpairmentStats struct {
+	Packets    int // packets given to the link
+	Lost       int // lost at random
+	Overflowed int // dropped for exceeding the maximum queueing delay
+	Duplicated int
+	Corrupted  int
+	Reordered  int
+}
+
+// Impaired is a network which subjects the packets going through
+// another network to loss, delay, reordering, duplication,
+// corruption and bandwidth limits, in either direction
+type Impaired struct {
+	network  Network
+	outbound *link
+	inbound  *link
+}
+
+// Impair returns the given network impaired as configured
+func Impair(n Network, c ImpairedConfig) *Impaired {
+	return &Impaired{
+		network:  n,
+		outbound: newLink(c.Outbound, c.Seed, c.Clock),
+		inbound:  newLink(c.Inbound, c.Seed+1, c.Clock),
+	}
+}
+
+// Send sends a packet through the impaired network
+func (i *Impaired) Send(pck *packet.Packet) error {
+	i.outbound.transmit(pck, i.network.Send)
+	return nil
+}
+
+// MaxPacketSize returns the largest rdtp packet
+// the network impaired can send unfragmented
+func (i *Impaired) MaxPacketSize(dst net.IP) int {
+	return MaxPacketSize(i.network, dst)
+}
+
+// StartReceiver forwards the packets which make it through the impaired network
+func (i *Impaired) StartReceiver(forward func(*packet.Packet) error) {
+	i.network.StartReceiver(func(pck *packet.Packet) error {
+		i.inbound.transmit(pck, forward)
+		return nil
+	})
+}
+
+// OutboundStats returns the counters of packets sent
+func (i *Impaired) OutboundStats() ImpairmentStats {
+	return i.outbound.statistics()
+}
+
+// InboundStats returns the counters of packets received
+func (i *Impaired) InboundStats() ImpairmentStats {
+	return i.inbound.statistics()
+}
+
+// link applies an impairment to packets going one way
+type link struct {
+	Impairment
+	clock clock.Clock
+
+	sync.Mutex
+	rng      *rand.Rand
+	bad      bool      // Gilbert-Elliott state
+	nextFree time.Time // time the link is done with queued packets
+	stats    ImpairmentStats
+
+	// packets in flight, handed over in order of arrival
+	// by a goroutine which runs while there are any, and
+	// which is woken up whenever the earliest may change
+	inFlight deliveryQueue
+	seq      uint64
+	running  bool
+	woken    bool
+	wake     clock.Cond
+}
+
+func newLink(imp Impairment, seed int64, clk clock.Clock) *link {
+	if imp.ReorderDelay == 0 {
+		imp.ReorderDelay = defaultReorderDelay
+	}
+	if clk == nil {
+		clk = clock.Real{}
+	}
+	l := &link{
+		Impairment: imp,
+		clock:      clk,
+		rng:        rand.New(rand.NewSource(seed)),
+	}
+	l.wake = clk.NewCond(&l.Mutex)
+	return l
+}
+
+// transmit decides the fate of a packet and hands it (or its copies) to
+// next after its delay, unless it is lost
+func (l *link) transmit(pck *packet.Packet, next func(*packet.Packet) error) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.stats.Packets++
+	if l.lose() {
+		l.stats.Lost++
+		return
+	}
+
+	now := l.clock.Now()
+	var queued time.Duration
+	if l.Bandwidth > 0 {
+		if l.nextFree.Before(now) {
+			l.nextFree = now
+		}
+		queued = l.nextFree.Sub(now)
+		if l.MaxQueueDelay > 0 && queued > l.MaxQueueDelay {
+			l.stats.Overflowed++
+			return
+		}
+		wire := len(pck.Serialize())
+		l.nextFree = l.nextFree.Add(time.Duration(float64(wire) / l.Bandwidth * float64(time.Second)))
+		queued = l.nextFree.Sub(now)
+	}
+
+	copies := 1
+	if l.chance(l.Duplicate) {
+		l.stats.Duplicated++
+		copies++
+	}
+	for c := 0; c < copies; c++ {
+		delay := queued + l.Delay
+		if l.Jitter > 0 {
+			delay += time.Duration(l.rng.Int63n(int64(l.Jitter)))
+		}
+		if l.chance(l.Reorder) {
+			l.stats.Reordered++
+			delay += l.ReorderDelay
+		}
+
+		wire := pck.Serialize()
+		if l.chance(l.Corrupt) {
+			l.stats.Corrupted++
+			bit := l.rng.Intn(len(wire) * 8)
+			wire[bit/8] ^= 1 << uint(bit%8)
+		}
+
+		// the sender may still modify its own copy while it is in flight
+		cp, err := packet.Deserialize(wire)
+		if err != nil {
+			continue // corrupted beyond recognition
+		}
+		if src, err := pck.GetSourceIP(); err == nil {
+			cp.SetSourceIP(src)
+		}
+		if dst, err := pck.GetDestinationIP(); err == nil {
+			cp.SetDestinationIP(dst)
+		}
+		l.schedule(cp, now.Add(delay), next)
+	}
+}
+
+// lose decides whether the next packet is lost. Must hold l's lock
+func (l *link) lose() bool {
+	if l.Burst == nil {
+		return l.chance(l.Loss)
+	}
+	if l.bad {
+		l.bad = !l.chance(l.Burst.R)
+	} else {
+		l.bad = l.chance(l.Burst.P)
+	}
+	if l.bad {
+		return l.chance(l.Burst.LossBad)
+	}
+	return l.chance(l.Burst.LossGood)
+}
+
+// chance returns true with the given probability. Must hold l's lock
+func (l *link) chance(p float64) bool {
+	return p > 0 && l.rng.Float64() < p
+}
+
+func (l *link) statistics() ImpairmentStats {
+	l.Lock()
+	defer l.Unlock()
+	return l.stats
+}
+
+// schedule queues a packet to be handed to next at the
+// given time of arrival. Must hold l's lock
+func (l *link) schedule(pck *packet.Packet, arrival time.Time, next func(*packet.Packet) error) {
+	l.seq++
+	heap.Push(&l.inFlight, &delivery{pck: pck, arrival: arrival, seq: l.seq, next: next})
+
+	if !l.running {
+		l.running = true
+		l.clock.Go(l.run)
+		return
+	}
+	l.nudge()
+}
+
+// nudge wakes up the goroutine handing over packets. Must hold l's lock
+func (l *link) nudge() {
+	l.woken = true
+	l.wake.Broadcast()
+}
+
+// run hands over packets as they arrive until there are none in flight
+func (l *link) run() {
+	l.Lock()
+	defer l.Unlock()
+
+	for l.inFlight.Len() > 0 {
+		d := l.inFlight[0]
+		if wait := d.arrival.Sub(l.clock.Now()); wait > 0 {
+			// an earlier arrival may be scheduled in the meantime
+			l.woken = false
+			timer := l.clock.AfterFunc(wait, func() {
+				l.Lock()
+				defer l.Unlock()
+				l.nudge()
+			})
+			for !l.woken {
+				l.wake.Wait()
+			}
+			timer.Stop()
+			continue
+		}
+		heap.Pop(&l.inFlight)
+
+		l.Unlock()
+		if err := d.next(d.pck); err != nil {
+			log.Println(errors.Wrap(err, "could not pass on impaired packet"))
+		}
+		l.Lock()
+	}
+	l.running = false
+}
+
+// delivery is a packet in flight through an impaired link
+type delivery struct {
+	pck     *packet.Packet
+	arrival time.Time
+	seq     uint64 // breaks ties in order of transmission
+	next    func(*packet.Packet) error
+}
+
+// deliveryQueue is a min-heap of deliveries by time of arrival
+type deliveryQueue []*delivery
+
+func (q deliveryQueue) Len() int { return len(q) }
+
+func (q deliveryQueue) Less(i, j int) bool {
+	if q[i].arrival.Equal(q[j].arrival) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].arrival.Before(q[j].arrival)
+}
+
+func (q deliveryQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *deliveryQueue) Push(x interface{}) { *q = append(*q, x.(*delivery)) }
+
+func (q *deliveryQueue) Pop() interface{} {
+	old := *q
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return d
+}
@@ -0,0 +1,233 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder is a network which records the packets sent through it
+// and lets tests inject the packets it receives
+type recorder struct {
+	sync.Mutex
+	sent    []*packet.Packet
+	arrived chan bool
+	forward func(*packet.Packet) error
+}
+
+func newRecorder() *recorder {
+	return &recorder{arrived: make(chan bool, 100000)}
+}
+
+func (r *recorder) Send(p *packet.Packet) error {
+	r.Lock()
+	r.sent = append(r.sent, p)
+	r.Unlock()
+	r.arrived <- true
+	return nil
+}
+
+func (r *recorder) StartReceiver(fn func(p *packet.Packet) error) {
+	r.forward = fn
+}
+
+// wait waits for n packets to arrive and returns all those sent so far
+func (r *recorder) wait(t *testing.T, n int) []*packet.Packet {
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.arrived:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("only %d of %d packets arrived", i, n)
+		}
+	}
+	r.Lock()
+	defer r.Unlock()
+	return append([]*packet.Packet{}, r.sent...)
+}
+
+func testPacket(seq uint32) *packet.Packet {
+	p, _ := packet.NewPacket(10, 20, make([]byte, 100))
+	p.SetSeqNo(seq)
+	p.SetDestinationIP(net.ParseIP("10.0.0.2"))
+	return p
+}
+
+// sendAll sends n packets through an impaired network and returns the
+// sequence numbers of those which make it through, in order of arrival
+func sendAll(t *testing.T, c ImpairedConfig, n int) ([]uint32, *Impaired) {
+	r := newRecorder()
+	imp := Impair(r, c)
+	for i := 0; i < n; i++ {
+		assert.Nil(t, imp.Send(testPacket(uint32(i))))
+	}
+	stats := imp.OutboundStats()
+	arrived := n - stats.Lost - stats.Overflowed + stats.Duplicated
+	var seqs []uint32
+	for _, p := range r.wait(t, arrived) {
+		seqs = append(seqs, p.SeqNo)
+	}
+	return seqs, imp
+}
+
+func TestImpairedPassThrough(t *testing.T) {
+	seqs, _ := sendAll(t, ImpairedConfig{}, 1000)
+	assert.Len(t, seqs, 1000)
+	for i, seq := range seqs {
+		assert.Equal(t, uint32(i), seq)
+	}
+}
+
+func TestImpairedLoss(t *testing.T) {
+	c := ImpairedConfig{Outbound: Impairment{Loss: 0.3}, Seed: 42}
+	seqs, imp := sendAll(t, c, 10000)
+
+	stats := imp.OutboundStats()
+	assert.InDelta(t, 3000, stats.Lost, 200)
+	assert.Len(t, seqs, 10000-stats.Lost)
+
+	// the same seed loses the same packets
+	again, _ := sendAll(t, c, 10000)
+	assert.Equal(t, seqs, again)
+
+	c.Seed++
+	other, _ := sendAll(t, c, 10000)
+	assert.NotEqual(t, seqs, other)
+}
+
+func TestImpairedBurstLoss(t *testing.T) {
+	c := ImpairedConfig{Outbound: Impairment{
+		Burst: &GilbertElliott{P: 0.02, R: 0.25, LossBad: 1},
+	}}
+	seqs, _ := sendAll(t, c, 20000)
+
+	// count the runs of consecutive losses
+	losses, bursts := 0, 0
+	prev := int64(-1)
+	for _, seq := range seqs {
+		if gap := int64(seq) - prev - 1; gap > 0 {
+			losses += int(gap)
+			bursts++
+		}
+		prev = int64(seq)
+	}
+
+	// bursts last 1/R = 4 packets on average, and the link
+	// spends P/(P+R) of the time in the bad state
+	assert.InDelta(t, 4, float64(losses)/float64(bursts), 0.5)
+	assert.InDelta(t, 20000*0.02/0.27, losses, 300)
+}
+
+func TestImpairedDelay(t *testing.T) {
+	r := newRecorder()
+	imp := Impair(r, ImpairedConfig{Outbound: Impairment{
+		Delay:  time.Millisecond * 50,
+		Jitter: time.Millisecond * 10,
+	}})
+
+	start := time.Now()
+	assert.Nil(t, imp.Send(testPacket(0)))
+	r.wait(t, 1)
+	assert.True(t, time.Since(start) >= time.Millisecond*50)
+}
+
+func TestImpairedReorder(t *testing.T) {
+	c := ImpairedConfig{Outbound: Impairment{
+		Reorder:      0.2,
+		ReorderDelay: time.Millisecond * 20,
+	}}
+	seqs, imp := sendAll(t, c, 1000)
+
+	assert.Len(t, seqs, 1000)
+	assert.NotZero(t, imp.OutboundStats().Reordered)
+	inOrder := true
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] < seqs[i-1] {
+			inOrder = false
+		}
+	}
+	assert.False(t, inOrder)
+}
+
+func TestImpairedDuplicate(t *testing.T) {
+	seqs, imp := sendAll(t, ImpairedConfig{Outbound: Impairment{Duplicate: 1}}, 100)
+	assert.Len(t, seqs, 200)
+	assert.Equal(t, 100, imp.OutboundStats().Duplicated)
+}
+
+func TestImpairedCorrupt(t *testing.T) {
+	r := newRecorder()
+	imp := Impair(r, ImpairedConfig{Outbound: Impairment{Corrupt: 1}})
+
+	sent := testPacket(0)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, imp.Send(sent))
+	}
+	assert.Equal(t, 100, imp.OutboundStats().Corrupted)
+
+	// packets which can still be parsed arrive with a single bit flipped
+	time.Sleep(time.Millisecond * 100)
+	r.Lock()
+	defer r.Unlock()
+	assert.NotEmpty(t, r.sent)
+	original := sent.Serialize()
+	for _, p := range r.sent {
+		corrupted := p.Serialize()
+		if len(corrupted) != len(original) {
+			continue // the length field was hit
+		}
+		flipped := 0
+		for i := range original {
+			for diff := original[i] ^ corrupted[i]; diff != 0; diff &= diff - 1 {
+				flipped++
+			}
+		}
+		assert.Equal(t, 1, flipped)
+	}
+}
+
+func TestImpairedBandwidth(t *testing.T) {
+	wire := len(testPacket(0).Serialize())
+
+	// ten packets through a link which takes 10ms for each
+	r := newRecorder()
+	imp := Impair(r, ImpairedConfig{Outbound: Impairment{Bandwidth: float64(wire) * 100}})
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, imp.Send(testPacket(uint32(i))))
+	}
+	r.wait(t, 10)
+	assert.True(t, time.Since(start) >= time.Millisecond*100)
+
+	// a queue which holds no more than 50ms of packets
+	_, imp = sendAll(t, ImpairedConfig{Outbound: Impairment{
+		Bandwidth:     float64(wire) * 100,
+		MaxQueueDelay: time.Millisecond * 50,
+	}}, 10)
+	assert.Equal(t, 4, imp.OutboundStats().Overflowed)
+}
+
+func TestImpairedInbound(t *testing.T) {
+	r := newRecorder()
+	imp := Impair(r, ImpairedConfig{Inbound: Impairment{Duplicate: 1}})
+
+	received := make(chan *packet.Packet, 2)
+	imp.StartReceiver(func(p *packet.Packet) error {
+		received <- p
+		return nil
+	})
+	assert.Nil(t, r.forward(testPacket(7)))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-received:
+			assert.Equal(t, uint32(7), p.SeqNo)
+		case <-time.After(time.Second):
+			t.Fatal("packet not received")
+		}
+	}
+	assert.Equal(t, ImpairmentStats{}, imp.OutboundStats())
+}
@@ -3,6 +3,7 @@ package network
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"syscall"
 
@@ -33,7 +34,7 @@ func NewIPv4() (*IPv4, error) {
 
 // Send sends a packet to the destination IP address
 func (ip *IPv4) Send(pck *packet.Packet) error {
-	dstIP, err := pck.GetDestinationIPv4()
+	dstIP, err := pck.GetDestinationIP()
 	if err != nil {
 		return errors.Wrap(err, "could not determine destination IP addresss")
 	}
@@ -47,6 +48,12 @@ func (ip *IPv4) Send(pck *packet.Packet) error {
 	return nil
 }
 
+// MaxPacketSize returns the largest rdtp packet which fits in an
+// IP packet the size of the outgoing interface's MTU
+func (ip *IPv4) MaxPacketSize(dst net.IP) int {
+	return interfaceMTU(dst) - IPv4HeaderBytes
+}
+
 // StartReceiver forwards all ipv4 packets received which carry rdtp
 // These ipv4 packets are processed until an rdtp packet.Packet
 // is extracted, then the next() function is called with the packet
@@ -84,8 +91,8 @@ func (ip *IPv4) StartReceiver(forward func(*packet.Packet) error) {
 				continue
 			}
 
-			rdtpPacket.SetDestinationIPv4(ipv4.DstIP)
-			rdtpPacket.SetSourceIPv4(ipv4.SrcIP)
+			rdtpPacket.SetDestinationIP(ipv4.DstIP)
+			rdtpPacket.SetSourceIP(ipv4.SrcIP)
 
 			if err = forward(rdtpPacket); err != nil {
 				log.Println(errors.Wrap(err, "could not forward received rdtp packet"))
@@ -0,0 +1,126 @@
+package network
+
+import (
+	"log"
+	"net"
+	"syscall"
+
+	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
+)
+
+// IPv6 represents the underlying IPv6 network
+// and functions to interact with the network
+// interface
+type IPv6 struct {
+	sckfd int
+}
+
+// NewIPv6 returns a new ipv6 network interface
+func NewIPv6() (*IPv6, error) {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, rdtp.IPProtoRDTP)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get raw network socket")
+	}
+	// raw IPv6 sockets do not hand over the IP header, so
+	// the destination address is received as ancillary data
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1); err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrap(err, "could not enable packet info on raw network socket")
+	}
+	return &IPv6{
+		sckfd: fd,
+	}, nil
+}
+
+// Send sends a packet to the destination IP address
+func (ip *IPv6) Send(pck *packet.Packet) error {
+	dstIP, err := pck.GetDestinationIP()
+	if err != nil {
+		return errors.Wrap(err, "could not determine destination IP addresss")
+	}
+	if dstIP.To4() != nil {
+		return errors.Errorf("%s is not an IPv6 address", dstIP)
+	}
+
+	var remote syscall.SockaddrInet6
+	copy(remote.Addr[:], dstIP.To16())
+
+	if err := syscall.Sendto(ip.sckfd, pck.Serialize(), 0, &remote); err != nil {
+		return errors.Wrap(err, "could not send data to network socket")
+	}
+	return nil
+}
+
+// MaxPacketSize returns the largest rdtp packet which fits in an
+// IP packet the size of the outgoing interface's MTU
+func (ip *IPv6) MaxPacketSize(dst net.IP) int {
+	return interfaceMTU(dst) - IPv6HeaderBytes
+}
+
+// StartReceiver forwards all rdtp packets received over ipv6,
+// the next() function is called with each packet received
+func (ip *IPv6) StartReceiver(forward func(*packet.Packet) error) {
+	buf := make([]byte, 65535) // maximum IP payload
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofInet6Pktinfo))
+
+	go func() {
+		defer syscall.Close(ip.sckfd)
+
+		for {
+			n, oobn, _, from, err := syscall.Recvmsg(ip.sckfd, buf, oob, 0)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				log.Println(errors.Wrap(err, "could not read data from network socket"))
+				continue
+			}
+
+			src, ok := from.(*syscall.SockaddrInet6)
+			if !ok {
+				log.Println("not an ipv6 packet")
+				continue
+			}
+
+			dst, err := destinationIPv6(oob[:oobn])
+			if err != nil {
+				log.Println(errors.Wrap(err, "could not determine destination of ipv6 packet"))
+				continue
+			}
+
+			// packets hold on to the bytes they are deserialized from
+			rdtpPacket, err := packet.Deserialize(append([]byte(nil), buf[:n]...))
+			if err != nil {
+				log.Println(errors.Wrap(err, "could not deserialize rdtp packet"))
+				continue
+			}
+
+			rdtpPacket.SetDestinationIP(dst)
+			rdtpPacket.SetSourceIP(net.IP(append([]byte(nil), src.Addr[:]...)))
+
+			if err = forward(rdtpPacket); err != nil {
+				log.Println(errors.Wrap(err, "could not forward received rdtp packet"))
+				continue
+			}
+		}
+	}()
+}
+
+// destinationIPv6 extracts the destination address of a
+// received packet from its IPV6_PKTINFO control message
+func destinationIPv6(oob []byte) (net.IP, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse control messages")
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.IPPROTO_IPV6 &&
+			msg.Header.Type == syscall.IPV6_PKTINFO &&
+			len(msg.Data) >= net.IPv6len {
+			return net.IP(append([]byte(nil), msg.Data[:net.IPv6len]...)), nil
+		}
+	}
+	return nil, errors.New("no packet info control message")
+}
@@ -0,0 +1,76 @@
+package network
+
+import (
+	"net"
+)
+
+const (
+	// DefaultMTU is the MTU assumed for links whose MTU is unknown
+	DefaultMTU = 1500
+
+	// IPv4HeaderBytes is the byte size of an IPv4 header without options
+	IPv4HeaderBytes = 20
+
+	// IPv6HeaderBytes is the byte size of an IPv6 header
+	IPv6HeaderBytes = 40
+
+	// udpHeaderBytes is the byte size of a UDP header
+	udpHeaderBytes = 8
+)
+
+// MaxPacketSizer is implemented by networks which know the largest
+// rdtp packet they can send to a destination without fragmenting it
+type MaxPacketSizer interface {
+	MaxPacketSize(dst net.IP) int
+}
+
+// MaxPacketSize returns the largest rdtp packet the network can send to
+// the destination without fragmenting it. Unless the network tells, that
+// is what fits in an IP packet of DefaultMTU bytes
+func MaxPacketSize(n Network, dst net.IP) int {
+	if sizer, ok := n.(MaxPacketSizer); ok {
+		return sizer.MaxPacketSize(dst)
+	}
+	return DefaultMTU - ipHeaderBytes(dst)
+}
+
+// ipHeaderBytes returns the byte size of the header of IP packets to dst
+func ipHeaderBytes(dst net.IP) int {
+	if dst.To4() != nil {
+		return IPv4HeaderBytes
+	}
+	return IPv6HeaderBytes
+}
+
+// interfaceMTU returns the MTU of the interface packets to dst
+// leave through, or DefaultMTU if that cannot be determined
+func interfaceMTU(dst net.IP) int {
+	network := "udp4"
+	if dst.To4() == nil {
+		network = "udp6"
+	}
+	// dialing UDP only picks a route, it sends nothing
+	conn, err := net.DialUDP(network, nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return DefaultMTU
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return DefaultMTU
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(local) && iface.MTU > 0 {
+				return iface.MTU
+			}
+		}
+	}
+	return DefaultMTU
+}
@@ -0,0 +1,181 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
+)
+
+// switchPortQueueSize is the number of packets a switch port
+// holds for its host before dropping any more
+const switchPortQueueSize = 1024
+
+// Switch is an in-memory virtual switch which connects simulated
+// hosts by IP address, e.g. to run several rdtp services in a
+// single process. Packets are delivered in order and never lost
+// unless a host's queue overflows or they exceed the switch's MTU
+type Switch struct {
+	sync.RWMutex
+	ports map[string]*SwitchPort
+	mtu   int         // largest IP packet forwarded, unlimited if zero
+	clock clock.Clock // runs the hosts' receivers
+}
+
+// SwitchPort is the network of a host attached to a switch
+type SwitchPort struct {
+	ip net.IP
+	sw *Switch
+
+	// packets held for the host, at most switchPortQueueSize,
+	// until its receiver hands them over
+	mu      sync.Mutex
+	queue   []*packet.Packet
+	arrived clock.Cond
+	closed  bool
+
+	startOnce sync.Once
+}
+
+// NewSwitch returns a virtual switch with no hosts attached
+func NewSwitch() *Switch {
+	return &Switch{ports: make(map[string]*SwitchPort), clock: clock.Real{}}
+}
+
+// SetClock sets the clock the receivers of the hosts attached
+// from then on run on, e.g. a simulated clock (defaults to real time)
+func (sw *Switch) SetClock(clk clock.Clock) {
+	sw.Lock()
+	defer sw.Unlock()
+	sw.clock = clk
+}
+
+// Attach connects a host with the given IP address to the switch
+// and returns its network
+func (sw *Switch) Attach(ip net.IP) (*SwitchPort, error) {
+	if ip == nil {
+		return nil, errors.New("host IP address cannot be nil")
+	}
+
+	sw.Lock()
+	defer sw.Unlock()
+
+	if _, ok := sw.ports[ip.String()]; ok {
+		return nil, fmt.Errorf("host %s already attached", ip)
+	}
+	port := &SwitchPort{ip: ip, sw: sw}
+	port.arrived = sw.clock.NewCond(&port.mu)
+	sw.ports[ip.String()] = port
+	return port, nil
+}
+
+// SetMTU sets the size of the largest IP packet the switch forwards,
+// which may change at any time like the MTU of a path would. Larger
+// packets are dropped silently, as with the DF bit set. Zero lifts the limit
+func (sw *Switch) SetMTU(mtu int) {
+	sw.Lock()
+	defer sw.Unlock()
+	sw.mtu = mtu
+}
+
+// forward hands a copy of a packet to the host with the destination
+// IP address, as if it had gone through a wire. Packets for unknown
+// hosts are dropped silently, like on a real network
+func (sw *Switch) forward(src net.IP, pck *packet.Packet) error {
+	dstIP, err := pck.GetDestinationIP()
+	if err != nil {
+		return errors.Wrap(err, "could not determine destination IP addresss")
+	}
+
+	// the sender may still modify its own copy (e.g. to retransmit it)
+	b := pck.Serialize()
+	wire, err := packet.Deserialize(b)
+	if err != nil {
+		return errors.Wrap(err, "could not copy packet")
+	}
+	wire.SetSourceIP(src)
+	wire.SetDestinationIP(dstIP)
+
+	sw.RLock()
+	defer sw.RUnlock()
+
+	if sw.mtu > 0 && ipHeaderBytes(dstIP)+len(b) > sw.mtu {
+		return nil
+	}
+	port, ok := sw.ports[dstIP.String()]
+	if !ok {
+		return nil
+	}
+	port.mu.Lock()
+	defer port.mu.Unlock()
+
+	if !port.closed && len(port.queue) < switchPortQueueSize {
+		port.queue = append(port.queue, wire)
+		port.arrived.Broadcast()
+	}
+	return nil
+}
+
+// IP returns the IP address of the host
+func (p *SwitchPort) IP() net.IP {
+	return p.ip
+}
+
+// Send sends a packet to the destination IP address through the switch
+func (p *SwitchPort) Send(pck *packet.Packet) error {
+	return p.sw.forward(p.ip, pck)
+}
+
+// StartReceiver forwards all packets sent to the host through the switch
+func (p *SwitchPort) StartReceiver(forward func(*packet.Packet) error) {
+	p.sw.RLock()
+	clk := p.sw.clock
+	p.sw.RUnlock()
+
+	p.startOnce.Do(func() {
+		clk.Go(func() { p.receive(forward) })
+	})
+}
+
+// receive hands over the packets queued for the host
+// until the port is detached and none are left
+func (p *SwitchPort) receive(forward func(*packet.Packet) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		for !p.closed && len(p.queue) == 0 {
+			p.arrived.Wait()
+		}
+		if len(p.queue) == 0 {
+			return
+		}
+		pck := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+
+		p.mu.Unlock()
+		if err := forward(pck); err != nil {
+			log.Println(errors.Wrap(err, "could not forward received rdtp packet"))
+		}
+		p.mu.Lock()
+	}
+}
+
+// Detach disconnects the host from the switch
+func (p *SwitchPort) Detach() {
+	p.sw.Lock()
+	if p.sw.ports[p.ip.String()] == p {
+		delete(p.sw.ports, p.ip.String())
+	}
+	p.sw.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.arrived.Broadcast()
+}
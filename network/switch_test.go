@@ -0,0 +1,107 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwitch(t *testing.T) {
+	sw := NewSwitch()
+
+	a, err := sw.Attach(net.ParseIP("10.0.0.1"))
+	assert.Nil(t, err)
+	defer a.Detach()
+	b, err := sw.Attach(net.ParseIP("10.0.0.2"))
+	assert.Nil(t, err)
+	defer b.Detach()
+
+	_, err = sw.Attach(net.ParseIP("10.0.0.2"))
+	assert.NotNil(t, err)
+
+	received := make(chan *packet.Packet, 1)
+	b.StartReceiver(func(p *packet.Packet) error {
+		received <- p
+		return nil
+	})
+
+	p, err := packet.NewPacket(10, 20, []byte("hello"))
+	assert.Nil(t, err)
+	p.SetDestinationIP(net.ParseIP("10.0.0.2"))
+	assert.Nil(t, a.Send(p))
+
+	// changes made by the sender after sending are not seen by the receiver
+	p.Payload[0] = 'j'
+
+	select {
+	case got := <-received:
+		assert.Equal(t, []byte("hello"), got.Payload)
+		src, err := got.GetSourceIP()
+		assert.Nil(t, err)
+		assert.True(t, src.Equal(net.ParseIP("10.0.0.1")))
+		dst, err := got.GetDestinationIP()
+		assert.Nil(t, err)
+		assert.True(t, dst.Equal(net.ParseIP("10.0.0.2")))
+	case <-time.After(time.Second):
+		t.Fatal("packet not received")
+	}
+
+	// packets to unknown hosts are dropped
+	p.SetDestinationIP(net.ParseIP("10.0.0.3"))
+	assert.Nil(t, a.Send(p))
+
+	// detached hosts are unknown
+	b.Detach()
+	p.SetDestinationIP(net.ParseIP("10.0.0.2"))
+	assert.Nil(t, a.Send(p))
+	_, err = sw.Attach(net.ParseIP("10.0.0.2"))
+	assert.Nil(t, err)
+}
+
+func TestSwitchMTU(t *testing.T) {
+	sw := NewSwitch()
+
+	a, err := sw.Attach(net.ParseIP("10.0.0.1"))
+	assert.Nil(t, err)
+	defer a.Detach()
+	b, err := sw.Attach(net.ParseIP("10.0.0.2"))
+	assert.Nil(t, err)
+	defer b.Detach()
+
+	received := make(chan *packet.Packet, 2)
+	b.StartReceiver(func(p *packet.Packet) error {
+		received <- p
+		return nil
+	})
+
+	// a packet which just fits in an IPv4 packet of the MTU
+	sw.SetMTU(576)
+	small, err := packet.NewPacket(10, 20, make([]byte, 576-IPv4HeaderBytes-packet.HeaderByteSize))
+	assert.Nil(t, err)
+	small.SetDestinationIP(net.ParseIP("10.0.0.2"))
+	big, err := packet.NewPacket(10, 20, make([]byte, len(small.Payload)+1))
+	assert.Nil(t, err)
+	big.SetDestinationIP(net.ParseIP("10.0.0.2"))
+
+	// gets through, unlike a larger one
+	assert.Nil(t, a.Send(big))
+	assert.Nil(t, a.Send(small))
+	select {
+	case got := <-received:
+		assert.Equal(t, len(small.Payload), len(got.Payload))
+	case <-time.After(time.Second):
+		t.Fatal("packet not received")
+	}
+	select {
+	case <-received:
+		t.Fatal("packet larger than the MTU received")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	// networks tell how large a packet they send unfragmented
+	assert.Equal(t, DefaultMTU-IPv4HeaderBytes, MaxPacketSize(a, net.ParseIP("10.0.0.2")))
+	assert.Equal(t, DefaultMTU-IPv6HeaderBytes, MaxPacketSize(a, net.ParseIP("fd00::2")))
+}
@@ -0,0 +1,203 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
+)
+
+const (
+	// peerIdleTimeout is the time after which the UDP address of a remote
+	// socket which has not sent anything is forgotten
+	peerIdleTimeout = time.Minute * 5
+
+	// maxUDPDatagram is the largest UDP payload
+	maxUDPDatagram = 65507
+)
+
+// UDP represents a network which encapsulates rdtp packets
+// in UDP datagrams, which requires no privileges and goes
+// through NATs that drop unknown IP protocols
+type UDP struct {
+	conn    *net.UDPConn
+	localIP net.IP // destination set on received packets
+	port    int    // port remote rdtp services are reached on
+
+	// UDP address each remote socket was last seen at, keyed
+	// by its IP and rdtp port, so that replies make it back
+	// through a NAT which remapped the remote's UDP port
+	sync.Mutex
+	peers     map[string]*udpPeer
+	lastPrune time.Time
+}
+
+type udpPeer struct {
+	addr     *net.UDPAddr
+	lastSeen time.Time
+}
+
+// NewUDP returns a new UDP encapsulation network listening on the given
+// IPv4 or IPv6 address and port. All remote rdtp services are expected to
+// listen on the same port. If the address is nil or unspecified, datagrams
+// are received on all interfaces and addressed to the preferred outbound
+// IP address of the family (IPv4 if nil)
+func NewUDP(ip net.IP, port int) (*UDP, error) {
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+	network := "udp4"
+	if ip.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: ip, Port: port})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not listen for UDP datagrams")
+	}
+
+	localIP := ip
+	if ip.IsUnspecified() {
+		if localIP, err = outboundIP(network); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "could not determine local IP address")
+		}
+	}
+
+	return &UDP{
+		conn:    conn,
+		localIP: localIP,
+		port:    conn.LocalAddr().(*net.UDPAddr).Port,
+		peers:   make(map[string]*udpPeer),
+	}, nil
+}
+
+// Port returns the UDP port the network is listening on
+func (u *UDP) Port() int {
+	return u.port
+}
+
+// Close stops the network from sending and receiving datagrams
+func (u *UDP) Close() error {
+	return u.conn.Close()
+}
+
+// Send sends a packet to the destination IP address, at the UDP address
+// the remote socket was last seen at if it has sent anything
+func (u *UDP) Send(pck *packet.Packet) error {
+	dstIP, err := pck.GetDestinationIP()
+	if err != nil {
+		return errors.Wrap(err, "could not determine destination IP addresss")
+	}
+
+	raddr := &net.UDPAddr{IP: dstIP, Port: u.port}
+	u.Lock()
+	if peer, ok := u.peers[peerKey(dstIP, pck.DstPort)]; ok {
+		raddr = peer.addr
+	}
+	u.Unlock()
+
+	// the addresses of either end differ across a NAT, so the
+	// checksum sent covers none of them (see StartReceiver)
+	unaddressed := *pck
+	unaddressed.SetSourceIP(nil)
+	unaddressed.SetDestinationIP(nil)
+	unaddressed.SetSum()
+
+	if _, err := u.conn.WriteToUDP(unaddressed.Serialize(), raddr); err != nil {
+		return errors.Wrap(err, "could not send data to network socket")
+	}
+	return nil
+}
+
+// MaxPacketSize returns the largest rdtp packet which fits in a UDP
+// datagram in an IP packet the size of the outgoing interface's MTU
+func (u *UDP) MaxPacketSize(dst net.IP) int {
+	return interfaceMTU(dst) - ipHeaderBytes(dst) - udpHeaderBytes
+}
+
+// StartReceiver forwards all rdtp packets received in UDP datagrams.
+// The packet's source is the IP address the datagram was received from
+func (u *UDP) StartReceiver(forward func(*packet.Packet) error) {
+	buf := make([]byte, maxUDPDatagram)
+
+	go func() {
+		for {
+			n, raddr, err := u.conn.ReadFromUDP(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				log.Println(errors.Wrap(err, "could not read data from network socket"))
+				continue
+			}
+
+			// packets hold on to the bytes they are deserialized from
+			rdtpPacket, err := packet.Deserialize(append([]byte(nil), buf[:n]...))
+			if err != nil {
+				log.Println(errors.Wrap(err, "could not deserialize rdtp packet"))
+				continue
+			}
+
+			// the checksum is translated to cover the addresses of this
+			// end, as a NAT would, only if the packet arrived intact. The
+			// checksum of a corrupted packet then fails in the receive path,
+			// and its source port is not trusted to learn the remote from
+			intact := rdtpPacket.CheckSum()
+			if intact {
+				u.learn(raddr, rdtpPacket.SrcPort, time.Now())
+			}
+			rdtpPacket.SetDestinationIP(u.localIP)
+			rdtpPacket.SetSourceIP(raddr.IP)
+			if intact {
+				rdtpPacket.SetSum()
+			}
+
+			if err = forward(rdtpPacket); err != nil {
+				log.Println(errors.Wrap(err, "could not forward received rdtp packet"))
+				continue
+			}
+		}
+	}()
+}
+
+// learn records the UDP address a remote socket was seen at and
+// forgets those of remote sockets which have been idle for long
+func (u *UDP) learn(raddr *net.UDPAddr, port uint16, now time.Time) {
+	u.Lock()
+	defer u.Unlock()
+
+	u.peers[peerKey(raddr.IP, port)] = &udpPeer{addr: raddr, lastSeen: now}
+
+	if now.Sub(u.lastPrune) < time.Minute {
+		return
+	}
+	u.lastPrune = now
+	for key, peer := range u.peers {
+		if now.Sub(peer.lastSeen) > peerIdleTimeout {
+			delete(u.peers, key)
+		}
+	}
+}
+
+func peerKey(ip net.IP, port uint16) string {
+	return fmt.Sprintf("%s:%d", ip.String(), port)
+}
+
+// get preferred outbound IP address of this machine
+// on the given network, either "udp4" or "udp6"
+func outboundIP(network string) (net.IP, error) {
+	remote := "8.8.8.8:80"
+	if network == "udp6" {
+		remote = "[2001:4860:4860::8888]:80"
+	}
+	conn, err := net.Dial(network, remote)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
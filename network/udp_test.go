@@ -0,0 +1,220 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveOne(u *UDP) chan *packet.Packet {
+	received := make(chan *packet.Packet, 1)
+	u.StartReceiver(func(p *packet.Packet) error {
+		received <- p
+		return nil
+	})
+	return received
+}
+
+func TestUDP(t *testing.T) {
+	a, err := NewUDP(net.ParseIP("127.0.0.1"), 0)
+	assert.Nil(t, err)
+	defer a.Close()
+
+	// remote services listen on the same port
+	b, err := NewUDP(net.ParseIP("127.0.0.2"), a.Port())
+	assert.Nil(t, err)
+	defer b.Close()
+
+	received := receiveOne(b)
+
+	p, err := packet.NewPacket(10, 20, []byte("hello"))
+	assert.Nil(t, err)
+	p.SetDestinationIP(net.ParseIP("127.0.0.2"))
+	assert.Nil(t, a.Send(p))
+
+	select {
+	case got := <-received:
+		assert.Equal(t, []byte("hello"), got.Payload)
+		assert.Equal(t, uint16(10), got.SrcPort)
+		assert.True(t, got.CheckSum())
+		src, err := got.GetSourceIP()
+		assert.Nil(t, err)
+		assert.True(t, src.Equal(net.ParseIP("127.0.0.1")))
+		dst, err := got.GetDestinationIP()
+		assert.Nil(t, err)
+		assert.True(t, dst.Equal(net.ParseIP("127.0.0.2")))
+	case <-time.After(time.Second):
+		t.Fatal("packet not received")
+	}
+}
+
+func TestUDPRemappedPort(t *testing.T) {
+	u, err := NewUDP(net.ParseIP("127.0.0.1"), 0)
+	assert.Nil(t, err)
+	defer u.Close()
+	received := receiveOne(u)
+
+	// a remote behind a NAT which remapped its UDP port
+	nat, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.2")})
+	assert.Nil(t, err)
+	defer nat.Close()
+
+	p, err := packet.NewPacket(10, 20, []byte("ping"))
+	assert.Nil(t, err)
+	p.SetSum()
+	_, err = nat.WriteToUDP(p.Serialize(), &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: u.Port()})
+	assert.Nil(t, err)
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("packet not received")
+	}
+
+	// replies go to the address the remote socket was seen at
+	reply, err := packet.NewPacket(20, 10, []byte("pong"))
+	assert.Nil(t, err)
+	reply.SetDestinationIP(net.ParseIP("127.0.0.2"))
+	assert.Nil(t, u.Send(reply))
+
+	buf := make([]byte, 1500)
+	nat.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := nat.Read(buf)
+	assert.Nil(t, err)
+	got, err := packet.Deserialize(buf[:n])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("pong"), got.Payload)
+}
+
+func TestUDPChecksum(t *testing.T) {
+	u, err := NewUDP(net.ParseIP("127.0.0.1"), 0)
+	assert.Nil(t, err)
+	defer u.Close()
+	received := make(chan *packet.Packet, 2)
+	u.StartReceiver(func(p *packet.Packet) error {
+		received <- p
+		return nil
+	})
+
+	remote, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.2")})
+	assert.Nil(t, err)
+	defer remote.Close()
+
+	// checksums sent over UDP cover no addresses
+	p, err := packet.NewPacket(10, 20, []byte("ping"))
+	assert.Nil(t, err)
+	p.SetSum()
+	intact := p.Serialize()
+	corrupted := append([]byte{}, intact...)
+	corrupted[len(corrupted)-1] ^= 0x01
+
+	for _, datagram := range [][]byte{intact, corrupted} {
+		_, err = remote.WriteToUDP(datagram, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: u.Port()})
+		assert.Nil(t, err)
+	}
+
+	// and are translated to cover the addresses of this end if intact
+	for _, want := range []bool{true, false} {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got.CheckSum())
+		case <-time.After(time.Second):
+			t.Fatal("packet not received")
+		}
+	}
+}
+
+func TestUDPCorruptedPeer(t *testing.T) {
+	u, err := NewUDP(net.ParseIP("127.0.0.1"), 0)
+	assert.Nil(t, err)
+	defer u.Close()
+	received := make(chan *packet.Packet, 2)
+	u.StartReceiver(func(p *packet.Packet) error {
+		received <- p
+		return nil
+	})
+
+	remote, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.2")})
+	assert.Nil(t, err)
+	defer remote.Close()
+	other, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.2")})
+	assert.Nil(t, err)
+	defer other.Close()
+
+	p, err := packet.NewPacket(10, 20, []byte("ping"))
+	assert.Nil(t, err)
+	p.SetSum()
+	intact := p.Serialize()
+	corrupted := append([]byte{}, intact...)
+	corrupted[len(corrupted)-1] ^= 0x01
+
+	// the remote socket is learnt from an intact packet, but not
+	// moved to another address by a corrupted one claiming its port
+	dst := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: u.Port()}
+	_, err = remote.WriteToUDP(intact, dst)
+	assert.Nil(t, err)
+	_, err = other.WriteToUDP(corrupted, dst)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("packet not received")
+		}
+	}
+
+	u.Lock()
+	peer, ok := u.peers[peerKey(net.ParseIP("127.0.0.2"), 10)]
+	u.Unlock()
+	assert.True(t, ok)
+	assert.Equal(t, remote.LocalAddr().(*net.UDPAddr).Port, peer.addr.Port)
+}
+
+func TestUDPIPv6(t *testing.T) {
+	a, err := NewUDP(net.IPv6loopback, 0)
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %s", err)
+	}
+	defer a.Close()
+
+	// the rdtp port alone tells sockets on the same host apart
+	received := receiveOne(a)
+
+	p, err := packet.NewPacket(10, 20, []byte("hello"))
+	assert.Nil(t, err)
+	p.SetDestinationIP(net.IPv6loopback)
+	assert.Nil(t, a.Send(p))
+
+	select {
+	case got := <-received:
+		assert.Equal(t, []byte("hello"), got.Payload)
+		src, err := got.GetSourceIP()
+		assert.Nil(t, err)
+		assert.True(t, src.Equal(net.IPv6loopback))
+		dst, err := got.GetDestinationIP()
+		assert.Nil(t, err)
+		assert.True(t, dst.Equal(net.IPv6loopback))
+	case <-time.After(time.Second):
+		t.Fatal("packet not received")
+	}
+}
+
+func TestUDPMaxPacketSize(t *testing.T) {
+	u, err := NewUDP(net.ParseIP("127.0.0.1"), 0)
+	assert.Nil(t, err)
+	defer u.Close()
+
+	ifaces, err := net.Interfaces()
+	assert.Nil(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			// packets to loopback addresses leave through the loopback interface
+			assert.Equal(t, iface.MTU-IPv4HeaderBytes-udpHeaderBytes, u.MaxPacketSize(net.ParseIP("127.0.0.2")))
+			return
+		}
+	}
+	t.Skip("no loopback interface")
+}
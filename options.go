@@ -0,0 +1,140 @@
+package rdtp
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+)
+
+// options are the settings of the requests made to the rdtp service
+type options struct {
+	serviceAddr       string
+	session           *Session
+	dialService       func(ctx context.Context) (net.Conn, error)
+	clock             clock.Clock
+	localAddr         *Addr
+	congestionControl string
+	receiveBufferSize int
+	sendBufferSize    int
+	keepAlive         time.Duration
+}
+
+// Option configures the requests Dial and Listen make to the rdtp service
+type Option func(*options)
+
+// DialOption configures the connection requested by Dial
+type DialOption = Option
+
+func newOptions(opts []Option) options {
+	o := options{serviceAddr: DefaultRDTPServiceAddr, clock: clock.Real{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// connect opens a connection to the rdtp service for a request,
+// which is a stream of the session to it if one is given
+func (o options) connect(ctx context.Context) (net.Conn, error) {
+	if o.session != nil {
+		return o.session.open()
+	}
+	if o.dialService != nil {
+		return o.dialService(ctx)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", o.serviceAddr)
+}
+
+// request returns a request for the rdtp service
+// carrying the settings of the connection's socket
+func (o options) request(t ClientMessageType, laddr, raddr *Addr) ClientMessage {
+	msg := newClientMessage(t, laddr, raddr)
+	msg.CongestionControl = o.congestionControl
+	msg.ReceiveBufferSize = o.receiveBufferSize
+	msg.SendBufferSize = o.sendBufferSize
+	msg.KeepAliveMillis = o.keepAlive.Milliseconds()
+	return msg
+}
+
+// WithServiceAddr makes requests to the rdtp service listening on the
+// given unix socket rather than on the default DefaultRDTPServiceAddr,
+// e.g. to run more than one rdtp service on the same host
+func WithServiceAddr(addr string) Option {
+	return func(o *options) {
+		o.serviceAddr = addr
+	}
+}
+
+// WithCongestionControl selects the congestion control algorithm used
+// when sending on the connection: "newreno", "cubic", "bbr" or "ledbat"
+// for background transfers which yield to other traffic (see the
+// congestion package). When given to Listen, it applies to all the
+// connections accepted. The rdtp service default is used if no
+// algorithm is selected
+func WithCongestionControl(algorithm string) Option {
+	return func(o *options) {
+		o.congestionControl = algorithm
+	}
+}
+
+// WithReceiveBufferSize sets the number of bytes the connection holds
+// for reassembly and delivery to the application, which bounds the
+// window advertised to the remote. When given to Listen, it applies
+// to all the connections accepted
+func WithReceiveBufferSize(bytes int) Option {
+	return func(o *options) {
+		o.receiveBufferSize = bytes
+	}
+}
+
+// WithSendBufferSize sets the number of bytes the connection holds
+// for retransmission, i.e. sent but not acknowledged yet, which
+// is otherwise bounded by the remote's window alone. When given
+// to Listen, it applies to all the connections accepted
+func WithSendBufferSize(bytes int) Option {
+	return func(o *options) {
+		o.sendBufferSize = bytes
+	}
+}
+
+// WithKeepAlive makes the connection probe the remote whenever it is
+// silent for the given period, and close once it leaves the probes
+// unanswered. When given to Listen, it applies to all the connections
+// accepted. Keepalives are disabled by default
+func WithKeepAlive(period time.Duration) Option {
+	return func(o *options) {
+		o.keepAlive = period
+	}
+}
+
+// WithServiceDialer makes requests to the rdtp service over the
+// connections dial opens, rather than over unix connections to
+// the service's address, e.g. to reach a service in the same process
+func WithServiceDialer(dial func(ctx context.Context) (net.Conn, error)) Option {
+	return func(o *options) {
+		o.dialService = dial
+	}
+}
+
+// WithClock sets the clock the connection waits on while reading from
+// the rdtp service, e.g. the simulated clock of a service running on
+// simulated time (see the sim package). Real time is used if nil
+func WithClock(clk clock.Clock) Option {
+	return func(o *options) {
+		if clk != nil {
+			o.clock = clk
+		}
+	}
+}
+
+// WithSession makes requests to the rdtp service over a session
+// shared with other connections and listeners (see NewSession),
+// rather than over a unix connection of their own
+func WithSession(s *Session) Option {
+	return func(o *options) {
+		o.session = s
+	}
+}
@@ -0,0 +1,36 @@
+package packet
+
+// NewAck returns an acknowledgement of a packet, addressed back to its
+// sender, as sent on behalf of connections which no longer have a socket
+func NewAck(p *Packet) *Packet {
+	a := answer(p)
+	a.SetSum()
+	return a
+}
+
+// answer returns an acknowledgement of a packet addressed back to its
+// sender, in the packet's version or the latest spoken if it is newer.
+// Its sequence number is the acknowledgement number of the packet,
+// if it has one, so that it falls within the sender's receive window.
+// FINs take up a sequence number from version 1 on
+func answer(p *Packet) *Packet {
+	a := &Packet{
+		SrcPort: p.DstPort,
+		DstPort: p.SrcPort,
+		Version: p.Version,
+		srcIP:   p.dstIP,
+		dstIP:   p.srcIP,
+	}
+	if a.Version > MaxVersion {
+		a.Version = MaxVersion
+	}
+	if p.IsACK() {
+		a.SetSeqNo(p.AckNo)
+	}
+	a.SetFlagACK()
+	a.SetAckNo(p.SeqNo + uint32(len(p.Payload)))
+	if p.IsFIN() && p.Version != Version0 {
+		a.SetAckNo(a.AckNo + 1)
+	}
+	return a
+}
@@ -0,0 +1,38 @@
+package packet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAck(t *testing.T) {
+	p, err := NewPacket(uint16(1024), uint16(4444), nil)
+	assert.Nil(t, err)
+	p.Version = Version1
+	p.SetSeqNo(1000)
+	p.SetFlagFIN()
+	p.SetFlagACK()
+	p.SetAckNo(2000)
+	p.SetSourceIP(net.ParseIP("10.0.0.1"))
+	p.SetDestinationIP(net.ParseIP("10.0.0.2"))
+
+	a := NewAck(p)
+	assert.True(t, a.IsACK())
+	assert.False(t, a.IsFIN() || a.IsERR())
+	assert.Equal(t, uint16(4444), a.SrcPort)
+	assert.Equal(t, uint16(1024), a.DstPort)
+	assert.Equal(t, uint32(2000), a.SeqNo)
+	assert.Equal(t, uint32(1001), a.AckNo) // FINs take up a sequence number
+	assert.Equal(t, Version1, a.Version)
+	assert.True(t, a.CheckSum())
+
+	src, err := a.GetSourceIP()
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2", src.String())
+
+	// from version 1 on
+	p.Version = Version0
+	assert.Equal(t, uint32(1000), NewAck(p).AckNo)
+}
@@ -3,7 +3,9 @@ package factory
 import (
 	"fmt"
 	"net"
+	"time"
 
+	"github.com/adrianosela/rdtp/clock"
 	"github.com/adrianosela/rdtp/packet"
 	"github.com/pkg/errors"
 )
@@ -18,6 +20,27 @@ type PacketFactory struct {
 	rport  uint16
 	fwFunc func(*packet.Packet) error
 	size   int
+	seq    uint32             // sequence number of the next data byte
+	ack    uint32             // sequence number of the next byte expected from remote
+	wnd    uint16             // receive window advertised to remote
+	sack   []packet.SACKBlock // out-of-order data held from remote
+
+	// timestamps are stamped on all packets once enabled,
+	// along with the delay of the latest packet from remote
+	timestamps bool
+	delay      uint32
+
+	// source of the timestamps
+	clock clock.Clock
+
+	// protocol version of all packets, the latest
+	// until negotiated otherwise with the remote
+	version uint8
+
+	// largest payload of data packets, options included, and
+	// the largest the local end receives, advertised on SYNs
+	mss           int
+	advertisedMSS uint16
 }
 
 // New returns a new packet factory
@@ -26,24 +49,30 @@ func New(lhost, rhost net.IP, lport, rport uint16, size int, fw func(*packet.Pac
 		return nil, fmt.Errorf("max size is %d", packet.MaxPayloadBytes)
 	}
 	return &PacketFactory{
-		lhost:  lhost,
-		rhost:  rhost,
-		lport:  lport,
-		rport:  rport,
-		fwFunc: fw,
-		size:   size,
+		lhost:   lhost,
+		rhost:   rhost,
+		lport:   lport,
+		rport:   rport,
+		fwFunc:  fw,
+		size:    size,
+		clock:   clock.Real{},
+		version: packet.MaxVersion,
+		mss:     packet.MaxPayloadBytes,
 	}, nil
 }
 
 // DefaultPacketFactory returns a new packet factory with the maximum chunk size
 func DefaultPacketFactory(lhost, rhost net.IP, lport, rport uint16, fw func(*packet.Packet) error) *PacketFactory {
 	return &PacketFactory{
-		lhost:  lhost,
-		rhost:  rhost,
-		lport:  lport,
-		rport:  rport,
-		fwFunc: fw,
-		size:   packet.MaxPayloadBytes,
+		lhost:   lhost,
+		rhost:   rhost,
+		lport:   lport,
+		rport:   rport,
+		fwFunc:  fw,
+		size:    packet.MaxPayloadBytes,
+		clock:   clock.Real{},
+		version: packet.MaxVersion,
+		mss:     packet.MaxPayloadBytes,
 	}
 }
 
@@ -56,6 +85,7 @@ func (pf *PacketFactory) SendControlPacket(syn, ack, fin, err bool) error {
 	}
 	if ack {
 		p.SetFlagACK()
+		p.SetAckNo(pf.ack)
 	}
 	if fin {
 		p.SetFlagFIN()
@@ -63,8 +93,14 @@ func (pf *PacketFactory) SendControlPacket(syn, ack, fin, err bool) error {
 	if err {
 		p.SetFlagERR()
 	}
-	p.SetSourceIPv4(pf.lhost)
-	p.SetDestinationIPv4(pf.rhost)
+	if syn {
+		p.MSS = pf.advertisedMSS
+	}
+	p.SetSeqNo(pf.seq)
+	p.SetWindow(pf.wnd)
+	pf.stamp(p)
+	p.SetSourceIP(pf.lhost)
+	p.SetDestinationIP(pf.rhost)
 	p.SetSum()
 
 	if fwErr := pf.fwFunc(p); fwErr != nil {
@@ -74,6 +110,268 @@ func (pf *PacketFactory) SendControlPacket(syn, ack, fin, err bool) error {
 	return nil
 }
 
+// Initialize sets the initial sequence number, which SYNs carry
+// and the first data byte sent is numbered with
+func (pf *PacketFactory) Initialize(isn uint32) {
+	pf.seq = isn
+}
+
+// SeqNo returns the sequence number of the next data byte,
+// which control packets are numbered with
+func (pf *PacketFactory) SeqNo() uint32 {
+	return pf.seq
+}
+
+// Acknowledge sets the cumulative acknowledgement number which
+// is piggybacked on all subsequent data and acknowledgement packets
+func (pf *PacketFactory) Acknowledge(ackNo uint32) {
+	pf.ack = ackNo
+}
+
+// Advertise sets the receive window which is
+// advertised on all subsequent packets
+func (pf *PacketFactory) Advertise(wnd uint16) {
+	pf.wnd = wnd
+}
+
+// SelectiveAcknowledge sets the SACK blocks which are reported on all
+// subsequent standalone acknowledgements. Data packets never carry
+// them so that their payload can always take up the maximum size
+func (pf *PacketFactory) SelectiveAcknowledge(blocks []packet.SACKBlock) {
+	pf.sack = blocks
+}
+
+// EnableTimestamps stamps the timestamp option on all subsequent
+// packets, making room for it by shrinking the maximum chunk size
+func (pf *PacketFactory) EnableTimestamps() {
+	pf.timestamps = true
+}
+
+// EchoDelay sets the one-way delay in microseconds, measured on the latest
+// packet received from remote, which is reported on subsequent packets
+func (pf *PacketFactory) EchoDelay(delay uint32) {
+	pf.delay = delay
+}
+
+// SetClock sets the clock the packets are timestamped with
+func (pf *PacketFactory) SetClock(c clock.Clock) {
+	pf.clock = c
+}
+
+// SetVersion sets the protocol version of all subsequent packets
+func (pf *PacketFactory) SetVersion(version uint8) {
+	pf.version = version
+}
+
+// Version returns the protocol version of the packets built
+func (pf *PacketFactory) Version() uint8 {
+	return pf.version
+}
+
+// SetMSS sets the maximum segment size: the largest payload, options
+// included, of all subsequent data packets. The chunk size shrinks
+// to fit along with their options, but never grows past the size
+// the factory was built with
+func (pf *PacketFactory) SetMSS(mss int) {
+	if mss > packet.MaxPayloadBytes {
+		mss = packet.MaxPayloadBytes
+	}
+	pf.mss = mss
+}
+
+// MSS returns the maximum segment size of data packets
+func (pf *PacketFactory) MSS() int {
+	return pf.mss
+}
+
+// AdvertiseMSS sets the maximum segment size the local end
+// receives, which is advertised on all subsequent SYNs
+func (pf *PacketFactory) AdvertiseMSS(mss uint16) {
+	pf.advertisedMSS = mss
+}
+
+// ChunkSize returns the maximum number of bytes carried by a data packet
+func (pf *PacketFactory) ChunkSize() int {
+	// data packets never carry SACK blocks, so their header size
+	// only depends on the version and on whether they are timestamped
+	var p packet.Packet
+	pf.stamp(&p)
+	if room := pf.mss - pf.optionsSize(&p); pf.size > room {
+		return room
+	}
+	return pf.size
+}
+
+// optionsSize returns the byte size of the options of a packet,
+// which is all of its header beyond the fixed part of its version
+func (pf *PacketFactory) optionsSize(p *packet.Packet) int {
+	fixed := packet.Packet{Version: p.Version}
+	return p.HeaderSize() - fixed.HeaderSize()
+}
+
+// stamp sets the protocol version on a packet,
+// and the timestamp option if enabled
+func (pf *PacketFactory) stamp(p *packet.Packet) {
+	p.Version = pf.version
+	if pf.timestamps {
+		p.SetTimestamps(Timestamp(pf.clock.Now()), pf.delay)
+	}
+}
+
+// Timestamp returns the microsecond timestamp of a point in time as
+// stamped on packets. It wraps around every 71 minutes or so
+func Timestamp(t time.Time) uint32 {
+	return uint32(t.UnixNano() / int64(time.Microsecond))
+}
+
+// SendAck crafts and sends a standalone acknowledgement to the network
+func (pf *PacketFactory) SendAck() error {
+	p, _ := packet.NewPacket(pf.lport, pf.rport, nil) // err checks for payload size (no payload)
+
+	p.SetFlagACK()
+	p.SetAckNo(pf.ack)
+	p.SetWindow(pf.wnd)
+	p.SetSACKBlocks(pf.sack)
+	pf.stamp(p)
+	p.SetSourceIP(pf.lhost)
+	p.SetDestinationIP(pf.rhost)
+	p.SetSum()
+
+	if err := pf.fwFunc(p); err != nil {
+		return errors.Wrap(err, "could not send acknowledgement")
+	}
+
+	return nil
+}
+
+// SendReset crafts and sends a reset to the network, which
+// aborts the connection at the remote for the given reason
+func (pf *PacketFactory) SendReset(reason packet.ResetReason) error {
+	p, _ := packet.NewPacket(pf.lport, pf.rport, nil) // err checks for payload size (no payload)
+
+	p.SetSeqNo(pf.seq)
+	p.SetFlagERR()
+	p.SetFlagACK()
+	p.SetAckNo(pf.ack)
+	pf.stamp(p)
+	if p.Version != packet.Version0 {
+		p.Reset = reason
+	}
+	p.SetSourceIP(pf.lhost)
+	p.SetDestinationIP(pf.rhost)
+	p.SetSum()
+
+	if err := pf.fwFunc(p); err != nil {
+		return errors.Wrap(err, "could not send reset")
+	}
+
+	return nil
+}
+
+// SendProbe crafts and sends a window probe to the network. The probe
+// carries a single byte the remote has already received, so it is
+// discarded by the remote but always answered with an acknowledgement
+// advertising the remote's current window
+func (pf *PacketFactory) SendProbe() error {
+	p, _ := packet.NewPacket(pf.lport, pf.rport, []byte{0}) // err checks for payload size (1 byte)
+
+	p.SetSeqNo(pf.seq - 1)
+	p.SetFlagACK()
+	p.SetAckNo(pf.ack)
+	p.SetWindow(pf.wnd)
+	pf.stamp(p)
+	p.SetSourceIP(pf.lhost)
+	p.SetDestinationIP(pf.rhost)
+	p.SetSum()
+
+	if err := pf.fwFunc(p); err != nil {
+		return errors.Wrap(err, "could not send window probe")
+	}
+
+	return nil
+}
+
+// SendPMTUProbe crafts and sends a path MTU probe to the network: a
+// packet as large as a full data packet would be with the given MSS.
+// Its payload is padding which takes up no sequence space, and it is
+// answered with an acknowledgement of the probe if it makes it across
+func (pf *PacketFactory) SendPMTUProbe(mss int) error {
+	probe := packet.Packet{PMTUProbe: uint16(mss)}
+	pf.stamp(&probe)
+	p, err := packet.NewPacket(pf.lport, pf.rport, make([]byte, mss-pf.optionsSize(&probe)))
+	if err != nil {
+		return errors.Wrap(err, "could not build path MTU probe")
+	}
+
+	p.SetSeqNo(pf.seq)
+	p.SetFlagACK()
+	p.SetAckNo(pf.ack)
+	p.SetWindow(pf.wnd)
+	p.PMTUProbe = probe.PMTUProbe
+	pf.stamp(p)
+	p.SetSourceIP(pf.lhost)
+	p.SetDestinationIP(pf.rhost)
+	p.SetSum()
+
+	if err := pf.fwFunc(p); err != nil {
+		return errors.Wrap(err, "could not send path MTU probe")
+	}
+
+	return nil
+}
+
+// SendPMTUProbeAck crafts and sends an acknowledgement
+// of a path MTU probe of the given MSS to the network
+func (pf *PacketFactory) SendPMTUProbeAck(mss uint16) error {
+	p, _ := packet.NewPacket(pf.lport, pf.rport, nil) // err checks for payload size (no payload)
+
+	p.SetFlagACK()
+	p.SetAckNo(pf.ack)
+	p.SetWindow(pf.wnd)
+	p.PMTUProbeAck = mss
+	pf.stamp(p)
+	p.SetSourceIP(pf.lhost)
+	p.SetDestinationIP(pf.rhost)
+	p.SetSum()
+
+	if err := pf.fwFunc(p); err != nil {
+		return errors.Wrap(err, "could not send path MTU probe acknowledgement")
+	}
+
+	return nil
+}
+
+// Split chops a previously built data packet which no longer fits
+// the chunk size (e.g. after the path MTU shrank) onto packets of
+// the same data, which are ready to be retransmitted in its place
+func (pf *PacketFactory) Split(p *packet.Packet) []*packet.Packet {
+	size := pf.ChunkSize()
+	var pcks []*packet.Packet
+	for off := 0; off < len(p.Payload); off += size {
+		end := off + size
+		if end > len(p.Payload) {
+			end = len(p.Payload)
+		}
+		pck, _ := packet.NewPacket(pf.lport, pf.rport, p.Payload[off:end]) // chunks are smaller than the packet
+		pck.SetSeqNo(p.SeqNo + uint32(off))
+		pck.SetSourceIP(pf.lhost)
+		pck.SetDestinationIP(pf.rhost)
+		pf.Restamp(pck)
+		pcks = append(pcks, pck)
+	}
+	return pcks
+}
+
+// Restamp refreshes the acknowledgement number and window on
+// a previously built data packet (e.g. before retransmitting it)
+func (pf *PacketFactory) Restamp(p *packet.Packet) {
+	p.SetFlagACK()
+	p.SetAckNo(pf.ack)
+	p.SetWindow(pf.wnd)
+	pf.stamp(p)
+	p.SetSum()
+}
+
 // PackAndForwardMessage chops a stream of bytes onto chunks of maximum size,
 // wraps them in rdtp Packets and forwards them to the fwFunc
 func (pf *PacketFactory) PackAndForwardMessage(msg []byte) (int, error) {
@@ -82,9 +380,10 @@ func (pf *PacketFactory) PackAndForwardMessage(msg []byte) (int, error) {
 	rem := msg
 	txBytes := 0
 
+	size := pf.ChunkSize()
 	for len(rem) > 0 {
-		if len(rem) >= pf.size {
-			chunk, rem = rem[:pf.size], rem[pf.size:]
+		if len(rem) >= size {
+			chunk, rem = rem[:size], rem[size:]
 		} else {
 			chunk, rem = rem, []byte{}
 		}
@@ -101,8 +400,14 @@ func (pf *PacketFactory) packetizeAndForwardChunk(chunk []byte) error {
 	if err != nil {
 		return errors.Wrap(err, "error packetizing message")
 	}
-	pck.SetSourceIPv4(pf.lhost)
-	pck.SetDestinationIPv4(pf.rhost)
+	pck.SetSeqNo(pf.seq)
+	pf.seq += uint32(len(chunk))
+	pck.SetFlagACK()
+	pck.SetAckNo(pf.ack)
+	pck.SetWindow(pf.wnd)
+	pf.stamp(pck)
+	pck.SetSourceIP(pf.lhost)
+	pck.SetDestinationIP(pf.rhost)
 	pck.SetSum() // set checksum here
 	if err = pf.fwFunc(pck); err != nil {
 		return errors.Wrap(err, "error forwarding packet")
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/adrianosela/rdtp/packet"
 	"github.com/pkg/errors"
@@ -229,6 +230,164 @@ func TestPackAndForwardMessageOK(t *testing.T) {
 	assert.Equal(t, testMsg, rx)
 }
 
+func TestPackAndForwardMessageSequenceNumbers(t *testing.T) {
+	var seqs []uint32
+
+	p, err := New(testSrcIP, testDstIP, 1234, 5678, 10,
+		func(x *packet.Packet) error {
+			seqs = append(seqs, x.SeqNo)
+			return nil
+		})
+	assert.Nil(t, err)
+
+	_, err = p.PackAndForwardMessage(testMsg[:25])
+	assert.Nil(t, err)
+	_, err = p.PackAndForwardMessage(testMsg[:5])
+	assert.Nil(t, err)
+
+	// sequence numbers are byte offsets into the stream
+	assert.Equal(t, []uint32{0, 10, 20, 25}, seqs)
+}
+
+func TestSendAckOK(t *testing.T) {
+	var forwarded *packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = p
+			return nil
+		})
+
+	pf.Acknowledge(uint32(4567))
+	err := pf.SendAck()
+	assert.Nil(t, err)
+	assert.NotNil(t, forwarded)
+	assert.True(t, forwarded.IsACK())
+	assert.False(t, forwarded.IsSYN())
+	assert.False(t, forwarded.IsFIN())
+	assert.Equal(t, uint32(4567), forwarded.AckNo)
+	assert.True(t, forwarded.CheckSum())
+}
+
+func TestSelectiveAcknowledge(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+
+	blocks := []packet.SACKBlock{{Start: 20, End: 30}, {Start: 40, End: 50}}
+	pf.SelectiveAcknowledge(blocks)
+	assert.Nil(t, pf.SendAck())
+	_, err := pf.PackAndForwardMessage(testMsg)
+	assert.Nil(t, err)
+
+	assert.Len(t, forwarded, 2)
+	assert.True(t, forwarded[0].IsSACK())
+	assert.Equal(t, blocks, forwarded[0].SACKBlocks)
+	assert.True(t, forwarded[0].CheckSum())
+	assert.False(t, forwarded[1].IsSACK())
+
+	pf.SelectiveAcknowledge(nil)
+	assert.Nil(t, pf.SendAck())
+	assert.False(t, forwarded[2].IsSACK())
+}
+
+func TestPackAndForwardMessagePiggybacksAck(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+
+	pf.Acknowledge(uint32(100))
+	_, err := pf.PackAndForwardMessage(testMsg)
+	assert.Nil(t, err)
+	assert.Len(t, forwarded, 1)
+	assert.True(t, forwarded[0].IsACK())
+	assert.Equal(t, uint32(100), forwarded[0].AckNo)
+	assert.True(t, forwarded[0].CheckSum())
+
+	// retransmissions carry the latest acknowledgement
+	pf.Acknowledge(uint32(200))
+	pf.Restamp(forwarded[0])
+	assert.Equal(t, uint32(200), forwarded[0].AckNo)
+	assert.True(t, forwarded[0].CheckSum())
+}
+
+func TestAdvertise(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+
+	pf.Advertise(uint16(1000))
+	assert.Nil(t, pf.SendControlPacket(true, false, false, false))
+	assert.Nil(t, pf.SendAck())
+	_, err := pf.PackAndForwardMessage(testMsg)
+	assert.Nil(t, err)
+
+	assert.Len(t, forwarded, 3)
+	for _, p := range forwarded {
+		assert.Equal(t, uint16(1000), p.Window)
+		assert.True(t, p.CheckSum())
+	}
+}
+
+func TestSendProbeOK(t *testing.T) {
+	var forwarded *packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = p
+			return nil
+		})
+
+	_, err := pf.PackAndForwardMessage(testMsg)
+	assert.Nil(t, err)
+
+	// probes carry the last byte already sent and
+	// do not advance the sequence number
+	assert.Nil(t, pf.SendProbe())
+	assert.Equal(t, uint32(len(testMsg)-1), forwarded.SeqNo)
+	assert.Len(t, forwarded.Payload, 1)
+	assert.True(t, forwarded.IsACK())
+	assert.Equal(t, uint32(len(testMsg)), pf.seq)
+}
+
+func TestSendProbeError(t *testing.T) {
+	mockError := errors.New("mock error")
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			return mockError
+		})
+
+	err := pf.SendProbe()
+	assert.NotNil(t, err)
+	assert.Equal(t, errors.Wrap(mockError, "could not send window probe").Error(), err.Error())
+}
+
+func TestSendAckError(t *testing.T) {
+	mockError := errors.New("mock error")
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			return mockError
+		})
+
+	err := pf.SendAck()
+	assert.NotNil(t, err)
+	assert.Equal(t, errors.Wrap(mockError, "could not send acknowledgement").Error(), err.Error())
+}
+
 func TestPackAndForwardMessageError(t *testing.T) {
 	mockError := errors.New("mock error")
 
@@ -243,3 +402,176 @@ func TestPackAndForwardMessageError(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, errors.Wrap(mockError, "could not packatize and forward chunk: error forwarding packet").Error(), err.Error())
 }
+
+func TestEnableTimestamps(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+
+	assert.Nil(t, pf.SendAck())
+	assert.False(t, forwarded[0].IsTimestamped())
+	assert.Equal(t, packet.MaxPayloadBytes, pf.ChunkSize())
+
+	pf.EnableTimestamps()
+	pf.EchoDelay(uint32(2500))
+	before := Timestamp(time.Now())
+	assert.Nil(t, pf.SendControlPacket(true, false, false, false))
+	assert.Nil(t, pf.SendAck())
+	assert.Nil(t, pf.SendProbe())
+	pf.Restamp(forwarded[0])
+	for _, p := range forwarded {
+		assert.True(t, p.IsTimestamped())
+		assert.True(t, p.Timestamp-before < uint32(time.Second/time.Microsecond))
+		assert.Equal(t, uint32(2500), p.TimestampDelay)
+		assert.True(t, p.CheckSum())
+	}
+
+	// data packets make room for the option
+	forwarded = nil
+	msg := make([]byte, packet.MaxPayloadBytes)
+	n, err := pf.PackAndForwardMessage(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, len(msg), n)
+	assert.Len(t, forwarded, 2)
+	assert.Less(t, len(forwarded[0].Payload), packet.MaxPayloadBytes)
+	assert.True(t, forwarded[0].IsTimestamped())
+	assert.Equal(t, packet.MaxPacketBytes, len(forwarded[0].Serialize()))
+}
+
+func TestSetVersion(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+
+	// packets are of the latest version until negotiated otherwise
+	assert.Equal(t, packet.MaxVersion, pf.Version())
+	assert.Nil(t, pf.SendControlPacket(true, false, false, false))
+	assert.Equal(t, packet.MaxVersion, forwarded[0].Version)
+
+	pf.SetVersion(packet.Version0)
+	pf.EnableTimestamps()
+	forwarded = nil
+	assert.Nil(t, pf.SendAck())
+	assert.Nil(t, pf.SendProbe())
+	_, err := pf.PackAndForwardMessage(make([]byte, packet.MaxPayloadBytes))
+	assert.Nil(t, err)
+	for _, p := range forwarded {
+		assert.Equal(t, packet.Version0, p.Version)
+		assert.LessOrEqual(t, len(p.Serialize()), packet.MaxPacketBytes)
+	}
+}
+
+func TestSetMSS(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+	pf.EnableTimestamps()
+
+	// SYNs advertise the MSS received
+	pf.AdvertiseMSS(1200)
+	assert.Nil(t, pf.SendControlPacket(true, false, false, false))
+	assert.Nil(t, pf.SendControlPacket(false, true, false, false))
+	assert.Equal(t, uint16(1200), forwarded[0].MSS)
+	assert.Zero(t, forwarded[1].MSS)
+
+	// data packets are as large as the MSS, options included
+	forwarded = nil
+	pf.SetMSS(1000)
+	assert.Equal(t, 1000, pf.MSS())
+	_, err := pf.PackAndForwardMessage(make([]byte, 2000))
+	assert.Nil(t, err)
+	assert.Len(t, forwarded, 3)
+	assert.Equal(t, packet.ExtendedHeaderByteSize+1000, len(forwarded[0].Serialize()))
+
+	// but never larger than the chunk size
+	pf.SetMSS(packet.MaxPayloadBytes + 100)
+	assert.Equal(t, packet.MaxPayloadBytes, pf.MSS())
+}
+
+func TestSendPMTUProbe(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+	pf.EnableTimestamps()
+
+	// probes are as large as a full data packet of the MSS probed
+	assert.Nil(t, pf.SendPMTUProbe(1300))
+	assert.Equal(t, uint16(1300), forwarded[0].PMTUProbe)
+	assert.Equal(t, packet.ExtendedHeaderByteSize+1300, len(forwarded[0].Serialize()))
+	assert.True(t, forwarded[0].CheckSum())
+
+	assert.Nil(t, pf.SendPMTUProbeAck(1300))
+	assert.Equal(t, uint16(1300), forwarded[1].PMTUProbeAck)
+	assert.Empty(t, forwarded[1].Payload)
+	assert.True(t, forwarded[1].CheckSum())
+}
+
+func TestSendReset(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+	pf.Initialize(1000)
+	pf.Acknowledge(2000)
+
+	assert.Nil(t, pf.SendReset(packet.ResetAborted))
+	assert.True(t, forwarded[0].IsERR())
+	assert.Equal(t, uint32(1000), forwarded[0].SeqNo)
+	assert.Equal(t, uint32(2000), forwarded[0].AckNo)
+	assert.Equal(t, packet.ResetAborted, forwarded[0].Reset)
+	assert.True(t, forwarded[0].CheckSum())
+
+	// version 0 resets carry no reason
+	pf.SetVersion(packet.Version0)
+	assert.Nil(t, pf.SendReset(packet.ResetAborted))
+	assert.Equal(t, packet.ResetUnspecified, forwarded[1].Reset)
+}
+
+func TestSplit(t *testing.T) {
+	var forwarded []*packet.Packet
+
+	pf := DefaultPacketFactory(testSrcIP, testDstIP, 1234, 5678,
+		func(p *packet.Packet) error {
+			forwarded = append(forwarded, p)
+			return nil
+		})
+	msg := make([]byte, packet.MaxPayloadBytes)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	_, err := pf.PackAndForwardMessage(msg)
+	assert.Nil(t, err)
+
+	// a packet sent before the MSS shrank goes out again in pieces
+	pf.SetMSS(600)
+	pieces := pf.Split(forwarded[0])
+	assert.Len(t, pieces, 3)
+	var data []byte
+	for _, p := range pieces {
+		assert.Equal(t, forwarded[0].SeqNo+uint32(len(data)), p.SeqNo)
+		assert.LessOrEqual(t, len(p.Payload), pf.ChunkSize())
+		assert.True(t, p.IsACK())
+		assert.True(t, p.CheckSum())
+		data = append(data, p.Payload...)
+	}
+	assert.Equal(t, msg, data)
+}
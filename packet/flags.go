@@ -5,6 +5,13 @@ const (
 	ackMask = 0x40
 	finMask = 0x20
 	errMask = 0x10
+	sckMask = 0x08
+	tsMask  = 0x04
+	icsMask = 0x02
+	verMask = 0x01 // set on the wire from version 1 on
+
+	// version0Flags are the flags of the original header
+	version0Flags = synMask | ackMask | finMask | errMask
 )
 
 // SetFlagSYN sets the SYN flag on a packet
@@ -46,3 +53,19 @@ func (p *Packet) IsFIN() bool {
 func (p *Packet) IsERR() bool {
 	return p.Flags&errMask != 0
 }
+
+// IsSACK returns true if the SACK flag is set
+func (p *Packet) IsSACK() bool {
+	return p.Flags&sckMask != 0
+}
+
+// IsTimestamped returns true if the TS flag is set
+func (p *Packet) IsTimestamped() bool {
+	return p.Flags&tsMask != 0
+}
+
+// IsICS returns true if the ICS flag is set, i.e. if the
+// checksum is the Internet checksum rather than the legacy sum
+func (p *Packet) IsICS() bool {
+	return p.Flags&icsMask != 0
+}
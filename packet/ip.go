@@ -0,0 +1,34 @@
+package packet
+
+import (
+	"errors"
+	"net"
+)
+
+// SetDestinationIP sets the destination IP (v4 or v6) on the packet
+func (p *Packet) SetDestinationIP(ip net.IP) {
+	p.dstIP = ip
+}
+
+// SetSourceIP sets the source IP (v4 or v6) on the packet
+func (p *Packet) SetSourceIP(ip net.IP) {
+	p.srcIP = ip
+}
+
+// GetDestinationIP returns the destination IP
+// set on the packet or an error if none is set
+func (p *Packet) GetDestinationIP() (net.IP, error) {
+	if p.dstIP == nil {
+		return nil, errors.New("no destination IP address set on packet")
+	}
+	return p.dstIP, nil
+}
+
+// GetSourceIP returns the source IP
+// set on the packet or an error if none is set
+func (p *Packet) GetSourceIP() (net.IP, error) {
+	if p.srcIP == nil {
+		return nil, errors.New("no source IP address set on packet")
+	}
+	return p.srcIP, nil
+}
@@ -0,0 +1,45 @@
+package packet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testIPs = []net.IP{
+	{127, 0, 0, 1},
+	net.ParseIP("fe80::1"),
+}
+
+func TestSetDestinationIP(t *testing.T) {
+	for _, testIP := range testIPs {
+		p, err := NewPacket(uint16(14), uint16(15), nil)
+		assert.Nil(t, err)
+
+		// error when not set
+		_, err = p.GetDestinationIP()
+		assert.NotNil(t, err)
+
+		p.SetDestinationIP(testIP)
+		got, err := p.GetDestinationIP()
+		assert.Nil(t, err)
+		assert.EqualValues(t, got, testIP)
+	}
+}
+
+func TestSetSourceIP(t *testing.T) {
+	for _, testIP := range testIPs {
+		p, err := NewPacket(uint16(14), uint16(15), nil)
+		assert.Nil(t, err)
+
+		// error when not set
+		_, err = p.GetSourceIP()
+		assert.NotNil(t, err)
+
+		p.SetSourceIP(testIP)
+		got, err := p.GetSourceIP()
+		assert.Nil(t, err)
+		assert.EqualValues(t, got, testIP)
+	}
+}
@@ -1,34 +1,33 @@
 package packet
 
-import (
-	"errors"
-	"net"
-)
+import "net"
 
 // SetDestinationIPv4 sets the destination IPv4 on the packet
+//
+// Deprecated: use SetDestinationIP, which takes either address family
 func (p *Packet) SetDestinationIPv4(ip net.IP) {
-	p.dstIP = ip
+	p.SetDestinationIP(ip)
 }
 
 // SetSourceIPv4 sets the source IPv4 on the packet
+//
+// Deprecated: use SetSourceIP, which takes either address family
 func (p *Packet) SetSourceIPv4(ip net.IP) {
-	p.srcIP = ip
+	p.SetSourceIP(ip)
 }
 
 // GetDestinationIPv4 returns the destination IPv4
 // set on the packet or an error if none is set
+//
+// Deprecated: use GetDestinationIP, which returns either address family
 func (p *Packet) GetDestinationIPv4() (net.IP, error) {
-	if p.dstIP == nil {
-		return nil, errors.New("no destination IPv4 address set on packet")
-	}
-	return p.dstIP, nil
+	return p.GetDestinationIP()
 }
 
 // GetSourceIPv4 returns the source IPv4
 // set on the packet or an error if none is set
+//
+// Deprecated: use GetSourceIP, which returns either address family
 func (p *Packet) GetSourceIPv4() (net.IP, error) {
-	if p.srcIP == nil {
-		return nil, errors.New("no source IPv4 address set on packet")
-	}
-	return p.srcIP, nil
+	return p.GetSourceIP()
 }
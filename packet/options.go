@@ -0,0 +1,153 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	// OptionTimestamps is the type of the timestamp option
+	OptionTimestamps = uint8(1)
+
+	// OptionSACK is the type of the SACK option
+	OptionSACK = uint8(2)
+
+	// OptionMSS is the type of the maximum segment size option
+	OptionMSS = uint8(3)
+
+	// OptionPMTUProbe is the type of the option which marks
+	// a path MTU probe, whose payload is padding
+	OptionPMTUProbe = uint8(4)
+
+	// OptionPMTUProbeAck is the type of the option
+	// which acknowledges a path MTU probe
+	OptionPMTUProbeAck = uint8(5)
+
+	// OptionReset is the type of the option which gives
+	// the reason of a reset, as a one byte value
+	OptionReset = uint8(6)
+
+	// optionHeaderByteSize is the byte size of an option's type and length
+	optionHeaderByteSize = 2
+)
+
+// Option is a header option in type-length-value form,
+// as carried by packets from version 1 on
+type Option struct {
+	Type  uint8
+	Value []byte
+}
+
+// options returns all of the packet's options in the order they go
+// over the wire, which is increasing order of type. Receivers keep
+// options they do not understand in that order, so that packets
+// re-serialize to the exact bytes received
+func (p *Packet) options() []Option {
+	var opts []Option
+	if p.IsTimestamped() {
+		opts = append(opts, Option{Type: OptionTimestamps, Value: p.serializeTimestampOption(nil)})
+	}
+	if p.IsSACK() {
+		// the SACK option's length gives the block count
+		opts = append(opts, Option{Type: OptionSACK, Value: p.serializeSACKOption(nil)[1:]})
+	}
+	for _, opt := range []struct {
+		typ   uint8
+		value uint16
+	}{
+		{OptionMSS, p.MSS},
+		{OptionPMTUProbe, p.PMTUProbe},
+		{OptionPMTUProbeAck, p.PMTUProbeAck},
+	} {
+		if opt.value != 0 {
+			value := make([]byte, 2)
+			binary.BigEndian.PutUint16(value, opt.value)
+			opts = append(opts, Option{Type: opt.typ, Value: value})
+		}
+	}
+	if p.Reset != ResetUnspecified {
+		opts = append(opts, Option{Type: OptionReset, Value: []byte{byte(p.Reset)}})
+	}
+	opts = append(opts, p.Options...)
+	sort.SliceStable(opts, func(i, j int) bool { return opts[i].Type < opts[j].Type })
+	return opts
+}
+
+// HeaderSize returns the byte size of the packet's header, options included
+func (p *Packet) HeaderSize() int {
+	if p.Version == Version0 {
+		return HeaderByteSize
+	}
+	size := ExtendedHeaderByteSize
+	for _, opt := range p.options() {
+		size += optionHeaderByteSize + len(opt.Value)
+	}
+	return size
+}
+
+// serializeOptions byte-encodes the packet's options in TLV form
+func (p *Packet) serializeOptions(b []byte) []byte {
+	for _, opt := range p.options() {
+		b = append(b, opt.Type, byte(len(opt.Value)))
+		b = append(b, opt.Value...)
+	}
+	return b
+}
+
+// deserializeOptions decodes the TLV options of an extended
+// header, keeping those which are not understood as received
+func (p *Packet) deserializeOptions(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < optionHeaderByteSize {
+			return fmt.Errorf("Invalid RDTP header. Truncated option (%d bytes)", len(data))
+		}
+		typ, size := data[0], int(data[1])
+		if len(data) < optionHeaderByteSize+size {
+			return fmt.Errorf(
+				"Invalid RDTP header. Option %d length %d longer than data (%d)",
+				typ,
+				size,
+				len(data)-optionHeaderByteSize)
+		}
+		value := data[optionHeaderByteSize : optionHeaderByteSize+size]
+		data = data[optionHeaderByteSize+size:]
+
+		switch typ {
+		case OptionTimestamps:
+			if size != TimestampOptionByteSize {
+				return fmt.Errorf("Invalid RDTP header. Timestamp option length %d", size)
+			}
+			p.SetTimestamps(binary.BigEndian.Uint32(value[0:4]), binary.BigEndian.Uint32(value[4:8]))
+		case OptionSACK:
+			if size%sackBlockByteSize != 0 {
+				return fmt.Errorf("Invalid RDTP header. SACK option length %d", size)
+			}
+			if _, err := p.deserializeSACKOption(append([]byte{byte(size / sackBlockByteSize)}, value...)); err != nil {
+				return err
+			}
+			p.Flags = p.Flags | sckMask
+		case OptionMSS, OptionPMTUProbe, OptionPMTUProbeAck:
+			if size != 2 {
+				return fmt.Errorf("Invalid RDTP header. Option %d length %d", typ, size)
+			}
+			v := binary.BigEndian.Uint16(value)
+			switch typ {
+			case OptionMSS:
+				p.MSS = v
+			case OptionPMTUProbe:
+				p.PMTUProbe = v
+			default:
+				p.PMTUProbeAck = v
+			}
+		case OptionReset:
+			if size != 1 {
+				return fmt.Errorf("Invalid RDTP header. Reset option length %d", size)
+			}
+			p.Reset = ResetReason(value[0])
+		default:
+			p.Options = append(p.Options, Option{Type: typ, Value: value})
+		}
+	}
+	return nil
+}
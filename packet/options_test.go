@@ -0,0 +1,137 @@
+package packet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerializeDeserializeExtendedHeader(t *testing.T) {
+	payload := []byte("[ mock http request ]")
+
+	pLocal, err := NewPacket(uint16(8081), uint16(8082), payload)
+	assert.Nil(t, err)
+	pLocal.Version = Version1
+	pLocal.SetFlagSYN()
+	pLocal.SetTimestamps(uint32(0x01020304), uint32(0x05060708))
+	pLocal.SetSACKBlocks([]SACKBlock{{Start: 200, End: 300}})
+	pLocal.SetSum()
+
+	byt := pLocal.Serialize()
+	assert.Equal(t, pLocal.HeaderSize()+len(payload), len(byt))
+	assert.Equal(t, ExtendedHeaderByteSize+10+10, pLocal.HeaderSize())
+	assert.Equal(t, []byte{Version1 << 4, byte(pLocal.HeaderSize())}, byt[19:21])
+
+	// options go in TLV form in increasing order of type
+	assert.Equal(t, []byte{OptionTimestamps, 8, 1, 2, 3, 4, 5, 6, 7, 8, OptionSACK, 8},
+		byt[ExtendedHeaderByteSize:ExtendedHeaderByteSize+12])
+
+	pRemote, err := Deserialize(byt)
+	assert.Nil(t, err)
+	assert.EqualValues(t, pLocal, pRemote)
+	assert.True(t, pRemote.CheckSum())
+}
+
+func TestDeserializeExtendedHeaderAsVersion0(t *testing.T) {
+	p, err := NewPacket(uint16(8081), uint16(8082), nil)
+	assert.Nil(t, err)
+	p.Version = Version1
+	p.SetFlagSYN()
+	p.SetTimestamps(uint32(1), uint32(2))
+
+	// version 0 peers see a SYN without options, which they answer in version 0
+	byt := p.Serialize()
+	flags := byt[16]
+	assert.NotZero(t, flags&synMask)
+	assert.Zero(t, flags&tsMask)
+	assert.Zero(t, flags&sckMask)
+}
+
+func TestSerializeDeserializePMTUOptions(t *testing.T) {
+	p, err := NewPacket(uint16(8081), uint16(8082), []byte{})
+	assert.Nil(t, err)
+	p.Version = Version1
+	p.MSS = 1460
+	p.PMTUProbe = 1300
+	p.PMTUProbeAck = 1200
+	p.SetSum()
+
+	byt := p.Serialize()
+	assert.Equal(t, ExtendedHeaderByteSize+3*4, p.HeaderSize())
+	assert.Equal(t, []byte{OptionMSS, 2, 0x05, 0xb4}, byt[ExtendedHeaderByteSize:ExtendedHeaderByteSize+4])
+
+	remote, err := Deserialize(byt)
+	assert.Nil(t, err)
+	assert.EqualValues(t, p, remote)
+
+	// version 0 packets have no room for them
+	p.Version = Version0
+	assert.Equal(t, HeaderByteSize, p.HeaderSize())
+}
+
+func TestDeserializeUnknownOptions(t *testing.T) {
+	p, err := NewPacket(uint16(8081), uint16(8082), []byte("data"))
+	assert.Nil(t, err)
+	p.Version = Version1
+	p.SetSACKBlocks([]SACKBlock{{Start: 200, End: 300}})
+	p.SetSourceIP(net.ParseIP("10.0.0.1"))
+	p.SetDestinationIP(net.ParseIP("10.0.0.2"))
+
+	// options of later versions, sent in increasing order of type
+	p.Options = []Option{{Type: 100, Value: []byte{}}, {Type: 200, Value: []byte{1, 2, 3}}}
+	p.SetSum()
+
+	// are skipped and kept as received
+	remote, err := Deserialize(p.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, p.Options, remote.Options)
+	assert.Equal(t, []byte("data"), remote.Payload)
+	assert.Equal(t, p.SACKBlocks, remote.SACKBlocks)
+
+	// so that the checksum still verifies
+	remote.SetSourceIP(net.ParseIP("10.0.0.1"))
+	remote.SetDestinationIP(net.ParseIP("10.0.0.2"))
+	assert.True(t, remote.CheckSum())
+
+	// later versions are decoded as version 1
+	byt := p.Serialize()
+	byt[19] = 7 << 4
+	remote, err = Deserialize(byt)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(7), remote.Version)
+	assert.Equal(t, []byte("data"), remote.Payload)
+}
+
+func TestDeserializeBadExtendedHeader(t *testing.T) {
+	header := []byte{
+		31, 145, 31, 146, // src port, dst port
+		0, 0, 0, 0, // length, checksum
+		0, 0, 0, 10, // seqno
+		0, 0, 0, 9, // ackno
+		verMask, // flags
+		0, 0,    // window
+	}
+
+	tests := map[string][]byte{
+		"truncated header":            header,
+		"version 0":                   {0 << 4, 21},
+		"header length too short":     {1 << 4, 20},
+		"header length too long":      {1 << 4, 22},
+		"truncated option":            {1 << 4, 22, 100},
+		"option longer than header":   {1 << 4, 23, 100, 1},
+		"bad timestamp option length": {1 << 4, 25, OptionTimestamps, 2, 0, 0},
+		"bad SACK option length":      {1 << 4, 27, OptionSACK, 4, 0, 0, 0, 0},
+		"empty SACK option":           {1 << 4, 23, OptionSACK, 0},
+		"too many SACK blocks":        append([]byte{1 << 4, 63, OptionSACK, 40}, make([]byte, 40)...),
+		"bad MSS option length":       {1 << 4, 24, OptionMSS, 1, 0},
+		"bad reset option length":     {1 << 4, 25, OptionReset, 2, 0, 0},
+	}
+	for name, extended := range tests {
+		_, err := Deserialize(append(append([]byte{}, header...), extended...))
+		assert.NotNil(t, err, name)
+	}
+
+	_, err := Deserialize(append(append([]byte{}, header...), 1<<4, 23, 100, 0))
+	assert.Nil(t, err)
+}
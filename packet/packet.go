@@ -6,15 +6,33 @@ import (
 )
 
 const (
-	// MaxPacketBytes is the maximum size of an RDTP packet incl. header
+	// MaxPacketBytes is the maximum size of an RDTP packet incl. header.
+	// Packets are smaller when the MSS negotiated or the path MTU is
 	MaxPacketBytes = 1500 // will chunk otherwise
 
-	// HeaderByteSize is the byte size of an RDTP header
+	// HeaderByteSize is the byte size of a version 0 RDTP header,
+	// that of the original rdtp, which later versions extend
 	HeaderByteSize = 17
 
+	// ExtendedHeaderByteSize is the byte size of the fixed part of the
+	// header from version 1 on, which TLV options follow
+	ExtendedHeaderByteSize = 21
+
 	// MaxPayloadBytes is the maximum size of a payload that
 	// a single RDTP packet can carry
-	MaxPayloadBytes = MaxPacketBytes - HeaderByteSize
+	MaxPayloadBytes = MaxPacketBytes - ExtendedHeaderByteSize
+)
+
+const (
+	// Version0 is the original header, without a window or options
+	Version0 = uint8(0)
+
+	// Version1 is the header revision which carries the version,
+	// the header length and options in type-length-value form
+	Version1 = uint8(1)
+
+	// MaxVersion is the latest protocol version spoken
+	MaxVersion = Version1
 )
 
 // Packet is an RDTP packet
@@ -24,6 +42,7 @@ type Packet struct {
 	DstPort uint16
 
 	// processing and integrity
+	Version  uint8 // header revision, version 0 if zero
 	Length   uint16
 	Checksum uint16
 
@@ -32,7 +51,22 @@ type Packet struct {
 	AckNo uint32
 
 	// control
-	Flags uint8 // {SYN, FIN, ACK, ERR, XXXX, XXXX, XXXX, XXXX}
+	Flags uint8 // {SYN, ACK, FIN, ERR, SACK, TS, ICS, VER}
+
+	// flow control
+	Window uint16 // bytes the sender is willing to receive
+
+	// options (present only if the corresponding flag is set)
+	Timestamp      uint32 // sender's clock in microseconds
+	TimestampDelay uint32 // one-way delay measured on the latest packet received
+	SACKBlocks     []SACKBlock
+
+	// options of version 1 on (present only if non-zero)
+	MSS          uint16      // largest payload the sender receives, on SYNs
+	PMTUProbe    uint16      // MSS probed for by a path MTU probe
+	PMTUProbeAck uint16      // MSS of the path MTU probe acknowledged
+	Reset        ResetReason // reason of a reset, on ERR packets
+	Options      []Option    // options received but not understood
 
 	// data
 	Payload []byte
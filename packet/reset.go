@@ -0,0 +1,56 @@
+package packet
+
+import "fmt"
+
+// ResetReason is the reason a reset (a packet with the ERR flag set)
+// was sent for, carried in the reset option from version 1 on
+type ResetReason uint8
+
+const (
+	// ResetUnspecified is the reason of resets which carry none,
+	// such as those of version 0
+	ResetUnspecified = ResetReason(0)
+
+	// ResetNoListener is the reason of resets answering
+	// a SYN to a port nothing listens on
+	ResetNoListener = ResetReason(1)
+
+	// ResetNoConnection is the reason of resets answering
+	// a packet of a connection which does not exist
+	ResetNoConnection = ResetReason(2)
+
+	// ResetAborted is the reason of resets sent by
+	// a socket aborting its connection
+	ResetAborted = ResetReason(3)
+)
+
+// String returns a description of the reason
+func (r ResetReason) String() string {
+	switch r {
+	case ResetUnspecified:
+		return "unspecified"
+	case ResetNoListener:
+		return "no listener"
+	case ResetNoConnection:
+		return "no connection"
+	case ResetAborted:
+		return "connection aborted"
+	default:
+		return fmt.Sprintf("reason %d", uint8(r))
+	}
+}
+
+// NewReset returns a reset answering a packet which could not be
+// delivered, addressed back to its sender. The reset's sequence number
+// is the acknowledgement number of the packet, if it has one, so that
+// it falls within the sender's receive window, and it acknowledges
+// the packet. Resets are never to be answered with resets
+func NewReset(p *Packet, reason ResetReason) *Packet {
+	r := answer(p)
+	r.SetFlagERR()
+	if r.Version != Version0 {
+		r.Reset = reason
+	}
+	r.SetSum()
+	return r
+}
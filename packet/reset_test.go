@@ -0,0 +1,47 @@
+package packet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewReset(t *testing.T) {
+	p, err := NewPacket(uint16(1024), uint16(4444), []byte("data"))
+	assert.Nil(t, err)
+	p.Version = Version1
+	p.SetSeqNo(1000)
+	p.SetFlagACK()
+	p.SetAckNo(2000)
+	p.SetSourceIP(net.ParseIP("10.0.0.1"))
+	p.SetDestinationIP(net.ParseIP("10.0.0.2"))
+
+	// resets go back to the sender, within its receive window
+	r := NewReset(p, ResetNoConnection)
+	assert.True(t, r.IsERR())
+	assert.True(t, r.IsACK())
+	assert.Equal(t, uint16(4444), r.SrcPort)
+	assert.Equal(t, uint16(1024), r.DstPort)
+	assert.Equal(t, uint32(2000), r.SeqNo)
+	assert.Equal(t, uint32(1004), r.AckNo)
+	assert.True(t, r.CheckSum())
+
+	// with their reason from version 1 on
+	remote, err := Deserialize(r.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, ResetNoConnection, remote.Reset)
+	assert.Equal(t, []byte{OptionReset, 1, byte(ResetNoConnection)},
+		r.Serialize()[ExtendedHeaderByteSize:ExtendedHeaderByteSize+3])
+
+	// SYNs acknowledge nothing, so their resets are numbered zero
+	syn, err := NewPacket(uint16(1024), uint16(4444), nil)
+	assert.Nil(t, err)
+	syn.SetFlagSYN()
+	syn.SetSeqNo(1000)
+	r = NewReset(syn, ResetNoListener)
+	assert.Zero(t, r.SeqNo)
+	assert.Equal(t, uint32(1000), r.AckNo)
+	assert.Equal(t, Version0, r.Version)
+	assert.Equal(t, ResetUnspecified, r.Reset)
+}
@@ -0,0 +1,79 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxSACKBlocks is the maximum number of selective
+	// acknowledgement blocks carried by a single packet
+	MaxSACKBlocks = 4
+
+	// sackBlockByteSize is the byte size of an encoded SACK block
+	sackBlockByteSize = 8
+)
+
+// SACKBlock is a contiguous range of sequence numbers [Start, End)
+// which the sender of the packet has received out of order
+type SACKBlock struct {
+	Start uint32
+	End   uint32
+}
+
+// SetSACKBlocks sets the selective acknowledgement blocks on the packet,
+// keeping at most MaxSACKBlocks. The SACK flag is set if any are given
+func (p *Packet) SetSACKBlocks(blocks []SACKBlock) {
+	if len(blocks) > MaxSACKBlocks {
+		blocks = blocks[:MaxSACKBlocks]
+	}
+	if len(blocks) == 0 {
+		p.Flags = p.Flags &^ sckMask
+		p.SACKBlocks = nil
+		return
+	}
+	p.Flags = p.Flags | sckMask
+	p.SACKBlocks = blocks
+}
+
+// serializeSACKOption byte-encodes the SACK option as a
+// block count followed by each block's start and end
+func (p *Packet) serializeSACKOption(b []byte) []byte {
+	b = append(b, byte(len(p.SACKBlocks)))
+	for _, block := range p.SACKBlocks {
+		var enc [sackBlockByteSize]byte
+		binary.BigEndian.PutUint32(enc[0:4], block.Start)
+		binary.BigEndian.PutUint32(enc[4:8], block.End)
+		b = append(b, enc[:]...)
+	}
+	return b
+}
+
+// deserializeSACKOption decodes the SACK option at the start
+// of data and returns the data which follows it
+func (p *Packet) deserializeSACKOption(data []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, errors.New("Invalid RDTP header. Missing SACK option")
+	}
+	count := int(data[0])
+	if count == 0 || count > MaxSACKBlocks {
+		return nil, fmt.Errorf("Invalid RDTP header. SACK option with %d blocks", count)
+	}
+	size := 1 + count*sackBlockByteSize
+	if len(data) < size {
+		return nil, fmt.Errorf(
+			"Invalid RDTP header. SACK option length %d longer than data (%d)",
+			size,
+			len(data))
+	}
+	p.SACKBlocks = make([]SACKBlock, count)
+	for i := range p.SACKBlocks {
+		enc := data[1+i*sackBlockByteSize:]
+		p.SACKBlocks[i] = SACKBlock{
+			Start: binary.BigEndian.Uint32(enc[0:4]),
+			End:   binary.BigEndian.Uint32(enc[4:8]),
+		}
+	}
+	return data[size:], nil
+}
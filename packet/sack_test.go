@@ -0,0 +1,55 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetSACKBlocks(t *testing.T) {
+	p, err := NewPacket(uint16(14), uint16(15), nil)
+	assert.Nil(t, err)
+	assert.False(t, p.IsSACK())
+
+	blocks := []SACKBlock{{Start: 10, End: 20}, {Start: 30, End: 40}}
+	p.SetSACKBlocks(blocks)
+	assert.True(t, p.IsSACK())
+	assert.Equal(t, blocks, p.SACKBlocks)
+
+	// blocks beyond the maximum are left out
+	p.SetSACKBlocks(make([]SACKBlock, MaxSACKBlocks+2))
+	assert.Len(t, p.SACKBlocks, MaxSACKBlocks)
+
+	p.SetSACKBlocks(nil)
+	assert.False(t, p.IsSACK())
+	assert.Nil(t, p.SACKBlocks)
+}
+
+func TestSerializeDeserializeSACK(t *testing.T) {
+	payload := []byte("[ mock http request ]")
+
+	pLocal, err := NewPacket(uint16(8081), uint16(8082), payload)
+	assert.Nil(t, err)
+	pLocal.Version = Version1
+	pLocal.SetFlagACK()
+	pLocal.SetAckNo(uint32(100))
+	pLocal.SetSACKBlocks([]SACKBlock{
+		{Start: 200, End: 300},
+		{Start: 0xFFFFFFF0, End: 0x10}, // wraps around
+	})
+	pLocal.SetSum()
+
+	byt := pLocal.Serialize()
+	assert.Len(t, byt, ExtendedHeaderByteSize+2+2*sackBlockByteSize+len(payload))
+
+	// option is each block's start and end, whose count its length gives
+	assert.Equal(t, []byte{OptionSACK, 2 * sackBlockByteSize,
+		0, 0, 0, 200, 0, 0, 1, 44,
+		255, 255, 255, 240, 0, 0, 0, 16,
+	}, byt[ExtendedHeaderByteSize:ExtendedHeaderByteSize+2+2*sackBlockByteSize])
+
+	pRemote, err := Deserialize(byt)
+	assert.Nil(t, err)
+	assert.EqualValues(t, pLocal, pRemote)
+	assert.True(t, pRemote.CheckSum())
+}
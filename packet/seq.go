@@ -9,3 +9,33 @@ func (p *Packet) SetSeqNo(seq uint32) {
 func (p *Packet) SetAckNo(ack uint32) {
 	p.AckNo = ack
 }
+
+// SeqLT returns true if sequence number a comes before b.
+// Comparisons are modulo 2^32 so that they hold across wraparound
+// as long as the numbers compared are less than 2^31 bytes apart
+func SeqLT(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// SeqLEQ returns true if sequence number a comes before or is equal to b
+func SeqLEQ(a, b uint32) bool {
+	return int32(a-b) <= 0
+}
+
+// SeqGT returns true if sequence number a comes after b
+func SeqGT(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+// SeqGEQ returns true if sequence number a comes after or is equal to b
+func SeqGEQ(a, b uint32) bool {
+	return int32(a-b) >= 0
+}
+
+// SeqMax returns whichever of two sequence numbers comes last
+func SeqMax(a, b uint32) uint32 {
+	if SeqGT(a, b) {
+		return a
+	}
+	return b
+}
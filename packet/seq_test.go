@@ -25,3 +25,34 @@ func TestSetAckNo(t *testing.T) {
 	p.SetAckNo(testAck)
 	assert.Equal(t, testAck, p.AckNo)
 }
+
+func TestSeqComparisons(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		lt   bool
+		eq   bool
+	}{
+		{a: 0, b: 0, lt: false, eq: true},
+		{a: 0, b: 1, lt: true, eq: false},
+		{a: 1, b: 0, lt: false, eq: false},
+		{a: 1000, b: 2000, lt: true, eq: false},
+		// wraparound
+		{a: ^uint32(0), b: 0, lt: true, eq: false},
+		{a: 0, b: ^uint32(0), lt: false, eq: false},
+		{a: ^uint32(0) - 100, b: 100, lt: true, eq: false},
+		{a: 100, b: ^uint32(0) - 100, lt: false, eq: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.lt, SeqLT(test.a, test.b))
+		assert.Equal(t, test.lt || test.eq, SeqLEQ(test.a, test.b))
+		assert.Equal(t, !test.lt && !test.eq, SeqGT(test.a, test.b))
+		assert.Equal(t, !test.lt, SeqGEQ(test.a, test.b))
+	}
+}
+
+func TestSeqMax(t *testing.T) {
+	assert.Equal(t, uint32(20), SeqMax(10, 20))
+	assert.Equal(t, uint32(20), SeqMax(20, 10))
+	assert.Equal(t, uint32(5), SeqMax(^uint32(0), 5))
+}
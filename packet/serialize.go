@@ -2,20 +2,34 @@ package packet
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
 // Serialize byte-encodes an RDTP packet ready to be encapsulated
 // in a network layer protocol packet (i.e. IP datagram)
 func (p *Packet) Serialize() []byte {
-	b := make([]byte, HeaderByteSize)
+	b := make([]byte, HeaderByteSize, p.HeaderSize()+len(p.Payload))
 	binary.BigEndian.PutUint16(b[0:2], p.SrcPort)
 	binary.BigEndian.PutUint16(b[2:4], p.DstPort)
 	binary.BigEndian.PutUint16(b[4:6], p.Length)
 	binary.BigEndian.PutUint16(b[6:8], p.Checksum)
 	binary.BigEndian.PutUint32(b[8:12], p.SeqNo)
 	binary.BigEndian.PutUint32(b[12:16], p.AckNo)
-	b[16] = byte(p.Flags)
+
+	// the header of version 0 is the original one, which carries
+	// neither a window nor options, nor flags other than its own
+	if p.Version == Version0 {
+		b[16] = p.Flags & version0Flags
+		return append(b, p.Payload...)
+	}
+
+	// options are given by the TLV options area rather than by flags
+	b[16] = byte(p.Flags&^(tsMask|sckMask) | verMask)
+	b = append(b, 0, 0, p.Version<<4, 0)
+	binary.BigEndian.PutUint16(b[17:19], p.Window)
+	b = p.serializeOptions(b)
+	b[20] = byte(len(b))
 	return append(b, p.Payload...)
 }
 
@@ -37,6 +51,52 @@ func Deserialize(data []byte) (*Packet, error) {
 		Flags:    data[16],
 		Payload:  data[HeaderByteSize:],
 	}
+
+	if p.Flags&verMask != 0 {
+		p.Flags = p.Flags &^ (verMask | tsMask | sckMask)
+		var err error
+		if p.Payload, err = p.deserializeExtendedHeader(data); err != nil {
+			return nil, err
+		}
+	} else {
+		// the ICS flag is kept for the checksum to fail
+		p.Flags = p.Flags & (version0Flags | icsMask)
+	}
+	return p.trimPayload()
+}
+
+// deserializeExtendedHeader decodes the version, header length and
+// options of a packet from version 1 on and returns its payload.
+// Later versions are decoded as version 1 since they may only
+// add to its header by means of options
+func (p *Packet) deserializeExtendedHeader(data []byte) ([]byte, error) {
+	if len(data) < ExtendedHeaderByteSize {
+		return nil, fmt.Errorf(
+			"Invalid RDTP header. Packet length %d less than %d bytes",
+			len(data),
+			ExtendedHeaderByteSize)
+	}
+	p.Window = binary.BigEndian.Uint16(data[17:19])
+	p.Version = data[19] >> 4
+	if p.Version == Version0 {
+		return nil, errors.New("Invalid RDTP header. Extended header with version 0")
+	}
+	size := int(data[20])
+	if size < ExtendedHeaderByteSize || size > len(data) {
+		return nil, fmt.Errorf(
+			"Invalid RDTP header. Header length %d out of bounds [%d, %d]",
+			size,
+			ExtendedHeaderByteSize,
+			len(data))
+	}
+	if err := p.deserializeOptions(data[ExtendedHeaderByteSize:size]); err != nil {
+		return nil, err
+	}
+	return data[size:], nil
+}
+
+// trimPayload cuts the payload down to the length in the header
+func (p *Packet) trimPayload() (*Packet, error) {
 	// safely clean up payload length
 	if p.Length <= uint16(len(p.Payload)) {
 		p.Payload = p.Payload[:p.Length]
@@ -44,7 +104,7 @@ func Deserialize(data []byte) (*Packet, error) {
 		return nil, fmt.Errorf(
 			"Invalid RDTP header. 'Length' field (%d) longer than data (%d)",
 			p.Length,
-			len(data)-HeaderByteSize)
+			len(p.Payload))
 	}
 	return p, nil
 }
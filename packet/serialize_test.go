@@ -76,3 +76,45 @@ func TestSerializeDeserialize(t *testing.T) {
 
 	assert.EqualValues(t, pRemote, pLocal)
 }
+
+func TestVersion0IsOriginalHeader(t *testing.T) {
+	// a SYN ACK as serialized by the original rdtp, whose header
+	// version 0 is, checksum included
+	original := append([]byte{
+		31, 145, 31, 146, // src port, dst port
+		0, 21, 161, 158, // length, checksum
+		0, 0, 4, 210, // seqno
+		0, 0, 17, 215, // ackno
+		synMask | ackMask}, []byte("[ mock http request ]")...) // flags, payload
+
+	p, err := Deserialize(original)
+	assert.Nil(t, err)
+	assert.Equal(t, Version0, p.Version)
+	assert.True(t, p.IsSYN() && p.IsACK())
+	assert.Equal(t, []byte("[ mock http request ]"), p.Payload)
+	assert.True(t, p.CheckSum())
+
+	local, err := NewPacket(uint16(8081), uint16(8082), []byte("[ mock http request ]"))
+	assert.Nil(t, err)
+	local.SetSeqNo(uint32(1234))
+	local.SetAckNo(uint32(4567))
+	local.SetFlagSYN()
+	local.SetFlagACK()
+	local.SetSum()
+	assert.Equal(t, original, local.Serialize())
+
+	// which carries neither a window nor options
+	local.SetWindow(uint16(8910))
+	local.SetTimestamps(uint32(1), uint32(2))
+	local.SetSACKBlocks([]SACKBlock{{Start: 1, End: 2}})
+	local.SetSum()
+	assert.Equal(t, original, local.Serialize())
+
+	// nor flags for them, which are dropped if received
+	original[16] |= tsMask | sckMask
+	p, err = Deserialize(original)
+	assert.Nil(t, err)
+	assert.False(t, p.IsTimestamped())
+	assert.False(t, p.IsSACK())
+	assert.True(t, p.CheckSum())
+}
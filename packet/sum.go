@@ -1,16 +1,86 @@
 package packet
 
-// SetSum sets the checksum on an rdtp packet
+import (
+	"encoding/binary"
+
+	"github.com/adrianosela/rdtp"
+)
+
+// SetSum sets the checksum on an rdtp packet. From version 1 on, it is
+// the Internet checksum (RFC 1071) of the packet and of a pseudo-header
+// of its IP addresses, which the ICS flag is set for. Packets of version
+// 0 carry the legacy sum, without the flag
 func (p *Packet) SetSum() {
-	p.Checksum = p.sum()
+	if p.Version == Version0 {
+		p.Flags = p.Flags &^ icsMask
+		p.Checksum = p.legacySum()
+		return
+	}
+	p.Flags = p.Flags | icsMask
+	p.Checksum = 0
+	p.Checksum = ^p.internetSum()
 }
 
-// CheckSum verifies the checksum on an rdtp packet
+// CheckSum verifies the checksum on an rdtp packet, which is the sum of
+// its version. Packets whose ICS flag disagrees with their version fail,
+// so that flipping the flag cannot downgrade them to the legacy sum
 func (p *Packet) CheckSum() bool {
-	return p.Checksum == p.sum()
+	if p.Version == Version0 {
+		return !p.IsICS() && p.Checksum == p.legacySum()
+	}
+	// the sum over a packet including its checksum is all ones
+	return p.IsICS() && p.internetSum() == 0xffff
+}
+
+// internetSum returns the one's complement sum of the 16 bit
+// words of the pseudo-header and of the serialized packet
+func (p *Packet) internetSum() uint16 {
+	b := p.Serialize()
+	sum := onesComplementAdd(0, p.pseudoHeader(len(b)))
+	sum = onesComplementAdd(sum, b)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return uint16(sum)
+}
+
+// pseudoHeader returns the IP pseudo-header of the packet, as that of
+// UDP over IPv4 (RFC 768) or IPv6 (RFC 8200). Unset addresses are zero
+func (p *Packet) pseudoHeader(length int) []byte {
+	src, dst := p.srcIP.To4(), p.dstIP.To4()
+	if src != nil && dst != nil {
+		b := make([]byte, 12)
+		copy(b[0:4], src)
+		copy(b[4:8], dst)
+		b[9] = rdtp.IPProtoRDTP
+		binary.BigEndian.PutUint16(b[10:12], uint16(length))
+		return b
+	}
+
+	b := make([]byte, 40)
+	copy(b[0:16], p.srcIP.To16())
+	copy(b[16:32], p.dstIP.To16())
+	binary.BigEndian.PutUint32(b[32:36], uint32(length))
+	b[39] = rdtp.IPProtoRDTP
+	return b
+}
+
+// onesComplementAdd adds the big endian 16 bit words of b to
+// a sum, padding b with a zero byte if its length is odd
+func onesComplementAdd(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
 }
 
-func (p *Packet) sum() uint16 {
+// legacySum is the sum of version 0, which misses most reorderings and
+// is kept only for its peers. It is that of the original rdtp, over the
+// fields of the original header, which are all version 0 packets carry
+func (p *Packet) legacySum() uint16 {
 	var csum uint16
 
 	csum += p.SrcPort
@@ -22,7 +92,7 @@ func (p *Packet) sum() uint16 {
 	csum += uint16(p.AckNo >> 8)
 	csum += uint16(p.AckNo)
 
-	csum += uint16(p.Flags)
+	csum += uint16(p.Flags & version0Flags)
 
 	for i := 0; i < len(p.Payload); i++ {
 		csum += uint16(p.Payload[i])
@@ -1,23 +1,47 @@
 package packet
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func TestOnesComplementAdd(t *testing.T) {
+	// the example of RFC 1071 section 3
+	sum := onesComplementAdd(0, []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7})
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	assert.Equal(t, uint32(0xddf2), sum)
+
+	// odd lengths are padded with a zero byte
+	assert.Equal(t, uint32(0x0100), onesComplementAdd(0, []byte{0x01}))
+}
+
 func TestSetSum(t *testing.T) {
 	payload := []byte{0x61, 0x61, 0x61}
 	p := &Packet{
 		SrcPort: uint16(8080),
 		DstPort: uint16(8081),
+		Version: Version1,
 		SeqNo:   uint32(10),
 		AckNo:   uint32(11),
 		Length:  uint16(len(payload)),
 		Payload: payload,
 	}
+	p.SetSourceIP(net.ParseIP("10.0.0.1"))
+	p.SetDestinationIP(net.ParseIP("10.0.0.2"))
 	p.SetSum()
-	assert.Equal(t, p.Checksum, ^uint16(len(payload)+8080+8081+10+11+0x61*len(payload)))
+	assert.True(t, p.IsICS())
+	assert.True(t, p.CheckSum())
+
+	// the checksum survives the trip over the wire
+	remote, err := Deserialize(p.Serialize())
+	assert.Nil(t, err)
+	remote.SetSourceIP(net.ParseIP("10.0.0.1"))
+	remote.SetDestinationIP(net.ParseIP("10.0.0.2"))
+	assert.True(t, remote.CheckSum())
 }
 
 func TestCheckSum(t *testing.T) {
@@ -32,10 +56,33 @@ func TestCheckSum(t *testing.T) {
 		Checksum: ^uint16(len(payload) + 8080 + 8081 + 10 + 11 + 0x61*len(payload)),
 	}
 
+	// packets of version 0 carry the legacy sum
 	assert.True(t, p.CheckSum())
 	// tamper with the packet
 	p.SrcPort = uint16(8000)
 	assert.False(t, p.CheckSum())
+
+	// which must not have the ICS flag set
+	p.SrcPort = uint16(8080)
+	p.Flags = p.Flags | icsMask
+	assert.False(t, p.CheckSum())
+}
+
+func TestCheckSumDowngrade(t *testing.T) {
+	p, err := NewPacket(uint16(8080), uint16(8081), []byte("hello"))
+	assert.Nil(t, err)
+	p.Version = Version1
+	p.SetSum()
+
+	// clearing the ICS flag of a packet of version 1 and setting
+	// the legacy sum does not get it verified by the legacy sum
+	p.Flags = p.Flags &^ icsMask
+	p.Checksum = p.legacySum()
+	assert.False(t, p.CheckSum())
+
+	remote, err := Deserialize(p.Serialize())
+	assert.Nil(t, err)
+	assert.False(t, remote.CheckSum())
 }
 
 func TestSum(t *testing.T) {
@@ -49,8 +96,44 @@ func TestSum(t *testing.T) {
 		Length:  uint16(len(payload)),
 		Payload: payload,
 	}
-	p.Checksum = p.sum()
+	p.SetSum()
+	assert.True(t, p.CheckSum())
+	p.Payload = malformed
+	assert.False(t, p.CheckSum())
+
+	p.Payload = payload
+	p.Version = Version1
+	p.SetSum()
 	assert.True(t, p.CheckSum())
 	p.Payload = malformed
 	assert.False(t, p.CheckSum())
+
+	// reordered bytes, which the legacy sum misses
+	p.Payload = []byte("[ mock htpt request ]")
+	assert.Equal(t, p.legacySum(), (&Packet{
+		SrcPort: p.SrcPort, DstPort: p.DstPort, SeqNo: p.SeqNo, AckNo: p.AckNo,
+		Length: p.Length, Flags: p.Flags, Payload: payload,
+	}).legacySum())
+	assert.False(t, p.CheckSum())
+}
+
+func TestSumPseudoHeader(t *testing.T) {
+	for _, ips := range [][2]string{
+		{"10.0.0.1", "10.0.0.2"},
+		{"fd00::1", "fd00::2"},
+	} {
+		p, err := NewPacket(uint16(8080), uint16(8081), []byte("hello"))
+		assert.Nil(t, err)
+		p.Version = Version1
+		p.SetSourceIP(net.ParseIP(ips[0]))
+		p.SetDestinationIP(net.ParseIP(ips[1]))
+		p.SetSum()
+		assert.True(t, p.CheckSum())
+
+		// the checksum covers the addresses the packet was sent to and from
+		p.SetDestinationIP(net.ParseIP(ips[0]))
+		assert.False(t, p.CheckSum())
+		p.SetDestinationIP(nil)
+		assert.False(t, p.CheckSum())
+	}
 }
@@ -0,0 +1,24 @@
+package packet
+
+import "encoding/binary"
+
+// TimestampOptionByteSize is the byte size of the timestamp option
+const TimestampOptionByteSize = 8
+
+// SetTimestamps sets the sender's timestamp and the one-way delay of the
+// latest packet received from the remote, both in microseconds, and the
+// TS flag. The delay is measured against the remote's clock, so it is
+// only meaningful relative to other delays measured the same way
+func (p *Packet) SetTimestamps(timestamp, delay uint32) {
+	p.Flags = p.Flags | tsMask
+	p.Timestamp = timestamp
+	p.TimestampDelay = delay
+}
+
+// serializeTimestampOption byte-encodes the timestamp option
+func (p *Packet) serializeTimestampOption(b []byte) []byte {
+	var enc [TimestampOptionByteSize]byte
+	binary.BigEndian.PutUint32(enc[0:4], p.Timestamp)
+	binary.BigEndian.PutUint32(enc[4:8], p.TimestampDelay)
+	return append(b, enc[:]...)
+}
@@ -0,0 +1,43 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetTimestamps(t *testing.T) {
+	p, err := NewPacket(uint16(14), uint16(15), nil)
+	assert.Nil(t, err)
+	assert.False(t, p.IsTimestamped())
+
+	p.SetTimestamps(uint32(123456), uint32(7890))
+	assert.True(t, p.IsTimestamped())
+	assert.Equal(t, uint32(123456), p.Timestamp)
+	assert.Equal(t, uint32(7890), p.TimestampDelay)
+}
+
+func TestSerializeDeserializeTimestamps(t *testing.T) {
+	payload := []byte("[ mock http request ]")
+
+	pLocal, err := NewPacket(uint16(8081), uint16(8082), payload)
+	assert.Nil(t, err)
+	pLocal.Version = Version1
+	pLocal.SetTimestamps(uint32(0x01020304), uint32(0x05060708))
+	pLocal.SetSACKBlocks([]SACKBlock{{Start: 200, End: 300}})
+	pLocal.SetSum()
+
+	// the timestamp option comes before the SACK option
+	byt := pLocal.Serialize()
+	assert.Equal(t, []byte{OptionTimestamps, TimestampOptionByteSize, 1, 2, 3, 4, 5, 6, 7, 8, OptionSACK},
+		byt[ExtendedHeaderByteSize:ExtendedHeaderByteSize+2+TimestampOptionByteSize+1])
+
+	pRemote, err := Deserialize(byt)
+	assert.Nil(t, err)
+	assert.EqualValues(t, pLocal, pRemote)
+	assert.True(t, pRemote.CheckSum())
+
+	// the checksum covers the timestamps
+	pRemote.TimestampDelay++
+	assert.False(t, pRemote.CheckSum())
+}
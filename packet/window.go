@@ -0,0 +1,6 @@
+package packet
+
+// SetWindow sets the receive window advertised by this packet
+func (p *Packet) SetWindow(wnd uint16) {
+	p.Window = wnd
+}
@@ -0,0 +1,31 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetWindow(t *testing.T) {
+	p, err := NewPacket(uint16(14), uint16(15), nil)
+	assert.Nil(t, err)
+
+	testWindow := uint16(28364)
+	p.SetWindow(testWindow)
+	assert.Equal(t, testWindow, p.Window)
+}
+
+func TestSerializeDeserializeWindow(t *testing.T) {
+	p, err := NewPacket(uint16(8081), uint16(8082), []byte("data"))
+	assert.Nil(t, err)
+	p.Version = Version1
+	p.SetWindow(uint16(8910))
+
+	// the window follows the flags from version 1 on
+	byt := p.Serialize()
+	assert.Equal(t, []byte{34, 206}, byt[HeaderByteSize:HeaderByteSize+2])
+
+	remote, err := Deserialize(byt)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(8910), remote.Window)
+}
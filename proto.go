@@ -6,6 +6,10 @@ const (
 	// https://en.wikipedia.org/wiki/List_of_IP_protocol_numbers
 	IPProtoRDTP = 0x9D
 
+	// UDPPortRDTP is the default UDP port on which
+	// rdtp packets are carried when encapsulated in UDP
+	UDPPortRDTP = 9157
+
 	// DiscoveryPort is the port that receives new RDTP connections
 	DiscoveryPort = uint16(0)
 
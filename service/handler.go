@@ -1,31 +1,35 @@
 package service
 
 import (
-	"encoding/json"
 	"io"
 	"log"
-	"math/rand"
 	"net"
+	"time"
 
 	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/handshake"
+	"github.com/adrianosela/rdtp/mux"
 	"github.com/adrianosela/rdtp/service/ports"
 	"github.com/adrianosela/rdtp/socket"
 	"github.com/pkg/errors"
 )
 
 func (s *Service) handleClientMessage(c net.Conn) {
-	buf := make([]byte, 1024)
-	n, err := c.Read(buf)
-	if err != nil {
-		log.Println("connection closed by client")
-		sendErrorMessage(c, rdtp.ServiceErrorTypeConnClosedByClient)
+	var req rdtp.ClientMessage
+	if err := rdtp.ReadMessage(c, &req); err != nil {
+		if err == io.EOF {
+			log.Println("connection closed by client")
+			sendErrorMessage(c, rdtp.ServiceErrorTypeConnClosedByClient)
+			return
+		}
+		log.Println(errors.Wrap(err, "malformed client message received"))
+		sendErrorMessage(c, rdtp.ServiceErrorTypeMalformedMessage)
 		return
 	}
 
-	var req rdtp.ClientMessage
-	if err := json.Unmarshal(buf[:n], &req); err != nil {
-		log.Println("malformed client message received")
-		sendErrorMessage(c, rdtp.ServiceErrorTypeMalformedMessage)
+	if req.Version != rdtp.ControlProtocolVersion {
+		log.Printf("client speaks unsupported control protocol version %d", req.Version)
+		sendErrorMessage(c, rdtp.ServiceErrorTypeUnsupportedVersion)
 		return
 	}
 
@@ -39,6 +43,9 @@ func (s *Service) handleClientMessage(c net.Conn) {
 	case rdtp.ClientMessageTypeListen:
 		s.handleClientMessageListen(c, req)
 		break
+	case rdtp.ClientMessageTypeMultiplex:
+		s.handleClientMessageMultiplex(c)
+		break
 	default:
 		log.Println("invalid message type received")
 		sendErrorMessage(c, rdtp.ServiceErrorTypeInvalidMessageType)
@@ -48,14 +55,37 @@ func (s *Service) handleClientMessage(c net.Conn) {
 	return
 }
 
+// socketConfig returns the configuration of the socket of a
+// connection dialed or accepted as requested by the client
+func (s *Service) socketConfig(r rdtp.ClientMessage, laddr *rdtp.Addr, c net.Conn) socket.Config {
+	app := rdtp.NewServiceConn(c)
+	app.SetClock(s.clock)
+	return socket.Config{
+		LocalAddr:         laddr,
+		RemoteAddr:        &r.RemoteAddr,
+		Application:       app,
+		Network:           s.network,
+		CongestionControl: r.CongestionControl,
+		ReceiveBufferSize: r.ReceiveBufferSize,
+		SendBufferSize:    r.SendBufferSize,
+		KeepAlive:         time.Duration(r.KeepAliveMillis) * time.Millisecond,
+		Clock:             s.clock,
+		ISNKey:            s.isnKey,
+	}
+}
+
 func (s *Service) handleClientMessageDial(c net.Conn, r rdtp.ClientMessage) {
-	laddr := &rdtp.Addr{Host: getOutboundIP(), Port: uint16(rand.Intn(int(rdtp.MaxPort)-1) + 1)}
-	sck, err := socket.New(socket.Config{
-		LocalAddr:   laddr,
-		RemoteAddr:  &r.RemoteAddr,
-		Application: c,
-		Network:     s.network,
-	})
+	laddr, err := s.dialAddrFor(r.LocalAddr, r.RemoteAddr.Host)
+	if err != nil {
+		log.Println(errors.Wrap(err, "failed to create socket"))
+		if errors.Is(err, errAddressNotAvailable) {
+			sendErrorMessage(c, rdtp.ServiceErrorTypeAddressNotAvailable)
+			return
+		}
+		sendErrorMessage(c, rdtp.ServiceErrorTypeFailedToCreateSocket)
+		return
+	}
+	sck, err := socket.New(s.socketConfig(r, laddr, c))
 	if err != nil {
 		c.Close()
 		log.Println(errors.Wrap(err, "failed to create socket"))
@@ -73,6 +103,10 @@ func (s *Service) handleClientMessageDial(c net.Conn, r rdtp.ClientMessage) {
 
 	if err := sck.Dial(); err != nil {
 		log.Println(errors.Wrap(err, "socket dial failed"))
+		if errors.Is(err, handshake.ErrConnectionRefused) {
+			sendErrorMessage(c, rdtp.ServiceErrorTypeConnectionRefused)
+			return
+		}
 		sendErrorMessage(c, rdtp.ServiceErrorTypeFailedHandshake)
 		return
 	}
@@ -83,18 +117,26 @@ func (s *Service) handleClientMessageDial(c net.Conn, r rdtp.ClientMessage) {
 		return
 	}
 
-	sck.Run()
+	if err := sck.Run(); err != nil {
+		log.Println(errors.Wrap(err, "socket terminated"))
+	}
 
 	return
 }
 
 func (s *Service) handleClientMessageAccept(c net.Conn, r rdtp.ClientMessage) {
-	sck, err := socket.New(socket.Config{
-		LocalAddr:   &r.LocalAddr,
-		RemoteAddr:  &r.RemoteAddr,
-		Application: c,
-		Network:     s.network,
-	})
+	// listeners on all addresses accept connections of either family
+	if r.LocalAddr.Host == "" {
+		localIP, err := s.localIPFor(r.RemoteAddr.Host)
+		if err != nil {
+			c.Close()
+			log.Println(errors.Wrap(err, "failed to create socket"))
+			sendErrorMessage(c, rdtp.ServiceErrorTypeFailedToCreateSocket)
+			return
+		}
+		r.LocalAddr.Host = localIP
+	}
+	sck, err := socket.New(s.socketConfig(r, &r.LocalAddr, c))
 	if err != nil {
 		c.Close()
 		log.Println(errors.Wrap(err, "failed to create socket"))
@@ -122,7 +164,9 @@ func (s *Service) handleClientMessageAccept(c net.Conn, r rdtp.ClientMessage) {
 		return
 	}
 
-	sck.Run()
+	if err := sck.Run(); err != nil {
+		log.Println(errors.Wrap(err, "socket terminated"))
+	}
 
 	return
 }
@@ -144,9 +188,37 @@ func (s *Service) handleClientMessageListen(c net.Conn, r rdtp.ClientMessage) {
 	// wait for EOF (connection close by client)
 	for {
 		if _, err := c.Read(make([]byte, 1)); err != nil {
-			if err == io.EOF {
-				return
-			}
+			return
+		}
+	}
+}
+
+func (s *Service) handleClientMessageMultiplex(c net.Conn) {
+	// sessions are not multiplexed over their own streams
+	if _, ok := c.(*mux.Stream); ok {
+		log.Println("multiplex request received over multiplexed session")
+		sendErrorMessage(c, rdtp.ServiceErrorTypeInvalidMessageType)
+		return
+	}
+
+	if err := sendOKMessage(c, nil, nil); err != nil {
+		log.Println(errors.Wrap(err, "failed to send ok message"))
+		c.Close()
+		return
+	}
+
+	// each stream of the session is served as a client connection
+	// of its own, which is closed once its request is over
+	session := mux.NewServer(c)
+	defer session.Close()
+	for {
+		st, err := session.Accept()
+		if err != nil {
+			return
 		}
+		s.clock.Go(func() {
+			s.handleClientMessage(st)
+			st.Close()
+		})
 	}
 }
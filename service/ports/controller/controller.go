@@ -4,6 +4,21 @@ import (
 	"github.com/adrianosela/rdtp/packet"
 	"github.com/adrianosela/rdtp/service/ports"
 	"github.com/adrianosela/rdtp/socket"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoListener is returned when delivering a SYN
+	// to a port which no listener is attached to
+	ErrNoListener = errors.New("no listener")
+
+	// ErrNoSocket is returned when delivering a packet
+	// of a connection which no socket is attached for
+	ErrNoSocket = errors.New("socket address not active")
+
+	// ErrTimeWait is returned when delivering a stray packet
+	// of a connection which is over but still in TIME_WAIT
+	ErrTimeWait = errors.New("connection in TIME_WAIT")
 )
 
 // Controller represents the rdtp ports controller.
@@ -14,4 +29,33 @@ type Controller interface {
 	Deliver(p *packet.Packet) error
 	AttachListener(l *ports.Listener) error
 	DetachListener(port uint16) error
+	Corrupted() uint64
+
+	// connection states, for diagnostics
+	State(sckID string) (socket.State, error)
+	States() map[string]socket.State
+}
+
+// Answer returns the packet which answers a packet a controller failed
+// to deliver with the given error, or nil if the packet is to be dropped
+// silently: if it was delivered, failed its checksum or is a reset itself.
+// Packets of no connection or listener are answered with a reset, and
+// FINs retransmitted to connections in TIME_WAIT with their ACK again
+func Answer(p *packet.Packet, err error) *packet.Packet {
+	if p.IsERR() {
+		return nil
+	}
+	switch errors.Cause(err) {
+	case ErrNoListener:
+		return packet.NewReset(p, packet.ResetNoListener)
+	case ErrNoSocket:
+		return packet.NewReset(p, packet.ResetNoConnection)
+	case ErrTimeWait:
+		if p.IsFIN() {
+			return packet.NewAck(p)
+		}
+		return nil
+	default:
+		return nil
+	}
 }
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 
 	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/clock"
 	"github.com/adrianosela/rdtp/packet"
 	"github.com/adrianosela/rdtp/service/ports"
 
@@ -23,8 +25,33 @@ type MemoryController struct {
 
 	// sockets is a map of sockets where each socket's
 	// unique identifier is "laddr:lport raddr:rport",
-	// e.g. "192.168.1.75:4444 192.168.1.88:1201"
+	// e.g. "192.168.1.75:4444 192.168.1.88:1201" or
+	// "[fe80::1]:4444 [fe80::2]:1201"
 	sockets map[string]*socket.Socket
+
+	// connections in TIME_WAIT by socket id, held in
+	// place of their sockets once these are evicted
+	timeWaits map[string]timeWait
+
+	// source of time, for TIME_WAIT
+	clock clock.Clock
+
+	// SYNs listeners were notified of, held for the sockets
+	// accepting them, which need them for the handshake
+	syns   map[string]pendingSYN
+	synSeq uint64
+
+	// number of packets dropped for failing their checksum
+	corrupted uint64
+}
+
+// maxPendingSYNs is the number of SYNs held for sockets yet to
+// accept them, beyond which the oldest SYN is dropped
+const maxPendingSYNs = 128
+
+type pendingSYN struct {
+	syn *packet.Packet
+	seq uint64 // order of arrival
 }
 
 // NewMemoryController returns an initialized in-memory rdtp sockets manager
@@ -32,9 +59,20 @@ func NewMemoryController() *MemoryController {
 	return &MemoryController{
 		listeners: make(map[uint16]*ports.Listener),
 		sockets:   make(map[string]*socket.Socket),
+		syns:      make(map[string]pendingSYN),
+		timeWaits: make(map[string]timeWait),
+		clock:     clock.Real{},
 	}
 }
 
+// SetClock sets the clock connections are held in TIME_WAIT by
+func (m *MemoryController) SetClock(c clock.Clock) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.clock = c
+}
+
 // Put attaches a socket to the controller
 func (m *MemoryController) Put(s *socket.Socket) error {
 	m.Lock()
@@ -44,13 +82,24 @@ func (m *MemoryController) Put(s *socket.Socket) error {
 	if _, ok := m.sockets[id]; ok {
 		return errors.New("socket address already in use")
 	}
+	if m.inTimeWait(id) {
+		return errors.New("socket address in TIME_WAIT")
+	}
 	m.sockets[id] = s
 
+	// sockets accepting a connection start off with its SYN
+	if pending, ok := m.syns[id]; ok {
+		delete(m.syns, id)
+		s.Deliver(pending.syn)
+	}
+
 	log.Printf("%s [attached]\n", id)
 	return nil
 }
 
-// Evict removes a socket given its id
+// Evict removes a socket given its id. Connections the socket closed
+// first are held in TIME_WAIT, so that their address is not reused
+// while their segments may still be in flight
 func (m *MemoryController) Evict(id string) error {
 	m.Lock()
 	defer m.Unlock()
@@ -64,6 +113,10 @@ func (m *MemoryController) Evict(id string) error {
 	delete(m.sockets, id)
 
 	log.Printf("%s [evicted]\n", id)
+
+	if tw, ok := sck.TimeWait(); ok {
+		m.enterTimeWait(id, tw)
+	}
 	return nil
 }
 
@@ -106,14 +159,20 @@ func (m *MemoryController) notifyListener(p *packet.Packet) error {
 	l, ok := m.listeners[p.DstPort]
 	m.RUnlock()
 	if !ok {
-		return fmt.Errorf("no listener on port %d", p.DstPort)
+		return errors.Wrapf(ErrNoListener, "port %d", p.DstPort)
 	}
 
-	remoteAddress, err := p.GetSourceIPv4()
+	remoteAddress, err := p.GetSourceIP()
 	if err != nil {
 		return errors.Wrap(err, "could not get destination address from packet")
 	}
 
+	id, err := socketIDFromPacket(p)
+	if err != nil {
+		return errors.Wrap(err, "could not build socket address from packet data")
+	}
+	m.holdSYN(id, p)
+
 	if err = l.Notify(&rdtp.Addr{Host: remoteAddress.String(), Port: p.SrcPort}); err != nil {
 		return errors.Wrap(err, fmt.Sprintf("could not notify listener of connection from %s", remoteAddress.String()))
 	}
@@ -121,13 +180,33 @@ func (m *MemoryController) notifyListener(p *packet.Packet) error {
 	return nil
 }
 
-// Deliver delivers an inbound rdtp packet
-func (m *MemoryController) Deliver(p *packet.Packet) error {
-	if p.IsSYN() && !p.IsACK() {
-		if err := m.notifyListener(p); err != nil {
-			return errors.Wrap(err, "could not notify listener")
+// holdSYN holds a SYN for the socket which accepts it,
+// dropping the oldest SYN held if there are too many
+func (m *MemoryController) holdSYN(id string, syn *packet.Packet) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.syns[id]; !ok && len(m.syns) >= maxPendingSYNs {
+		oldest := ""
+		for pid, pending := range m.syns {
+			if oldest == "" || pending.seq < m.syns[oldest].seq {
+				oldest = pid
+			}
 		}
-		return nil
+		delete(m.syns, oldest)
+	}
+	m.synSeq++
+	m.syns[id] = pendingSYN{syn: syn, seq: m.synSeq}
+}
+
+// Deliver delivers an inbound rdtp packet. SYNs go to the listener on
+// their port, unless a socket of their connection is already open,
+// as when both ends open a connection to each other at once, or the
+// connection is in TIME_WAIT and they are not of a new one
+func (m *MemoryController) Deliver(p *packet.Packet) error {
+	if !p.CheckSum() {
+		atomic.AddUint64(&m.corrupted, 1)
+		return errors.New("packet dropped: checksum mismatch")
 	}
 
 	id, err := socketIDFromPacket(p)
@@ -138,24 +217,76 @@ func (m *MemoryController) Deliver(p *packet.Packet) error {
 	m.RLock()
 	s, ok := m.sockets[id]
 	m.RUnlock()
+	if ok {
+		s.Deliver(p)
+		return nil
+	}
+
+	if m.absorb(id, p) {
+		return ErrTimeWait
+	}
+
+	if p.IsSYN() && !p.IsACK() {
+		if err := m.notifyListener(p); err != nil {
+			return errors.Wrap(err, "could not notify listener")
+		}
+		return nil
+	}
+	return ErrNoSocket
+}
+
+// State returns the state of the connection of a socket given its id
+func (m *MemoryController) State(id string) (socket.State, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	s, ok := m.sockets[id]
 	if !ok {
-		return errors.New("socket address not active")
+		if tw, ok := m.timeWaits[id]; ok && m.clock.Now().Before(tw.expires) {
+			return socket.StateTimeWait, nil
+		}
+		return socket.StateClosed, ErrNoSocket
 	}
+	return s.State(), nil
+}
 
-	s.Deliver(p)
-	return nil
+// States returns the state of the connection of every socket by id
+func (m *MemoryController) States() map[string]socket.State {
+	m.RLock()
+	defer m.RUnlock()
+
+	states := make(map[string]socket.State, len(m.sockets)+len(m.timeWaits))
+	now := m.clock.Now()
+	for id, tw := range m.timeWaits {
+		if now.Before(tw.expires) {
+			states[id] = socket.StateTimeWait
+		}
+	}
+	for id, s := range m.sockets {
+		states[id] = s.State()
+	}
+	return states
+}
+
+// Corrupted returns the number of inbound
+// packets dropped for failing their checksum
+func (m *MemoryController) Corrupted() uint64 {
+	return atomic.LoadUint64(&m.corrupted)
 }
 
 func socketIDFromPacket(p *packet.Packet) (string, error) {
 	// destination = local for inbound, remote for outbound pcks
-	dst, err := p.GetDestinationIPv4()
+	dst, err := p.GetDestinationIP()
 	if err != nil {
 		return "", err
 	}
 	// source = local for outbound, remote for inbound pcks
-	src, err := p.GetSourceIPv4()
+	src, err := p.GetSourceIP()
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%s:%d %s:%d", dst, p.DstPort, src, p.SrcPort), nil
+	// formatted as socket IDs are
+	local := &rdtp.Addr{Host: dst.String(), Port: p.DstPort}
+	remote := &rdtp.Addr{Host: src.String(), Port: p.SrcPort}
+	return fmt.Sprintf("%s %s", local, remote), nil
 }
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"log"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/adrianosela/rdtp/socket"
+)
+
+// MaxSegmentLifetime is the longest a segment is assumed to live in
+// the network. Connections are held in TIME_WAIT for twice as long,
+// by when no segment of theirs can be left in flight
+const MaxSegmentLifetime = 30 * time.Second
+
+// timeWait is a connection held in TIME_WAIT
+type timeWait struct {
+	socket.TimeWait
+	expires time.Time
+}
+
+// newer returns true if a SYN is of a new connection from the remote
+// rather than a stray of the one in TIME_WAIT: if it was stamped after
+// the latest packet of the connection, or numbered past its sequence
+// space when the remote does not stamp packets (RFC 6191)
+func (tw timeWait) newer(syn *packet.Packet) bool {
+	if tw.Timestamped {
+		return syn.IsTimestamped() && packet.SeqGT(syn.Timestamp, tw.Timestamp)
+	}
+	return packet.SeqGT(syn.SeqNo, tw.NextSeqNo)
+}
+
+// enterTimeWait holds a connection in TIME_WAIT, dropping those
+// held for long enough. Must hold m's lock
+func (m *MemoryController) enterTimeWait(id string, tw socket.TimeWait) {
+	now := m.clock.Now()
+	for held, old := range m.timeWaits {
+		if !now.Before(old.expires) {
+			delete(m.timeWaits, held)
+		}
+	}
+	m.timeWaits[id] = timeWait{TimeWait: tw, expires: now.Add(2 * MaxSegmentLifetime)}
+
+	log.Printf("%s [time wait]\n", id)
+}
+
+// inTimeWait returns true if a connection is held in TIME_WAIT,
+// dropping it if it has been held for long enough. Must hold m's lock
+func (m *MemoryController) inTimeWait(id string) bool {
+	tw, ok := m.timeWaits[id]
+	if ok && !m.clock.Now().Before(tw.expires) {
+		delete(m.timeWaits, id)
+		return false
+	}
+	return ok
+}
+
+// absorb returns true if a packet is for a connection in TIME_WAIT,
+// and is to be dropped. FINs are retransmitted by remotes which missed
+// their ACK, and hold the connection in TIME_WAIT for longer, while
+// SYNs of new connections end TIME_WAIT and are not absorbed
+func (m *MemoryController) absorb(id string, p *packet.Packet) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.inTimeWait(id) {
+		return false
+	}
+	tw := m.timeWaits[id]
+
+	if p.IsSYN() && !p.IsACK() && tw.newer(p) {
+		delete(m.timeWaits, id)
+		log.Printf("%s [reused]\n", id)
+		return false
+	}
+	if p.IsFIN() {
+		tw.expires = m.clock.Now().Add(2 * MaxSegmentLifetime)
+		m.timeWaits[id] = tw
+	}
+	return true
+}
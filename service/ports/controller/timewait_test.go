@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/adrianosela/rdtp/socket"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const timeWaitID = "10.0.0.1:1 10.0.0.2:2"
+
+// newTimeWaitController returns a controller holding the connection
+// from 10.0.0.1:1 to 10.0.0.2:2 in TIME_WAIT
+func newTimeWaitController(tw socket.TimeWait) (*MemoryController, *clock.Simulated) {
+	clk := clock.NewSimulated(time.Unix(0, 0))
+	m := NewMemoryController()
+	m.SetClock(clk)
+
+	m.Lock()
+	m.enterTimeWait(timeWaitID, tw)
+	m.Unlock()
+	return m, clk
+}
+
+// mockRemotePacket returns a packet from 10.0.0.2:2 to 10.0.0.1:1
+func mockRemotePacket(seq uint32, syn, ack, fin bool) *packet.Packet {
+	p, _ := packet.NewPacket(2, 1, nil)
+	p.SetSeqNo(seq)
+	if syn {
+		p.SetFlagSYN()
+	}
+	if ack {
+		p.SetFlagACK()
+		p.SetAckNo(5000)
+	}
+	if fin {
+		p.SetFlagFIN()
+	}
+	p.SetSourceIP(net.ParseIP("10.0.0.2"))
+	p.SetDestinationIP(net.ParseIP("10.0.0.1"))
+	p.SetSum()
+	return p
+}
+
+func TestTimeWaitAbsorbsStrayPackets(t *testing.T) {
+	m, _ := newTimeWaitController(socket.TimeWait{NextSeqNo: 1000})
+
+	st, err := m.State(timeWaitID)
+	assert.Nil(t, err)
+	assert.Equal(t, socket.StateTimeWait, st)
+	assert.Equal(t, socket.StateTimeWait, m.States()[timeWaitID])
+
+	// stray segments and resets of the old connection are dropped
+	stray := mockRemotePacket(900, false, true, false)
+	err = m.Deliver(stray)
+	assert.Equal(t, ErrTimeWait, err)
+	assert.Nil(t, Answer(stray, err))
+
+	rst := mockRemotePacket(1000, false, true, false)
+	rst.SetFlagERR()
+	rst.SetSum()
+	assert.Equal(t, ErrTimeWait, m.Deliver(rst))
+
+	// as are SYNs numbered within its sequence space
+	syn := mockRemotePacket(1000, true, false, false)
+	assert.Equal(t, ErrTimeWait, m.Deliver(syn))
+}
+
+func TestTimeWaitAcknowledgesFINs(t *testing.T) {
+	m, clk := newTimeWaitController(socket.TimeWait{NextSeqNo: 1000})
+
+	// FINs retransmitted by the remote are acknowledged again
+	clk.Advance(MaxSegmentLifetime)
+	fin := mockRemotePacket(1000, false, true, true)
+	err := m.Deliver(fin)
+	assert.Equal(t, ErrTimeWait, err)
+
+	ack := Answer(fin, err)
+	assert.NotNil(t, ack)
+	assert.True(t, ack.IsACK())
+	assert.False(t, ack.IsFIN() || ack.IsERR())
+	assert.Equal(t, uint32(5000), ack.SeqNo)
+	assert.Equal(t, uint32(1000), ack.AckNo)
+
+	// and restart TIME_WAIT
+	clk.Advance(MaxSegmentLifetime * 3 / 2)
+	assert.Equal(t, ErrTimeWait, m.Deliver(mockRemotePacket(1000, false, true, false)))
+
+	// which is over after twice the maximum segment lifetime
+	clk.Advance(MaxSegmentLifetime)
+	assert.Equal(t, ErrNoSocket, m.Deliver(mockRemotePacket(1000, false, true, false)))
+	_, err = m.State(timeWaitID)
+	assert.Equal(t, ErrNoSocket, err)
+}
+
+func TestTimeWaitReuse(t *testing.T) {
+	// SYNs numbered past the old connection's sequence space open a new one
+	m, _ := newTimeWaitController(socket.TimeWait{NextSeqNo: 1000})
+	err := m.Deliver(mockRemotePacket(2000, true, false, false))
+	assert.Equal(t, ErrNoListener, errors.Cause(err))
+	_, err = m.State(timeWaitID)
+	assert.Equal(t, ErrNoSocket, err)
+
+	// from remotes which stamp timestamps, SYNs stamped after the latest
+	// packet of the old connection do, whatever their sequence number
+	m, _ = newTimeWaitController(socket.TimeWait{NextSeqNo: 1000, Timestamp: 700, Timestamped: true})
+
+	syn := mockRemotePacket(2000, true, false, false)
+	assert.Equal(t, ErrTimeWait, m.Deliver(syn))
+
+	syn.SetTimestamps(600, 0)
+	syn.SetSum()
+	assert.Equal(t, ErrTimeWait, m.Deliver(syn))
+
+	syn = mockRemotePacket(0, true, false, false)
+	syn.SetTimestamps(800, 0)
+	syn.SetSum()
+	assert.NotEqual(t, ErrTimeWait, m.Deliver(syn))
+	_, err = m.State(timeWaitID)
+	assert.Equal(t, ErrNoSocket, err)
+}
@@ -3,59 +3,223 @@ package service
 import (
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/clock"
 	"github.com/adrianosela/rdtp/network"
 	"github.com/adrianosela/rdtp/packet"
 	"github.com/adrianosela/rdtp/service/ports/controller"
+	"github.com/adrianosela/rdtp/socket"
 	"github.com/pkg/errors"
 )
 
+// errAddressNotAvailable is returned for dials from
+// local addresses which are not the rdtp service's
+var errAddressNotAvailable = errors.New("address not available")
+
 // Service is an abstraction of the rdtp service
 type Service struct {
 	ports   controller.Controller
 	network network.Network
+	clock   clock.Clock
+	isnKey  []byte
+
+	// unix socket clients reach the service on and local
+	// IP addresses of connections of each address family
+	serviceAddr string
+	localIP     string
+	localIPv6   string
+
+	// set once the service is running
+	sync.Mutex
+	clients net.Listener
+	closed  bool
 }
 
-// NewService returns an rdtp service instance
-func NewService() (*Service, error) {
+// Config is the configuration of an rdtp service
+type Config struct {
+	// network carrying rdtp packets to and from remote services,
+	// e.g. network.NewUDP() to run unprivileged (defaults to raw
+	// IPv4 and IPv6 networks, which require CAP_NET_RAW, if nil)
+	Network network.Network
+
+	// unix socket on which rdtp clients reach the service
+	// (defaults to rdtp.DefaultRDTPServiceAddr if empty)
+	ServiceAddr string
+
+	// local IP address of the connections dialed, which must be
+	// the address of the network (defaults to the preferred
+	// outbound IP address of this machine if nil). The
+	// unspecified address 0.0.0.0 serves IPv6 only
+	LocalIP net.IP
+
+	// local IP address of the connections dialed to IPv6 addresses
+	// (defaults to the preferred outbound IPv6 address of this
+	// machine if nil, if it has one). The unspecified address ::
+	// serves IPv4 only
+	LocalIPv6 net.IP
+
+	// source of time of the service and its sockets, e.g. a simulated
+	// clock to run the service on simulated time (defaults to real
+	// time if nil)
+	Clock clock.Clock
+
+	// secret key the initial sequence numbers of the connections
+	// are derived from (defaults to a random key if nil)
+	ISNKey []byte
+}
+
+// New returns an rdtp service instance
+func New(c Config) (*Service, error) {
+	if c.Network == nil {
+		ipNetwork, err := newIPNetwork()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not acquire network")
+		}
+		c.Network = ipNetwork
+	}
+	if c.ServiceAddr == "" {
+		c.ServiceAddr = rdtp.DefaultRDTPServiceAddr
+	}
+	if c.LocalIP == nil {
+		ip, err := getOutboundIP("udp4")
+		if err != nil {
+			return nil, errors.Wrap(err, "could not determine local IP address")
+		}
+		c.LocalIP = ip
+	}
+	if c.LocalIPv6 == nil {
+		// machines without IPv6 connectivity serve IPv4 only
+		c.LocalIPv6, _ = getOutboundIP("udp6")
+	}
+	if c.Clock == nil {
+		c.Clock = clock.Real{}
+	}
+	ports := controller.NewMemoryController()
+	ports.SetClock(c.Clock)
+	s := &Service{
+		ports:       ports,
+		network:     c.Network,
+		clock:       c.Clock,
+		isnKey:      c.ISNKey,
+		serviceAddr: c.ServiceAddr,
+	}
+	if !c.LocalIP.IsUnspecified() {
+		s.localIP = c.LocalIP.String()
+	}
+	if c.LocalIPv6 != nil && !c.LocalIPv6.IsUnspecified() {
+		s.localIPv6 = c.LocalIPv6.String()
+	}
+	return s, nil
+}
+
+// newIPNetwork returns a network over raw IPv4 and IPv6,
+// or over raw IPv4 alone if IPv6 is not available
+func newIPNetwork() (network.Network, error) {
 	ipv4Network, err := network.NewIPv4()
 	if err != nil {
-		return nil, errors.Wrap(err, "could not acquire network")
+		return nil, err
+	}
+	ipv6Network, err := network.NewIPv6()
+	if err != nil {
+		log.Println(errors.Wrap(err, "[rdtp] IPv6 unavailable, serving IPv4 only"))
+		return ipv4Network, nil
+	}
+	return network.NewDualStack(ipv4Network, ipv6Network), nil
+}
+
+// localIPFor returns the local IP address of connections to or
+// from a remote host, which depends on the host's address family
+func (s *Service) localIPFor(remoteHost string) (string, error) {
+	ip := net.ParseIP(remoteHost)
+	if ip == nil {
+		return "", fmt.Errorf("invalid remote host %q", remoteHost)
+	}
+	if ip.To4() != nil {
+		if s.localIP == "" {
+			return "", errors.New("no local IPv4 address")
+		}
+		return s.localIP, nil
+	}
+	if s.localIPv6 == "" {
+		return "", errors.New("no local IPv6 address")
+	}
+	return s.localIPv6, nil
+}
+
+// dialAddrFor returns the local address of a connection dialed to the
+// given remote host: the address requested, with the service's IP of
+// the remote's family if no host is, and a random port if no port is
+func (s *Service) dialAddrFor(requested rdtp.Addr, remoteHost string) (*rdtp.Addr, error) {
+	localIP, err := s.localIPFor(remoteHost)
+	if err != nil {
+		return nil, err
 	}
-	return &Service{
-		ports:   controller.NewMemoryController(),
-		network: ipv4Network,
-	}, nil
+	if requested.Host != "" && !net.ParseIP(requested.Host).Equal(net.ParseIP(localIP)) {
+		return nil, errors.Wrapf(errAddressNotAvailable, "%s is not the local address of the rdtp service for %s", requested.Host, remoteHost)
+	}
+	port := requested.Port
+	if port == 0 {
+		port = uint16(rand.Intn(int(rdtp.MaxPort)-1) + 1)
+	}
+	return &rdtp.Addr{Host: localIP, Port: port}, nil
 }
 
-// Run runs the rdtp service
+// NewService returns an rdtp service instance over raw IP
+func NewService() (*Service, error) {
+	return New(Config{})
+}
+
+// Run runs the rdtp service, serving clients on its unix socket
 func (s *Service) Run() error {
+	clients, err := safeUnixListener(s.serviceAddr)
+	if err != nil {
+		return errors.Wrap(err, "could not start system's rdtp client listener")
+	}
+	return s.Serve(clients)
+}
+
+// Serve runs the rdtp service, serving the clients which connect
+// through the given listener, e.g. one of connections in memory
+// to serve clients in the same process, until it is closed
+func (s *Service) Serve(clients net.Listener) error {
 	// receive all rdtp packets passed on by the network
-	// and forward them to the corresponding socket
+	// and forward them to the corresponding socket, answering
+	// those of no connection or listener with a reset, and FINs
+	// retransmitted to connections in TIME_WAIT with their ACK
 	s.network.StartReceiver(func(p *packet.Packet) error {
 		if err := s.ports.Deliver(p); err != nil {
-			// TODO: send error message outbound
+			if ans := controller.Answer(p, err); ans != nil {
+				if sendErr := s.network.Send(ans); sendErr != nil {
+					log.Println(errors.Wrap(sendErr, "could not answer rdtp packet"))
+				}
+			}
 			return errors.Wrap(err, "could not deliver packet to rdtp socket")
 		}
 		return nil
 	})
 
-	clients, err := safeUnixListener(rdtp.DefaultRDTPServiceAddr)
-	if err != nil {
-		return errors.Wrap(err, "could not start system's rdtp client listener")
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		clients.Close()
+		return nil
 	}
+	s.clients = clients
+	s.Unlock()
 	log.Println("[rdtp] service running")
 
 	for {
 		conn, err := clients.Accept()
 		if err != nil {
-			// connection closed by a signal
-			if err.Error() == fmt.Sprintf("accept unix %s: use of closed network connection", rdtp.DefaultRDTPServiceAddr) {
+			// connection closed by a signal or Close
+			if errors.Is(err, net.ErrClosed) {
 				log.Printf("[rdtp] service stopped\n")
 				return nil
 			}
@@ -63,8 +227,26 @@ func (s *Service) Run() error {
 			log.Println(errors.Wrap(err, "could not accept rdtp client connection"))
 			return err
 		}
-		go s.handleClientMessage(conn)
+		s.clock.Go(func() { s.handleClientMessage(conn) })
+	}
+}
+
+// States returns the state of the connection of every socket
+// of the service by id, connections in TIME_WAIT included
+func (s *Service) States() map[string]socket.State {
+	return s.ports.States()
+}
+
+// Close stops the service from accepting rdtp clients
+func (s *Service) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closed = true
+	if s.clients == nil {
+		return nil
 	}
+	return s.clients.Close()
 }
 
 func safeUnixListener(unixAddr string) (net.Listener, error) {
@@ -9,15 +9,20 @@ import (
 )
 
 // get preferred outbound ip of this machine
-func getOutboundIP() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// on the given network, either "udp4" or "udp6"
+func getOutboundIP(network string) (net.IP, error) {
+	remote := "8.8.8.8:80"
+	if network == "udp6" {
+		remote = "[2001:4860:4860::8888]:80"
+	}
+	conn, err := net.Dial(network, remote)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer conn.Close()
 
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String()
+	return localAddr.IP, nil
 }
 
 func sendOKMessage(c net.Conn, laddr, raddr *rdtp.Addr) error {
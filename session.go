@@ -0,0 +1,55 @@
+package rdtp
+
+import (
+	"net"
+
+	"github.com/adrianosela/rdtp/mux"
+	"github.com/pkg/errors"
+)
+
+// Session is a single connection to the rdtp service which connections,
+// listeners and their requests share, rather than each opening a unix
+// connection of its own. Each is a stream of the session (see the mux
+// package), with flow control of its own. Pass it to Dial and Listen
+// with WithSession
+type Session struct {
+	mux *mux.Session
+}
+
+// NewSession connects to the rdtp service for connections and
+// listeners to share. Only WithServiceAddr applies to it
+func NewSession(opts ...Option) (*Session, error) {
+	o := newOptions(opts)
+
+	svc, err := net.Dial("unix", o.serviceAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not connect to rdtp service")
+	}
+
+	if err = WriteMessage(svc, newClientMessage(ClientMessageTypeMultiplex, nil, nil)); err != nil {
+		svc.Close()
+		return nil, errors.Wrap(err, "could not send multiplex request to rdtp service")
+	}
+
+	if _, err = waitForServiceMessageOK(svc); err != nil {
+		svc.Close()
+		return nil, errors.Wrap(err, "RDTP session error")
+	}
+
+	return &Session{mux: mux.NewClient(svc)}, nil
+}
+
+// Close closes the session, and with it all the
+// connections and listeners which use it
+func (s *Session) Close() error {
+	return s.mux.Close()
+}
+
+// open opens a stream to the rdtp service for a request
+func (s *Session) open() (net.Conn, error) {
+	st, err := s.mux.Open()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open stream of rdtp session")
+	}
+	return st, nil
+}
@@ -0,0 +1,174 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/network"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/adrianosela/rdtp/service"
+	"github.com/adrianosela/rdtp/socket"
+	"github.com/pkg/errors"
+)
+
+// firstDialPort is the local port of the first connection a host dials
+const firstDialPort = 1024
+
+// HostConfig is the configuration of a simulated host
+type HostConfig struct {
+	IP string // IPv4 or IPv6 address on the virtual network
+
+	// impairment of the host's link to the virtual network,
+	// applied to the packets the host sends
+	Link network.Impairment
+
+	// settings of the sockets of all of the host's connections
+	CongestionControl string
+	ReceiveBufferSize int
+
+	// Trace, if set, is written a line for each packet the host
+	// receives, stamped with the simulated time, e.g. to compare
+	// runs of the same seed
+	Trace io.Writer
+}
+
+// Host is a simulated host running the rdtp service, which
+// its connections and listeners reach over pipes in memory
+type Host struct {
+	sim     *Simulator
+	config  HostConfig
+	port    *network.SwitchPort
+	network *network.Impaired
+	service *service.Service
+	clients *pipeListener
+
+	// the local port of the next connection dialed, so that
+	// ports do not depend on the service's random picks
+	nextPort uint16
+}
+
+// AddHost attaches a host to the virtual network and starts its
+// rdtp service, which serves the address family of its IP only
+func (s *Simulator) AddHost(c HostConfig) (*Host, error) {
+	ip := net.ParseIP(c.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address", c.IP)
+	}
+	c.IP = ip.String()
+	port, err := s.sw.Attach(ip)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not attach host to virtual network")
+	}
+
+	h := &Host{
+		sim:    s,
+		config: c,
+		port:   port,
+		network: network.Impair(port, network.ImpairedConfig{
+			Outbound: c.Link,
+			Seed:     s.seed + int64(len(s.hosts)+1)*2,
+			Clock:    s.clock,
+		}),
+		clients:  newPipeListener(s.clock),
+		nextPort: firstDialPort,
+	}
+
+	config := service.Config{
+		Network:   &tracedNetwork{Impaired: h.network, host: h},
+		LocalIP:   net.IPv4zero,
+		LocalIPv6: net.IPv6unspecified,
+		Clock:     s.clock,
+		ISNKey:    s.isnKey,
+	}
+	if ip.To4() != nil {
+		config.LocalIP = ip
+	} else {
+		config.LocalIPv6 = ip
+	}
+	if h.service, err = service.New(config); err != nil {
+		port.Detach()
+		return nil, errors.Wrap(err, "could not create rdtp service")
+	}
+	s.hosts = append(s.hosts, h)
+
+	s.clock.Go(func() {
+		if err := h.service.Serve(h.clients); err != nil {
+			log.Println(errors.Wrap(err, "rdtp service failed"))
+		}
+	})
+	// hosts start one after another, as the same seed replays them
+	s.clock.Settle()
+	return h, nil
+}
+
+// close stops the host's service and detaches it from the virtual network
+func (h *Host) close() {
+	h.service.Close()
+	h.port.Detach()
+}
+
+// tracedNetwork is the network of a host, which
+// traces the packets the host receives, if asked to
+type tracedNetwork struct {
+	*network.Impaired
+	host *Host
+}
+
+// StartReceiver forwards the packets the host receives, tracing them
+func (n *tracedNetwork) StartReceiver(forward func(*packet.Packet) error) {
+	n.Impaired.StartReceiver(func(p *packet.Packet) error {
+		if n.host.config.Trace != nil {
+			n.host.trace(p)
+		}
+		return forward(p)
+	})
+}
+
+// trace writes a line describing a packet received to the host's trace
+func (h *Host) trace(p *packet.Packet) {
+	src, _ := p.GetSourceIP()
+	fmt.Fprintf(h.config.Trace, "%v %s:%d > %d flags=%08b seq=%d ack=%d win=%d len=%d\n",
+		h.sim.Elapsed(), src, p.SrcPort, p.DstPort, p.Flags, p.SeqNo, p.AckNo, p.Window, len(p.Payload))
+}
+
+// Stats returns the counters of the packets the host sent
+func (h *Host) Stats() network.ImpairmentStats {
+	return h.network.OutboundStats()
+}
+
+// States returns the state of the connection of every
+// socket of the host's rdtp service by id
+func (h *Host) States() map[string]socket.State {
+	return h.service.States()
+}
+
+// Dial returns a connection to a remote rdtp address, dialed through
+// the host's rdtp service as an application of the host would
+func (h *Host) Dial(address string) (net.Conn, error) {
+	d := rdtp.Dialer{
+		LocalAddr:         &rdtp.Addr{Port: h.nextPort},
+		DialService:       h.clients.dial,
+		Clock:             h.sim.clock,
+		CongestionControl: h.config.CongestionControl,
+		ReceiveBufferSize: h.config.ReceiveBufferSize,
+	}
+	h.nextPort++
+	return d.Dial(address)
+}
+
+// Listen returns a listener for connections on a local port,
+// listening through the host's rdtp service
+func (h *Host) Listen(port uint16) (net.Listener, error) {
+	lc := rdtp.ListenConfig{
+		DialService:       h.clients.dial,
+		Clock:             h.sim.clock,
+		CongestionControl: h.config.CongestionControl,
+		ReceiveBufferSize: h.config.ReceiveBufferSize,
+	}
+	return lc.Listen(context.Background(), net.JoinHostPort(h.config.IP, strconv.Itoa(int(port))))
+}
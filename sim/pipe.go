@@ -0,0 +1,306 @@
+package sim
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/pkg/errors"
+)
+
+// pipeBufferSize is the number of bytes a pipe holds
+// each way until they are read
+const pipeBufferSize = 64 * 1024
+
+// pipe returns the two ends of an in-memory full-duplex connection,
+// like net.Pipe, whose reads and writes wait on the simulated clock
+// rather than on the runtime, so that the simulation knows when the
+// goroutines using it are idle
+func pipe(clk *clock.Simulated) (net.Conn, net.Conn) {
+	ab, ba := newHalfPipe(clk), newHalfPipe(clk)
+	return &pipeConn{in: ba, out: ab}, &pipeConn{in: ab, out: ba}
+}
+
+// halfPipe carries bytes one way, from the writing to the reading end
+type halfPipe struct {
+	clock *clock.Simulated
+
+	mu      sync.Mutex
+	changed clock.Cond // signalled whenever any of the below changes
+	buf     []byte
+
+	// the writing end closed, after which reads return EOF
+	// once all of the bytes are read, and the reading end closed,
+	// after which writes fail
+	writeClosed bool
+	readClosed  bool
+
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+func newHalfPipe(clk *clock.Simulated) *halfPipe {
+	h := &halfPipe{clock: clk}
+	h.changed = clk.NewCond(&h.mu)
+	return h
+}
+
+// read waits for bytes to read, for the writing end to close,
+// or for the read deadline to expire
+func (h *halfPipe) read(b []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for len(h.buf) == 0 && !h.writeClosed && !h.readClosed && !h.readDeadline.expired {
+		h.changed.Wait()
+	}
+	switch {
+	case h.readClosed:
+		return 0, io.ErrClosedPipe
+	case len(h.buf) > 0:
+		n := copy(b, h.buf)
+		h.buf = h.buf[n:]
+		h.changed.Broadcast()
+		return n, nil
+	case h.writeClosed:
+		return 0, io.EOF
+	default:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// write waits for room for all of the bytes, for either end to
+// close, or for the write deadline to expire
+func (h *halfPipe) write(b []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := 0
+	for {
+		switch {
+		case h.writeClosed:
+			return n, io.ErrClosedPipe
+		case h.readClosed:
+			return n, errors.Wrap(syscall.EPIPE, "write to pipe closed for reading")
+		case h.writeDeadline.expired:
+			return n, os.ErrDeadlineExceeded
+		}
+		if n == len(b) {
+			return n, nil
+		}
+
+		room := pipeBufferSize - len(h.buf)
+		if room <= 0 {
+			h.changed.Wait()
+			continue
+		}
+		chunk := b[n:]
+		if len(chunk) > room {
+			chunk = chunk[:room]
+		}
+		h.buf = append(h.buf, chunk...)
+		n += len(chunk)
+		h.changed.Broadcast()
+	}
+}
+
+// closeWrite closes the writing end
+func (h *halfPipe) closeWrite() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeClosed = true
+	h.changed.Broadcast()
+}
+
+// closeRead closes the reading end, dropping the bytes not yet read
+func (h *halfPipe) closeRead() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readClosed = true
+	h.buf = nil
+	h.changed.Broadcast()
+}
+
+// setDeadline sets the given deadline of the half pipe to t
+func (h *halfPipe) setDeadline(d *deadline, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.generation++
+	d.expired = false
+	if t.IsZero() {
+		return
+	}
+
+	wait := t.Sub(h.clock.Now())
+	if wait <= 0 {
+		d.expired = true
+		h.changed.Broadcast()
+		return
+	}
+	generation := d.generation
+	d.timer = h.clock.AfterFunc(wait, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		// the deadline may have been set again in the meantime
+		if d.generation == generation {
+			d.expired = true
+			h.changed.Broadcast()
+		}
+	})
+}
+
+// deadline is a read or write deadline of a half pipe
+type deadline struct {
+	timer      clock.Timer
+	generation uint64 // tells the timers of successive deadlines apart
+	expired    bool
+}
+
+// pipeConn is an end of a pipe
+type pipeConn struct {
+	in  *halfPipe // read from
+	out *halfPipe // written to
+}
+
+// Read reads data from the other end
+func (c *pipeConn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+// Write writes data to the other end
+func (c *pipeConn) Write(b []byte) (int, error) {
+	return c.out.write(b)
+}
+
+// Close closes both ways
+func (c *pipeConn) Close() error {
+	c.out.closeWrite()
+	c.in.closeRead()
+	return nil
+}
+
+// CloseWrite closes the writing side, after which the
+// other end reads EOF once it has read all of the data
+func (c *pipeConn) CloseWrite() error {
+	c.out.closeWrite()
+	return nil
+}
+
+// CloseRead closes the reading side, after
+// which writes of the other end fail
+func (c *pipeConn) CloseRead() error {
+	c.in.closeRead()
+	return nil
+}
+
+// LocalAddr returns the address of the pipe
+func (c *pipeConn) LocalAddr() net.Addr {
+	return pipeAddr{}
+}
+
+// RemoteAddr returns the address of the pipe
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
+
+// SetDeadline sets the read and write deadlines, on simulated time
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline, on simulated time
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(&c.in.readDeadline, t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline, on simulated time
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.out.setDeadline(&c.out.writeDeadline, t)
+	return nil
+}
+
+// pipeListener is a listener of pipes in memory, the other
+// ends of which are returned by its dial method
+type pipeListener struct {
+	clock *clock.Simulated
+
+	mu      sync.Mutex
+	changed clock.Cond
+	pending []net.Conn // ends of pipes dialed, not yet accepted
+	closed  bool
+}
+
+func newPipeListener(clk *clock.Simulated) *pipeListener {
+	l := &pipeListener{clock: clk}
+	l.changed = clk.NewCond(&l.mu)
+	return l
+}
+
+// dial returns an end of a pipe whose other end the listener accepts
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, errors.Wrap(syscall.ECONNREFUSED, "pipe listener closed")
+	}
+	local, remote := pipe(l.clock)
+	l.pending = append(l.pending, remote)
+	l.changed.Broadcast()
+	return local, nil
+}
+
+// Accept waits for and returns the next pipe dialed
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for len(l.pending) == 0 && !l.closed {
+		l.changed.Wait()
+	}
+	if l.closed {
+		return nil, net.ErrClosed
+	}
+	c := l.pending[0]
+	l.pending[0] = nil
+	l.pending = l.pending[1:]
+	return c, nil
+}
+
+// Close stops the listener, closing the pipes not yet accepted
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, c := range l.pending {
+		c.Close()
+	}
+	l.pending = nil
+	l.closed = true
+	l.changed.Broadcast()
+	return nil
+}
+
+// Addr returns the address of the pipes
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// pipeAddr is the address of both ends of a pipe
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,118 @@
+// Package sim runs rdtp hosts over a virtual network on simulated time.
+// Time only moves on once every goroutine of the simulation is waiting
+// for it, so that minutes of transfers over lossy links run in
+// milliseconds.
+//
+// The hosts' goroutines run one at a time on the simulated clock, which
+// hands over between them in a fixed order, and all random decisions,
+// e.g. which packets are lost, derive from the seed of the simulation.
+// The same seed therefore replays the same run exactly, packet for packet
+package sim
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/adrianosela/rdtp/network"
+	"github.com/pkg/errors"
+)
+
+// epoch is the simulated time every simulation starts at
+var epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// drainTimeout is how long connections are given to close once done with
+const drainTimeout = time.Minute
+
+// Simulator is a virtual network of rdtp hosts on simulated time
+type Simulator struct {
+	clock *clock.Simulated
+	sw    *network.Switch
+	seed  int64
+	hosts []*Host
+
+	// secret of the initial sequence numbers of all connections
+	isnKey []byte
+}
+
+// New returns a simulator with no hosts, whose random
+// decisions all derive from the given seed
+func New(seed int64) *Simulator {
+	isnKey := make([]byte, 8)
+	binary.BigEndian.PutUint64(isnKey, uint64(seed))
+	clk := clock.NewSimulated(epoch)
+	sw := network.NewSwitch()
+	sw.SetClock(clk)
+	return &Simulator{
+		clock:  clk,
+		sw:     sw,
+		seed:   seed,
+		isnKey: isnKey,
+	}
+}
+
+// Close stops the rdtp services of all of the hosts
+// and detaches them from the virtual network
+func (s *Simulator) Close() {
+	for _, h := range s.hosts {
+		h.close()
+	}
+}
+
+// SetMTU sets the size of the largest IP packet the virtual network
+// carries between hosts, e.g. to shrink the path mid-transfer.
+// Zero lifts the limit
+func (s *Simulator) SetMTU(mtu int) {
+	s.sw.SetMTU(mtu)
+}
+
+// Clock returns the simulated clock
+func (s *Simulator) Clock() *clock.Simulated {
+	return s.clock
+}
+
+// Elapsed returns the simulated time elapsed since the simulation started
+func (s *Simulator) Elapsed() time.Duration {
+	return s.clock.Now().Sub(epoch)
+}
+
+// Run calls fn in a goroutine of the simulation, e.g. to dial and accept
+// connections and transfer data between hosts, moving simulated time on
+// whenever every goroutine of the simulation is waiting, until fn returns.
+// Time then runs for up to a minute more so that the connections closed by
+// fn finish closing. Like the hosts' goroutines, fn and those it starts
+// must wait on nothing but the hosts' connections and the simulated clock,
+// starting goroutines with its Go method. Run returns an error if every
+// goroutine is waiting with no timers pending before fn returns, since
+// time would stand still
+func (s *Simulator) Run(fn func()) error {
+	// only read once the goroutines settle, after fn is done with it
+	done := false
+	s.clock.Go(func() {
+		defer func() { done = true }()
+		fn()
+	})
+
+	for {
+		s.clock.Settle()
+		if done {
+			s.drain(drainTimeout)
+			return nil
+		}
+		if !s.clock.Step() {
+			return errors.New("deadlock: all goroutines are waiting and no timers are pending")
+		}
+	}
+}
+
+// drain runs the pending timers due within the given duration
+func (s *Simulator) drain(d time.Duration) {
+	until := s.clock.Now().Add(d)
+	for {
+		next, ok := s.clock.Next()
+		if !ok || next.After(until) {
+			return
+		}
+		s.clock.Step()
+	}
+}
@@ -0,0 +1,286 @@
+package sim
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/network"
+	"github.com/adrianosela/rdtp/service/ports/controller"
+	"github.com/adrianosela/rdtp/socket"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// result is the outcome of a simulated transfer
+type result struct {
+	elapsed     time.Duration
+	client      network.ImpairmentStats
+	server      network.ImpairmentStats
+	intact      bool
+	transferred int
+
+	// packets received by either host
+	clientTrace string
+	serverTrace string
+}
+
+// acceptLater accepts the next connection to a listener in a goroutine
+// of the simulation, as dials only complete once accepted, and returns
+// a function which waits for the connection accepted
+func acceptLater(s *Simulator, l net.Listener) func() (net.Conn, error) {
+	var mu sync.Mutex
+	accepted := s.Clock().NewCond(&mu)
+	var conn net.Conn
+	var err error
+	done := false
+	s.Clock().Go(func() {
+		c, e := l.Accept()
+		mu.Lock()
+		defer mu.Unlock()
+		conn, err, done = c, e, true
+		accepted.Broadcast()
+	})
+	return func() (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for !done {
+			accepted.Wait()
+		}
+		return conn, err
+	}
+}
+
+// transfer sends size bytes from one simulated host to another
+// over links with the given impairment
+func transfer(t *testing.T, seed int64, link network.Impairment, size int) result {
+	s := New(seed)
+	defer s.Close()
+	var clientTrace, serverTrace bytes.Buffer
+	client, err := s.AddHost(HostConfig{IP: "10.0.0.1", Link: link, Trace: &clientTrace})
+	assert.Nil(t, err)
+	server, err := s.AddHost(HostConfig{IP: "10.0.0.2", Link: link, Trace: &serverTrace})
+	assert.Nil(t, err)
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	got := make([]byte, size)
+
+	var r result
+	err = s.Run(func() {
+		l, err := server.Listen(80)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer l.Close()
+
+		accept := acceptLater(s, l)
+		conn, err := client.Dial("10.0.0.2:80")
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		peer, err := accept()
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer peer.Close()
+
+		s.Clock().Go(func() { conn.Write(data) })
+		r.transferred, err = io.ReadFull(peer, got)
+		assert.Nil(t, err)
+
+		// the socket still updates its window as the last bytes are
+		// read, which closing at the same instant would race with
+		s.Clock().Sleep(time.Millisecond)
+	})
+	assert.Nil(t, err)
+
+	r.elapsed = s.Elapsed()
+	r.client = client.Stats()
+	r.server = server.Stats()
+	r.intact = bytes.Equal(data, got)
+	r.clientTrace = clientTrace.String()
+	r.serverTrace = serverTrace.String()
+	return r
+}
+
+func TestSimulatorLongLossyTransfer(t *testing.T) {
+	// a slow and lossy link which takes minutes to transfer a megabyte
+	link := network.Impairment{
+		Loss:          0.02,
+		Delay:         time.Millisecond * 50,
+		Jitter:        time.Millisecond * 10,
+		Bandwidth:     2000,
+		MaxQueueDelay: time.Second,
+	}
+
+	start := time.Now()
+	r := transfer(t, 1, link, 1<<20)
+	assert.True(t, r.intact)
+	assert.True(t, r.elapsed > time.Minute*8, "took %v of simulated time", r.elapsed)
+	assert.NotZero(t, r.client.Lost)
+	t.Logf("%v of simulated time in %v", r.elapsed, time.Since(start))
+}
+
+func TestSimulatorReplay(t *testing.T) {
+	link := network.Impairment{
+		Loss:      0.05,
+		Delay:     time.Millisecond * 20,
+		Jitter:    time.Millisecond * 20,
+		Duplicate: 0.01,
+		Bandwidth: 100000,
+	}
+
+	first := transfer(t, 3, link, 1<<18)
+	assert.True(t, first.intact)
+
+	// the same seed replays the same run, packet for packet
+	second := transfer(t, 3, link, 1<<18)
+	assert.NotEmpty(t, first.clientTrace)
+	assert.Equal(t, first.clientTrace, second.clientTrace)
+	assert.Equal(t, first.serverTrace, second.serverTrace)
+	assert.Equal(t, first, second)
+
+	// and another seed does not
+	other := transfer(t, 4, link, 1<<18)
+	assert.True(t, other.intact)
+	assert.NotEqual(t, first.client, other.client)
+}
+
+func TestSimulatorDeadlock(t *testing.T) {
+	s := New(1)
+	defer s.Close()
+	server, err := s.AddHost(HostConfig{IP: "10.0.0.2"})
+	assert.Nil(t, err)
+
+	err = s.Run(func() {
+		l, err := server.Listen(80)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer l.Close()
+
+		// waits forever, as no one dials, with no timer to wake up
+		l.Accept()
+	})
+	assert.NotNil(t, err)
+}
+
+func TestSimulatorPathMTUShrinks(t *testing.T) {
+	s := New(1)
+	defer s.Close()
+	link := network.Impairment{Delay: time.Millisecond * 20}
+	client, err := s.AddHost(HostConfig{IP: "10.0.0.1", Link: link})
+	assert.Nil(t, err)
+	server, err := s.AddHost(HostConfig{IP: "10.0.0.2", Link: link})
+	assert.Nil(t, err)
+
+	data := make([]byte, 1<<18)
+	rand.New(rand.NewSource(1)).Read(data)
+	got := make([]byte, len(data))
+
+	err = s.Run(func() {
+		l, err := server.Listen(80)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer l.Close()
+
+		accept := acceptLater(s, l)
+		conn, err := client.Dial("10.0.0.2:80")
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		peer, err := accept()
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer peer.Close()
+
+		s.Clock().Go(func() { conn.Write(data) })
+		_, err = io.ReadFull(peer, got[:len(got)/2])
+		assert.Nil(t, err)
+
+		// full-size packets no longer make it across halfway through,
+		// so the sender falls back to smaller ones and searches again
+		s.SetMTU(1280)
+		_, err = io.ReadFull(peer, got[len(got)/2:])
+		assert.Nil(t, err)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestSimulatorConnectionRefused(t *testing.T) {
+	s := New(1)
+	defer s.Close()
+	link := network.Impairment{Delay: time.Millisecond * 20}
+	client, err := s.AddHost(HostConfig{IP: "10.0.0.1", Link: link})
+	assert.Nil(t, err)
+	_, err = s.AddHost(HostConfig{IP: "10.0.0.2", Link: link})
+	assert.Nil(t, err)
+
+	// nothing listens on the port, so the SYN is answered with a reset
+	// rather than waiting for the handshake to time out
+	err = s.Run(func() {
+		start := s.Clock().Now()
+		_, err := client.Dial("10.0.0.2:80")
+		assert.True(t, errors.Is(err, rdtp.ErrConnectionRefused))
+		assert.Equal(t, time.Millisecond*40, s.Clock().Now().Sub(start))
+	})
+	assert.Nil(t, err)
+}
+
+func TestSimulatorTimeWait(t *testing.T) {
+	s := New(1)
+	defer s.Close()
+	link := network.Impairment{Delay: time.Millisecond * 20}
+	client, err := s.AddHost(HostConfig{IP: "10.0.0.1", Link: link})
+	assert.Nil(t, err)
+	server, err := s.AddHost(HostConfig{IP: "10.0.0.2", Link: link})
+	assert.Nil(t, err)
+
+	err = s.Run(func() {
+		l, err := server.Listen(80)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer l.Close()
+
+		accept := acceptLater(s, l)
+		conn, err := client.Dial("10.0.0.2:80")
+		if !assert.Nil(t, err) {
+			return
+		}
+		peer, err := accept()
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// the client closes first, and the server follows
+		conn.Close()
+		_, err = peer.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+		peer.Close()
+	})
+	assert.Nil(t, err)
+
+	// the client holds the connection in TIME_WAIT once its socket is
+	// gone, for twice the maximum segment lifetime
+	assert.Equal(t, map[string]socket.State{
+		"10.0.0.1:1024 10.0.0.2:80": socket.StateTimeWait,
+	}, client.States())
+	assert.Empty(t, server.States())
+
+	s.Clock().Advance(2 * controller.MaxSegmentLifetime)
+	assert.Empty(t, client.States())
+}
@@ -0,0 +1,64 @@
+package socket
+
+import (
+	"log"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+)
+
+const (
+	// delayedAckTimeout is how long an acknowledgement is held
+	// back waiting for outbound data to piggyback on
+	delayedAckTimeout = time.Millisecond * 40
+
+	// maxDelayedSegments is the number of in-order segments
+	// after which an acknowledgement is sent without delay
+	maxDelayedSegments = 2
+)
+
+// scheduleAck acknowledges newly received in-order data, either on
+// the next outbound data packet or, if none is sent in time, on a
+// standalone acknowledgement. Must hold s.mu
+func (s *Socket) scheduleAck() {
+	s.ackPending++
+	if s.ackPending >= maxDelayedSegments {
+		s.sendAck()
+		return
+	}
+	if s.ackTimer == nil {
+		s.ackTimer = s.clock.AfterFunc(delayedAckTimeout, s.onDelayedAckTimeout)
+		return
+	}
+	s.ackTimer.Reset(delayedAckTimeout)
+}
+
+// sendAck sends a standalone acknowledgement immediately, reporting
+// any out-of-order data held in SACK blocks. Must hold s.mu
+func (s *Socket) sendAck() {
+	s.ackSent()
+	s.packetizer.SelectiveAcknowledge(s.rcv.sackBlocks(packet.MaxSACKBlocks))
+	if err := s.packetizer.SendAck(); err != nil {
+		log.Printf("[rdtp socket %s] Error sending acknowledgement: %s", s.ID(), err)
+	}
+}
+
+// ackSent clears any pending delayed acknowledgement once the current
+// acknowledgement number and window have gone out on any packet.
+// Must hold s.mu
+func (s *Socket) ackSent() {
+	s.ackPending = 0
+	s.advertised = s.rcv.window()
+	if s.ackTimer != nil {
+		s.ackTimer.Stop()
+	}
+}
+
+func (s *Socket) onDelayedAckTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ackPending > 0 && !s.closed && s.err == nil {
+		s.sendAck()
+	}
+}
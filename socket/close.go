@@ -0,0 +1,199 @@
+package socket
+
+import (
+	"log"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
+)
+
+// finWait2Timeout is how long a socket waits in FIN_WAIT_2 for a
+// remote which sends nothing, neither data nor its FIN, before
+// giving up on the connection
+const finWait2Timeout = time.Second * 60
+
+var (
+	errFinWait2Timeout   = errors.New("connection aborted: FIN_WAIT_2 timeout")
+	errApplicationClosed = errors.New("connection aborted: closed by application")
+)
+
+// closeWriter is implemented by application connections which can be
+// closed for writing only, as unix connections to rdtp clients are
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// errorCloser is implemented by application connections which can let the
+// application know why they are closed, as rdtp.ServiceConn does, so that
+// it can tell an aborted connection apart from one closed by the remote
+type errorCloser interface {
+	CloseWithError(error) error
+}
+
+// closeWrite sends the socket's FIN once the application is done
+// sending and all of its data has been acknowledged. From version 1 on
+// the socket keeps receiving until the remote sends its own FIN, while
+// remotes of version 0 close both ways at once
+func (s *Socket) closeWrite() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.packetizer.Version() == packet.Version0 {
+		s.signalShutdown()
+		return
+	}
+	if err := s.transitionLocked(eventClose); err != nil {
+		log.Printf("[rdtp socket %s] Error closing connection: %s", s.ID(), err)
+		return
+	}
+	s.finSent = true
+	s.finSeq = s.packetizer.SeqNo()
+	s.sendFIN()
+}
+
+// sendFIN sends the socket's FIN, numbered after all of its data, and
+// retransmits it until acknowledged. Must hold s.mu
+func (s *Socket) sendFIN() {
+	s.ackSent() // piggybacked
+	if err := s.packetizer.SendControlPacket(false, true, true, false); err != nil {
+		log.Printf("[rdtp socket %s] Error sending FIN: %s", s.ID(), err)
+	}
+
+	timeout := clamp(s.rto.timeout()<<uint(s.finRetries), minRTO, maxRTO)
+	if s.finTimer == nil {
+		s.finTimer = s.clock.AfterFunc(timeout, s.onFINTimeout)
+		return
+	}
+	s.finTimer.Reset(timeout)
+}
+
+// onFINTimeout retransmits an unacknowledged FIN, backing off
+// exponentially, and aborts the connection once it exceeds
+// the retransmission limit
+func (s *Socket) onFINTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.err != nil || s.finAcked {
+		return
+	}
+	if s.finRetries >= s.maxRetransmissions {
+		if err := s.packetizer.SendReset(packet.ResetAborted); err != nil {
+			log.Printf("[rdtp socket %s] Error sending reset: %s", s.ID(), err)
+		}
+		s.abortLocked(errRetransmissionLimit)
+		return
+	}
+	s.finRetries++
+	s.sendFIN()
+}
+
+// acknowledgeFIN processes the acknowledgement of the socket's FIN,
+// which takes up the sequence number after its data. Must hold s.mu
+func (s *Socket) acknowledgeFIN(p *packet.Packet) {
+	if !s.finSent || s.finAcked || !packet.SeqGT(p.AckNo, s.finSeq) || !s.sent(p.AckNo) {
+		return
+	}
+	s.finAcked = true
+	s.finTimer.Stop()
+	if err := s.transitionLocked(eventACK); err != nil {
+		log.Printf("[rdtp socket %s] Error closing connection: %s", s.ID(), err)
+		return
+	}
+	if s.state == StateFinWait2 {
+		s.armFinWait2Timer(finWait2Timeout)
+	}
+	s.shutdownIfOver()
+}
+
+// armFinWait2Timer schedules the next check of whether the
+// remote has gone silent in FIN_WAIT_2. Must hold s.mu
+func (s *Socket) armFinWait2Timer(d time.Duration) {
+	if s.finWait2Timer == nil {
+		s.finWait2Timer = s.clock.AfterFunc(d, s.onFinWait2Timeout)
+		return
+	}
+	s.finWait2Timer.Reset(d)
+}
+
+// onFinWait2Timeout aborts a connection in FIN_WAIT_2 once the remote
+// has not been heard from for finWait2Timeout, e.g. since it is gone
+// without ever sending its FIN
+func (s *Socket) onFinWait2Timeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.err != nil || s.state != StateFinWait2 {
+		return
+	}
+	if idle := s.clock.Now().Sub(s.lastHeard); idle < finWait2Timeout {
+		s.armFinWait2Timer(finWait2Timeout - idle)
+		return
+	}
+	if err := s.packetizer.SendReset(packet.ResetAborted); err != nil {
+		log.Printf("[rdtp socket %s] Error sending reset: %s", s.ID(), err)
+	}
+	s.abortLocked(errFinWait2Timeout)
+}
+
+// receiveFIN processes the remote's FIN once all of its data is in,
+// acknowledging it right away, and passes it on to the application
+// as EOF after that data. Must hold s.mu
+func (s *Socket) receiveFIN(p *packet.Packet) {
+	// retransmissions of the FIN, and FINs received ahead
+	// of some data, remind the remote of what is expected
+	if s.finReceived || p.SeqNo != s.rcv.next {
+		s.sendAck()
+		return
+	}
+	if err := s.transitionLocked(eventFIN); err != nil {
+		log.Printf("[rdtp socket %s] Error closing connection: %s", s.ID(), err)
+		return
+	}
+	s.finReceived = true
+	s.rcv.skipFIN()
+	s.packetizer.Acknowledge(s.rcv.next)
+	s.sendAck()
+	s.signalReadable()
+	s.shutdownIfOver()
+}
+
+// shutdownIfOver notifies the socket's Run loop of shutdown once
+// the connection is closed both ways. Must hold s.mu
+func (s *Socket) shutdownIfOver() {
+	if s.state == StateClosed || s.state == StateTimeWait {
+		s.signalShutdown()
+	}
+}
+
+// applicationClosed aborts the connection once the application has
+// closed its connection altogether, so data from the remote can no
+// longer be delivered. The remote is reset rather than sent
+// acknowledgements of data dropped, so that its writes fail
+func (s *Socket) applicationClosed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.err != nil {
+		return
+	}
+	log.Printf("[rdtp socket %s] Error writing to application: %s", s.ID(), err)
+	if err := s.packetizer.SendReset(packet.ResetAborted); err != nil {
+		log.Printf("[rdtp socket %s] Error sending reset: %s", s.ID(), err)
+	}
+	s.abortLocked(errApplicationClosed)
+}
+
+// deliverEOF lets the application know that the remote is done
+// sending. Application connections which cannot be closed for
+// writing only are closed altogether, which closes the socket's
+// connection in turn
+func (s *Socket) deliverEOF() {
+	if cw, ok := s.application.(closeWriter); ok {
+		if err := cw.CloseWrite(); err == nil {
+			return
+		}
+	}
+	s.application.Close()
+}
@@ -0,0 +1,194 @@
+package socket
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// newEstablishedSocket returns a socket with a connection of the latest
+// version open, the remote numbering its data from 7000 on
+func newEstablishedSocket(t *testing.T) (*Socket, *mockNetwork) {
+	return newEstablishedSocketWithConfig(t, Config{})
+}
+
+func newEstablishedSocketWithConfig(t *testing.T, c Config) (*Socket, *mockNetwork) {
+	s, nw := newTestSocketWithConfig(t, c)
+	synAck := mockControlPacket(packet.MaxVersion, true, true)
+	synAck.SetSeqNo(7000)
+	s.synchronize(synAck, packet.MaxVersion)
+	s.state = StateEstablished
+	return s, nw
+}
+
+func mockFINPacket(seq, ack uint32) *packet.Packet {
+	p := mockControlPacket(packet.MaxVersion, false, true)
+	p.SetFlagFIN()
+	p.SetSeqNo(seq)
+	p.SetAckNo(ack)
+	return p
+}
+
+func TestCloseWriteKeepsReceiving(t *testing.T) {
+	s, nw := newEstablishedSocket(t)
+
+	// the FIN is numbered after all data sent
+	s.closeWrite()
+	fin := waitSent(t, nw, 1)
+	assert.True(t, fin.IsFIN() && fin.IsACK())
+	assert.Equal(t, s.packetizer.SeqNo(), fin.SeqNo)
+	assert.Equal(t, StateFinWait1, s.State())
+
+	// acknowledgements of data alone do not acknowledge it
+	s.handle(mockAckPacket(fin.SeqNo))
+	assert.Equal(t, StateFinWait1, s.State())
+	s.handle(mockAckPacket(fin.SeqNo + 1))
+	assert.Equal(t, StateFinWait2, s.State())
+
+	// data keeps coming in until the remote's FIN
+	s.handle(mockDataPacket(7000, 10))
+	assert.Len(t, s.unread, 1)
+	assert.False(t, s.shutdown)
+
+	s.handle(mockFINPacket(7010, fin.SeqNo+1))
+	assert.Equal(t, StateTimeWait, s.State())
+	assert.True(t, s.shutdown)
+
+	// which is acknowledged right away, taking up a sequence number
+	assert.Equal(t, uint32(7011), waitSent(t, nw, nw.count()).AckNo)
+	tw, ok := s.TimeWait()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(7011), tw.NextSeqNo)
+}
+
+func TestCloseWait(t *testing.T) {
+	s, nw := newEstablishedSocket(t)
+
+	// FINs ahead of some of the remote's data are not taken yet
+	s.handle(mockFINPacket(7010, 0))
+	assert.Equal(t, StateEstablished, s.State())
+	assert.Equal(t, uint32(7000), waitSent(t, nw, 1).AckNo)
+
+	s.handle(mockDataPacket(7000, 10))
+	s.handle(mockFINPacket(7010, 0))
+	assert.Equal(t, StateCloseWait, s.State())
+	assert.True(t, s.finReceived)
+
+	// the local end closes in turn, and is done once its FIN is acknowledged
+	s.closeWrite()
+	assert.Equal(t, StateLastAck, s.State())
+	fin := waitSent(t, nw, nw.count())
+	assert.True(t, fin.IsFIN())
+	assert.Equal(t, uint32(7011), fin.AckNo)
+
+	s.handle(mockAckPacket(fin.SeqNo + 1))
+	assert.Equal(t, StateClosed, s.State())
+	assert.True(t, s.shutdown)
+	_, ok := s.TimeWait()
+	assert.False(t, ok)
+}
+
+func TestSimultaneousClose(t *testing.T) {
+	s, nw := newEstablishedSocket(t)
+
+	s.closeWrite()
+	fin := waitSent(t, nw, 1)
+
+	// the FINs cross
+	s.handle(mockFINPacket(7000, fin.SeqNo))
+	assert.Equal(t, StateClosing, s.State())
+	s.handle(mockAckPacket(fin.SeqNo + 1))
+	assert.Equal(t, StateTimeWait, s.State())
+}
+
+func TestFINRetransmission(t *testing.T) {
+	s, nw := newEstablishedSocket(t)
+	s.maxRetransmissions = 1
+
+	s.closeWrite()
+	fin := waitSent(t, nw, 1)
+
+	// unacknowledged FINs are retransmitted
+	s.onFINTimeout()
+	assert.Equal(t, fin.SeqNo, waitSent(t, nw, 2).SeqNo)
+	assert.True(t, waitSent(t, nw, 2).IsFIN())
+
+	// until the retransmission limit aborts the connection
+	s.onFINTimeout()
+	assert.True(t, waitSent(t, nw, 3).IsERR())
+	assert.Equal(t, StateClosed, s.State())
+	assert.Equal(t, errRetransmissionLimit, aborted(s))
+}
+
+// abortRecorder is an application connection
+// which records why the socket closes it
+type abortRecorder struct {
+	net.Conn
+	reason chan error
+}
+
+func (a *abortRecorder) CloseWithError(err error) error {
+	a.reason <- err
+	return a.Conn.Close()
+}
+
+func TestAbortClosesApplicationWithError(t *testing.T) {
+	s, _ := newEstablishedSocket(t)
+	app := &abortRecorder{Conn: s.application, reason: make(chan error, 1)}
+	s.application = app
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Run() }()
+
+	// giving up on the remote lets the application know why,
+	// rather than closing its connection as the remote would
+	assert.Nil(t, s.send([]byte("data")))
+	s.mu.Lock()
+	s.maxRetransmissions = 0
+	s.rtxDeadline = time.Time{}
+	s.mu.Unlock()
+	s.onRetransmissionTimeout()
+
+	assert.Equal(t, errRetransmissionLimit, <-stopped)
+	select {
+	case err := <-app.reason:
+		assert.Equal(t, errRetransmissionLimit, err)
+	default:
+		t.Fatal("application connection closed without error")
+	}
+}
+
+func TestFinWait2Timeout(t *testing.T) {
+	clk := clock.NewSimulated(time.Unix(0, 0))
+	s, nw := newEstablishedSocketWithConfig(t, Config{Clock: clk})
+
+	s.closeWrite()
+	fin := waitSent(t, nw, 1)
+	s.handle(mockAckPacket(fin.SeqNo + 1))
+	assert.Equal(t, StateFinWait2, s.State())
+
+	// remotes heard from lately are waited for
+	clk.Advance(finWait2Timeout / 2)
+	s.handle(mockAckPacket(fin.SeqNo + 1))
+	clk.Advance(finWait2Timeout / 2)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if next, ok := clk.Next(); ok && next.Equal(time.Unix(0, 0).Add(finWait2Timeout*3/2)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("FIN_WAIT_2 timer never rearmed")
+		}
+	}
+	assert.Equal(t, StateFinWait2, s.State())
+	assert.Equal(t, 1, nw.count())
+
+	// while silent ones are given up on
+	clk.Advance(finWait2Timeout / 2)
+	assert.True(t, waitSent(t, nw, 2).IsERR())
+	assert.Equal(t, StateClosed, s.State())
+	assert.Equal(t, errFinWait2Timeout, aborted(s))
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/adrianosela/rdtp/handshake"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -12,20 +14,140 @@ const (
 
 // Dial sends a SYN, waits for a SYN ACK, and sends an ACK
 func (s *Socket) Dial() error {
-	return handshake.InitiateConnection(s.inbound, handshakeResponseTimeout, s.packetizer.SendControlPacket)
+	return s.open(eventConnect, func() error {
+		return handshake.InitiateConnection(s.receiveControl, s.clock, handshakeResponseTimeout, s.sendControlPacket, s.initialize, s.synchronize, s.step)
+	})
 }
 
-// Accept sends a SYN ACK and waits for an ACK
+// Accept waits for a SYN, sends a SYN ACK and waits for an ACK
 func (s *Socket) Accept() error {
-	return handshake.AcceptConnection(s.inbound, handshakeResponseTimeout, s.packetizer.SendControlPacket)
+	return s.open(eventListen, func() error {
+		return handshake.AcceptConnection(s.receiveControl, s.clock, handshakeResponseTimeout, s.sendControlPacket, s.initialize, s.synchronize, s.step)
+	})
 }
 
-// finish manages the termination handshake
+// open opens the connection with the given handshake,
+// closing it if the handshake fails
+func (s *Socket) open(ev event, shake func() error) error {
+	if err := s.transition(ev); err != nil {
+		return errors.Wrap(err, "could not open connection")
+	}
+	err := shake()
+	if err != nil {
+		s.transition(eventAbort)
+	}
+	return err
+}
+
+// finish manages the termination handshake of version 0: the socket
+// answers the remote's FIN if it was received, and sends its own
+// otherwise. Connections of later versions are closed one way at
+// a time, and are over by the time they finish
 func (s *Socket) finish() error {
-	select {
-	case <-s.fin:
-		return handshake.AcceptDisconnection(s.inbound, handshakeResponseTimeout, s.packetizer.SendControlPacket)
-	default:
-		return handshake.InitiateDisconnection(s.inbound, handshakeResponseTimeout, s.packetizer.SendControlPacket)
+	s.mu.Lock()
+	if s.state == StateClosed || s.state == StateTimeWait {
+		s.mu.Unlock()
+		return nil
+	}
+	passive := s.state == StateCloseWait
+	err := s.transitionLocked(eventClose)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if passive {
+		err = handshake.AcceptDisconnection(s.receiveControl, s.clock, handshakeResponseTimeout, s.sendControlPacket, s.step)
+	} else {
+		err = handshake.InitiateDisconnection(s.receiveControl, s.clock, handshakeResponseTimeout, s.sendControlPacket, s.step)
+	}
+	if err != nil {
+		s.transition(eventAbort)
+	}
+	return err
+}
+
+// receiveControl waits for the next inbound packet of a handshake
+// for up to the given timeout. It returns false if none arrives
+func (s *Socket) receiveControl(timeout time.Duration) (*packet.Packet, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := false
+	timer := s.clock.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		expired = true
+		s.events.Broadcast()
+	})
+	defer timer.Stop()
+
+	for len(s.inbound) == 0 && !expired {
+		s.events.Wait()
+	}
+	return s.nextInbound()
+}
+
+// sendControlPacket sends a handshake packet, holding s.mu since
+// other goroutines may be updating the packetizer's state
+func (s *Socket) sendControlPacket(syn, ack, fin, err bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.packetizer.SendControlPacket(syn, ack, fin, err)
+}
+
+// initialize picks the initial sequence number of the connection,
+// which is zero on connections of version 0
+func (s *Socket) initialize() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var isn uint32
+	if s.packetizer.Version() != packet.Version0 {
+		isn = s.isn.ISN(s.lAddr.String(), s.rAddr.String())
+	}
+	s.setInitialSequence(isn)
+	return isn
+}
+
+// setInitialSequence numbers the data sent from the given initial
+// sequence number on. Must hold s.mu
+func (s *Socket) setInitialSequence(isn uint32) {
+	s.packetizer.Initialize(isn)
+	s.lastAck = isn
+}
+
+// synchronize adopts the protocol version negotiated with the remote
+// and its initial sequence number, and sends segments no larger than
+// either end receives
+func (s *Socket) synchronize(remote *packet.Packet, version uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.packetizer.SetVersion(version)
+
+	// remotes of version 0 number their data from zero on and
+	// expect the same, whatever the initial sequence numbers
+	if version == packet.Version0 {
+		s.setInitialSequence(0)
+	}
+	s.rcv = newReassemblyBuffer(remote.SeqNo, s.rcv.capacity)
+	s.packetizer.Acknowledge(remote.SeqNo)
+	s.synchronized = true
+
+	mss := s.localMSS
+	if remote.MSS != 0 && int(remote.MSS) < mss {
+		mss = int(remote.MSS)
+	}
+	if mss < minMSS {
+		mss = minMSS
+	}
+	s.setMSS(mss)
+
+	// remotes of version 0 do not answer path MTU probes
+	if version >= packet.Version1 {
+		s.pmtu = newPMTUSearch(baseMSS(version), mss)
 	}
 }
@@ -0,0 +1,247 @@
+package socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/handshake"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitSent waits for the nth packet sent through the network
+func waitSent(t *testing.T, nw *mockNetwork, n int) *packet.Packet {
+	for deadline := time.Now().Add(time.Second); nw.count() < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("packet %d never sent", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	return nw.sent[n-1]
+}
+
+// aborted returns why the socket's connection was aborted, if it was
+func aborted(s *Socket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func mockControlPacket(version uint8, syn, ack bool) *packet.Packet {
+	p, _ := packet.NewPacket(2, 1, nil)
+	p.Version = version
+	if syn {
+		p.SetFlagSYN()
+	}
+	if ack {
+		p.SetFlagACK()
+	}
+	return p
+}
+
+func TestDialNegotiatesVersion(t *testing.T) {
+	s, nw := newTestSocket(t, "")
+
+	go func() {
+		// the SYN offers the latest version
+		syn := waitSent(t, nw, 1)
+		assert.True(t, syn.IsSYN())
+		assert.Equal(t, packet.MaxVersion, syn.Version)
+
+		// which a version 0 remote answers in version 0
+		s.Deliver(mockControlPacket(packet.Version0, true, true))
+	}()
+
+	assert.Nil(t, s.Dial())
+	ack := waitSent(t, nw, 2)
+	assert.Equal(t, packet.Version0, ack.Version)
+}
+
+func TestAcceptNegotiatesVersion(t *testing.T) {
+	for offered, negotiated := range map[uint8]uint8{
+		packet.Version0:       packet.Version0,
+		packet.MaxVersion:     packet.MaxVersion,
+		packet.MaxVersion + 1: packet.MaxVersion,
+	} {
+		s, nw := newTestSocket(t, "")
+		s.Deliver(mockControlPacket(offered, true, false))
+
+		go func() {
+			// the SYN ACK is sent in the version negotiated
+			synAck := waitSent(t, nw, 1)
+			assert.True(t, synAck.IsSYN() && synAck.IsACK())
+			assert.Equal(t, negotiated, synAck.Version)
+
+			// and acknowledged by the remote
+			ack := mockControlPacket(negotiated, false, true)
+			ack.SetAckNo(synAck.SeqNo)
+			s.Deliver(ack)
+		}()
+
+		assert.Nil(t, s.Accept())
+		assert.Equal(t, negotiated, s.packetizer.Version())
+	}
+}
+
+func TestDialInitialSequenceNumbers(t *testing.T) {
+	s, nw := newTestSocket(t, "")
+
+	go func() {
+		syn := waitSent(t, nw, 1)
+
+		// the remote acknowledges the SYN's sequence number with its own
+		synAck := mockControlPacket(packet.MaxVersion, true, true)
+		synAck.SetSeqNo(7000)
+		synAck.SetAckNo(syn.SeqNo)
+		s.Deliver(synAck)
+	}()
+
+	assert.Nil(t, s.Dial())
+	syn, ack := waitSent(t, nw, 1), waitSent(t, nw, 2)
+	assert.Equal(t, uint32(7000), ack.AckNo)
+
+	// data is numbered from the SYN's sequence number on
+	assert.Nil(t, s.send([]byte("data")))
+	assert.Equal(t, syn.SeqNo, waitSent(t, nw, 3).SeqNo)
+}
+
+func TestSegmentsOutsideReceiveWindow(t *testing.T) {
+	s, nw := newTestSocket(t, "")
+	synAck := mockControlPacket(packet.MaxVersion, true, true)
+	synAck.SetSeqNo(7000)
+	s.synchronize(synAck, packet.MaxVersion)
+	s.state = StateEstablished
+
+	// data beyond the window is dropped and answered with the sequence number expected
+	injected := mockDataPacket(7000+defaultReceiveBufferSize, 10)
+	injected.SetFlagACK()
+	s.handle(injected)
+	assert.Empty(t, s.unread)
+	assert.Equal(t, uint32(7000), waitSent(t, nw, 1).AckNo)
+
+	// as are FINs
+	fin := mockControlPacket(packet.MaxVersion, false, false)
+	fin.SetFlagFIN()
+	fin.SetSeqNo(1000)
+	s.Deliver(fin)
+	assert.Empty(t, s.inbound)
+
+	// unlike those within it
+	s.handle(mockDataPacket(7000, 10))
+	assert.Len(t, s.unread, 1)
+	fin.SetSeqNo(7010)
+	s.Deliver(fin)
+	s.handle(s.inbound[0])
+	assert.Equal(t, StateCloseWait, s.State())
+
+	// which leave the local end sending until it closes too
+	assert.False(t, s.shutdown)
+}
+
+func TestZeroWindowProcessesAcknowledgements(t *testing.T) {
+	s, nw := newTestSocketWithConfig(t, Config{ReceiveBufferSize: 10})
+	synAck := mockControlPacket(packet.MaxVersion, true, true)
+	synAck.SetSeqNo(7000)
+	s.synchronize(synAck, packet.MaxVersion)
+	s.state = StateEstablished
+	s.sndWnd = 0xFFFF
+	assert.Nil(t, s.send([]byte("data")))
+
+	// the receive window fills up
+	s.handle(mockDataPacket(7000, 10))
+	assert.Equal(t, 0, s.rcv.window())
+
+	// so the data of the next segment is dropped, but not its
+	// acknowledgement and window
+	p := mockDataPacket(7010, 10)
+	p.SetFlagACK()
+	p.SetAckNo(s.packetizer.SeqNo())
+	p.SetWindow(0x1000)
+	s.handle(p)
+
+	assert.Len(t, s.unread, 1)
+	assert.Equal(t, uint32(7010), waitSent(t, nw, nw.count()).AckNo)
+	assert.True(t, s.rtx.empty())
+	assert.Equal(t, s.packetizer.SeqNo(), s.lastAck)
+	assert.Equal(t, 0x1000, s.sndWnd)
+}
+
+func TestVersion0Window(t *testing.T) {
+	s, _ := newTestSocket(t, "")
+	synAck := mockControlPacket(packet.Version0, true, true)
+	s.synchronize(synAck, packet.Version0)
+	s.state = StateEstablished
+	assert.Nil(t, s.send([]byte("data")))
+
+	// remotes of version 0 advertise no window, which
+	// does not stop the local end from sending
+	ack := mockControlPacket(packet.Version0, false, true)
+	ack.SetAckNo(s.packetizer.SeqNo())
+	s.handle(ack)
+	assert.True(t, s.rtx.empty())
+	assert.Equal(t, 0xFFFF, s.sndWnd)
+}
+
+func TestForgedAcknowledgements(t *testing.T) {
+	s, nw := newEstablishedSocket(t)
+	s.sndWnd = 0xFFFF
+	assert.Nil(t, s.send([]byte("data")))
+	sent := nw.count()
+	lastAck := s.lastAck
+
+	// an acknowledgement of data never sent, outside the receive window
+	forged := mockDataPacket(7000+defaultReceiveBufferSize, 10)
+	forged.SetFlagACK()
+	forged.SetAckNo(s.packetizer.SeqNo() + 100000)
+	s.handle(forged)
+
+	// is answered with the acknowledgement expected, and not processed
+	assert.Equal(t, uint32(7000), waitSent(t, nw, sent+1).AckNo)
+	assert.Equal(t, lastAck, s.lastAck)
+	assert.False(t, s.rtx.empty())
+
+	// so the data is retransmitted until the remote acknowledges it
+	s.mu.Lock()
+	s.rtxDeadline = s.clock.Now()
+	s.mu.Unlock()
+	s.onRetransmissionTimeout()
+	assert.Equal(t, "data", string(waitSent(t, nw, sent+2).Payload))
+	s.handle(mockAckPacket(s.packetizer.SeqNo()))
+	assert.True(t, s.rtx.empty())
+	assert.Equal(t, s.packetizer.SeqNo(), s.lastAck)
+}
+
+func TestResets(t *testing.T) {
+	s, nw := newTestSocket(t, "")
+	synAck := mockControlPacket(packet.MaxVersion, true, true)
+	synAck.SetSeqNo(7000)
+	s.synchronize(synAck, packet.MaxVersion)
+
+	// resets outside the window are dropped
+	rst := mockControlPacket(packet.MaxVersion, false, true)
+	rst.SetFlagERR()
+	rst.Reset = packet.ResetNoConnection
+	rst.SetSeqNo(1000)
+	s.Deliver(rst)
+	assert.Empty(t, s.inbound)
+
+	// while those within it abort the connection
+	rst.SetSeqNo(7000)
+	s.Deliver(rst)
+	s.handle(s.inbound[0])
+	assert.Equal(t, handshake.ErrConnectionReset, aborted(s))
+
+	// which sockets reset themselves when giving up on the remote
+	s, nw = newTestSocket(t, "")
+	s.synchronize(synAck, packet.MaxVersion)
+	assert.Nil(t, s.send([]byte("data")))
+	s.maxRetransmissions = 0
+	s.rtxDeadline = time.Time{}
+	s.onRetransmissionTimeout()
+	assert.Equal(t, errRetransmissionLimit, aborted(s))
+	sent := waitSent(t, nw, 2)
+	assert.True(t, sent.IsERR())
+	assert.Equal(t, packet.ResetAborted, sent.Reset)
+}
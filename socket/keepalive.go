@@ -0,0 +1,86 @@
+package socket
+
+import (
+	"log"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
+)
+
+// keepAliveProbes is the number of keepalive probes left
+// unanswered in a row after which the connection is aborted
+const keepAliveProbes = 9
+
+var errKeepAliveTimeout = errors.New("connection aborted: keepalive timeout")
+
+// startKeepAlive starts probing the remote whenever it is
+// not heard from for the keepalive period, if any is set
+func (s *Socket) startKeepAlive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keepAlive <= 0 {
+		return
+	}
+	s.lastHeard = s.clock.Now()
+	s.armKeepAliveTimer(s.keepAlive)
+}
+
+// heard records a packet received from the remote, which
+// answers any keepalive probes sent. Must hold s.mu
+func (s *Socket) heard() {
+	s.lastHeard = s.clock.Now()
+	s.keepAliveProbes = 0
+}
+
+// armKeepAliveTimer schedules the next keepalive check. Must hold s.mu
+func (s *Socket) armKeepAliveTimer(d time.Duration) {
+	if s.keepAliveTimer == nil {
+		s.keepAliveTimer = s.clock.AfterFunc(d, s.onKeepAliveTimeout)
+		return
+	}
+	s.keepAliveTimer.Reset(d)
+}
+
+// onKeepAliveTimeout probes a remote not heard from for the keepalive
+// period, once per period, with a window probe (which is always
+// answered), and aborts the connection once keepAliveProbes probes
+// in a row are left unanswered
+func (s *Socket) onKeepAliveTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.err != nil {
+		return
+	}
+
+	idle := s.clock.Now().Sub(s.lastHeard)
+	if s.keepAliveProbes == 0 && idle < s.keepAlive {
+		s.armKeepAliveTimer(s.keepAlive - idle)
+		return
+	}
+
+	// data in flight is retransmitted until acknowledged instead,
+	// and probes carry a byte the remote must have received
+	if !s.rtx.empty() {
+		s.keepAliveProbes = 0
+		s.armKeepAliveTimer(s.keepAlive)
+		return
+	}
+
+	if s.keepAliveProbes >= keepAliveProbes {
+		// let the remote know, should it still be reachable
+		if err := s.packetizer.SendReset(packet.ResetAborted); err != nil {
+			log.Printf("[rdtp socket %s] Error sending reset: %s", s.ID(), err)
+		}
+		s.abortLocked(errKeepAliveTimeout)
+		return
+	}
+
+	if err := s.packetizer.SendProbe(); err != nil {
+		log.Printf("[rdtp socket %s] Error sending keepalive probe: %s", s.ID(), err)
+	}
+	s.keepAliveProbes++
+	s.armKeepAliveTimer(s.keepAlive)
+}
@@ -0,0 +1,43 @@
+package socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepAlive(t *testing.T) {
+	clk := clock.NewSimulated(time.Unix(0, 0))
+	s, nw := newTestSocketWithConfig(t, Config{Clock: clk, KeepAlive: time.Minute})
+	s.state = StateEstablished
+
+	// remotes heard from within the keepalive period are not probed
+	s.lastHeard = clk.Now().Add(-time.Second * 30)
+	s.onKeepAliveTimeout()
+	assert.Equal(t, 0, nw.count())
+
+	// while silent ones are, with a byte they already received
+	s.lastHeard = clk.Now().Add(-time.Minute)
+	s.onKeepAliveTimeout()
+	probe := waitSent(t, nw, 1)
+	assert.Equal(t, s.packetizer.SeqNo()-1, probe.SeqNo)
+	assert.Len(t, probe.Payload, 1)
+
+	// answering keeps the connection alive
+	s.handle(mockAckPacket(s.packetizer.SeqNo()))
+	assert.Equal(t, 0, s.keepAliveProbes)
+	assert.Equal(t, StateEstablished, s.State())
+
+	// and leaving all probes unanswered aborts it
+	s.lastHeard = clk.Now().Add(-time.Minute)
+	for i := 0; i < keepAliveProbes; i++ {
+		s.onKeepAliveTimeout()
+	}
+	assert.False(t, waitSent(t, nw, 1+keepAliveProbes).IsERR())
+	s.onKeepAliveTimeout()
+	assert.True(t, waitSent(t, nw, 2+keepAliveProbes).IsERR())
+	assert.Equal(t, StateClosed, s.State())
+	assert.Equal(t, errKeepAliveTimeout, aborted(s))
+}
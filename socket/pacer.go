@@ -0,0 +1,73 @@
+package socket
+
+import "time"
+
+const (
+	// pacingBurstSegments is the number of full size segments
+	// which may be sent back-to-back after an idle period
+	pacingBurstSegments = 2
+
+	// pacingGain scales the window over the round trip time when the
+	// congestion controller does not set a pacing rate of its own, so
+	// that pacing does not keep the window from being filled
+	pacingGain = 1.2
+)
+
+// pacer is a token bucket which spaces packets out at a rate. Tokens
+// accumulate at the rate up to a small burst, so an idle socket can only
+// send a few packets back-to-back before it must wait for more tokens
+type pacer struct {
+	rate   float64   // bytes per second, zero if unpaced
+	tokens float64   // bytes which may be sent right away
+	last   time.Time // last time tokens were added
+}
+
+// setRate changes the pacing rate from now on
+func (p *pacer) setRate(now time.Time, rate float64, segment int) {
+	p.refill(now, segment)
+	p.rate = rate
+}
+
+// refill adds the tokens accumulated since the last refill
+func (p *pacer) refill(now time.Time, segment int) {
+	if p.last.IsZero() {
+		p.tokens = p.burst(segment) // start with a full bucket
+	} else {
+		p.tokens += p.rate * now.Sub(p.last).Seconds()
+	}
+	p.last = now
+	if burst := p.burst(segment); p.tokens > burst {
+		p.tokens = burst
+	}
+}
+
+// burst returns the bucket's size: a few segments, or as many as go out
+// in a tick of the pacing wheel at high rates, so that the wheel's
+// granularity does not cap the rate
+func (p *pacer) burst(segment int) float64 {
+	burst := float64(pacingBurstSegments * segment)
+	if perTick := p.rate * pacingWheel.tick.Seconds(); perTick > burst {
+		burst = perTick
+	}
+	return burst
+}
+
+// wait returns how long until size bytes may be sent, zero if right away
+func (p *pacer) wait(now time.Time, size, segment int) time.Duration {
+	if p.rate <= 0 {
+		return 0
+	}
+	p.refill(now, segment)
+	missing := float64(size) - p.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / p.rate * float64(time.Second))
+}
+
+// spend takes the tokens for bytes sent
+func (p *pacer) spend(size int) {
+	if p.rate > 0 {
+		p.tokens -= float64(size)
+	}
+}
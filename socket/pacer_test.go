@@ -0,0 +1,67 @@
+package socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacerUnpaced(t *testing.T) {
+	var p pacer
+	now := time.Unix(0, 0)
+	p.setRate(now, 0, 1000)
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, time.Duration(0), p.wait(now, 1000, 1000))
+		p.spend(1000)
+	}
+}
+
+func TestPacerSpacesPackets(t *testing.T) {
+	var p pacer
+	now := time.Unix(0, 0)
+
+	// 100 segments per second
+	p.setRate(now, 100*1000, 1000)
+
+	// a burst goes out right away
+	for i := 0; i < pacingBurstSegments; i++ {
+		assert.Equal(t, time.Duration(0), p.wait(now, 1000, 1000))
+		p.spend(1000)
+	}
+
+	// and then a segment every 10ms
+	assert.Equal(t, 10*time.Millisecond, p.wait(now, 1000, 1000))
+	now = now.Add(4 * time.Millisecond)
+	assert.Equal(t, 6*time.Millisecond, p.wait(now, 1000, 1000))
+	now = now.Add(6 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), p.wait(now, 1000, 1000))
+	p.spend(1000)
+
+	// smaller packets wait less
+	assert.Equal(t, 5*time.Millisecond, p.wait(now, 500, 1000))
+}
+
+func TestPacerIdleBurst(t *testing.T) {
+	var p pacer
+	now := time.Unix(0, 0)
+	p.setRate(now, 100*1000, 1000)
+
+	// tokens do not accumulate beyond a burst while idle
+	now = now.Add(time.Minute)
+	for i := 0; i < pacingBurstSegments; i++ {
+		assert.Equal(t, time.Duration(0), p.wait(now, 1000, 1000))
+		p.spend(1000)
+	}
+	assert.Equal(t, 10*time.Millisecond, p.wait(now, 1000, 1000))
+}
+
+func TestPacerHighRateBurst(t *testing.T) {
+	var p pacer
+
+	// at 100MB/s a tick of the wheel is worth 100 segments,
+	// which may all go out at once
+	p.setRate(time.Unix(0, 0), 100e6, 1000)
+	assert.InDelta(t, 100*1000, p.burst(1000), 0.001)
+}
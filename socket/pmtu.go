@@ -0,0 +1,223 @@
+package socket
+
+import (
+	"log"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+)
+
+const (
+	// minMSS is the smallest maximum segment size sent at, whatever
+	// the remote advertises (that of IPv4's minimum MTU, RFC 1122)
+	minMSS = 536
+
+	// pmtuBasePacketSize is the size of the largest rdtp packet assumed
+	// to make it across any path, BASE_PLPMTU in RFC 8899. Along with
+	// an IPv6 header it fits within IPv6's minimum MTU of 1280 bytes
+	pmtuBasePacketSize = 1200
+
+	// pmtuMaxProbes is the number of probes of a size which go
+	// unacknowledged before the path is deemed not to take that
+	// size, MAX_PROBES in RFC 8899
+	pmtuMaxProbes = 3
+
+	// pmtuSearchGranularity is the gap in bytes between the MSS
+	// known to make it across and the smallest known not to,
+	// below which searching stops
+	pmtuSearchGranularity = 16
+
+	// pmtuRaiseTimeout is the time after the search completes at which
+	// it starts over, in case the path now takes larger packets,
+	// PMTU_RAISE_TIMER in RFC 8899
+	pmtuRaiseTimeout = time.Minute * 10
+
+	// pmtuBlackHoleTimeouts is the number of consecutive retransmission
+	// timeouts of a segment larger than the base after which the path
+	// is suspected to no longer take packets of its size
+	pmtuBlackHoleTimeouts = 2
+)
+
+// pmtuSearch is the state of the packetization layer path MTU
+// discovery of a connection (RFC 8899), in terms of the MSS
+type pmtuSearch struct {
+	base    int // MSS assumed to make it across any path
+	max     int // largest MSS either end takes
+	current int // largest MSS known to make it across
+	ceiling int // largest MSS not known to fail to
+
+	// MSS of the probe in flight (zero if none), and the
+	// number of probes of that size sent so far
+	probe  int
+	probes int
+}
+
+func newPMTUSearch(base, max int) *pmtuSearch {
+	if base > max {
+		base = max
+	}
+	return &pmtuSearch{
+		base:    base,
+		max:     max,
+		current: base,
+		ceiling: max,
+	}
+}
+
+// candidate returns the MSS to probe for next, or zero once the search
+// is complete. The largest MSS is tried first since most paths take it,
+// and the range left is halved on every probe from then on
+func (m *pmtuSearch) candidate() int {
+	if m.ceiling <= m.current {
+		return 0
+	}
+	if m.ceiling == m.max {
+		return m.max
+	}
+	if m.ceiling-m.current < pmtuSearchGranularity {
+		return 0
+	}
+	return m.current + (m.ceiling-m.current+1)/2
+}
+
+// sent records a probe of the given MSS
+func (m *pmtuSearch) sent(size int) {
+	if size != m.probe {
+		m.probe, m.probes = size, 0
+	}
+	m.probes++
+}
+
+// confirm records the acknowledgement of a probe. It returns
+// false if the probe is not the one in flight
+func (m *pmtuSearch) confirm(size int) bool {
+	if size == 0 || size != m.probe {
+		return false
+	}
+	m.current = size
+	m.probe, m.probes = 0, 0
+	return true
+}
+
+// lost records the loss of the probe in flight. The path is
+// deemed not to take its size once pmtuMaxProbes are lost
+func (m *pmtuSearch) lost() {
+	if m.probes < pmtuMaxProbes {
+		return
+	}
+	m.ceiling = m.probe - 1
+	m.probe, m.probes = 0, 0
+}
+
+// raise starts the search over from the MSS known to make it across
+func (m *pmtuSearch) raise() {
+	m.ceiling = m.max
+	m.probe, m.probes = 0, 0
+}
+
+// blackHole falls back to the base MSS and starts the search over,
+// since the path no longer takes the MSS which used to make it across
+func (m *pmtuSearch) blackHole() {
+	m.current = m.base
+	m.raise()
+}
+
+// baseMSS returns the MSS of the packets of pmtuBasePacketSize
+// bytes in the given version of the protocol
+func baseMSS(version uint8) int {
+	fixed := packet.Packet{Version: version}
+	return pmtuBasePacketSize - fixed.HeaderSize()
+}
+
+// segmentMSS returns the MSS a packet takes up: its size
+// beyond the fixed part of the header of its version
+func segmentMSS(p *packet.Packet) int {
+	fixed := packet.Packet{Version: p.Version}
+	return p.HeaderSize() + len(p.Payload) - fixed.HeaderSize()
+}
+
+// startPMTUDiscovery sends data at the base MSS while searching for
+// the largest MSS the path takes, if the remote answers probes.
+// Must hold s.mu
+func (s *Socket) startPMTUDiscovery() {
+	if s.pmtu == nil {
+		return
+	}
+	s.setMSS(s.pmtu.current)
+	s.probePMTU()
+}
+
+// probePMTU sends a probe for the next candidate MSS or, once the
+// search is complete, waits to start it over. Must hold s.mu
+func (s *Socket) probePMTU() {
+	size := s.pmtu.candidate()
+	if size == 0 {
+		s.armPMTUTimer(pmtuRaiseTimeout)
+		return
+	}
+	s.pmtu.sent(size)
+	if err := s.packetizer.SendPMTUProbe(size); err != nil {
+		log.Printf("[rdtp socket %s] Error probing path MTU: %s", s.ID(), err)
+	}
+	s.armPMTUTimer(s.rto.timeout())
+}
+
+// onPMTUProbeAck adopts the MSS of an acknowledged probe
+// and probes for the next candidate. Must hold s.mu
+func (s *Socket) onPMTUProbeAck(size int) {
+	if s.pmtu == nil || !s.pmtu.confirm(size) {
+		return
+	}
+	s.setMSS(size)
+	s.probePMTU()
+}
+
+// onPMTUTimeout re-sends or gives up on an unacknowledged
+// probe, or starts the search over once it was complete
+func (s *Socket) onPMTUTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.err != nil {
+		return
+	}
+	if s.pmtu.probe != 0 {
+		s.pmtu.lost()
+	} else {
+		s.pmtu.raise()
+	}
+	s.probePMTU()
+}
+
+// detectBlackHole falls back to the base MSS if a segment of the current
+// MSS, which is larger than the base, keeps timing out, as happens when
+// the path MTU shrinks. Segments larger than the MSS are split as they
+// are retransmitted, so they tell nothing of the path. Must hold s.mu
+func (s *Socket) detectBlackHole(seg *segment) {
+	if s.pmtu == nil || seg.retries+1 < pmtuBlackHoleTimeouts {
+		return
+	}
+	if size := segmentMSS(seg.pck); size <= s.pmtu.base || size > s.pmtu.current {
+		return
+	}
+	log.Printf("[rdtp socket %s] Segment %d of %d bytes keeps timing out, falling back to an MSS of %d", s.ID(), seg.pck.SeqNo, len(seg.pck.Payload), s.pmtu.base)
+	s.pmtu.blackHole()
+	s.setMSS(s.pmtu.current)
+	s.probePMTU()
+}
+
+// setMSS sets the largest payload of the segments sent, which
+// the congestion window is counted in. Must hold s.mu
+func (s *Socket) setMSS(mss int) {
+	s.packetizer.SetMSS(mss)
+	s.cc.SetMSS(mss)
+}
+
+// armPMTUTimer schedules the next step of the path MTU search. Must hold s.mu
+func (s *Socket) armPMTUTimer(d time.Duration) {
+	if s.pmtuTimer == nil {
+		s.pmtuTimer = s.clock.AfterFunc(d, s.onPMTUTimeout)
+		return
+	}
+	s.pmtuTimer.Reset(d)
+}
@@ -0,0 +1,145 @@
+package socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/network"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPMTUSearch(t *testing.T) {
+	m := newPMTUSearch(baseMSS(packet.Version1), packet.MaxPayloadBytes)
+	assert.Equal(t, m.base, m.current)
+
+	// a path which takes segments of up to 1300 bytes
+	probes := 0
+	for size := m.candidate(); size != 0; size = m.candidate() {
+		m.sent(size)
+		probes++
+		if size <= 1300 {
+			assert.True(t, m.confirm(size))
+		} else {
+			m.lost()
+		}
+	}
+	assert.LessOrEqual(t, m.current, 1300)
+	assert.Greater(t, m.current, 1300-pmtuSearchGranularity)
+	assert.Less(t, probes, 30)
+
+	// the search starts over with the largest MSS
+	m.raise()
+	assert.Equal(t, packet.MaxPayloadBytes, m.candidate())
+	assert.False(t, m.confirm(1000))
+
+	m.blackHole()
+	assert.Equal(t, m.base, m.current)
+	assert.Equal(t, packet.MaxPayloadBytes, m.candidate())
+}
+
+func TestPMTUDiscovery(t *testing.T) {
+	s, nw := newTestSocket(t, "")
+
+	// SYNs advertise what fits in an IP packet of the default MTU
+	assert.Nil(t, s.sendControlPacket(true, false, false, false))
+	localMSS := network.DefaultMTU - network.IPv4HeaderBytes - packet.ExtendedHeaderByteSize
+	assert.Equal(t, uint16(localMSS), nw.sent[0].MSS)
+
+	// segments are no larger than the remote receives,
+	// and the congestion window is counted in them
+	segments := s.cc.Window() / packet.MaxPayloadBytes
+	synAck := mockControlPacket(packet.Version1, true, true)
+	synAck.MSS = 1300
+	s.synchronize(synAck, packet.Version1)
+	assert.Equal(t, 1300, s.packetizer.MSS())
+	assert.Equal(t, segments*1300, s.cc.Window())
+
+	// data goes out at the base MSS while probing the largest
+	s.mu.Lock()
+	s.startPMTUDiscovery()
+	s.mu.Unlock()
+	assert.Equal(t, baseMSS(packet.Version1), s.packetizer.MSS())
+	assert.Equal(t, segments*baseMSS(packet.Version1), s.cc.Window())
+	probe := nw.sent[1]
+	assert.Equal(t, uint16(1300), probe.PMTUProbe)
+	assert.Equal(t, packet.ExtendedHeaderByteSize+1300, len(probe.Serialize()))
+	assert.True(t, s.rtx.empty())
+
+	// which is adopted once acknowledged
+	ack := mockAckPacket(0)
+	ack.PMTUProbeAck = 1300
+	s.handle(ack)
+	assert.Equal(t, 1300, s.packetizer.MSS())
+	assert.Equal(t, segments*1300, s.cc.Window())
+	assert.Zero(t, s.pmtu.candidate())
+
+	// probes from the remote are acknowledged, and their padding dropped
+	remoteProbe := mockAckPacket(0)
+	remoteProbe.PMTUProbe = 1200
+	remoteProbe.Payload = make([]byte, 1000)
+	s.handle(remoteProbe)
+	assert.Equal(t, uint16(1200), nw.sent[2].PMTUProbeAck)
+	assert.Empty(t, s.unread)
+}
+
+func TestBlackHoleRescalesCongestionWindow(t *testing.T) {
+	s, _ := newTestSocket(t, "")
+	synAck := mockControlPacket(packet.Version1, true, true)
+	synAck.SetSeqNo(7000)
+	synAck.MSS = 1300
+	s.synchronize(synAck, packet.Version1)
+	s.state = StateEstablished
+	s.sndWnd = 0xFFFF
+
+	s.mu.Lock()
+	s.startPMTUDiscovery()
+	s.mu.Unlock()
+	ack := mockAckPacket(s.packetizer.SeqNo())
+	ack.PMTUProbeAck = 1300
+	s.handle(ack)
+	assert.Equal(t, 1300, s.packetizer.MSS())
+
+	// a segment of the MSS discovered keeps timing out
+	assert.Nil(t, s.send(make([]byte, s.packetizer.ChunkSize())))
+	for i := 0; i < pmtuBlackHoleTimeouts; i++ {
+		s.mu.Lock()
+		s.rtxDeadline = time.Time{}
+		s.mu.Unlock()
+		s.onRetransmissionTimeout()
+	}
+
+	// so the MSS falls back to the base, and the congestion window,
+	// collapsed to a segment by the timeouts, is one of the base MSS
+	// rather than one of the larger MSS the path no longer takes
+	assert.Equal(t, s.pmtu.base, s.packetizer.MSS())
+	assert.Equal(t, s.pmtu.base, s.cc.Window())
+}
+
+func TestRetransmitSplitsSegments(t *testing.T) {
+	s, nw := newTestSocket(t, "")
+
+	assert.Nil(t, s.send(make([]byte, packet.MaxPayloadBytes)))
+	assert.Equal(t, 1, nw.count())
+
+	// a segment sent before the MSS shrank is retransmitted in pieces
+	s.mu.Lock()
+	s.setMSS(600)
+	seg, _ := s.rtx.oldest()
+	s.retransmit(seg, s.clock.Now())
+	s.mu.Unlock()
+
+	assert.Equal(t, 4, nw.count())
+	assert.Len(t, s.rtx.order, 3)
+	assert.Equal(t, packet.MaxPayloadBytes, s.rtx.inFlight)
+	for _, p := range nw.sent[1:] {
+		assert.LessOrEqual(t, len(p.Payload), s.packetizer.ChunkSize())
+	}
+
+	// and acknowledged as such
+	s.mu.Lock()
+	acked, _, _ := s.rtx.ack(nw.sent[2].SeqNo+uint32(len(nw.sent[2].Payload)), s.clock.Now())
+	s.mu.Unlock()
+	assert.Equal(t, len(nw.sent[1].Payload)+len(nw.sent[2].Payload), acked)
+	assert.Len(t, s.rtx.order, 1)
+}
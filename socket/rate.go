@@ -0,0 +1,94 @@
+package socket
+
+import "time"
+
+// rateSample is a measurement of the rate at which data was delivered
+// to the remote socket, taken when an acknowledgement arrives
+type rateSample struct {
+	delivered      int           // bytes delivered over the interval
+	interval       time.Duration // time over which they were delivered
+	priorDelivered int           // bytes delivered when the latest segment acknowledged was sent
+	appLimited     bool          // whether the application left the network idle
+}
+
+// rate returns the delivery rate in bytes per second
+func (rs rateSample) rate() float64 {
+	return float64(rs.delivered) / rs.interval.Seconds()
+}
+
+// rateSampler estimates the delivery rate of a connection by recording,
+// for each segment sent, how much had been delivered at the time. Once
+// the segment is delivered, the bytes delivered in between over the
+// time elapsed give the rate (draft-cheng-iccrg-delivery-rate-estimation)
+type rateSampler struct {
+	delivered     int       // bytes delivered so far
+	deliveredTime time.Time // when delivered was last updated
+	firstSent     time.Time // send time of the segment starting the current interval
+	appLimited    int       // delivered count up to which samples are app-limited, zero if none
+
+	// the sample being built from the segments an
+	// acknowledgement delivers, keeping the latest sent
+	pending     bool
+	sample      rateSample
+	priorTime   time.Time
+	sendElapsed time.Duration
+}
+
+// sent records the delivery state on a segment as it is (re)transmitted
+func (r *rateSampler) sent(seg *segment, now time.Time, pipe int) {
+	if pipe == 0 {
+		r.firstSent = now
+		r.deliveredTime = now
+	}
+	seg.delivered = r.delivered
+	seg.deliveredTime = r.deliveredTime
+	seg.firstSent = r.firstSent
+	seg.appLimited = r.appLimited != 0
+}
+
+// onDelivered accounts for a segment acknowledged cumulatively or selectively
+func (r *rateSampler) onDelivered(seg *segment, now time.Time) {
+	r.delivered += len(seg.pck.Payload)
+	r.deliveredTime = now
+
+	if r.pending && seg.delivered < r.sample.priorDelivered {
+		return
+	}
+	r.pending = true
+	r.sample.priorDelivered = seg.delivered
+	r.sample.appLimited = seg.appLimited
+	r.priorTime = seg.deliveredTime
+	r.sendElapsed = seg.sent.Sub(seg.firstSent)
+	r.firstSent = seg.sent
+}
+
+// take returns the sample built from the segments delivered since the
+// last call. The interval is the longer of the send and acknowledgement
+// intervals, since either may be compressed by queueing
+func (r *rateSampler) take() (rateSample, bool) {
+	if !r.pending {
+		return rateSample{}, false
+	}
+	r.pending = false
+
+	if r.appLimited != 0 && r.delivered > r.appLimited {
+		r.appLimited = 0
+	}
+
+	rs := r.sample
+	rs.delivered = r.delivered - rs.priorDelivered
+	rs.interval = r.deliveredTime.Sub(r.priorTime)
+	if r.sendElapsed > rs.interval {
+		rs.interval = r.sendElapsed
+	}
+	return rs, rs.interval > 0
+}
+
+// markAppLimited flags the samples of the data in flight as limited by
+// the application, which did not have enough data to fill the window
+func (r *rateSampler) markAppLimited(inFlight int) {
+	r.appLimited = r.delivered + inFlight
+	if r.appLimited == 0 {
+		r.appLimited = 1
+	}
+}
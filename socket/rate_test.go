@@ -0,0 +1,88 @@
+package socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateSampler(t *testing.T) {
+	q := newRetransmissionQueue()
+	start := time.Unix(0, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	_, ok := q.rate.take()
+	assert.False(t, ok)
+
+	for i := 0; i < 4; i++ {
+		q.push(mockDataPacket(uint32(i*10), 10), at(i))
+	}
+
+	// the acknowledgement interval is longer than the send interval
+	q.ack(20, at(10))
+	rs, ok := q.rate.take()
+	assert.True(t, ok)
+	assert.Equal(t, 20, rs.delivered)
+	assert.Equal(t, 0, rs.priorDelivered)
+	assert.Equal(t, 10*time.Millisecond, rs.interval)
+	assert.InDelta(t, 2000, rs.rate(), 0.001)
+
+	// nothing new delivered
+	_, ok = q.rate.take()
+	assert.False(t, ok)
+
+	// selectively acknowledged segments count as delivered
+	q.sack([]packet.SACKBlock{{Start: 30, End: 40}}, at(12))
+	rs, ok = q.rate.take()
+	assert.True(t, ok)
+	assert.Equal(t, 30, rs.delivered)
+	assert.Equal(t, 12*time.Millisecond, rs.interval)
+	assert.Equal(t, 30, q.rate.delivered)
+
+	// and are not counted again once cumulatively acknowledged
+	q.ack(40, at(13))
+	assert.Equal(t, 40, q.rate.delivered)
+}
+
+func TestRateSamplerSendInterval(t *testing.T) {
+	q := newRetransmissionQueue()
+	start := time.Unix(0, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	// segments sent slowly but acknowledged in a burst are
+	// delivered no faster than they were sent
+	q.push(mockDataPacket(0, 10), at(0))
+	q.push(mockDataPacket(10, 10), at(1))
+	q.ack(10, at(5))
+	q.rate.take()
+	q.push(mockDataPacket(20, 10), at(20))
+	q.ack(30, at(22))
+	rs, ok := q.rate.take()
+	assert.True(t, ok)
+	assert.Equal(t, 20, rs.delivered)
+	assert.Equal(t, 10, rs.priorDelivered)
+	assert.Equal(t, 20*time.Millisecond, rs.interval)
+}
+
+func TestRateSamplerAppLimited(t *testing.T) {
+	q := newRetransmissionQueue()
+	now := time.Unix(0, 0)
+
+	q.rate.markAppLimited(q.inFlight)
+	q.push(mockDataPacket(0, 10), now)
+	q.push(mockDataPacket(10, 10), now)
+
+	q.ack(10, now.Add(time.Millisecond))
+	rs, ok := q.rate.take()
+	assert.True(t, ok)
+	assert.True(t, rs.appLimited)
+
+	// data sent once the marked data is delivered is not app-limited
+	q.push(mockDataPacket(20, 10), now.Add(time.Millisecond))
+	q.ack(30, now.Add(2*time.Millisecond))
+	rs, ok = q.rate.take()
+	assert.True(t, ok)
+	assert.False(t, rs.appLimited)
+}
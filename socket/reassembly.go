@@ -0,0 +1,190 @@
+package socket
+
+import "github.com/adrianosela/rdtp/packet"
+
+// fragment is a contiguous run of out-of-order bytes
+type fragment struct {
+	seq  uint32
+	data []byte
+}
+
+// reassemblyBuffer puts received segments back in order. It holds
+// out-of-order bytes until the gap before them is filled and only
+// ever releases contiguous bytes, starting at the next sequence
+// number expected. Released bytes count against the buffer's
+// capacity until they are consumed by the application, and
+// bytes beyond the buffer's capacity are dropped
+type reassemblyBuffer struct {
+	next      uint32      // next in-order sequence number expected
+	fragments []*fragment // out-of-order bytes sorted by sequence number
+	buffered  int         // out-of-order bytes held
+	unread    int         // released bytes not yet consumed
+	capacity  int         // maximum bytes held
+	latest    uint32      // start of the most recent out-of-order segment
+}
+
+func newReassemblyBuffer(next uint32, capacity int) *reassemblyBuffer {
+	return &reassemblyBuffer{next: next, capacity: capacity}
+}
+
+// offset returns the position of a sequence number relative
+// to the next in-order sequence number expected
+func (r *reassemblyBuffer) offset(seq uint32) int {
+	return int(int32(seq - r.next))
+}
+
+// skipFIN moves past the remote's FIN, which follows
+// its data and takes up a sequence number of its own
+func (r *reassemblyBuffer) skipFIN() {
+	r.next++
+}
+
+// insert adds a segment to the buffer, trimming any bytes that were
+// already received or fall beyond the buffer's capacity. It returns
+// the contiguous bytes which are now ready to be passed on in order
+func (r *reassemblyBuffer) insert(seq uint32, data []byte) []byte {
+	start := r.offset(seq)
+	end := start + len(data)
+
+	// trim bytes which were already released
+	if end <= 0 {
+		return nil
+	}
+	if start < 0 {
+		data, start = data[-start:], 0
+	}
+
+	// trim bytes beyond the memory budget
+	limit := r.capacity - r.unread
+	if start >= limit {
+		return nil
+	}
+	if end > limit {
+		data, end = data[:limit-start], limit
+	}
+
+	// fast path for in-order segments with nothing held
+	if start == 0 && len(r.fragments) == 0 {
+		r.next += uint32(len(data))
+		r.unread += len(data)
+		return data
+	}
+
+	// keep only the bytes not already held, so that
+	// fragments in the buffer never overlap
+	var pieces []*fragment
+	cur := start
+	for _, f := range r.fragments {
+		fStart := r.offset(f.seq)
+		fEnd := fStart + len(f.data)
+		if fEnd <= cur {
+			continue
+		}
+		if fStart >= end {
+			break
+		}
+		if fStart > cur {
+			pieces = append(pieces, r.newFragment(data, start, cur, fStart))
+		}
+		cur = fEnd
+	}
+	if cur < end {
+		pieces = append(pieces, r.newFragment(data, start, cur, end))
+	}
+	for _, p := range pieces {
+		r.add(p)
+	}
+	r.latest = r.next + uint32(start)
+
+	return r.release()
+}
+
+// newFragment copies bytes [from, to) of a segment starting at offset start
+func (r *reassemblyBuffer) newFragment(data []byte, start, from, to int) *fragment {
+	buf := make([]byte, to-from)
+	copy(buf, data[from-start:to-start])
+	return &fragment{seq: r.next + uint32(from), data: buf}
+}
+
+// add inserts a fragment keeping the fragments sorted
+func (r *reassemblyBuffer) add(f *fragment) {
+	i := len(r.fragments)
+	for i > 0 && r.offset(r.fragments[i-1].seq) > r.offset(f.seq) {
+		i--
+	}
+	r.fragments = append(r.fragments, nil)
+	copy(r.fragments[i+1:], r.fragments[i:])
+	r.fragments[i] = f
+	r.buffered += len(f.data)
+}
+
+// release pops all fragments contiguous with the next sequence number
+func (r *reassemblyBuffer) release() []byte {
+	var ready []byte
+	for len(r.fragments) > 0 && r.fragments[0].seq == r.next {
+		f := r.fragments[0]
+		ready = append(ready, f.data...)
+		r.next += uint32(len(f.data))
+		r.buffered -= len(f.data)
+		r.fragments = r.fragments[1:]
+	}
+	r.unread += len(ready)
+	return ready
+}
+
+// consume frees space taken by released bytes
+// once they have been read by the application
+func (r *reassemblyBuffer) consume(n int) {
+	r.unread -= n
+}
+
+// window returns the number of bytes the buffer can still take
+// in beyond the next in-order sequence number expected
+func (r *reassemblyBuffer) window() int {
+	return r.capacity - r.unread
+}
+
+// acceptable returns true if any of the bytes of a segment fall
+// within the receive window, as in RFC 793. No segment with
+// bytes is acceptable while the window is closed
+func (r *reassemblyBuffer) acceptable(seq uint32, length int) bool {
+	start, wnd := r.offset(seq), r.window()
+	return wnd > 0 && start+length > 0 && start < wnd
+}
+
+// hasGaps returns true if there are out-of-order bytes held
+func (r *reassemblyBuffer) hasGaps() bool {
+	return len(r.fragments) > 0
+}
+
+// sackBlocks describes up to max runs of out-of-order bytes held. As per
+// RFC 2018 the first block holds the most recent out-of-order segment so
+// that the sender learns about every block eventually, and the rest follow
+// from the highest sequence number down
+func (r *reassemblyBuffer) sackBlocks(max int) []packet.SACKBlock {
+	var runs []packet.SACKBlock
+	for _, f := range r.fragments {
+		end := f.seq + uint32(len(f.data))
+		if n := len(runs); n > 0 && runs[n-1].End == f.seq {
+			runs[n-1].End = end
+			continue
+		}
+		runs = append(runs, packet.SACKBlock{Start: f.seq, End: end})
+	}
+
+	var blocks []packet.SACKBlock
+	for i, run := range runs {
+		if packet.SeqGEQ(r.latest, run.Start) && packet.SeqLT(r.latest, run.End) {
+			blocks = append(blocks, run)
+			runs = append(runs[:i], runs[i+1:]...)
+			break
+		}
+	}
+	for i := len(runs) - 1; i >= 0 && len(blocks) < max; i-- {
+		blocks = append(blocks, runs[i])
+	}
+	if len(blocks) > max {
+		blocks = blocks[:max]
+	}
+	return blocks
+}
@@ -0,0 +1,154 @@
+package socket
+
+import (
+	"testing"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReassemblyInOrder(t *testing.T) {
+	r := newReassemblyBuffer(0, 100)
+
+	assert.Equal(t, []byte("hello"), r.insert(0, []byte("hello")))
+	assert.Equal(t, []byte(" world"), r.insert(5, []byte(" world")))
+	assert.Equal(t, uint32(11), r.next)
+	assert.False(t, r.hasGaps())
+}
+
+func TestReassemblyOutOfOrder(t *testing.T) {
+	r := newReassemblyBuffer(0, 100)
+
+	assert.Nil(t, r.insert(10, []byte("klmno")))
+	assert.Nil(t, r.insert(5, []byte("fghij")))
+	assert.True(t, r.hasGaps())
+	assert.Equal(t, 10, r.buffered)
+
+	assert.Equal(t, []byte("abcdefghijklmno"), r.insert(0, []byte("abcde")))
+	assert.Equal(t, uint32(15), r.next)
+	assert.Equal(t, 0, r.buffered)
+	assert.False(t, r.hasGaps())
+}
+
+func TestReassemblyDuplicates(t *testing.T) {
+	r := newReassemblyBuffer(0, 100)
+
+	assert.Equal(t, []byte("abcde"), r.insert(0, []byte("abcde")))
+
+	// already released
+	assert.Nil(t, r.insert(0, []byte("abcde")))
+
+	// already held
+	assert.Nil(t, r.insert(10, []byte("klmno")))
+	assert.Nil(t, r.insert(10, []byte("klmno")))
+	assert.Equal(t, 5, r.buffered)
+
+	assert.Equal(t, []byte("fghijklmno"), r.insert(5, []byte("fghij")))
+}
+
+func TestReassemblyOverlaps(t *testing.T) {
+	r := newReassemblyBuffer(0, 100)
+
+	assert.Nil(t, r.insert(4, []byte("efg")))
+	assert.Nil(t, r.insert(9, []byte("jk")))
+
+	// overlaps both held fragments and spans the hole between them
+	assert.Nil(t, r.insert(2, []byte("cdefghijkl")))
+	assert.Equal(t, 10, r.buffered)
+
+	// overlaps already released bytes
+	assert.Equal(t, []byte("abcdefghijkl"), r.insert(0, []byte("abc")))
+	assert.Equal(t, []byte("mn"), r.insert(10, []byte("klmn")))
+	assert.Equal(t, uint32(14), r.next)
+}
+
+func TestReassemblyCapacity(t *testing.T) {
+	r := newReassemblyBuffer(0, 10)
+
+	// beyond the buffer entirely
+	assert.Nil(t, r.insert(10, []byte("klmno")))
+	assert.Equal(t, 0, r.buffered)
+
+	// trimmed at the buffer's edge
+	assert.Nil(t, r.insert(8, []byte("ijklm")))
+	assert.Equal(t, 2, r.buffered)
+
+	assert.Equal(t, []byte("abcdefghij"), r.insert(0, []byte("abcdefgh")))
+}
+
+func TestReassemblyWraparound(t *testing.T) {
+	isn := ^uint32(0) - 2
+	r := newReassemblyBuffer(isn, 100)
+
+	assert.Nil(t, r.insert(isn+5, []byte("fgh")))
+	assert.Equal(t, []byte("abcdefgh"), r.insert(isn, []byte("abcde")))
+	assert.Equal(t, uint32(5), r.next)
+}
+
+func TestReassemblyWindow(t *testing.T) {
+	r := newReassemblyBuffer(0, 10)
+	assert.Equal(t, 10, r.window())
+
+	// out-of-order bytes do not move the window's left edge
+	assert.Nil(t, r.insert(5, []byte("fgh")))
+	assert.Equal(t, 10, r.window())
+
+	// released bytes take up space until consumed
+	assert.Equal(t, []byte("abcdefgh"), r.insert(0, []byte("abcde")))
+	assert.Equal(t, 2, r.window())
+
+	// bytes beyond the window are dropped
+	assert.Equal(t, []byte("ij"), r.insert(8, []byte("ijklm")))
+	assert.Equal(t, 0, r.window())
+	assert.Nil(t, r.insert(10, []byte("klm")))
+
+	r.consume(10)
+	assert.Equal(t, 10, r.window())
+	assert.Equal(t, []byte("klm"), r.insert(10, []byte("klm")))
+}
+
+func TestReassemblyAcceptable(t *testing.T) {
+	isn := ^uint32(0) - 2
+	r := newReassemblyBuffer(isn, 10)
+
+	assert.True(t, r.acceptable(isn, 5))
+	assert.True(t, r.acceptable(isn+9, 5))   // partly within the window
+	assert.True(t, r.acceptable(isn-2, 5))   // partly received already
+	assert.False(t, r.acceptable(isn-5, 5))  // received already
+	assert.False(t, r.acceptable(isn+10, 1)) // beyond the window
+	assert.False(t, r.acceptable(isn+1<<31, 1))
+
+	// nothing is acceptable while the window is closed
+	assert.Equal(t, []byte("abcdefghij"), r.insert(isn, []byte("abcdefghij")))
+	assert.False(t, r.acceptable(isn+10, 1))
+}
+
+func TestReassemblySACKBlocks(t *testing.T) {
+	r := newReassemblyBuffer(0, 100)
+	assert.Empty(t, r.sackBlocks(packet.MaxSACKBlocks))
+
+	assert.Nil(t, r.insert(10, []byte("klmno")))
+	assert.Nil(t, r.insert(30, []byte("EFGHI")))
+	assert.Nil(t, r.insert(50, []byte("YZ")))
+
+	// adjacent fragments are reported as a single block
+	assert.Nil(t, r.insert(15, []byte("pqrst")))
+
+	// the most recent segment's block comes first
+	assert.Equal(t, []packet.SACKBlock{
+		{Start: 10, End: 20},
+		{Start: 50, End: 52},
+		{Start: 30, End: 35},
+	}, r.sackBlocks(packet.MaxSACKBlocks))
+	assert.Equal(t, []packet.SACKBlock{
+		{Start: 10, End: 20},
+		{Start: 50, End: 52},
+	}, r.sackBlocks(2))
+
+	// released bytes are no longer reported
+	assert.Equal(t, []byte("abcdefghijklmnopqrst"), r.insert(0, []byte("abcdefghij")))
+	assert.Equal(t, []packet.SACKBlock{
+		{Start: 50, End: 52},
+		{Start: 30, End: 35},
+	}, r.sackBlocks(packet.MaxSACKBlocks))
+}
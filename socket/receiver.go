@@ -0,0 +1,248 @@
+package socket
+
+import (
+	"log"
+
+	"github.com/adrianosela/rdtp/handshake"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/adrianosela/rdtp/packet/factory"
+)
+
+// receive handles inbound packets until the Run loop stops
+func (s *Socket) receive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		for !s.stopping && len(s.inbound) == 0 {
+			s.events.Wait()
+		}
+		if s.stopping {
+			return
+		}
+		p, _ := s.nextInbound()
+
+		s.mu.Unlock()
+		s.handle(p)
+		s.mu.Lock()
+	}
+}
+
+// handle processes an inbound packet's acknowledgement and payload
+func (s *Socket) handle(p *packet.Packet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.heard()
+
+	// the remote no longer has the connection
+	if p.IsERR() {
+		log.Printf("[rdtp socket %s] Connection reset by remote (%s)", s.ID(), p.Reset)
+		s.abortLocked(handshake.ErrConnectionReset)
+		return
+	}
+
+	// report the one-way delay of timestamped packets
+	// back to the remote, stamping timestamps from then on
+	if p.IsTimestamped() {
+		s.packetizer.EnableTimestamps()
+		s.packetizer.EchoDelay(factory.Timestamp(s.clock.Now()) - p.Timestamp)
+	}
+
+	// segments acknowledging data never sent are forged or stray, and
+	// are dropped whole, answered with what was actually acknowledged
+	// (RFC 5961). Otherwise, acknowledgements and windows are processed
+	// whatever the segment's sequence number, e.g. while the receive
+	// window is closed
+	if p.IsACK() && !s.sent(p.AckNo) {
+		s.sendAck()
+		return
+	}
+	if p.IsACK() {
+		s.acknowledge(p)
+		s.acknowledgeFIN(p)
+	}
+
+	// while the data of segments outside the receive window is dropped,
+	// and answered with the sequence number expected
+	if len(p.Payload) > 0 && !s.rcv.acceptable(p.SeqNo, len(p.Payload)) {
+		s.sendAck()
+		return
+	}
+	if p.IsFIN() && s.packetizer.Version() != packet.Version0 {
+		s.receiveFIN(p)
+		return
+	}
+
+	// path MTU probes are answered right away and their padding dropped
+	if p.PMTUProbe != 0 {
+		if err := s.packetizer.SendPMTUProbeAck(p.PMTUProbe); err != nil {
+			log.Printf("[rdtp socket %s] Error acknowledging path MTU probe: %s", s.ID(), err)
+		}
+		return
+	}
+	if p.PMTUProbeAck != 0 {
+		s.onPMTUProbeAck(int(p.PMTUProbeAck))
+	}
+
+	if len(p.Payload) == 0 {
+		return
+	}
+
+	// only contiguous bytes are passed on to the application.
+	// Duplicate and out-of-order segments are acknowledged right
+	// away to remind the remote socket of the sequence number
+	// expected next, as is data which fills a gap
+	ready := s.rcv.insert(p.SeqNo, p.Payload)
+	s.packetizer.Acknowledge(s.rcv.next)
+	s.advertise()
+	if len(ready) == 0 {
+		s.sendAck()
+		return
+	}
+
+	s.rxBytes += uint32(len(ready)) // stats
+	s.unread = append(s.unread, ready)
+	s.signalReadable()
+
+	if s.rcv.hasGaps() {
+		s.sendAck()
+		return
+	}
+	s.scheduleAck()
+}
+
+// acceptableVersion returns true if a packet is of the version spoken
+// on the connection, or if it is not negotiated yet. Packets of another
+// version are not of the connection, e.g. one of version 1 corrupted
+// into one of version 0, which the legacy sum may not tell
+func (s *Socket) acceptableVersion(p *packet.Packet) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.synchronized || p.Version == s.packetizer.Version()
+}
+
+// acceptableControl returns true if the sequence number of a FIN or
+// reset falls within the receive buffer, or if the remote's initial
+// sequence number is not known yet. Remotes of version 0 do not
+// number their FINs and resets
+func (s *Socket) acceptableControl(p *packet.Packet) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.synchronized || s.packetizer.Version() == packet.Version0 {
+		return true
+	}
+	offset := s.rcv.offset(p.SeqNo)
+	return offset >= 0 && offset <= s.rcv.capacity
+}
+
+// deliver writes in-order data to the application. Writes happen
+// outside the receive path so that a slow application reader
+// shrinks the advertised window rather than stalling the socket
+func (s *Socket) deliver() {
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.delivering = false
+		s.events.Broadcast()
+	}()
+
+	for {
+		s.mu.Lock()
+		for !s.stopping && !s.readable {
+			s.events.Wait()
+		}
+		stopping := s.stopping
+		s.readable = false
+		s.mu.Unlock()
+		if stopping {
+			return
+		}
+
+		if err := s.deliverUnread(); err != nil {
+			s.applicationClosed(err)
+			return
+		}
+	}
+}
+
+// deliverUnread writes the in-order data received so far to the
+// application, followed by EOF once the remote's FIN is received
+func (s *Socket) deliverUnread() error {
+	s.mu.Lock()
+	unread := s.unread
+	s.unread = nil
+	s.mu.Unlock()
+
+	for _, data := range unread {
+		if _, err := s.application.Write(data); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.rcv.consume(len(data))
+		s.advertise()
+		s.updateWindow()
+		s.mu.Unlock()
+	}
+
+	// the remote's FIN follows all of its data
+	s.mu.Lock()
+	eof := s.finReceived && !s.eofDelivered && len(s.unread) == 0
+	s.eofDelivered = s.eofDelivered || eof
+	s.mu.Unlock()
+	if eof {
+		s.deliverEOF()
+	}
+	return nil
+}
+
+// deliverRest writes what is left to deliver to the application once the
+// connection is closed, and EOF whether or not the remote sent a FIN, as
+// remotes of version 0 close both ways at once. Otherwise applications
+// would be closed without EOF, as they are when connections abort
+func (s *Socket) deliverRest() {
+	if err := s.deliverUnread(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	eof := !s.eofDelivered
+	s.eofDelivered = true
+	s.mu.Unlock()
+	if eof {
+		s.deliverEOF()
+	}
+}
+
+// signalReadable wakes up delivery to the
+// application. Must hold s.mu
+func (s *Socket) signalReadable() {
+	s.readable = true
+	s.events.Broadcast()
+}
+
+// advertise stamps the current receive window
+// on outbound packets. Must hold s.mu
+func (s *Socket) advertise() {
+	wnd := s.rcv.window()
+	if wnd > 0xFFFF {
+		wnd = 0xFFFF
+	}
+	s.packetizer.Advertise(uint16(wnd))
+}
+
+// updateWindow lets the remote socket know that the receive window
+// has opened once it has grown by at least a full segment or half
+// the receive buffer since it was last advertised. Must hold s.mu
+func (s *Socket) updateWindow() {
+	threshold := s.rcv.capacity / 2
+	if threshold > packet.MaxPayloadBytes {
+		threshold = packet.MaxPayloadBytes
+	}
+	if s.rcv.window()-s.advertised >= threshold {
+		s.sendAck()
+	}
+}
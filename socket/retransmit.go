@@ -0,0 +1,180 @@
+package socket
+
+import (
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+)
+
+// dupThresh is the number of segments selectively acknowledged
+// above a hole after which the hole is deemed lost (RFC 6675)
+const dupThresh = 3
+
+// segment is a data packet which has been sent to the
+// network but not yet acknowledged by the remote socket
+type segment struct {
+	pck      *packet.Packet
+	sent     time.Time // time of the last (re)transmission
+	retries  int       // number of retransmissions so far
+	sacked   bool      // selectively acknowledged by the remote
+	repaired bool      // retransmitted after being deemed lost
+
+	// delivery state of the connection at the time of
+	// the last (re)transmission, for rate sampling
+	delivered     int
+	deliveredTime time.Time
+	firstSent     time.Time
+	appLimited    bool
+}
+
+// end returns the sequence number following the segment's last byte
+func (s *segment) end() uint32 {
+	return s.pck.SeqNo + uint32(len(s.pck.Payload))
+}
+
+// retransmissionQueue holds unacknowledged segments keyed by sequence number
+type retransmissionQueue struct {
+	segments map[uint32]*segment
+	order    []uint32 // sequence numbers in transmission order
+	inFlight int      // unacknowledged payload bytes
+	sacked   int      // selectively acknowledged payload bytes
+	rate     rateSampler
+}
+
+func newRetransmissionQueue() *retransmissionQueue {
+	return &retransmissionQueue{segments: make(map[uint32]*segment)}
+}
+
+// push adds a newly transmitted packet to the queue
+func (q *retransmissionQueue) push(p *packet.Packet, now time.Time) {
+	if _, ok := q.segments[p.SeqNo]; ok {
+		return
+	}
+	seg := &segment{pck: p, sent: now}
+	q.rate.sent(seg, now, q.pipe())
+	q.segments[p.SeqNo] = seg
+	q.order = append(q.order, p.SeqNo)
+	q.inFlight += len(p.Payload)
+}
+
+// resend records the retransmission of a segment
+func (q *retransmissionQueue) resend(seg *segment, now time.Time) {
+	seg.retries++
+	seg.sent = now
+	q.rate.sent(seg, now, q.pipe())
+}
+
+// split replaces a segment with segments of the given packets, which
+// carry its data in pieces, and returns them in sequence order
+func (q *retransmissionQueue) split(seg *segment, pcks []*packet.Packet) []*segment {
+	i := 0
+	for q.order[i] != seg.pck.SeqNo {
+		i++
+	}
+	segs := make([]*segment, len(pcks))
+	seqs := make([]uint32, len(pcks))
+	for j, p := range pcks {
+		piece := *seg
+		piece.pck = p
+		segs[j] = &piece
+		seqs[j] = p.SeqNo
+		q.segments[p.SeqNo] = &piece
+	}
+	q.order = append(q.order[:i], append(seqs, q.order[i+1:]...)...)
+	return segs
+}
+
+// ack removes all segments fully covered by a cumulative acknowledgement.
+// It returns the number of bytes acknowledged and, unless any of the
+// removed segments was retransmitted (which makes the acknowledgement
+// ambiguous as per Karn's algorithm), a round trip time sample
+func (q *retransmissionQueue) ack(ackNo uint32, now time.Time) (acked int, rtt time.Duration, sampled bool) {
+	retransmitted := false
+	for len(q.order) > 0 {
+		seg := q.segments[q.order[0]]
+		if packet.SeqLT(ackNo, seg.end()) {
+			break
+		}
+		if seg.retries > 0 {
+			retransmitted = true
+		}
+		rtt = now.Sub(seg.sent)
+		acked += len(seg.pck.Payload)
+		q.inFlight -= len(seg.pck.Payload)
+		if seg.sacked {
+			q.sacked -= len(seg.pck.Payload)
+		} else {
+			q.rate.onDelivered(seg, now)
+		}
+		delete(q.segments, q.order[0])
+		q.order = q.order[1:]
+	}
+	sampled = acked > 0 && !retransmitted
+	return
+}
+
+// sack marks the segments fully covered by any of the given blocks as
+// selectively acknowledged. They are kept until cumulatively acknowledged
+// since the remote socket is allowed to discard out-of-order data
+func (q *retransmissionQueue) sack(blocks []packet.SACKBlock, now time.Time) {
+	for _, seq := range q.order {
+		seg := q.segments[seq]
+		if seg.sacked {
+			continue
+		}
+		for _, b := range blocks {
+			if packet.SeqGEQ(seg.pck.SeqNo, b.Start) && packet.SeqLEQ(seg.end(), b.End) {
+				seg.sacked = true
+				q.sacked += len(seg.pck.Payload)
+				q.rate.onDelivered(seg, now)
+				break
+			}
+		}
+	}
+}
+
+// lost returns the holes in the scoreboard which have at least dupThresh
+// segments selectively acknowledged above them and which have not been
+// repaired yet, in sequence order
+func (q *retransmissionQueue) lost() []*segment {
+	var holes []*segment
+	sackedAbove := 0
+	for i := len(q.order) - 1; i >= 0; i-- {
+		seg := q.segments[q.order[i]]
+		if seg.sacked {
+			sackedAbove++
+			continue
+		}
+		if sackedAbove >= dupThresh && !seg.repaired {
+			holes = append([]*segment{seg}, holes...)
+		}
+	}
+	return holes
+}
+
+// pipe returns the number of unacknowledged bytes which have not been
+// selectively acknowledged either, and are therefore still in the network
+func (q *retransmissionQueue) pipe() int {
+	return q.inFlight - q.sacked
+}
+
+// next returns the sequence number following the latest segment sent
+func (q *retransmissionQueue) next() (uint32, bool) {
+	if len(q.order) == 0 {
+		return 0, false
+	}
+	return q.segments[q.order[len(q.order)-1]].end(), true
+}
+
+// oldest returns the earliest unacknowledged segment
+func (q *retransmissionQueue) oldest() (*segment, bool) {
+	if len(q.order) == 0 {
+		return nil, false
+	}
+	return q.segments[q.order[0]], true
+}
+
+// empty returns true if there are no unacknowledged segments
+func (q *retransmissionQueue) empty() bool {
+	return len(q.order) == 0
+}
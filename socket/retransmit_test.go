@@ -0,0 +1,223 @@
+package socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockDataPacket(seq uint32, size int) *packet.Packet {
+	p, _ := packet.NewPacket(1234, 5678, make([]byte, size))
+	p.Version = packet.MaxVersion
+	p.SetSeqNo(seq)
+	return p
+}
+
+func TestRetransmissionQueuePush(t *testing.T) {
+	q := newRetransmissionQueue()
+	assert.True(t, q.empty())
+
+	now := time.Now()
+	q.push(mockDataPacket(0, 10), now)
+	q.push(mockDataPacket(10, 10), now)
+
+	// pushing an already queued sequence number is a no-op
+	q.push(mockDataPacket(10, 10), now)
+
+	assert.False(t, q.empty())
+	assert.Equal(t, 20, q.inFlight)
+	assert.Equal(t, []uint32{0, 10}, q.order)
+}
+
+func TestRetransmissionQueueAck(t *testing.T) {
+	q := newRetransmissionQueue()
+
+	now := time.Now()
+	q.push(mockDataPacket(0, 10), now)
+	q.push(mockDataPacket(10, 10), now)
+	q.push(mockDataPacket(20, 10), now)
+
+	// partial acknowledgement of a segment releases nothing
+	acked, _, sampled := q.ack(5, now)
+	assert.Equal(t, 0, acked)
+	assert.False(t, sampled)
+
+	acked, rtt, sampled := q.ack(20, now.Add(time.Millisecond*50))
+	assert.Equal(t, 20, acked)
+	assert.True(t, sampled)
+	assert.Equal(t, time.Millisecond*50, rtt)
+	assert.Equal(t, 10, q.inFlight)
+
+	// retransmitted segments yield no round trip time sample
+	q.segments[20].retries = 1
+	acked, _, sampled = q.ack(30, now)
+	assert.Equal(t, 10, acked)
+	assert.False(t, sampled)
+	assert.True(t, q.empty())
+}
+
+func TestRetransmissionQueueAckWraparound(t *testing.T) {
+	q := newRetransmissionQueue()
+
+	now := time.Now()
+	q.push(mockDataPacket(^uint32(0)-4, 10), now)
+
+	acked, _, _ := q.ack(5, now)
+	assert.Equal(t, 10, acked)
+	assert.True(t, q.empty())
+}
+
+func TestRetransmissionQueueOldest(t *testing.T) {
+	q := newRetransmissionQueue()
+
+	_, ok := q.oldest()
+	assert.False(t, ok)
+
+	now := time.Now()
+	q.push(mockDataPacket(0, 10), now)
+	q.push(mockDataPacket(10, 10), now.Add(time.Millisecond*500))
+
+	seg, ok := q.oldest()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0), seg.pck.SeqNo)
+
+	q.ack(10, now)
+	seg, ok = q.oldest()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(10), seg.pck.SeqNo)
+
+	q.ack(20, now)
+	_, ok = q.oldest()
+	assert.False(t, ok)
+}
+
+func TestRetransmissionQueueSACK(t *testing.T) {
+	q := newRetransmissionQueue()
+
+	now := time.Now()
+	for seq := uint32(0); seq < 60; seq += 10 {
+		q.push(mockDataPacket(seq, 10), now)
+	}
+
+	// a block must cover a whole segment to mark it
+	q.sack([]packet.SACKBlock{{Start: 10, End: 25}, {Start: 30, End: 60}}, now)
+	assert.False(t, q.segments[0].sacked)
+	assert.True(t, q.segments[10].sacked)
+	assert.False(t, q.segments[20].sacked)
+	assert.True(t, q.segments[30].sacked)
+	assert.True(t, q.segments[40].sacked)
+	assert.True(t, q.segments[50].sacked)
+
+	// sacked segments still count as in flight until cumulatively
+	// acked, but are no longer in the network
+	assert.Equal(t, 60, q.inFlight)
+	assert.Equal(t, 20, q.pipe())
+
+	next, ok := q.next()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(60), next)
+
+	lost := q.lost()
+	assert.Len(t, lost, 2)
+	assert.Equal(t, uint32(0), lost[0].pck.SeqNo)
+	assert.Equal(t, uint32(20), lost[1].pck.SeqNo)
+
+	// repaired holes are not reported again
+	lost[0].repaired = true
+	lost = q.lost()
+	assert.Len(t, lost, 1)
+	assert.Equal(t, uint32(20), lost[0].pck.SeqNo)
+
+	q.ack(20, now)
+	assert.Equal(t, 40, q.inFlight)
+	assert.Equal(t, 10, q.pipe())
+}
+
+func TestRetransmissionQueueLostBelowThreshold(t *testing.T) {
+	q := newRetransmissionQueue()
+
+	now := time.Now()
+	for seq := uint32(0); seq < 40; seq += 10 {
+		q.push(mockDataPacket(seq, 10), now)
+	}
+
+	q.sack([]packet.SACKBlock{{Start: 10, End: 30}}, now)
+	assert.Empty(t, q.lost())
+
+	q.sack([]packet.SACKBlock{{Start: 30, End: 40}}, now)
+	assert.Len(t, q.lost(), 1)
+}
+
+// TestSelectiveRetransmissionEveryOtherDropped plays a transfer in which
+// every other segment is lost on its first transmission through the
+// receiver's reassembly buffer and the sender's scoreboard, checking
+// that only the holes are ever retransmitted
+func TestSelectiveRetransmissionEveryOtherDropped(t *testing.T) {
+	const (
+		segments = 20
+		size     = 10
+	)
+
+	rcv := newReassemblyBuffer(0, segments*size)
+	q := newRetransmissionQueue()
+	now := time.Now()
+
+	var arrivals []*packet.Packet
+	dropped := map[uint32]bool{}
+	for i := 0; i < segments; i++ {
+		p := mockDataPacket(uint32(i*size), size)
+		q.push(p, now)
+		if i%2 == 0 {
+			dropped[p.SeqNo] = true
+			continue
+		}
+		arrivals = append(arrivals, p)
+	}
+
+	retransmitted := map[uint32]int{}
+	repairs, timeouts := 0, 0
+	for !q.empty() {
+		if len(arrivals) == 0 {
+			// nothing left in flight, so the retransmission timer fires
+			seg, _ := q.oldest()
+			seg.retries++
+			retransmitted[seg.pck.SeqNo]++
+			arrivals = append(arrivals, seg.pck)
+			timeouts++
+			continue
+		}
+
+		p := arrivals[0]
+		arrivals = arrivals[1:]
+		rcv.insert(p.SeqNo, p.Payload)
+
+		// every arrival is answered with an acknowledgement
+		q.ack(rcv.next, now)
+		q.sack(rcv.sackBlocks(packet.MaxSACKBlocks), now)
+		for _, seg := range q.lost() {
+			seg.repaired = true
+			seg.retries++
+			retransmitted[seg.pck.SeqNo]++
+			arrivals = append(arrivals, seg.pck)
+			repairs++
+		}
+	}
+
+	assert.Equal(t, uint32(segments*size), rcv.next)
+	assert.False(t, rcv.hasGaps())
+
+	// each dropped segment is retransmitted exactly once and
+	// no segment which made it through is sent again
+	assert.Len(t, retransmitted, len(dropped))
+	for seq, n := range retransmitted {
+		assert.True(t, dropped[seq], "segment %d was not dropped", seq)
+		assert.Equal(t, 1, n)
+	}
+
+	// the last two holes lack enough segments above
+	// them and are only recovered by a timeout
+	assert.Equal(t, 8, repairs)
+	assert.Equal(t, 2, timeouts)
+}
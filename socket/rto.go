@@ -0,0 +1,91 @@
+package socket
+
+import "time"
+
+const (
+	// initialRTO is the retransmission timeout used before
+	// any round trip time measurements have been made
+	initialRTO = time.Second * 1
+
+	// minRTO and maxRTO bound the retransmission timeout
+	minRTO = time.Millisecond * 200
+	maxRTO = time.Second * 60
+
+	// clockGranularity is the smallest variance term
+	// added to the smoothed round trip time
+	clockGranularity = time.Millisecond * 1
+)
+
+// rtoEstimator computes the retransmission timeout from round trip
+// time samples as per the Jacobson/Karels algorithm (RFC 6298)
+type rtoEstimator struct {
+	srtt   time.Duration // smoothed round trip time
+	rttvar time.Duration // round trip time variation
+	rto    time.Duration // retransmission timeout before backoff
+
+	backoffs uint // consecutive timer expiries
+	measured bool // whether a first sample has been taken
+}
+
+// newRTOEstimator returns an estimator with no round trip time samples
+func newRTOEstimator() *rtoEstimator {
+	return &rtoEstimator{rto: initialRTO}
+}
+
+// sample updates the estimator with a new round trip time measurement.
+// Callers must not sample retransmitted segments (Karn's algorithm)
+func (e *rtoEstimator) sample(rtt time.Duration) {
+	if rtt <= 0 {
+		rtt = clockGranularity
+	}
+	if !e.measured {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.measured = true
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		// RTTVAR <- (1 - 1/4) * RTTVAR + 1/4 * |SRTT - R'|
+		e.rttvar = e.rttvar - e.rttvar/4 + delta/4
+		// SRTT <- (1 - 1/8) * SRTT + 1/8 * R'
+		e.srtt = e.srtt - e.srtt/8 + rtt/8
+	}
+
+	variance := 4 * e.rttvar
+	if variance < clockGranularity {
+		variance = clockGranularity
+	}
+	e.rto = clamp(e.srtt+variance, minRTO, maxRTO)
+	e.backoffs = 0
+}
+
+// backoff doubles the retransmission timeout after a timer expiry
+func (e *rtoEstimator) backoff() {
+	if e.timeout() < maxRTO {
+		e.backoffs++
+	}
+}
+
+// reset clears any backoff once new data is acknowledged, since the
+// path is evidently working again even if the acknowledgement was
+// ambiguous and yielded no sample (as QUIC does, RFC 9002)
+func (e *rtoEstimator) reset() {
+	e.backoffs = 0
+}
+
+// timeout returns the current retransmission timeout
+func (e *rtoEstimator) timeout() time.Duration {
+	return clamp(e.rto<<e.backoffs, minRTO, maxRTO)
+}
+
+func clamp(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
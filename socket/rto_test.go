@@ -0,0 +1,68 @@
+package socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTOEstimatorInitial(t *testing.T) {
+	e := newRTOEstimator()
+	assert.Equal(t, initialRTO, e.timeout())
+}
+
+func TestRTOEstimatorFirstSample(t *testing.T) {
+	e := newRTOEstimator()
+	e.sample(time.Millisecond * 100)
+
+	assert.Equal(t, time.Millisecond*100, e.srtt)
+	assert.Equal(t, time.Millisecond*50, e.rttvar)
+	// 100ms + 4 * 50ms
+	assert.Equal(t, time.Millisecond*300, e.timeout())
+}
+
+func TestRTOEstimatorSubsequentSamples(t *testing.T) {
+	e := newRTOEstimator()
+	e.sample(time.Millisecond * 400)
+	e.sample(time.Millisecond * 200)
+
+	// RTTVAR = 3/4 * 200ms + 1/4 * |400ms - 200ms|
+	assert.Equal(t, time.Millisecond*200, e.rttvar)
+	// SRTT = 7/8 * 400ms + 1/8 * 200ms
+	assert.Equal(t, time.Millisecond*375, e.srtt)
+	assert.Equal(t, time.Millisecond*1175, e.timeout())
+}
+
+func TestRTOEstimatorBounds(t *testing.T) {
+	e := newRTOEstimator()
+	e.sample(time.Microsecond * 10)
+	assert.Equal(t, minRTO, e.timeout())
+
+	e = newRTOEstimator()
+	e.sample(time.Minute * 5)
+	assert.Equal(t, maxRTO, e.timeout())
+}
+
+func TestRTOEstimatorBackoff(t *testing.T) {
+	e := newRTOEstimator()
+
+	e.backoff()
+	assert.Equal(t, initialRTO*2, e.timeout())
+	e.backoff()
+	assert.Equal(t, initialRTO*4, e.timeout())
+
+	for i := 0; i < 10; i++ {
+		e.backoff()
+	}
+	assert.Equal(t, maxRTO, e.timeout())
+
+	// a new sample resets the backed-off timeout
+	e.sample(time.Millisecond * 100)
+	assert.Equal(t, time.Millisecond*300, e.timeout())
+
+	e.backoff()
+	assert.Equal(t, time.Millisecond*600, e.timeout())
+	e.reset()
+	assert.Equal(t, time.Millisecond*300, e.timeout())
+}
@@ -0,0 +1,394 @@
+package socket
+
+import (
+	"log"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/adrianosela/rdtp/congestion"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/pkg/errors"
+)
+
+func (s *Socket) transmit() {
+	buf := make([]byte, 1500)
+	for {
+		n, err := s.application.Read(buf)
+		if err != nil {
+			// the application is done sending, having closed
+			// its connection for writing or altogether
+			if s.linger() {
+				s.closeWrite()
+			}
+			return
+		}
+
+		// segments are kept around for retransmission
+		// so they must not alias the read buffer
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		if err = s.send(data); err != nil {
+			log.Printf("[rdtp socket %s] Error packetizing and forwarding message: %s", s.ID(), err)
+			return
+		}
+	}
+}
+
+// send packetizes and forwards data as the remote socket's window
+// allows, blocking while the window is full
+func (s *Socket) send(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer func() { s.blocked = false }()
+
+	// the application left the network idle
+	if s.rtx.empty() {
+		s.rtx.rate.markAppLimited(s.rtx.inFlight)
+	}
+
+	for len(data) > 0 {
+		if s.closed || s.err != nil {
+			return errors.New("socket closed")
+		}
+
+		// the remote's window and the send buffer bound all
+		// unacknowledged bytes while the congestion window
+		// only bounds those which are still in the network
+		wnd := s.sndWnd
+		if s.sndBuf > 0 && s.sndBuf < wnd {
+			wnd = s.sndBuf
+		}
+		allowed := wnd - s.rtx.inFlight
+		if allowed <= 0 {
+			s.blocked = true
+			s.armPersistTimer()
+			s.sendCond.Wait()
+			continue
+		}
+		s.blocked = false
+
+		// avoid sending small segments while a full one
+		// could go out once more data is acknowledged
+		if cwnd := s.cc.Window() - s.rtx.pipe(); cwnd < allowed {
+			allowed = cwnd
+		}
+		if allowed < len(data) && allowed < s.packetizer.ChunkSize() && !s.rtx.empty() {
+			s.sendCond.Wait()
+			continue
+		}
+
+		chunk := data
+		if len(chunk) > allowed {
+			chunk = chunk[:allowed]
+		}
+
+		// packets go out one at a time, spaced out at the pacing rate
+		segment := s.packetizer.ChunkSize()
+		if len(chunk) > segment {
+			chunk = chunk[:segment]
+		}
+		now := s.clock.Now()
+		s.pacer.setRate(now, s.pacingRate(), segment)
+		if wait := s.pacer.wait(now, len(chunk), segment); wait > 0 {
+			s.schedulePacing(wait)
+			s.sendCond.Wait()
+			continue
+		}
+
+		n, err := s.packetizer.PackAndForwardMessage(chunk)
+		s.txBytes += uint32(n) // stats
+		s.pacer.spend(n)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// pacingRate returns the rate in bytes per second at which packets are
+// spaced out: the configured rate if any, the congestion controller's
+// otherwise, or else the congestion window over the smoothed round trip
+// time. It returns zero until a round trip has been measured
+func (s *Socket) pacingRate() float64 {
+	if s.fixedPacingRate > 0 {
+		return s.fixedPacingRate
+	}
+	if rate := s.cc.PacingRate(); rate > 0 {
+		return rate
+	}
+	if !s.rto.measured {
+		return 0
+	}
+	return pacingGain * float64(s.cc.Window()) / s.rto.srtt.Seconds()
+}
+
+// schedulePacing wakes up transmission once the pacer lets the
+// next packet out, unless already scheduled. Must hold s.mu
+func (s *Socket) schedulePacing(wait time.Duration) {
+	if s.pacing {
+		return
+	}
+	s.pacing = true
+
+	// sockets on real time share a single timer wheel, while
+	// those on other clocks have timers of their own
+	if _, ok := s.clock.(clock.Real); ok {
+		pacingWheel.schedule(wait, s.onPacingTimeout)
+		return
+	}
+	if s.pacingTimer == nil {
+		s.pacingTimer = s.clock.AfterFunc(wait, s.onPacingTimeout)
+		return
+	}
+	s.pacingTimer.Reset(wait)
+}
+
+func (s *Socket) onPacingTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pacing = false
+	s.sendCond.Broadcast()
+}
+
+// linger waits until all data sent has been acknowledged. It returns
+// false if the connection is aborted or closed in the meantime
+func (s *Socket) linger() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.rtx.empty() && !s.closed && s.err == nil {
+		s.sendCond.Wait()
+	}
+	return !s.closed && s.err == nil
+}
+
+// forward sends a packet to the network layer, tracking data
+// packets for retransmission until they are acknowledged.
+// Data packets are only ever forwarded with s.mu held
+func (s *Socket) forward(p *packet.Packet) error {
+	// path MTU probes carry padding rather than data
+	if len(p.Payload) == 0 || p.PMTUProbe != 0 {
+		return s.network.Send(p)
+	}
+
+	// window probes re-send an already acknowledged
+	// byte and are therefore not tracked
+	if packet.SeqGEQ(p.SeqNo, s.lastAck) {
+		idle := s.rtx.empty()
+		s.rtx.push(p, s.clock.Now())
+		if idle {
+			s.restartRetransmissionTimer()
+		}
+	}
+	s.ackSent() // piggybacked
+
+	// data which fails to go out is treated as lost
+	if err := s.network.Send(p); err != nil {
+		log.Printf("[rdtp socket %s] Error sending segment %d: %s", s.ID(), p.SeqNo, err)
+	}
+	return nil
+}
+
+// sent returns true if an acknowledgement number acknowledges no
+// more than has been sent, the FIN included. Must hold s.mu
+func (s *Socket) sent(ackNo uint32) bool {
+	sndNxt := s.packetizer.SeqNo()
+	if s.finSent {
+		sndNxt++
+	}
+	return !packet.SeqGT(ackNo, sndNxt)
+}
+
+// acknowledge processes the acknowledgement and window on an inbound
+// packet, releasing acknowledged segments from the retransmission
+// queue and updating the round trip time estimate. Must hold s.mu
+func (s *Socket) acknowledge(p *packet.Packet) {
+	if packet.SeqLT(p.AckNo, s.lastAck) || !s.sent(p.AckNo) {
+		return // stale, or of data never sent
+	}
+	s.lastAck = p.AckNo
+	s.sndWnd = int(p.Window)
+	if s.packetizer.Version() == packet.Version0 {
+		// remotes of version 0 advertise no window,
+		// and are sent as much as any window allows
+		s.sndWnd = 0xFFFF
+	}
+	if s.sndWnd > 0 {
+		s.persistBackoff = 0
+	}
+
+	now := s.clock.Now()
+	inFlight := s.rtx.pipe()
+	acked, rtt, sampled := s.rtx.ack(p.AckNo, now)
+	if sampled {
+		s.rto.sample(rtt)
+	} else {
+		rtt = 0
+	}
+	if acked > 0 {
+		s.rto.reset()
+		s.restartRetransmissionTimer()
+	}
+	if s.recovery && packet.SeqGEQ(p.AckNo, s.recoveryPoint) {
+		s.recovery = false
+	}
+	if p.IsSACK() {
+		s.rtx.sack(p.SACKBlocks, now)
+	}
+
+	if rs, ok := s.rtx.rate.take(); ok || acked > 0 {
+		sample := congestion.AckSample{
+			Acked:      acked,
+			InFlight:   inFlight,
+			RTT:        rtt,
+			InRecovery: s.recovery,
+			Now:        now,
+			Delivered:  s.rtx.rate.delivered,
+		}
+		if ok {
+			sample.PriorDelivered = rs.priorDelivered
+			sample.DeliveryRate = rs.rate()
+			sample.AppLimited = rs.appLimited
+		}
+		if p.IsTimestamped() {
+			sample.OneWayDelay = time.Duration(p.TimestampDelay) * time.Microsecond
+		}
+		s.cc.OnAck(sample)
+	}
+
+	// only the holes reported by the remote are retransmitted
+	s.repair(now)
+	s.sendCond.Broadcast()
+}
+
+// repair retransmits the segments deemed lost, reducing the
+// congestion window unless already recovering from losses
+// in the same round trip. Must hold s.mu
+func (s *Socket) repair(now time.Time) {
+	lost := s.rtx.lost()
+	if len(lost) == 0 {
+		return
+	}
+	if !s.recovery {
+		s.cc.OnLoss(s.rtx.inFlight, now)
+		s.recovery = true
+		s.recoveryPoint, _ = s.rtx.next()
+	}
+	for _, seg := range lost {
+		seg.repaired = true
+		s.retransmit(seg, now)
+	}
+}
+
+// onRetransmissionTimeout retransmits the earliest unacknowledged
+// segment, backing off the retransmission timeout exponentially, and
+// aborts the connection once it exceeds the retransmission limit
+func (s *Socket) onRetransmissionTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.err != nil {
+		return
+	}
+
+	now := s.clock.Now()
+	seg, ok := s.rtx.oldest()
+	if !ok || now.Before(s.rtxDeadline) {
+		return // timer was restarted or stopped since firing
+	}
+
+	if seg.retries >= s.maxRetransmissions {
+		// let the remote know, should it still be reachable
+		if err := s.packetizer.SendReset(packet.ResetAborted); err != nil {
+			log.Printf("[rdtp socket %s] Error sending reset: %s", s.ID(), err)
+		}
+		s.abortLocked(errRetransmissionLimit)
+		return
+	}
+
+	s.cc.OnTimeout(s.rtx.inFlight, now)
+	s.recovery = false
+	s.detectBlackHole(seg)
+	s.retransmit(seg, now)
+	s.rto.backoff()
+	s.restartRetransmissionTimer()
+}
+
+// retransmit re-sends a segment with the latest acknowledgement
+// and window stamped on it, in pieces if it no longer fits the
+// MSS (e.g. after the path MTU shrank). Must hold s.mu
+func (s *Socket) retransmit(seg *segment, now time.Time) {
+	segs := []*segment{seg}
+	if len(seg.pck.Payload) > s.packetizer.ChunkSize() {
+		segs = s.rtx.split(seg, s.packetizer.Split(seg.pck))
+	}
+	for _, seg := range segs {
+		s.rtx.resend(seg, now)
+		s.packetizer.Restamp(seg.pck)
+		s.ackSent() // piggybacked
+		if err := s.network.Send(seg.pck); err != nil {
+			log.Printf("[rdtp socket %s] Error retransmitting segment %d: %s", s.ID(), seg.pck.SeqNo, err)
+		}
+	}
+}
+
+// restartRetransmissionTimer sets the retransmission timer to expire
+// one retransmission timeout from now, or stops it if there are no
+// unacknowledged segments left (RFC 6298). Must hold s.mu
+func (s *Socket) restartRetransmissionTimer() {
+	if s.rtx.empty() {
+		if s.rtxTimer != nil {
+			s.rtxTimer.Stop()
+		}
+		return
+	}
+	rto := s.rto.timeout()
+	s.rtxDeadline = s.clock.Now().Add(rto)
+	if s.rtxTimer == nil {
+		s.rtxTimer = s.clock.AfterFunc(rto, s.onRetransmissionTimeout)
+		return
+	}
+	s.rtxTimer.Reset(rto)
+}
+
+// armPersistTimer schedules a window probe when transmission is blocked
+// on a closed window and there is no data in flight whose acknowledgement
+// would bring news of the window opening. Must hold s.mu
+func (s *Socket) armPersistTimer() {
+	if !s.rtx.empty() {
+		return
+	}
+	if s.persistBackoff == 0 {
+		s.persistBackoff = s.rto.timeout()
+	}
+	if s.persistTimer == nil {
+		s.persistTimer = s.clock.AfterFunc(s.persistBackoff, s.onPersistTimeout)
+		return
+	}
+	s.persistTimer.Reset(s.persistBackoff)
+}
+
+// onPersistTimeout probes the remote socket's window, backing off
+// exponentially between probes for as long as the window stays closed.
+// Unanswered probes do not count towards the retransmission limit
+func (s *Socket) onPersistTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// probes are only ever sent with nothing in flight, so
+	// that the byte they carry is known to be acknowledged
+	if s.closed || s.err != nil || !s.blocked || s.sndWnd > 0 || !s.rtx.empty() {
+		return
+	}
+
+	if err := s.packetizer.SendProbe(); err != nil {
+		log.Printf("[rdtp socket %s] Error probing window: %s", s.ID(), err)
+	}
+	s.persistBackoff = clamp(s.persistBackoff*2, minRTO, maxRTO)
+	s.armPersistTimer()
+}
@@ -0,0 +1,222 @@
+package socket
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/congestion"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/adrianosela/rdtp/packet/factory"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockNetwork records the packets sent through it
+type mockNetwork struct {
+	mu   sync.Mutex
+	sent []*packet.Packet
+}
+
+func (n *mockNetwork) Send(p *packet.Packet) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, p)
+	return nil
+}
+
+func (n *mockNetwork) StartReceiver(fn func(p *packet.Packet) error) {}
+
+func (n *mockNetwork) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.sent)
+}
+
+func newTestSocket(t *testing.T, cc string) (*Socket, *mockNetwork) {
+	return newTestSocketWithConfig(t, Config{CongestionControl: cc})
+}
+
+func newTestSocketWithConfig(t *testing.T, c Config) (*Socket, *mockNetwork) {
+	app, _ := net.Pipe()
+	nw := &mockNetwork{}
+	c.LocalAddr = &rdtp.Addr{Host: "10.0.0.1", Port: 1}
+	c.RemoteAddr = &rdtp.Addr{Host: "10.0.0.2", Port: 2}
+	c.Application = app
+	c.Network = nw
+	s, err := New(c)
+	assert.Nil(t, err)
+	t.Cleanup(s.stop)
+	return s, nw
+}
+
+func mockAckPacket(ackNo uint32, blocks ...packet.SACKBlock) *packet.Packet {
+	p, _ := packet.NewPacket(2, 1, nil)
+	p.Version = packet.MaxVersion
+	p.SetFlagACK()
+	p.SetAckNo(ackNo)
+	p.SetWindow(0xFFFF)
+	p.SetSACKBlocks(blocks)
+	return p
+}
+
+func TestNewInvalidCongestionControl(t *testing.T) {
+	app, _ := net.Pipe()
+	_, err := New(Config{
+		LocalAddr:         &rdtp.Addr{Host: "10.0.0.1", Port: 1},
+		RemoteAddr:        &rdtp.Addr{Host: "10.0.0.2", Port: 2},
+		Application:       app,
+		Network:           &mockNetwork{},
+		CongestionControl: "vegas",
+	})
+	assert.NotNil(t, err)
+}
+
+func TestSendRespectsCongestionWindow(t *testing.T) {
+	s, nw := newTestSocket(t, congestion.AlgorithmNewReno)
+	mss := packet.MaxPayloadBytes
+
+	s.mu.Lock()
+	s.sndWnd = 0xFFFF
+	s.mu.Unlock()
+
+	go s.send(make([]byte, 30*mss))
+
+	// the initial window goes out and nothing more
+	assert.Eventually(t, func() bool { return nw.count() == 10 }, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, 10, nw.count())
+
+	// acknowledging two segments lets four more out in slow start
+	s.handle(mockAckPacket(uint32(2 * mss)))
+	assert.Eventually(t, func() bool { return nw.count() == 14 }, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, 14, nw.count())
+}
+
+func TestSendBufferBoundsUnacknowledged(t *testing.T) {
+	s, nw := newTestSocketWithConfig(t, Config{SendBufferSize: 3 * packet.MaxPayloadBytes})
+	mss := packet.MaxPayloadBytes
+
+	s.mu.Lock()
+	s.sndWnd = 0xFFFF
+	s.mu.Unlock()
+
+	go s.send(make([]byte, 10*mss))
+
+	// no more is sent than the send buffer holds
+	assert.Eventually(t, func() bool { return nw.count() == 3 }, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, 3, nw.count())
+
+	// until some of it is acknowledged
+	s.handle(mockAckPacket(uint32(mss)))
+	assert.Eventually(t, func() bool { return nw.count() == 4 }, time.Second, time.Millisecond)
+}
+
+func TestRepairReducesWindowOncePerRoundTrip(t *testing.T) {
+	s, nw := newTestSocket(t, congestion.AlgorithmNewReno)
+	mss := uint32(packet.MaxPayloadBytes)
+
+	s.mu.Lock()
+	s.sndWnd = 0xFFFF
+	s.mu.Unlock()
+
+	go s.send(make([]byte, 10*mss))
+	assert.Eventually(t, func() bool { return nw.count() == 10 }, time.Second, time.Millisecond)
+
+	// the first segment is lost
+	s.handle(mockAckPacket(0, packet.SACKBlock{Start: mss, End: 4 * mss}))
+	assert.Equal(t, 11, nw.count())
+	assert.Equal(t, uint32(0), nw.sent[10].SeqNo)
+
+	s.mu.Lock()
+	assert.True(t, s.recovery)
+	assert.Equal(t, 10*mss, s.recoveryPoint)
+	assert.Equal(t, int(5*mss), s.cc.Window())
+	s.mu.Unlock()
+
+	// so is the fifth, in the same round trip
+	s.handle(mockAckPacket(4*mss, packet.SACKBlock{Start: 5 * mss, End: 8 * mss}))
+	assert.Equal(t, 12, nw.count())
+	assert.Equal(t, 4*mss, nw.sent[11].SeqNo)
+
+	s.mu.Lock()
+	assert.Equal(t, int(5*mss), s.cc.Window())
+	s.mu.Unlock()
+
+	// recovery ends once everything outstanding
+	// at the time of the first loss is acknowledged
+	s.handle(mockAckPacket(10 * mss))
+	s.mu.Lock()
+	assert.False(t, s.recovery)
+	s.mu.Unlock()
+}
+
+func TestDelayBasedCongestionControlTimestamps(t *testing.T) {
+	s, nw := newTestSocket(t, congestion.AlgorithmLEDBAT)
+
+	go s.send([]byte("background transfer"))
+	assert.Eventually(t, func() bool { return nw.count() == 1 }, time.Second, time.Millisecond)
+	nw.mu.Lock()
+	assert.True(t, nw.sent[0].IsTimestamped())
+	nw.mu.Unlock()
+
+	s, nw = newTestSocket(t, congestion.AlgorithmCUBIC)
+	go s.send([]byte("bulk transfer"))
+	assert.Eventually(t, func() bool { return nw.count() == 1 }, time.Second, time.Millisecond)
+	nw.mu.Lock()
+	assert.False(t, nw.sent[0].IsTimestamped())
+	nw.mu.Unlock()
+}
+
+func TestTimestampsEchoDelay(t *testing.T) {
+	s, nw := newTestSocket(t, congestion.AlgorithmCUBIC)
+
+	// an out-of-order segment is acknowledged right away
+	p := mockDataPacket(100, 10)
+	p.SetTimestamps(factory.Timestamp(time.Now().Add(-5*time.Millisecond)), 0)
+	s.handle(p)
+
+	assert.Equal(t, 1, nw.count())
+	ack := nw.sent[0]
+	assert.True(t, ack.IsTimestamped())
+	assert.GreaterOrEqual(t, ack.TimestampDelay, uint32(5000))
+	assert.Less(t, ack.TimestampDelay, uint32(time.Second/time.Microsecond))
+}
+
+func TestSendPacing(t *testing.T) {
+	mss := packet.MaxPayloadBytes
+
+	// a segment every 10ms
+	s, nw := newTestSocketWithConfig(t, Config{PacingRate: float64(100 * mss)})
+	s.mu.Lock()
+	s.sndWnd = 0xFFFF
+	s.mu.Unlock()
+
+	start := time.Now()
+	go s.send(make([]byte, 7*mss))
+	assert.Eventually(t, func() bool { return nw.count() == 7 }, time.Second, time.Millisecond)
+
+	// the first two segments go out as a burst
+	// and the rest are spaced out at the rate
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestPacingRate(t *testing.T) {
+	s, _ := newTestSocket(t, congestion.AlgorithmLEDBAT)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// unpaced until a round trip is measured
+	assert.Equal(t, float64(0), s.pacingRate())
+
+	// LEDBAT sets no rate of its own, so the window
+	// is spread over the smoothed round trip time
+	s.rto.sample(100 * time.Millisecond)
+	assert.InDelta(t, pacingGain*float64(s.cc.Window())/0.1, s.pacingRate(), 0.001)
+
+	s.fixedPacingRate = 1000
+	assert.Equal(t, float64(1000), s.pacingRate())
+}
@@ -2,14 +2,15 @@ package socket
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"net"
-	"os"
-	"os/signal"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/adrianosela/rdtp/congestion"
+	"github.com/adrianosela/rdtp/handshake"
 	"github.com/adrianosela/rdtp/network"
 	"github.com/adrianosela/rdtp/packet"
 	"github.com/adrianosela/rdtp/packet/factory"
@@ -17,17 +18,28 @@ import (
 )
 
 const (
-	inboundPacketChannelSize = 100
+	inboundQueueSize = 100
+
+	// defaultMaxRetransmissions is the number of times a segment
+	// is retransmitted before the connection is aborted
+	defaultMaxRetransmissions = 8
+
+	// defaultReceiveBufferSize is the default number of bytes
+	// a socket holds for reassembly and delivery, which is also
+	// the largest window which can be advertised in the header
+	defaultReceiveBufferSize = 65535
 )
 
+var errRetransmissionLimit = errors.New("connection aborted: retransmission limit reached")
+
 // Socket represents a socket abstraction and carries all
 // necessary info and statistics about the socket
 type Socket struct {
 	lAddr *rdtp.Addr // local rdtp address
 	rAddr *rdtp.Addr // remote rdtp address
 
-	txBytes uint32 // current sequence number
-	rxBytes uint32 // current ack number
+	txBytes uint32 // bytes sent (stats)
+	rxBytes uint32 // bytes received (stats)
 
 	// connection to app layer
 	application net.Conn
@@ -35,18 +47,133 @@ type Socket struct {
 	// packetizes and forwards to network layer
 	packetizer *factory.PacketFactory
 
+	// connection to network layer
+	network network.Network
+
+	// source of time for all timers and measurements
+	clock clock.Clock
+
+	// picks the initial sequence number of the connection
+	isn *handshake.ISNGenerator
+
+	// guards the reliability state below
+	mu sync.Mutex
+
+	// unacknowledged segments and the timer
+	// which drives their retransmission
+	rtx                *retransmissionQueue
+	rto                *rtoEstimator
+	rtxTimer           clock.Timer
+	rtxDeadline        time.Time
+	maxRetransmissions int
+
+	// latest cumulative acknowledgement and
+	// window received from the remote socket
+	lastAck uint32
+	sndWnd  int
+
+	// most bytes held for retransmission at once, if set,
+	// however large the remote's window
+	sndBuf int
+
+	// limits the data in flight to what the network can take,
+	// reducing the window at most once per round trip of losses
+	cc            congestion.Controller
+	recovery      bool
+	recoveryPoint uint32
+
+	// spaces packets out, at a fixed rate if set
+	pacer           pacer
+	pacingTimer     clock.Timer
+	pacing          bool
+	fixedPacingRate float64
+
+	// largest segment the local end receives, and the search
+	// for the largest the path takes, if the remote answers
+	// path MTU probes (nil otherwise)
+	localMSS  int
+	pmtu      *pmtuSearch
+	pmtuTimer clock.Timer
+
+	// probes a zero window while transmission is blocked
+	blocked        bool
+	persistTimer   clock.Timer
+	persistBackoff time.Duration
+
+	// signalled whenever an acknowledgement or window update
+	// is received, or the socket is closed or aborted
+	sendCond clock.Cond
+
+	// puts inbound segments back in order
+	rcv *reassemblyBuffer
+
+	// in-order data waiting to be written to the application,
+	// and whether delivery to the application is due
+	unread   [][]byte
+	readable bool
+
+	// in-order segments received but not yet acknowledged
+	// and the last window advertised to the remote socket
+	ackPending int
+	ackTimer   clock.Timer
+	advertised int
+
+	// set once the remote's initial sequence number is known,
+	// from which on its FINs must fall within the receive window
+	synchronized bool
+
+	// state of the connection, which moves on as it is
+	// opened and closed by either end
+	state State
+
+	// closing one way at a time, from version 1 on: the FIN sent
+	// and retransmitted until acknowledged, and whether the remote's
+	// was received and passed on to the application as EOF
+	finSent       bool
+	finSeq        uint32
+	finAcked      bool
+	finRetries    int
+	finTimer      clock.Timer
+	finWait2Timer clock.Timer
+	finReceived   bool
+	eofDelivered  bool
+
+	// when the remote was last heard from, and the probes sent to
+	// it once not heard from for the keepalive period, if set, to
+	// find out whether the connection is still alive
+	lastHeard       time.Time
+	keepAlive       time.Duration
+	keepAliveTimer  clock.Timer
+	keepAliveProbes int
+
+	// latest timestamp received from the remote, if it stamps any,
+	// which tells its later connections apart in TIME_WAIT
+	tsRecent    uint32
+	timestamped bool
+
+	// set when the socket is closed or aborted
+	closed bool
+	err    error
+
 	// packets received at the network
 	// are ultimately delivered in this
-	// channel to be read by the socket
+	// queue to be read by the socket
 	// and be written as messages to
 	// the application layer
-	inbound chan *packet.Packet
+	inbound []*packet.Packet
+
+	// set to notify the socket of shutdown,
+	// either by the application or the remote
+	shutdown bool
 
-	// used to notify socket of shutdown
-	shutdown chan bool
+	// set once the Run loop stops receiving and delivering,
+	// and while delivery to the application is still running
+	stopping   bool
+	delivering bool
 
-	// used to notify socket of fin received
-	fin chan bool
+	// signalled whenever a packet is queued inbound, delivery to
+	// the application is due, or the socket shuts down or aborts
+	events clock.Cond
 }
 
 // Config is the necessary configuration to initialize a socket
@@ -59,6 +186,40 @@ type Config struct {
 
 	// connection to network layer
 	Network network.Network
+
+	// number of retransmissions of a segment after which
+	// the connection is aborted (defaults to 8 if zero)
+	MaxRetransmissions int
+
+	// maximum number of bytes held for reassembly and
+	// delivery to the application, which bounds the
+	// window advertised (defaults to 65535 if zero)
+	ReceiveBufferSize int
+
+	// maximum number of bytes sent but not yet acknowledged, held
+	// for retransmission (bounded by the remote's window if zero)
+	SendBufferSize int
+
+	// congestion control algorithm (defaults to CUBIC if empty),
+	// see the congestion package for those available
+	CongestionControl string
+
+	// fixed rate in bytes per second at which packets are spaced
+	// out (derived from congestion control if zero)
+	PacingRate float64
+
+	// period after which a remote not heard from is probed, and the
+	// connection aborted if it stays silent (disabled if zero)
+	KeepAlive time.Duration
+
+	// source of time, e.g. a simulated clock to run the
+	// socket on simulated time (defaults to real time if nil)
+	Clock clock.Clock
+
+	// secret key initial sequence numbers are derived from, e.g. so
+	// that a simulation replays exactly (defaults to a random key
+	// shared by all sockets of the process if nil)
+	ISNKey []byte
 }
 
 // New is the socket constructor
@@ -66,8 +227,12 @@ func New(c Config) (*Socket, error) {
 	if c.LocalAddr == nil || net.ParseIP(c.LocalAddr.Host) == nil {
 		return nil, errors.New("invalid local address")
 	}
-	if c.RemoteAddr == nil || net.ParseIP(c.LocalAddr.Host) == nil {
-		return nil, errors.New("remote address cannot be nil")
+	if c.RemoteAddr == nil || net.ParseIP(c.RemoteAddr.Host) == nil {
+		return nil, errors.New("invalid remote address")
+	}
+	lhost, rhost := net.ParseIP(c.LocalAddr.Host), net.ParseIP(c.RemoteAddr.Host)
+	if (lhost.To4() == nil) != (rhost.To4() == nil) {
+		return nil, errors.New("local and remote addresses are of different address families")
 	}
 	if c.Application == nil {
 		return nil, errors.New("connection to application layer cannot be nil")
@@ -76,26 +241,74 @@ func New(c Config) (*Socket, error) {
 		return nil, errors.New("connection to network layer cannot be nil")
 	}
 
-	toNetwork := func(p *packet.Packet) error {
-		p.SetSourceIPv4(net.ParseIP(c.LocalAddr.Host))
-		p.SetDestinationIPv4(net.ParseIP(c.RemoteAddr.Host))
-		return c.Network.Send(p)
-	}
-
-	return &Socket{
-		lAddr:       c.LocalAddr,
-		rAddr:       c.RemoteAddr,
-		application: c.Application,
-		packetizer: factory.DefaultPacketFactory(
-			net.ParseIP(c.LocalAddr.Host),
-			net.ParseIP(c.RemoteAddr.Host),
-			uint16(c.LocalAddr.Port),
-			uint16(c.RemoteAddr.Port),
-			toNetwork),
-		inbound:  make(chan *packet.Packet, inboundPacketChannelSize),
-		shutdown: make(chan bool, 1),
-		fin:      make(chan bool, 1),
-	}, nil
+	maxRetransmissions := c.MaxRetransmissions
+	if maxRetransmissions <= 0 {
+		maxRetransmissions = defaultMaxRetransmissions
+	}
+
+	receiveBufferSize := c.ReceiveBufferSize
+	if receiveBufferSize <= 0 {
+		receiveBufferSize = defaultReceiveBufferSize
+	}
+
+	cc, err := congestion.New(c.CongestionControl, packet.MaxPayloadBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid congestion control")
+	}
+
+	clk := c.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	// the largest segment received is the largest which
+	// makes it to the local end in an unfragmented packet
+	localMSS := network.MaxPacketSize(c.Network, rhost) - packet.ExtendedHeaderByteSize
+	if localMSS > packet.MaxPayloadBytes {
+		localMSS = packet.MaxPayloadBytes
+	}
+	if localMSS < minMSS {
+		localMSS = minMSS
+	}
+
+	s := &Socket{
+		// hosts are normalized for the socket ID to match that of its packets
+		lAddr:              &rdtp.Addr{Host: lhost.String(), Port: c.LocalAddr.Port},
+		rAddr:              &rdtp.Addr{Host: rhost.String(), Port: c.RemoteAddr.Port},
+		application:        c.Application,
+		network:            c.Network,
+		clock:              clk,
+		isn:                handshake.NewISNGenerator(c.ISNKey, clk),
+		rtx:                newRetransmissionQueue(),
+		rto:                newRTOEstimator(),
+		rcv:                newReassemblyBuffer(0, receiveBufferSize),
+		maxRetransmissions: maxRetransmissions,
+		sndWnd:             packet.MaxPayloadBytes,
+		sndBuf:             c.SendBufferSize,
+		cc:                 cc,
+		fixedPacingRate:    c.PacingRate,
+		keepAlive:          c.KeepAlive,
+		localMSS:           localMSS,
+	}
+	s.sendCond = clk.NewCond(&s.mu)
+	s.events = clk.NewCond(&s.mu)
+	s.packetizer = factory.DefaultPacketFactory(
+		lhost,
+		rhost,
+		uint16(c.LocalAddr.Port),
+		uint16(c.RemoteAddr.Port),
+		s.forward)
+	s.packetizer.SetClock(clk)
+	s.packetizer.AdvertiseMSS(uint16(localMSS))
+	s.advertise()
+
+	// delay based congestion control needs the remote
+	// to measure the one-way delay of outbound packets
+	if _, ok := cc.(congestion.DelayBased); ok {
+		s.packetizer.EnableTimestamps()
+	}
+
+	return s, nil
 }
 
 // ID returns the of unique identifier of the socket
@@ -118,71 +331,150 @@ func (s *Socket) Close() {
 	s.application.Close()
 }
 
-// Deliver delivers a packet to a socket's inbound packet channel
+// Deliver delivers a packet to a socket's inbound packet queue.
+// Packets of another version than the connection's are dropped,
+// and FINs and resets unless their sequence number falls
+// within the receive window, so that connections cannot be shut
+// down or reset blindly. Packets are dropped once the connection
+// is over, as they are no longer read, and while the queue is
+// full, as they would be by a congested network, rather than
+// holding up the network's receiver
 func (s *Socket) Deliver(p *packet.Packet) {
-	if p.IsFIN() && !p.IsACK() {
-		s.fin <- true
-		s.shutdown <- true
+	if !s.acceptableVersion(p) {
+		log.Printf("[rdtp socket %s] Dropped packet of version %d", s.ID(), p.Version)
 		return
 	}
-	s.inbound <- p
+	if p.IsFIN() && !s.acceptableControl(p) {
+		log.Printf("[rdtp socket %s] Dropped FIN with sequence number %d outside the receive window", s.ID(), p.SeqNo)
+		return
+	}
+	if p.IsERR() && !s.acceptableControl(p) {
+		log.Printf("[rdtp socket %s] Dropped reset with sequence number %d outside the receive window", s.ID(), p.SeqNo)
+		return
+	}
+
+	s.mu.Lock()
+	over := s.overLocked()
+	// a FIN of version 0 on an open connection closes it from the
+	// remote, while any other is part of the closing handshake.
+	// FINs of later versions are received in order with data
+	closeWait := !over && p.IsFIN() && !p.IsACK() && s.state == StateEstablished &&
+		s.packetizer.Version() == packet.Version0
+	if closeWait {
+		s.transitionLocked(eventFIN)
+		s.signalShutdown()
+	}
+	if !over && p.IsTimestamped() {
+		s.tsRecent, s.timestamped = p.Timestamp, true
+	}
+	if !over && !closeWait && len(s.inbound) < inboundQueueSize {
+		s.inbound = append(s.inbound, p)
+		s.events.Broadcast()
+	}
+	s.mu.Unlock()
 }
 
-// Run kicks-off socket processes
-func (s *Socket) Run() {
-	done := make(chan bool, 1)
-
-	go s.receive(done)
-	go s.transmit()
-
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-
-	for {
-		select {
-		case <-sigs:
-		case <-s.shutdown:
-			done <- true
-			s.finish()
-			close(s.inbound)
-			close(s.shutdown)
-			close(s.fin)
-			return
-		}
+// nextInbound removes the earliest packet from the inbound
+// queue, if there is any. Must hold s.mu
+func (s *Socket) nextInbound() (*packet.Packet, bool) {
+	if len(s.inbound) == 0 {
+		return nil, false
 	}
+	p := s.inbound[0]
+	s.inbound[0] = nil
+	s.inbound = s.inbound[1:]
+	return p, true
+}
+
+// overLocked returns true if the socket is done with its connection,
+// after it was closed or aborted. Must hold s.mu
+func (s *Socket) overLocked() bool {
+	return (s.closed || s.err != nil) && (s.state == StateClosed || s.state == StateTimeWait)
+}
+
+// signalShutdown notifies the socket's Run loop
+// of shutdown. Must hold s.mu
+func (s *Socket) signalShutdown() {
+	s.shutdown = true
+	s.events.Broadcast()
 }
 
-func (s *Socket) receive(done chan bool) {
-	for {
-		select {
-		case <-done:
-			close(done)
-			return
-		case p := <-s.inbound:
-			s.rxBytes += uint32(p.Length)  // stats
-			s.application.Write(p.Payload) // pass packet to application layer
+// Run kicks-off socket processes. It returns an
+// error if the connection was aborted rather than closed
+func (s *Socket) Run() error {
+	s.mu.Lock()
+	s.startPMTUDiscovery()
+	s.delivering = true
+	s.mu.Unlock()
+	s.startKeepAlive()
+
+	s.clock.Go(s.receive)
+	s.clock.Go(s.deliver)
+	s.clock.Go(s.transmit)
+
+	s.mu.Lock()
+	for s.err == nil && !s.shutdown {
+		s.events.Wait()
+	}
+	s.stopping = true
+	s.events.Broadcast()
+	err := s.err
+	s.mu.Unlock()
+
+	if err != nil {
+		// closing the application connection unblocks the
+		// application's pending reads and writes, which
+		// return why the connection aborted if they can
+		if ec, ok := s.application.(errorCloser); ok {
+			ec.CloseWithError(err)
+		} else {
+			s.application.Close()
 		}
+		return err
 	}
+
+	s.stop()
+	if err := s.finish(); err != nil {
+		log.Printf("[rdtp socket %s] Error closing connection: %s", s.ID(), err)
+	}
+	s.mu.Lock()
+	for s.delivering {
+		s.events.Wait()
+	}
+	s.mu.Unlock()
+	s.deliverRest()
+	return nil
 }
 
-func (s *Socket) transmit() {
-	buf := make([]byte, 1500)
-	for {
-		n, err := s.application.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				s.shutdown <- true
-				return
-			}
-			continue
-		}
+// stop stops the socket's timers and wakes up any
+// transmission waiting for the remote's window
+func (s *Socket) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		n, err = s.packetizer.PackAndForwardMessage(buf[:n])
-		if err != nil {
-			log.Printf("[rdtp socket %s] Error packetizing and forwarding message: %s", s.ID(), err)
-			return
+	s.closed = true
+	s.stopTimers()
+	s.sendCond.Broadcast()
+}
+
+// stopTimers stops all of the socket's timers. Must hold s.mu
+func (s *Socket) stopTimers() {
+	for _, t := range []clock.Timer{s.rtxTimer, s.persistTimer, s.ackTimer, s.pacingTimer, s.pmtuTimer, s.finTimer, s.finWait2Timer, s.keepAliveTimer} {
+		if t != nil {
+			t.Stop()
 		}
+	}
+}
 
-		s.txBytes += uint32(n) // stats
+// abortLocked terminates the connection without a closing
+// handshake and notifies the socket's Run loop. Must hold s.mu
+func (s *Socket) abortLocked(err error) {
+	if s.err != nil {
+		return
 	}
+	s.err = err
+	s.transitionLocked(eventAbort)
+	s.stopTimers()
+	s.sendCond.Broadcast()
+	s.events.Broadcast()
 }
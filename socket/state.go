@@ -0,0 +1,208 @@
+package socket
+
+import (
+	"fmt"
+
+	"github.com/adrianosela/rdtp/packet"
+)
+
+// State is the state of a socket's connection, as that of a TCP
+// connection (RFC 793), from opening through closing
+type State int
+
+const (
+	// StateClosed is the state of sockets yet to open
+	// a connection, and of those done with theirs
+	StateClosed State = iota
+
+	// StateListen is the state of sockets waiting for a SYN
+	StateListen
+
+	// StateSynSent is the state of sockets which sent a SYN
+	// and wait for it to be answered
+	StateSynSent
+
+	// StateSynReceived is the state of sockets which answered a
+	// SYN and wait for their own SYN to be acknowledged
+	StateSynReceived
+
+	// StateEstablished is the state of open connections
+	StateEstablished
+
+	// StateFinWait1 is the state of sockets which sent a FIN
+	// and wait for it to be acknowledged
+	StateFinWait1
+
+	// StateFinWait2 is the state of sockets whose FIN was
+	// acknowledged and which wait for the remote's FIN
+	StateFinWait2
+
+	// StateCloseWait is the state of sockets which received
+	// a FIN and wait for their application to close
+	StateCloseWait
+
+	// StateClosing is the state of sockets whose FIN crossed
+	// the remote's, and wait for it to be acknowledged
+	StateClosing
+
+	// StateLastAck is the state of sockets which answered a FIN
+	// with their own and wait for it to be acknowledged
+	StateLastAck
+
+	// StateTimeWait is the state of sockets done with their
+	// connection whose remote may still retransmit its FIN
+	StateTimeWait
+)
+
+var stateNames = map[State]string{
+	StateClosed:      "CLOSED",
+	StateListen:      "LISTEN",
+	StateSynSent:     "SYN_SENT",
+	StateSynReceived: "SYN_RCVD",
+	StateEstablished: "ESTABLISHED",
+	StateFinWait1:    "FIN_WAIT_1",
+	StateFinWait2:    "FIN_WAIT_2",
+	StateCloseWait:   "CLOSE_WAIT",
+	StateClosing:     "CLOSING",
+	StateLastAck:     "LAST_ACK",
+	StateTimeWait:    "TIME_WAIT",
+}
+
+// String returns the name of the state as in RFC 793, e.g. "SYN_SENT"
+func (st State) String() string {
+	if name, ok := stateNames[st]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(st))
+}
+
+// event moves a connection from one state to another: either an
+// action of the application or a control packet from the remote
+type event int
+
+const (
+	eventConnect event = iota // application dials, SYN sent
+	eventListen               // application accepts
+	eventClose                // application done sending, FIN sent
+	eventSYN                  // SYN received
+	eventSYNACK               // SYN ACK received
+	eventACK                  // ACK received
+	eventFIN                  // FIN received
+	eventFINACK               // FIN ACK received
+	eventTimeout              // TIME_WAIT over
+	eventAbort                // reset received or sent, or handshake failed
+)
+
+var eventNames = map[event]string{
+	eventConnect: "connect",
+	eventListen:  "listen",
+	eventClose:   "close",
+	eventSYN:     "SYN",
+	eventSYNACK:  "SYN ACK",
+	eventACK:     "ACK",
+	eventFIN:     "FIN",
+	eventFINACK:  "FIN ACK",
+	eventTimeout: "timeout",
+	eventAbort:   "abort",
+}
+
+func (ev event) String() string {
+	return eventNames[ev]
+}
+
+// eventOf returns the event of a control packet received
+func eventOf(p *packet.Packet) event {
+	switch {
+	case p.IsERR():
+		return eventAbort
+	case p.IsSYN() && p.IsACK():
+		return eventSYNACK
+	case p.IsSYN():
+		return eventSYN
+	case p.IsFIN() && p.IsACK():
+		return eventFINACK
+	case p.IsFIN():
+		return eventFIN
+	default:
+		return eventACK
+	}
+}
+
+type transition struct {
+	from State
+	on   event
+}
+
+// transitions gives the state each event moves a connection to from
+// each state it is valid in. Aborting is valid in any state, and
+// moves the connection to StateClosed
+var transitions = map[transition]State{
+	// opening
+	{StateClosed, eventConnect}:     StateSynSent,
+	{StateClosed, eventListen}:      StateListen,
+	{StateListen, eventSYN}:         StateSynReceived,
+	{StateSynSent, eventSYNACK}:     StateEstablished,
+	{StateSynSent, eventSYN}:        StateSynReceived, // simultaneous open
+	{StateSynReceived, eventACK}:    StateEstablished,
+	{StateSynReceived, eventSYNACK}: StateEstablished, // simultaneous open
+
+	// closing
+	{StateListen, eventClose}:      StateClosed,
+	{StateSynSent, eventClose}:     StateClosed,
+	{StateSynReceived, eventClose}: StateFinWait1,
+	{StateEstablished, eventClose}: StateFinWait1,
+	{StateEstablished, eventFIN}:   StateCloseWait,
+	{StateFinWait1, eventACK}:      StateFinWait2,
+	{StateFinWait1, eventFINACK}:   StateTimeWait,
+	{StateFinWait1, eventFIN}:      StateClosing, // simultaneous close
+	{StateFinWait2, eventFIN}:      StateTimeWait,
+	{StateCloseWait, eventClose}:   StateLastAck,
+	{StateClosing, eventACK}:       StateTimeWait,
+	{StateLastAck, eventACK}:       StateClosed,
+	{StateTimeWait, eventTimeout}:  StateClosed,
+}
+
+// nextState returns the state an event moves a connection to
+// from the given state, or an error if it is not valid in it
+func nextState(from State, ev event) (State, error) {
+	if ev == eventAbort {
+		return StateClosed, nil
+	}
+	to, ok := transitions[transition{from, ev}]
+	if !ok {
+		return from, fmt.Errorf("unexpected %s in state %s", ev, from)
+	}
+	return to, nil
+}
+
+// State returns the state of the socket's connection
+func (s *Socket) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state
+}
+
+// transition moves the socket's connection on an event
+func (s *Socket) transition(ev event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transitionLocked(ev)
+}
+
+// transitionLocked moves the socket's connection on an event. Must hold s.mu
+func (s *Socket) transitionLocked(ev event) error {
+	to, err := nextState(s.state, ev)
+	if err != nil {
+		return err
+	}
+	s.state = to
+	return nil
+}
+
+// step moves the socket's connection on a control packet
+// received during a handshake
+func (s *Socket) step(p *packet.Packet) error {
+	return s.transition(eventOf(p))
+}
@@ -0,0 +1,134 @@
+package socket
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/rdtp"
+	"github.com/adrianosela/rdtp/network"
+	"github.com/adrianosela/rdtp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextState(t *testing.T) {
+	for tr, want := range transitions {
+		got, err := nextState(tr.from, tr.on)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got, "%s in state %s", tr.on, tr.from)
+	}
+
+	// events not valid in a state leave it unchanged
+	for _, tr := range []transition{
+		{StateClosed, eventSYN},
+		{StateListen, eventSYNACK},
+		{StateEstablished, eventSYN},
+		{StateCloseWait, eventFIN},
+		{StateTimeWait, eventACK},
+	} {
+		got, err := nextState(tr.from, tr.on)
+		assert.NotNil(t, err)
+		assert.Equal(t, tr.from, got)
+	}
+
+	// aborting closes the connection from any state
+	for st := range stateNames {
+		got, err := nextState(st, eventAbort)
+		assert.Nil(t, err)
+		assert.Equal(t, StateClosed, got)
+	}
+}
+
+func TestStateString(t *testing.T) {
+	assert.Equal(t, "SYN_RCVD", StateSynReceived.String())
+	assert.Equal(t, "TIME_WAIT", StateTimeWait.String())
+	assert.Equal(t, "State(42)", State(42).String())
+}
+
+// newSwitchedSocket returns a socket attached to a switch
+// which delivers the packets it receives to it
+func newSwitchedSocket(t *testing.T, sw *network.Switch, local, remote *rdtp.Addr) *Socket {
+	port, err := sw.Attach(net.ParseIP(local.Host))
+	assert.Nil(t, err)
+	t.Cleanup(port.Detach)
+
+	app, _ := net.Pipe()
+	s, err := New(Config{
+		LocalAddr:   local,
+		RemoteAddr:  remote,
+		Application: app,
+		Network:     port,
+	})
+	assert.Nil(t, err)
+	t.Cleanup(s.stop)
+
+	port.StartReceiver(func(p *packet.Packet) error {
+		s.Deliver(p)
+		return nil
+	})
+	return s
+}
+
+func TestSimultaneousOpen(t *testing.T) {
+	sw := network.NewSwitch()
+	addrA := &rdtp.Addr{Host: "10.0.0.1", Port: 1}
+	addrB := &rdtp.Addr{Host: "10.0.0.2", Port: 2}
+	a := newSwitchedSocket(t, sw, addrA, addrB)
+	b := newSwitchedSocket(t, sw, addrB, addrA)
+
+	// both ends dial each other at once, their SYNs crossing
+	errs := make(chan error, 2)
+	for _, s := range []*Socket{a, b} {
+		go func(s *Socket) { errs <- s.Dial() }(s)
+	}
+	assert.Nil(t, <-errs)
+	assert.Nil(t, <-errs)
+
+	assert.Equal(t, StateEstablished, a.State())
+	assert.Equal(t, StateEstablished, b.State())
+}
+
+func TestAbortClosesConnection(t *testing.T) {
+	s, _ := newTestSocket(t, "")
+	s.state = StateEstablished
+
+	s.mu.Lock()
+	s.abortLocked(assert.AnError)
+	s.mu.Unlock()
+
+	assert.Equal(t, StateClosed, s.State())
+}
+
+func TestDeliverDoesNotBlock(t *testing.T) {
+	s, _ := newEstablishedSocket(t)
+
+	// packets delivered while no one reads them fill up
+	// the inbound queue, and are dropped from then on
+	delivered := make(chan bool)
+	go func() {
+		for i := 0; i <= inboundQueueSize; i++ {
+			s.Deliver(mockDataPacket(7000+uint32(i), 1))
+		}
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("delivery blocked on a full inbound queue")
+	}
+	assert.Len(t, s.inbound, inboundQueueSize)
+}
+
+func TestDeliverDropsOtherVersions(t *testing.T) {
+	s, _ := newEstablishedSocket(t)
+
+	// packets of another version than the connection's are dropped,
+	// as they are not of the connection even if their sum verifies
+	p := mockDataPacket(7000, 1)
+	p.Version = packet.Version0
+	s.Deliver(p)
+	assert.Empty(t, s.inbound)
+
+	s.Deliver(mockDataPacket(7000, 1))
+	assert.Len(t, s.inbound, 1)
+}
@@ -0,0 +1,26 @@
+package socket
+
+// TimeWait is what is held of a connection in TIME_WAIT once its socket
+// is gone, to tell new connections from the remote on the same addresses
+// apart from stray segments of the old one (RFC 6191)
+type TimeWait struct {
+	NextSeqNo   uint32 // sequence number expected next from the remote
+	Timestamp   uint32 // latest timestamp received from the remote
+	Timestamped bool   // whether the remote stamps timestamps at all
+}
+
+// TimeWait returns what is to be held of the socket's connection
+// in TIME_WAIT, or false if the connection is not in TIME_WAIT
+func (s *Socket) TimeWait() (TimeWait, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != StateTimeWait {
+		return TimeWait{}, false
+	}
+	return TimeWait{
+		NextSeqNo:   s.rcv.next,
+		Timestamp:   s.tsRecent,
+		Timestamped: s.timestamped,
+	}, true
+}
@@ -0,0 +1,87 @@
+package socket
+
+import (
+	"sync"
+	"time"
+)
+
+// pacingWheel schedules the pacing of all sockets in the process
+var pacingWheel = newTimerWheel(time.Millisecond, 256)
+
+// timedFunc is a callback due once the wheel has gone round rounds times
+type timedFunc struct {
+	rounds int
+	fn     func()
+}
+
+// timerWheel runs callbacks after a delay, rounded up to its tick. A
+// single goroutine serves all of the callbacks scheduled, however many
+// sockets schedule them, and only runs while there are any pending
+type timerWheel struct {
+	mu      sync.Mutex
+	tick    time.Duration
+	slots   [][]timedFunc
+	pos     int
+	pending int
+}
+
+func newTimerWheel(tick time.Duration, slots int) *timerWheel {
+	return &timerWheel{tick: tick, slots: make([][]timedFunc, slots)}
+}
+
+// schedule runs fn in its own goroutine once d has elapsed
+func (w *timerWheel) schedule(d time.Duration, fn func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	slot := (w.pos + ticks) % len(w.slots)
+	rounds := (ticks - 1) / len(w.slots)
+	w.slots[slot] = append(w.slots[slot], timedFunc{rounds: rounds, fn: fn})
+
+	w.pending++
+	if w.pending == 1 {
+		go w.run()
+	}
+}
+
+// run turns the wheel until there are no callbacks left
+func (w *timerWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !w.advance() {
+			return
+		}
+	}
+}
+
+// advance moves the wheel on by a tick and runs the callbacks due.
+// It returns false once there are no callbacks left
+func (w *timerWheel) advance() bool {
+	w.mu.Lock()
+	w.pos = (w.pos + 1) % len(w.slots)
+	var due []func()
+	remaining := w.slots[w.pos][:0]
+	for _, t := range w.slots[w.pos] {
+		if t.rounds > 0 {
+			t.rounds--
+			remaining = append(remaining, t)
+			continue
+		}
+		due = append(due, t.fn)
+	}
+	w.slots[w.pos] = remaining
+	w.pending -= len(due)
+	more := w.pending > 0
+	w.mu.Unlock()
+
+	for _, fn := range due {
+		go fn()
+	}
+	return more
+}
@@ -0,0 +1,74 @@
+package socket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimerWheelSchedule(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 8)
+
+	var mu sync.Mutex
+	var fired []int
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, ms := range []int{20, 0, 5, 3} {
+		ms := ms
+		wg.Add(1)
+		w.schedule(time.Duration(ms)*time.Millisecond, func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			fired = append(fired, ms)
+			// never early
+			assert.GreaterOrEqual(t, time.Since(start), time.Duration(ms)*time.Millisecond)
+		})
+	}
+	wg.Wait()
+
+	// including the 20ms callback, which took more than a turn of the wheel
+	assert.ElementsMatch(t, []int{0, 3, 5, 20}, fired)
+}
+
+func TestTimerWheelStopsWhenIdle(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 8)
+
+	done := make(chan bool)
+	w.schedule(time.Millisecond, func() { done <- true })
+	<-done
+
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.pending == 0
+	}, time.Second, time.Millisecond)
+
+	// and starts turning again when needed
+	w.schedule(time.Millisecond, func() { done <- true })
+	<-done
+}
+
+func TestTimerWheelAdvance(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 4)
+
+	fired := make(chan int, 10)
+	w.mu.Lock()
+	w.pending++ // keep the wheel from turning on its own
+	w.mu.Unlock()
+	for _, ticks := range []int{1, 4, 5, 9} {
+		ticks := ticks
+		w.schedule(time.Duration(ticks)*time.Millisecond, func() { fired <- ticks })
+	}
+
+	for tick := 1; tick <= 9; tick++ {
+		w.advance()
+		switch tick {
+		case 1, 4, 5, 9:
+			assert.Equal(t, tick, <-fired)
+		}
+		assert.Len(t, fired, 0)
+	}
+}
@@ -0,0 +1,335 @@
+package rdtp
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/pkg/errors"
+)
+
+// Once a connection is established, the rdtp service writes its stream
+// to the client in frames, each headed by its type and the byte length
+// of its payload as a 4-byte big-endian integer, so that the remote
+// closing the connection can be told apart from the connection aborting
+const (
+	streamFrameData  byte = iota // bytes of the stream
+	streamFrameEnd               // the remote is done sending
+	streamFrameAbort             // the connection aborted, the payload says why
+
+	streamFrameHeaderBytes = 1 + messageLengthBytes
+
+	// maxStreamFrameBytes is the largest payload of a stream frame
+	maxStreamFrameBytes = 64 * 1024
+
+	// abortFrameTimeout bounds how long the service waits on a
+	// client which is not reading to tell it its connection aborted
+	abortFrameTimeout = time.Second
+)
+
+// abortError is the error reads and writes return once the
+// rdtp service aborted a connection, saying why it did
+type abortError string
+
+func (e abortError) Error() string { return string(e) }
+
+// Is makes errors.Is(err, ErrConnectionAborted) hold for aborts
+func (e abortError) Is(target error) bool { return target == ErrConnectionAborted }
+
+// ServiceConn is the rdtp service's end of a client's connection once
+// it is established, which frames the stream written to the client
+type ServiceConn struct {
+	net.Conn
+
+	// held while writing a frame, as a lock which waits
+	// on the clock rather than on the runtime (see lock)
+	mu      sync.Mutex
+	clock   clock.Clock
+	idle    clock.Cond
+	writing bool
+
+	buf    []byte
+	broken bool // a frame was cut short, so no other can follow it
+}
+
+// NewServiceConn returns the service's end of an established
+// connection of a client connected to the service over c
+func NewServiceConn(c net.Conn) *ServiceConn {
+	sc := &ServiceConn{Conn: c}
+	sc.SetClock(clock.Real{})
+	return sc
+}
+
+// SetClock sets the clock writes wait on and deadlines are set on,
+// e.g. the simulated clock of a service running on simulated time
+// (defaults to real time). It must be called before the connection
+// is used
+func (c *ServiceConn) SetClock(clk clock.Clock) {
+	c.clock = clk
+	c.idle = clk.NewCond(&c.mu)
+}
+
+// lock waits until no other frame is being written, and claims writing
+// the next. Writes to the client may wait on a simulated clock, which
+// must not find the goroutines waiting on the lock blocked in the runtime
+func (c *ServiceConn) lock() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.writing {
+		c.idle.Wait()
+	}
+	c.writing = true
+}
+
+// unlock lets the next frame be written
+func (c *ServiceConn) unlock() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writing = false
+	c.idle.Signal()
+}
+
+// Write writes data of the stream to the client
+func (c *ServiceConn) Write(b []byte) (n int, err error) {
+	c.lock()
+	defer c.unlock()
+
+	for len(b) > 0 {
+		size := len(b)
+		if size > maxStreamFrameBytes {
+			size = maxStreamFrameBytes
+		}
+		if err = c.writeFrame(streamFrameData, b[:size]); err != nil {
+			return n, err
+		}
+		n += size
+		b = b[size:]
+	}
+	return n, nil
+}
+
+// CloseWrite lets the client know that the remote is done sending: its
+// reads return io.EOF once it has read everything written before
+func (c *ServiceConn) CloseWrite() error {
+	c.lock()
+	defer c.unlock()
+	return c.writeFrame(streamFrameEnd, nil)
+}
+
+// CloseWithError closes the connection once it lets the client know that
+// it aborted and why, e.g. since the remote stopped answering. The
+// client's reads and writes return an error for which
+// errors.Is(err, ErrConnectionAborted) holds from then on
+func (c *ServiceConn) CloseWithError(reason error) error {
+	// a write held up by a client which is not reading would
+	// hold up the abort too, so it is made to fail right away
+	c.Conn.SetWriteDeadline(c.clock.Now())
+
+	c.lock()
+	defer c.unlock()
+	c.Conn.SetWriteDeadline(c.clock.Now().Add(abortFrameTimeout))
+	if err := c.writeFrame(streamFrameAbort, []byte(reason.Error())); err != nil {
+		// the client still learns of the abort, as the
+		// service closes the stream without ending it
+		c.Conn.Close()
+		return errors.Wrap(err, "could not write abort to client")
+	}
+	return c.Conn.Close()
+}
+
+// writeFrame writes a frame of the stream all at once. Must hold c (see lock)
+func (c *ServiceConn) writeFrame(frameType byte, payload []byte) error {
+	if c.broken {
+		return errors.New("stream to client cut short by an incomplete frame")
+	}
+	c.buf = append(c.buf[:0], frameType, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(c.buf[1:], uint32(len(payload)))
+	c.buf = append(c.buf, payload...)
+	n, err := c.Conn.Write(c.buf)
+	if err != nil && n > 0 {
+		c.broken = true
+	}
+	return err
+}
+
+// stream reads the frames the rdtp service writes to a client's
+// connection, passing on the bytes of the stream they carry
+type stream struct {
+	conn net.Conn
+
+	// held while reading from the service, as a lock which
+	// waits on the clock rather than on the runtime (see lock)
+	mu      sync.Mutex
+	idle    clock.Cond
+	reading bool
+
+	header     [streamFrameHeaderBytes]byte
+	headerRead int    // bytes of the current frame's header read
+	left       int    // bytes of the current frame's payload left to read
+	reason     []byte // payload read of the current abort frame
+	buffered   []byte // data read ahead of the application, see aborted
+	ended      bool   // whether the remote is done sending
+	err        error  // why the stream is over, if it is
+
+	closeMu     sync.Mutex
+	writeClosed bool
+}
+
+func newStream(conn net.Conn, clk clock.Clock) *stream {
+	s := &stream{conn: conn}
+	s.idle = clk.NewCond(&s.mu)
+	return s
+}
+
+// lock waits until no other goroutine reads from the service, and
+// claims reading from it. Reads from the service may wait on a simulated
+// clock, which must not find the goroutines waiting on the lock blocked
+// in the runtime
+func (s *stream) lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.reading {
+		s.idle.Wait()
+	}
+	s.reading = true
+}
+
+// unlock lets other goroutines read from the service
+func (s *stream) unlock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reading = false
+	s.idle.Signal()
+}
+
+// Read reads data of the stream
+func (s *stream) Read(b []byte) (int, error) {
+	s.lock()
+	defer s.unlock()
+
+	if len(s.buffered) > 0 {
+		n := copy(b, s.buffered)
+		s.buffered = s.buffered[n:]
+		return n, nil
+	}
+	if s.ended {
+		return 0, io.EOF
+	}
+	return s.read(b)
+}
+
+// read reads data of the stream from the service, going
+// through frames other than data frames. Must hold s (see lock)
+func (s *stream) read(b []byte) (int, error) {
+	for s.err == nil {
+		if s.headerRead < len(s.header) {
+			n, err := s.conn.Read(s.header[s.headerRead:])
+			s.headerRead += n
+			if err != nil {
+				return 0, s.fail(err)
+			}
+			if s.headerRead < len(s.header) {
+				continue
+			}
+			s.left = int(binary.BigEndian.Uint32(s.header[1:]))
+			if s.left > maxStreamFrameBytes {
+				s.err = errors.Errorf("frame of %d bytes from rdtp service exceeds maximum of %d", s.left, maxStreamFrameBytes)
+				break
+			}
+		}
+
+		switch s.header[0] {
+		case streamFrameData:
+			if s.left == 0 {
+				s.headerRead = 0
+				continue
+			}
+			if len(b) > s.left {
+				b = b[:s.left]
+			}
+			n, err := s.conn.Read(b)
+			if s.left -= n; s.left == 0 {
+				s.headerRead = 0
+			}
+			if err != nil {
+				err = s.fail(err)
+			}
+			return n, err
+		case streamFrameEnd:
+			s.headerRead = 0
+			s.ended = true
+			return 0, io.EOF
+		case streamFrameAbort:
+			for s.left > 0 {
+				chunk := make([]byte, s.left)
+				n, err := s.conn.Read(chunk)
+				s.reason = append(s.reason, chunk[:n]...)
+				s.left -= n
+				if err != nil && s.left > 0 {
+					return 0, s.fail(err)
+				}
+			}
+			s.err = abortError(s.reason)
+		default:
+			s.err = errors.Errorf("frame of unknown type %d from rdtp service", s.header[0])
+		}
+	}
+	return 0, s.err
+}
+
+// fail returns the error reading from the service, which aborts
+// the stream if the service closed it before it ended. Must hold s (see lock)
+func (s *stream) fail(err error) error {
+	if err != io.EOF {
+		return err
+	}
+	if s.ended && s.headerRead == 0 {
+		s.err = io.EOF
+	} else {
+		s.err = abortError("connection aborted: stream from rdtp service cut short")
+	}
+	return s.err
+}
+
+// aborted returns why the connection aborted, if it did, once the service
+// stopped taking writes. What is left of the stream is read ahead for
+// reads which follow, which is why it must not be called unless the
+// service closed the connection, as reads would otherwise block
+func (s *stream) aborted() error {
+	s.closeMu.Lock()
+	writeClosed := s.writeClosed
+	s.closeMu.Unlock()
+	if writeClosed {
+		return nil
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	buf := make([]byte, maxStreamFrameBytes)
+	for s.err == nil {
+		n, err := s.read(buf)
+		s.buffered = append(s.buffered, buf[:n]...)
+		if err != nil && err != io.EOF && s.err == nil {
+			return nil // e.g. past the read deadline
+		}
+	}
+	if errors.Is(s.err, ErrConnectionAborted) {
+		return s.err
+	}
+	return nil
+}
+
+// closeWrite records that the client closed the stream for writing
+func (s *stream) closeWrite() {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	s.writeClosed = true
+}
@@ -0,0 +1,58 @@
+package rdtp
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/adrianosela/rdtp/clock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamEnd(t *testing.T) {
+	svc, client := net.Pipe()
+	sc := NewServiceConn(svc)
+	go func() {
+		sc.Write([]byte("hello "))
+		sc.Write([]byte("world"))
+		sc.CloseWrite()
+		sc.Close()
+	}()
+
+	// the remote closing the connection is read as EOF
+	data, err := io.ReadAll(newStream(client, clock.Real{}))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(data))
+}
+
+func TestStreamAbort(t *testing.T) {
+	svc, client := net.Pipe()
+	sc := NewServiceConn(svc)
+	reason := errors.New("connection aborted: retransmission limit reached")
+	go func() {
+		sc.Write([]byte("hello"))
+		sc.CloseWithError(reason)
+	}()
+
+	// while the connection aborting is read as why it did,
+	// once the data written before is read
+	data, err := io.ReadAll(newStream(client, clock.Real{}))
+	assert.Equal(t, "hello", string(data))
+	assert.True(t, errors.Is(err, ErrConnectionAborted))
+	assert.Equal(t, reason.Error(), err.Error())
+}
+
+func TestStreamCutShort(t *testing.T) {
+	svc, client := net.Pipe()
+	sc := NewServiceConn(svc)
+	go func() {
+		sc.Write([]byte("hello"))
+		sc.Close()
+	}()
+
+	// as is the service closing the connection before the stream ends
+	data, err := io.ReadAll(newStream(client, clock.Real{}))
+	assert.Equal(t, "hello", string(data))
+	assert.True(t, errors.Is(err, ErrConnectionAborted))
+}